@@ -17,6 +17,7 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/gorilla/schema"
 	"github.com/kodekoding/phastos/v2/go/sse"
+	"github.com/kodekoding/phastos/v2/go/sse/pgnotify"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/pkg/errors"
 	"github.com/unrolled/secure"
@@ -25,14 +26,23 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
 
+	"github.com/kodekoding/phastos/v2/go/cache"
 	"github.com/kodekoding/phastos/v2/go/common"
+	"github.com/kodekoding/phastos/v2/go/config"
 	phastosctx "github.com/kodekoding/phastos/v2/go/context"
 	"github.com/kodekoding/phastos/v2/go/cron"
 	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/health"
+	"github.com/kodekoding/phastos/v2/go/jobs"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
 	"github.com/kodekoding/phastos/v2/go/monitoring"
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
 	"github.com/kodekoding/phastos/v2/go/server"
+	"github.com/kodekoding/phastos/v2/go/ws"
 )
 
 var decoder = schema.NewDecoder()
@@ -42,6 +52,7 @@ type routeRegistryEntry struct {
 	Path           string
 	Doc            *RouteDoc
 	PathParamTypes []PathParamType
+	Policy         RoutePolicy
 }
 
 type HandlerV2 func(ctx context.Context) (any, error)
@@ -72,11 +83,24 @@ type (
 		newRelic              *newrelic.Application
 		timezoneRegion        string
 		pprofEnabled          bool
+		metricsEnabled        bool
 		sf                    singleflight.Group
 		middlewares           map[string]any
 		globalMiddlewares     []func(http.Handler) http.Handler
 		pendingMiddlewares    bool
 		sseEvent              *sse.Hub
+		wsHub                 *ws.Hub
+		dbListener            *database.Listener
+		cache                 *cache.Store
+		rateLimiter           ratelimit.Limiter
+		cacheProvided         bool
+		notifProvided         bool
+		handlerBuilt          bool
+		routeMounter          func(method, path string, handler http.Handler) // FastHttpApp: receives the Controller routes instead of chi
+		health                *health.Registry
+		grpcServer            *grpc.Server
+		grpcHealth            *grpchealth.Server
+		grpcCfg               *grpcConfig
 		useFastHttp           bool                // if true, server runs with fasthttp
 		sfActive              bool                // cached SINGLEFLIGHT_ACTIVE env var
 		syncMode              bool                // true when apiTimeout==0 && !sfActive → sync handler path
@@ -90,6 +114,15 @@ type (
 		routeRegistry         []routeRegistryEntry
 		enableOpenAPI         bool
 		apiRouters            map[string]*chi.Mux
+		appConfig             *config.Config // WithConfig, used instead of the env variables
+		jobs                  *jobs.Manager
+		jobsEnabled           bool
+		jobsOpts              []jobs.Options
+		jobsAdmin             bool
+		jobsAdminMiddlewares  []func(http.Handler) http.Handler
+		sseBackplane          bool
+		sseBackplaneOpts      []sse.RedisBackplaneOption
+		responseOptions       *responseOptions // WithProblemDetails, WithContentNegotiation, WithCompression, WithEncoder
 	}
 
 	Options func(api *App)
@@ -102,9 +135,11 @@ type (
 
 func NewApp(opts ...Options) *App {
 	apiApp := App{
-		TotalEndpoints: 0,
-		middlewares:    make(map[string]any),
-		middlewareDocs: make(map[string]MiddlewareInfo),
+		TotalEndpoints:  0,
+		middlewares:     make(map[string]any),
+		middlewareDocs:  make(map[string]MiddlewareInfo),
+		health:          health.NewRegistry(),
+		responseOptions: newResponseOptions(),
 	}
 
 	apiApp.Config = new(server.Config)
@@ -208,9 +243,42 @@ func WithPprof(enabled bool) Options {
 	}
 }
 
-func WithSSE() Options {
+// WithSSE mounts an SSE hub at /events, started and stopped with the App. The options set the
+// identity of the connections, the topic authorizer and the topic buffers.
+func WithSSE(opts ...sse.HubOption) Options {
 	return func(app *App) {
-		app.sseEvent = sse.NewHub(context.Background())
+		app.sseEvent = sse.NewHub(context.Background(), opts...)
+	}
+}
+
+// WithWebSocket mounts a WebSocket hub at /ws, started and stopped with the App.
+// Configure it (token validators, handlers, rooms) through app.WebSocket().
+func WithWebSocket(opts ...ws.HubOption) Options {
+	return func(app *App) {
+		app.wsHub = ws.NewHub(context.Background(), opts...)
+	}
+}
+
+// WithDBListener enables the Postgres LISTEN/NOTIFY listener. It is started and
+// stopped together with the App; subscribe channels via app.DBListener().
+func WithDBListener(opts ...database.ListenerOption) Options {
+	return func(app *App) {
+		app.dbListener = database.NewListener("", opts...)
+	}
+}
+
+// WithProblemDetails renders every error response as RFC 7807 application/problem+json
+// (type, title, status, detail, instance, errors) instead of the default HttpError shape.
+// typeBaseURI, when given, builds the problem type from the error code
+// (https://errors.example.com + DATA_CONFLICT → https://errors.example.com/data-conflict),
+// otherwise the type is "about:blank".
+func WithProblemDetails(typeBaseURI ...string) Options {
+	return func(app *App) {
+		app.responseOptions.problemDetails = true
+		app.responseOptions.problemTypeBaseURI = ""
+		if len(typeBaseURI) > 0 {
+			app.responseOptions.problemTypeBaseURI = typeBaseURI[0]
+		}
 	}
 }
 
@@ -270,7 +338,7 @@ func (app *App) AddGlobalMiddlewareMeta(opts ...MiddlewareOption) {
 	for _, opt := range opts {
 		opt(&info)
 	}
-	if len(info.Headers) > 0 || info.SecurityScheme != nil || info.Description != "" {
+	if len(info.Headers) > 0 || info.SecurityScheme != nil || info.Description != "" || len(info.ErrorResponses) > 0 {
 		app.globalMiddlewareMetas = append(app.globalMiddlewareMetas, info)
 	}
 }
@@ -362,6 +430,9 @@ func (app *App) Init() {
 	// load Notifications if env config is exists
 	app.loadNotification()
 	app.loadResources()
+	app.registerResourceMetrics()
+	app.initJobs()
+	app.initSSEBackplane()
 }
 
 func (app *App) DB() database.ISQL {
@@ -376,6 +447,56 @@ func (app *App) SSE() sse.Events {
 	return app.sseEvent
 }
 
+func (app *App) WebSocket() *ws.Hub {
+	if app.wsHub == nil {
+		log := plog.Get()
+		log.Fatal().Msg("WebSocket Hub not initialized")
+	}
+	return app.wsHub
+}
+
+func (app *App) DBListener() *database.Listener {
+	if app.dbListener == nil {
+		log := plog.Get()
+		log.Fatal().Msg("DB Listener not initialized")
+	}
+	return app.dbListener
+}
+
+// startDBListener starts the DB listener in the background, returning the function stopping it.
+// Start does not return while PostgreSQL is unreachable, so stop does not wait for it: Stop closes
+// the connection Start is waiting on, and a Start returning after stop is stopped again
+func (app *App) startDBListener() (stop func()) {
+	stopping := make(chan struct{})
+	go func() {
+		log := plog.Get()
+		if err := app.dbListener.Start(app.Ctx); err != nil {
+			log.Err(err).Msg("failed to start DB listener")
+			return
+		}
+		select {
+		case <-stopping:
+			_ = app.dbListener.Stop()
+		default:
+		}
+	}()
+	return func() {
+		close(stopping)
+		if err := app.dbListener.Stop(); err != nil {
+			log := plog.Get()
+			log.Err(err).Msg("failed to stop DB listener")
+		}
+	}
+}
+
+// ForwardNotifyToSSE pushes every NOTIFY of channel to the SSE clients
+func (app *App) ForwardNotifyToSSE(channel string, opts ...pgnotify.Option) error {
+	if app.sseEvent == nil {
+		return errors.New("phastos.api.ForwardNotifyToSSE: SSE is not enabled, use WithSSE()")
+	}
+	return app.DBListener().Subscribe(channel, pgnotify.Forwarder(app.sseEvent, opts...))
+}
+
 func (app *App) SetVersion(version string) {
 	app.Version = version
 	appVersion = version
@@ -393,10 +514,14 @@ func (app *App) Trx() database.Transactions {
 }
 
 func (app *App) initPlugins() {
+	// outermost, so panics recovered below are counted as 500
+	if app.metricsEnabled {
+		app.Http.Use(metrics.HTTPMiddleware)
+	}
 	app.Http.Use(
 		requestLogger,
 		middleware.Recoverer,
-		PanicHandler,
+		app.panicHandler,
 	)
 
 	// apply global middlewares registered via WithGlobalMiddleware option
@@ -411,8 +536,12 @@ func (app *App) initPlugins() {
 // It is called lazily before the first user-defined route to ensure all global
 // middlewares (including those added via AddGlobalMiddleware) are applied first.
 func (app *App) initRoutes() {
-	app.Http.NotFound(RouteNotFoundHandler)
-	app.Http.MethodNotAllowed(MethodNotAllowedHandler)
+	app.Http.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.WriteHttpError(w, r, NotFound("route not found", "ROUTE_NOT_FOUND"))
+	})
+	app.Http.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.WriteHttpError(w, r, MethodNotAllowed("method not allowed", "METHOD_NOT_ALLOWED"))
+	})
 
 	app.initDefaultHandlers()
 
@@ -440,29 +569,50 @@ func (app *App) initDefaultHandlers() {
 		return NewResponse().SetMessage(msgString)
 	})
 
+	// liveness & readiness probes and metrics, not counted in TotalEndpoints (like pprof)
+	app.Http.Get("/healthz", app.health.LivenessHandler())
+	app.Http.Get("/readyz", app.health.ReadinessHandler())
+
+	if app.metricsEnabled {
+		app.Http.Method("GET", "/metrics", metrics.Handler())
+	}
+
 	if app.sseEvent != nil {
 		app.Http.Get("/events", app.sseEvent.Handle)
 		app.TotalEndpoints++
-		app.registerHandler("GET", "/events/missed-msg", func(request Request, ctx context.Context) *Response {
-			var req struct {
-				ClientID       string "schema:\"client_id\" validate:\"required\""
-				LastReceivedID string "schema:\"last_received_id\" validate:\"required\""
-			}
-			if err := request.GetQuery(&req); err != nil {
-				return NewResponse().SetError(err)
-			}
+		app.registerHandler("GET", "/events/missed-msg", app.sseMissedMessages)
+		app.Http.Post("/events/subscriptions", app.sseEvent.HandleSubscriptions)
+		app.Http.Delete("/events/subscriptions", app.sseEvent.HandleSubscriptions)
+		app.TotalEndpoints += 2
+	}
 
-			// Retrieve missed messages
-			missedMessages := app.sseEvent.GetMissedMessages(req.ClientID, req.LastReceivedID)
-			data := Map{
-				"client_id": req.ClientID,
-				"messages":  missedMessages,
-				"count":     len(missedMessages),
-			}
+	if app.wsHub != nil {
+		app.Http.Get("/ws", app.wsHub.Handle)
+		app.TotalEndpoints++
+	}
 
-			return NewResponse().SetData(data)
-		})
+	app.mountJobsAdmin()
+}
+
+// sseMissedMessages returns the SSE messages a client missed since last_received_id
+func (app *App) sseMissedMessages(request Request, ctx context.Context) *Response {
+	var req struct {
+		ClientID       string "schema:\"client_id\" validate:\"required\""
+		LastReceivedID string "schema:\"last_received_id\" validate:\"required\""
+	}
+	if err := request.GetQuery(&req); err != nil {
+		return NewResponse().SetError(err)
+	}
+
+	// Retrieve missed messages
+	missedMessages := app.sseEvent.GetMissedMessages(req.ClientID, req.LastReceivedID)
+	data := Map{
+		"client_id": req.ClientID,
+		"messages":  missedMessages,
+		"count":     len(missedMessages),
 	}
+
+	return NewResponse().SetData(data)
 }
 
 func (app *App) requestValidator(i interface{}) error {
@@ -483,6 +633,7 @@ type handler2WithMeta struct {
 	requestType    any
 	queryType      any
 	pathParamTypes []PathParamType
+	policy         RoutePolicy
 }
 
 // HandlerV2Meta is the public wrapper for HandlerV2 with auto-binding metadata.
@@ -491,31 +642,45 @@ type HandlerV2Meta struct {
 	RequestType    any
 	QueryType      any
 	PathParamTypes []PathParamType
+	Policy         RoutePolicy
 }
 
 // WrapHandlerV2Meta wraps a HandlerV2 with auto-binding metadata for testing.
 func (app *App) WrapHandlerV2Meta(m HandlerV2Meta) http.HandlerFunc {
-	return app.wrapHandlerV2WithMeta(handler2WithMeta{
+	return app.withRoutePolicy(m.Policy, app.wrapHandlerV2WithMeta(handler2WithMeta{
 		h:              m.H,
 		requestType:    m.RequestType,
 		queryType:      m.QueryType,
 		pathParamTypes: m.PathParamTypes,
-	})
+		policy:         m.Policy,
+	}))
 }
 
 func (app *App) wrapHandler(handler any) http.HandlerFunc {
+	if ph, ok := handler.(policyHandler); ok {
+		return app.withRoutePolicy(ph.policy, app.wrapHandlerWithPolicy(ph.handler, ph.policy))
+	}
+	return app.wrapHandlerWithPolicy(handler, RoutePolicy{})
+}
+
+// wrapHandlerWithPolicy wraps any handler style with the timeout / singleflight of policy.
+func (app *App) wrapHandlerWithPolicy(handler any, policy RoutePolicy) http.HandlerFunc {
 	if h2m, ok := handler.(handler2WithMeta); ok {
+		h2m.policy = policy
 		return app.wrapHandlerV2WithMeta(h2m)
 	}
 	if h2, ok := handler.(HandlerV2); ok {
-		return app.wrapHandlerV2(h2)
+		return app.wrapHandlerV2WithMeta(handler2WithMeta{h: h2, policy: policy})
 	}
 	if isHandlerV2(handler) {
-		return app.wrapHandlerV2(handler.(func(context.Context) (any, error))) //nolint:errcheck
+		return app.wrapHandlerV2WithMeta(handler2WithMeta{h: handler.(func(context.Context) (any, error)), policy: policy}) //nolint:errcheck
 	}
 
 	h := handler.(func(Request, context.Context) *Response) //nolint:errcheck
+	timeout := app.routeTimeout(policy)
 	return func(w http.ResponseWriter, r *http.Request) {
+		sfActive := app.routeSingleflight(r.Method, policy)
+		syncMode := timeout <= 0 && !sfActive
 		request := app.initRequest(r)
 		ctx := r.Context()
 		requestId := r.Header.Get(common.RequestIDHeader)
@@ -528,7 +693,7 @@ func (app *App) wrapHandler(handler any) http.HandlerFunc {
 		// Executes the handler directly on the current goroutine,
 		// avoiding channel + goroutine + context.WithTimeout overhead.
 		// Panic recovery is still provided via defer panicRecover().
-		if app.syncMode {
+		if syncMode {
 			var uniqueReqKey string
 			defer panicRecover(r, requestId, uniqueReqKey)
 			response := h(*request, ctx)
@@ -543,13 +708,12 @@ func (app *App) wrapHandler(handler any) http.HandlerFunc {
 
 		// --- Async path: timeout + optional singleflight ---
 		var response *Response
-		var err error
 		log := plog.Ctx(ctx)
 
-		timeoutCtx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(app.apiTimeout))
+		timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
-		respChan := make(chan *Response)
+		respChan := make(chan *Response, 1)
 		go func() {
 			var resp *Response
 			defer func() {
@@ -563,14 +727,14 @@ func (app *App) wrapHandler(handler any) http.HandlerFunc {
 			var uniqueReqKey string
 			defer panicRecover(r, requestId, uniqueReqKey)
 
-			if !app.sfActive {
+			if !sfActive {
 				resp = h(*request, ctx)
 				return
 			}
 
 			uniqueReqKey = generateUniqueRequestKey(r)
 
-			sfResponse, sfErr, _ := app.sf.Do(uniqueReqKey, func() (any, error) {
+			sfResponse, sfErr, shared := app.sf.Do(uniqueReqKey, func() (any, error) {
 				defer func() {
 					if r := recover(); r != nil {
 						log.Error().Interface("panic", r).Msg("[SINGLEFLIGHT] handler panic recovered")
@@ -585,19 +749,21 @@ func (app *App) wrapHandler(handler any) http.HandlerFunc {
 				return
 			}
 			resp, _ = sfResponse.(*Response)
+			if resp != nil && shared {
+				// every request of the shared call sends and releases its own copy
+				resp = resp.clone()
+			}
 		}()
 
 		select {
 		case <-timeoutCtx.Done():
 			if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
-				w.WriteHeader(http.StatusGatewayTimeout)
-				_, err = w.Write([]byte("timeout"))
-				if err != nil {
-					log.Err(err).Msg("[REQUEST][TIMEOUT] Failed when write to client")
-				} else {
-					log.Info().Msg("[REQUEST][TIMEOUT] context deadline exceed")
-				}
-
+				log.Info().Msg("[REQUEST][TIMEOUT] context deadline exceed")
+				response = NewResponse().SetError(timeoutError(timeout))
+				response.TraceId = requestId
+				app.handleResponseError(response, r, requestId, ctx)
+				response.Send(w)
+				ReleaseResponse(response)
 			}
 		case response = <-respChan:
 			response.TraceId = requestId
@@ -615,34 +781,7 @@ func (app *App) wrapHandler(handler any) http.HandlerFunc {
 // It performs auto-binding (path → query → body → validate) before
 // calling the handler, and wraps the (any, error) return into *Response.
 func (app *App) wrapHandlerV2(h HandlerV2) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		requestId := r.Header.Get(common.RequestIDHeader)
-		if requestId == "" {
-			requestId = r.Header.Get("X-Request-ID")
-		}
-		w.Header().Set("X-Trace-ID", requestId)
-
-		ctx := phastosctx.SetHTTPRequest(r.Context(), r)
-		result, err := h(ctx)
-
-		var response *Response
-		switch {
-		case err != nil:
-			response = NewResponse().SetError(err)
-		case result == nil:
-			response = NewResponse()
-		default:
-			if customResp, ok := result.(*Response); ok {
-				response = customResp
-			} else {
-				response = NewResponse().SetData(result)
-			}
-		}
-
-		app.handleResponseError(response, r, requestId, r.Context())
-		response.Send(w)
-		ReleaseResponse(response)
-	}
+	return app.wrapHandlerV2WithMeta(handler2WithMeta{h: h})
 }
 
 // wrapHandlerV2WithMeta wraps a HandlerV2 with full auto-binding (path → query → body → validate).
@@ -710,15 +849,15 @@ func (app *App) wrapHandlerV2WithMeta(m handler2WithMeta) http.HandlerFunc {
 			ctx = phastosctx.SetQueryParams(ctx, queryVal)
 		}
 
-		// 3. Bind body (for POST/PUT/PATCH)
+		// 3. Bind body (for POST/PUT/PATCH): JSON, urlencoded or multipart form
 		if m.requestType != nil && (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch) {
 			bodyType := reflect.TypeOf(m.requestType)
 			if bodyType.Kind() == reflect.Ptr {
 				bodyType = bodyType.Elem()
 			}
-			bodyVal := reflect.New(bodyType).Interface()
-			if err := json.NewDecoder(r.Body).Decode(bodyVal); err != nil {
-				resp := NewResponse().SetError(BadRequest(err.Error(), "ERROR_PARSING_BODY"))
+			bodyVal, err := bindBody(r, bodyType)
+			if err != nil {
+				resp := NewResponse().SetError(err)
 				app.handleResponseError(resp, r, requestId, ctx)
 				resp.Send(w)
 				ReleaseResponse(resp)
@@ -736,7 +875,7 @@ func (app *App) wrapHandlerV2WithMeta(m handler2WithMeta) http.HandlerFunc {
 
 		// 4. Call handler with enriched context
 		ctx = phastosctx.SetHTTPRequest(ctx, r)
-		result, err := m.h(ctx)
+		result, err := app.runHandlerV2(ctx, r, requestId, m.h, m.policy)
 
 		var response *Response
 		switch {
@@ -795,6 +934,8 @@ func validatePathParam(raw string, pt PathParamType) error {
 // handleResponseError processes error responses: sets HTTPError, sends notifications,
 // and logs asynchronously. Shared by both sync and async handler paths.
 func (app *App) handleResponseError(response *Response, r *http.Request, requestId string, ctx context.Context) {
+	response.Negotiate(r)
+	response.options = app.responseOptions
 	if response.Err == nil {
 		return
 	}
@@ -938,6 +1079,7 @@ func (app *App) registerRoutes(prefix string, parentMiddlewares *[]func(http.Han
 						route.Doc.Security = info.SecurityScheme
 					}
 					route.Doc.Headers = append(route.Doc.Headers, info.Headers...)
+					route.Doc.ErrorResponses = append(route.Doc.ErrorResponses, info.ErrorResponses...)
 				}
 			}
 		}
@@ -949,6 +1091,7 @@ func (app *App) registerRoutes(prefix string, parentMiddlewares *[]func(http.Han
 				route.Doc = &RouteDoc{}
 			}
 			route.Doc.Headers = append(route.Doc.Headers, meta.Headers...)
+			route.Doc.ErrorResponses = append(route.Doc.ErrorResponses, meta.ErrorResponses...)
 		}
 
 		// Inject security scheme from ALL registered middlewares into every route
@@ -979,8 +1122,18 @@ func (app *App) registerRoutes(prefix string, parentMiddlewares *[]func(http.Han
 				pathParamTypes: route.PathParamTypes,
 			}
 		}
+		if !route.Policy.isZero() {
+			handler = policyHandler{handler: handler, policy: route.Policy}
+		}
 
-		app.registerHandler(route.Method, routePath, handler, middlewares...)
+		if app.routeMounter != nil {
+			// FastHttpApp: the global middlewares are not applied by a chi router
+			chain := append(append([]func(http.Handler) http.Handler{}, app.globalMiddlewares...), middlewares...)
+			rawPath := "/v" + strconv.Itoa(route.Version) + prefix + route.Path
+			app.routeMounter(route.Method, rawPath, chi.Chain(chain...).Handler(app.wrapHandler(handler)))
+		} else {
+			app.registerHandler(route.Method, routePath, handler, middlewares...)
+		}
 
 		if route.Doc == nil {
 			route.Doc = &RouteDoc{}
@@ -990,6 +1143,7 @@ func (app *App) registerRoutes(prefix string, parentMiddlewares *[]func(http.Han
 			Path:           routePath,
 			Doc:            route.Doc,
 			PathParamTypes: route.PathParamTypes,
+			Policy:         route.Policy,
 		})
 	}
 }
@@ -1082,7 +1236,15 @@ func (app *App) WrapScheduler(wrapper cron.Wrapper) {
 	app.cron.Wrap(wrapper)
 }
 
-func (app *App) Start() error {
+// BuildHandler registers the pending and default routes and builds the HTTP handler served by Start:
+// request ID, security headers, CORS and the App wrappers (cache, notifications, tracing ...).
+// It runs once, later calls return the same handler; apitest serves it in memory.
+func (app *App) BuildHandler() http.Handler {
+	if app.handlerBuilt {
+		return app.Handler
+	}
+	app.handlerBuilt = true
+
 	// ensure any pending middlewares and default routes are registered
 	app.flushPendingMiddlewares()
 
@@ -1105,7 +1267,8 @@ func (app *App) Start() error {
 		})
 	}
 
-	log := plog.Get()
+	app.registerDefaultHealthChecks()
+
 	app.Handler = InitHandler(app.Http)
 	secureMiddleware := secure.New(secure.Options{
 		BrowserXssFilter:   true,
@@ -1118,13 +1281,12 @@ func (app *App) Start() error {
 		AllowedHeaders: []string{"Origin", "Referer", "token", "content-type", "Content-Type", "Authorization"},
 		MaxAge:         60 * 60, //1 hour
 	}
-	corsOriginEnv := os.Getenv("CORS_ORIGIN")
-	if corsOriginEnv != "" {
+	if app.appConfig != nil && len(app.appConfig.CORS.Origins) > 0 {
+		corsOptions.AllowedOrigins = app.appConfig.CORS.Origins
+		corsOptions.AllowedHeaders = append(corsOptions.AllowedHeaders, app.appConfig.CORS.Headers...)
+	} else if corsOriginEnv := os.Getenv("CORS_ORIGIN"); corsOriginEnv != "" {
 		corsOptions.AllowedOrigins = strings.Split(corsOriginEnv, ",")
-	}
-
-	corsAllowedHeader := os.Getenv("CORS_HEADER")
-	if corsOriginEnv != "" {
+		corsAllowedHeader := os.Getenv("CORS_HEADER")
 		corsOptions.AllowedHeaders = append(corsOptions.AllowedHeaders, strings.Split(corsAllowedHeader, ",")...)
 	}
 
@@ -1135,7 +1297,15 @@ func (app *App) Start() error {
 		app.Handler = wrapper.WrapToHandler(app.Handler)
 		app.Ctx = wrapper.WrapToContext(app.Ctx)
 	}
+	return app.Handler
+}
 
+func (app *App) Start() error {
+	app.failReadinessOnShutdown()
+	app.BuildHandler()
+	app.attachGRPC()
+
+	log := plog.Get()
 	log.Info().Int("total_endpoint(s)", app.TotalEndpoints).Msg("Server Starting")
 
 	if app.cron != nil {
@@ -1148,6 +1318,19 @@ func (app *App) Start() error {
 		go app.sseEvent.Run()
 	}
 
+	if app.wsHub != nil {
+		defer app.wsHub.Stop()
+		go app.wsHub.Run()
+	}
+
+	if app.jobs != nil {
+		defer app.startJobs()()
+	}
+
+	if app.dbListener != nil {
+		defer app.startDBListener()()
+	}
+
 	if app.otelTp != nil {
 		defer func() {
 			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -15,6 +15,7 @@ import (
 	context2 "github.com/kodekoding/phastos/v2/go/context"
 	"github.com/kodekoding/phastos/v2/go/common"
 	"github.com/kodekoding/phastos/v2/go/notifications"
+	"github.com/kodekoding/phastos/v2/go/sse"
 )
 
 // stubNotifPlatforms implements notifications.Platforms for testing.
@@ -89,6 +90,13 @@ func TestWithSSE(t *testing.T) {
 	assert.NotNil(t, app.sseEvent)
 }
 
+func TestWithSSEBackplane(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithSSE(), WithSSEBackplane(sse.WithBackplaneStream(1000)))
+	assert.True(t, app.sseBackplane)
+	assert.Len(t, app.sseBackplaneOpts, 1)
+	assert.NotPanics(t, app.Init, "the hub serves the clients of the instance without Redis")
+}
+
 func TestWithFastHttp(t *testing.T) {
 	app := NewApp(WithTimezone("UTC"), WithFastHttp())
 	assert.True(t, app.useFastHttp)
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"github.com/gorilla/schema"
+	"github.com/pkg/errors"
+)
+
+// defaultMultipartMemory is the part of a multipart body kept in memory, the rest spills to temp files.
+const defaultMultipartMemory int64 = 32 << 20
+
+// formDecoder decodes urlencoded / multipart values using the `form` struct tag.
+var formDecoder = newFormDecoder()
+
+var fileHeaderType = reflect.TypeOf(multipart.FileHeader{})
+
+// uploadedFiles is the validation view of file fields. Being a slice, the validator applies the
+// field tags (max_size, mime) to it instead of walking the multipart.FileHeader struct.
+type uploadedFiles []*multipart.FileHeader
+
+func init() {
+	validate.RegisterCustomTypeFunc(func(v reflect.Value) interface{} {
+		fh, _ := v.Interface().(multipart.FileHeader)
+		return uploadedFiles{&fh}
+	}, multipart.FileHeader{})
+	_ = validate.RegisterValidation("max_size", validateFileMaxSize)
+	_ = validate.RegisterValidation("mime", validateFileMime)
+}
+
+func newFormDecoder() *schema.Decoder {
+	d := schema.NewDecoder()
+	d.SetAliasTag("form")
+	d.IgnoreUnknownKeys(true)
+	return d
+}
+
+// bindBody decodes the request body into a new value of bodyType according to the
+// Content-Type: JSON (default), application/x-www-form-urlencoded or multipart/form-data
+// (`form` tags, *multipart.FileHeader / []*multipart.FileHeader fields for files).
+func bindBody(r *http.Request, bodyType reflect.Type) (any, error) {
+	bodyVal := reflect.New(bodyType).Interface()
+
+	switch filterFlags(r.Header.Get("Content-Type")) {
+	case ContentFormData:
+		maxMemory := defaultMultipartMemory
+		if limited, ok := r.Body.(*limitedBody); ok && limited.limit < maxMemory {
+			maxMemory = limited.limit
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, bodyError(r, err)
+		}
+		if err := formDecoder.Decode(bodyVal, r.MultipartForm.Value); err != nil {
+			return nil, BadRequest(err.Error(), ErrDecodeBodyCode)
+		}
+		bindFiles(reflect.ValueOf(bodyVal).Elem(), r.MultipartForm.File)
+	case ContentURLEncoded:
+		if err := r.ParseForm(); err != nil {
+			return nil, bodyError(r, err)
+		}
+		if err := formDecoder.Decode(bodyVal, r.PostForm); err != nil {
+			return nil, BadRequest(err.Error(), ErrDecodeBodyCode)
+		}
+	default:
+		if err := json.NewDecoder(r.Body).Decode(bodyVal); err != nil {
+			return nil, bodyError(r, err)
+		}
+	}
+	return bodyVal, nil
+}
+
+// limitedBody is the body of a route with WithMaxBodyBytes. It records the error of the
+// http.MaxBytesReader once the limit is hit, as the multipart reader does not always wrap it.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	exceeded error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.exceeded = err
+	}
+	return n, err
+}
+
+// bodyError converts a body read / parse error of r into a 413 when the body limit of the
+// route was hit, a 400 ERROR_PARSING_BODY otherwise.
+func bodyError(r *http.Request, err error) error {
+	if limited, ok := r.Body.(*limitedBody); ok && limited.exceeded != nil {
+		err = limited.exceeded
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return PayloadTooLarge(fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), "REQUEST_BODY_TOO_LARGE")
+	}
+	return BadRequest(err.Error(), ErrParsedBodyCode)
+}
+
+// bindFiles assigns the uploaded files to the *multipart.FileHeader and
+// []*multipart.FileHeader fields of val, matched by `form` tag (or field name).
+func bindFiles(val reflect.Value, files map[string][]*multipart.FileHeader) {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindFiles(val.Field(i), files)
+			continue
+		}
+		if !isFileType(field.Type) {
+			continue
+		}
+		uploaded := files[formFieldName(field)]
+		if len(uploaded) == 0 {
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice {
+			val.Field(i).Set(reflect.ValueOf(uploaded))
+		} else {
+			val.Field(i).Set(reflect.ValueOf(uploaded[0]))
+		}
+	}
+}
+
+func formFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("form"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// isFileType reports whether t is *multipart.FileHeader or []*multipart.FileHeader.
+func isFileType(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Ptr && t.Elem() == fileHeaderType
+}
+
+// hasFileFields reports whether model is a struct with file fields, i.e. a multipart request.
+func hasFileFields(model any) bool {
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isFileType(field.Type) {
+			return true
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && hasFileFields(reflect.New(field.Type).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldFiles(fl validator.FieldLevel) []*multipart.FileHeader {
+	switch files := fl.Field().Interface().(type) {
+	case uploadedFiles:
+		return files
+	case []*multipart.FileHeader:
+		return files
+	}
+	return nil
+}
+
+// validateFileMaxSize implements `max_size=5MB` (B, KB, MB, GB; plain numbers are bytes).
+func validateFileMaxSize(fl validator.FieldLevel) bool {
+	limit, err := parseByteSize(fl.Param())
+	if err != nil {
+		return false
+	}
+	for _, fh := range fieldFiles(fl) {
+		if fh != nil && fh.Size > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// validateFileMime implements `mime=image/*` (space separated alternatives, e.g. `mime=image/png application/pdf`).
+// The content type is sniffed from the file, falling back to the declared Content-Type.
+func validateFileMime(fl validator.FieldLevel) bool {
+	allowed := strings.Fields(fl.Param())
+	for _, fh := range fieldFiles(fl) {
+		if fh == nil {
+			continue
+		}
+		if !mimeAllowed(fileContentType(fh), allowed) {
+			return false
+		}
+	}
+	return true
+}
+
+func fileContentType(fh *multipart.FileHeader) string {
+	declared := filterFlags(fh.Header.Get("Content-Type"))
+	file, err := fh.Open()
+	if err != nil {
+		return declared
+	}
+	defer file.Close() //nolint:errcheck
+
+	buf := make([]byte, 512)
+	n, _ := file.Read(buf)
+	sniffed := filterFlags(http.DetectContentType(buf[:n]))
+	if sniffed == "application/octet-stream" && declared != "" {
+		return declared
+	}
+	return sniffed
+}
+
+func mimeAllowed(contentType string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if pattern == "*/*" || strings.EqualFold(pattern, contentType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseByteSize(raw string) (int64, error) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(raw, unit.suffix) {
+			multiplier = unit.size
+			raw = strings.TrimSuffix(raw, unit.suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "phastos.api.parseByteSize")
+	}
+	return size * multiplier, nil
+}
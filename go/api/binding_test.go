@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/kodekoding/phastos/v2/go/context"
+)
+
+type uploadTestPayload struct {
+	Title string                `form:"title" validate:"required"`
+	File  *multipart.FileHeader `form:"file" validate:"required,max_size=1KB,mime=image/*"`
+}
+
+type formTestPayload struct {
+	Name  string `form:"name" validate:"required"`
+	Value int    `form:"value"`
+}
+
+// 1x1 transparent PNG
+var pngBytes = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+
+func newMultipartRequest(t *testing.T, fields map[string]string, fileName, contentType string, content []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, val := range fields {
+		require.NoError(t, writer.WriteField(key, val))
+	}
+	if content != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="`+fileName+`"`)
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func newUploadHandler(t *testing.T, maxBodyBytes int64) http.HandlerFunc {
+	t.Helper()
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	return app.WrapHandlerV2Meta(HandlerV2Meta{
+		H: func(ctx context.Context) (any, error) {
+			payload := context2.RequestBody[uploadTestPayload](ctx)
+			return map[string]any{"title": payload.Title, "file": payload.File.Filename, "size": payload.File.Size}, nil
+		},
+		RequestType: new(uploadTestPayload),
+		Policy:      RoutePolicy{MaxBodyBytes: maxBodyBytes},
+	})
+}
+
+func TestBindBody_Multipart_Success(t *testing.T) {
+	handler := newUploadHandler(t, 0)
+	req := newMultipartRequest(t, map[string]string{"title": "avatar"}, "avatar.png", "image/png", pngBytes)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"title":"avatar"`)
+	assert.Contains(t, w.Body.String(), `"file":"avatar.png"`)
+}
+
+func TestBindBody_Multipart_MissingFile(t *testing.T) {
+	handler := newUploadHandler(t, 0)
+	req := newMultipartRequest(t, map[string]string{"title": "avatar"}, "", "", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "VALIDATION_ERROR")
+}
+
+func TestBindBody_Multipart_FileTooLarge(t *testing.T) {
+	handler := newUploadHandler(t, 0)
+	content := append(append([]byte{}, pngBytes...), bytes.Repeat([]byte{0}, 2048)...)
+	req := newMultipartRequest(t, map[string]string{"title": "avatar"}, "avatar.png", "image/png", content)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "max_size")
+}
+
+func TestBindBody_Multipart_WrongMime(t *testing.T) {
+	handler := newUploadHandler(t, 0)
+	// declared as image/png, but the content sniffs as a PDF
+	req := newMultipartRequest(t, map[string]string{"title": "doc"}, "doc.png", "image/png", []byte("%PDF-1.4 fake"))
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "mime")
+}
+
+func TestBindBody_MaxBodyBytes_Returns413(t *testing.T) {
+	handler := newUploadHandler(t, 256)
+	content := append(append([]byte{}, pngBytes...), bytes.Repeat([]byte{0}, 512)...)
+	req := newMultipartRequest(t, map[string]string{"title": "avatar"}, "avatar.png", "image/png", content)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "REQUEST_BODY_TOO_LARGE")
+
+	// chunked body without Content-Length hits the limit while parsing
+	req = newMultipartRequest(t, map[string]string{"title": "avatar"}, "avatar.png", "image/png", content)
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestBindBody_URLEncoded(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+	handler := app.WrapHandlerV2Meta(HandlerV2Meta{
+		H: func(ctx context.Context) (any, error) {
+			payload := context2.RequestBody[formTestPayload](ctx)
+			return map[string]any{"name": payload.Name, "value": payload.Value}, nil
+		},
+		RequestType: new(formTestPayload),
+	})
+
+	form := url.Values{"name": {"item"}, "value": {"7"}}
+	req := httptest.NewRequest(http.MethodPost, "/v1/form", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", ContentURLEncoded)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"item"`)
+	assert.Contains(t, w.Body.String(), `"value":7`)
+}
+
+func TestBindBody_JSONMaxBodyBytes(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+	handler := app.WrapHandlerV2Meta(HandlerV2Meta{
+		H:           func(ctx context.Context) (any, error) { return "ok", nil },
+		RequestType: new(handler2TestPayload),
+		Policy:      RoutePolicy{MaxBodyBytes: 8},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/json", strings.NewReader(`{"name":"a very long name","value":1}`))
+	req.Header.Set("Content-Type", ContentJSON)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{"100": 100, "10B": 10, "2KB": 2048, "5MB": 5 << 20, "1gb": 1 << 30}
+	for raw, expected := range cases {
+		size, err := parseByteSize(raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, expected, size, raw)
+	}
+	_, err := parseByteSize("five")
+	assert.Error(t, err)
+}
+
+func TestMimeAllowed(t *testing.T) {
+	assert.True(t, mimeAllowed("image/png", []string{"image/*"}))
+	assert.True(t, mimeAllowed("application/pdf", []string{"image/png", "application/pdf"}))
+	assert.True(t, mimeAllowed("text/plain", []string{"*/*"}))
+	assert.False(t, mimeAllowed("application/pdf", []string{"image/*"}))
+}
+
+func TestOpenAPI_MultipartRequestBody(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	assert.True(t, hasFileFields(new(uploadTestPayload)))
+	assert.False(t, hasFileFields(new(formTestPayload)))
+
+	schema := app.generateSchema(new(uploadTestPayload))
+	require.Contains(t, schema.Properties, "file")
+	assert.Equal(t, "binary", schema.Properties["file"].Value.Format)
+	assert.Contains(t, schema.Required, "file")
+
+	app.routeRegistry = append(app.routeRegistry, routeRegistryEntry{
+		Method: http.MethodPost,
+		Path:   "/v1/upload",
+		Doc:    &RouteDoc{RequestType: new(uploadTestPayload)},
+	})
+	spec := app.buildOpenAPISpec()
+	operation := spec.Paths.Find("/v1/upload").Post
+	require.NotNil(t, operation)
+	assert.NotNil(t, operation.RequestBody.Value.Content.Get(ContentFormData))
+	assert.Nil(t, operation.RequestBody.Value.Content.Get(ContentJSON))
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(io.Discard)
+		},
+	}
+	brotliWriterPool = sync.Pool{
+		New: func() interface{} {
+			return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+		},
+	}
+)
+
+// WithCompression compresses response bodies of at least minSize bytes with brotli or gzip,
+// depending on the client Accept-Encoding. Streamed responses (NDJSON) and file downloads
+// are sent uncompressed.
+func WithCompression(minSize int) Options {
+	return func(app *App) {
+		if minSize <= 0 {
+			minSize = 1
+		}
+		app.responseOptions.compressionMinSize = minSize
+	}
+}
+
+// negotiateEncoding returns "br", "gzip" or "" for the given Accept-Encoding header.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	// "*" covers the codings the header does not list, so an explicit gzip;q=0 still refuses gzip
+	var gzipListed, gzipAccepted, anyAccepted bool
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q := parseAcceptPart(part)
+		switch coding {
+		case encodingBrotli:
+			if q > 0 {
+				return encodingBrotli
+			}
+		case encodingGzip:
+			gzipListed = true
+			gzipAccepted = q > 0
+		case "*":
+			anyAccepted = q > 0
+		}
+	}
+	if gzipAccepted || (!gzipListed && anyAccepted) {
+		return encodingGzip
+	}
+	return ""
+}
+
+// compressBody compresses body when compression is enabled, the body reaches the threshold and
+// the client accepts a supported encoding. It returns the (possibly unchanged) body and the
+// Content-Encoding to set.
+func (o *responseOptions) compressBody(body []byte, acceptEncoding string) ([]byte, string) {
+	if o.compressionMinSize <= 0 || len(body) < o.compressionMinSize {
+		return body, ""
+	}
+	encoding := negotiateEncoding(acceptEncoding)
+	if encoding == "" {
+		return body, ""
+	}
+
+	var buf bytes.Buffer
+	switch encoding {
+	case encodingBrotli:
+		bw := brotliWriterPool.Get().(*brotli.Writer) //nolint:errcheck
+		defer brotliWriterPool.Put(bw)
+		bw.Reset(&buf)
+		if _, err := bw.Write(body); err != nil {
+			return body, ""
+		}
+		if err := bw.Close(); err != nil {
+			return body, ""
+		}
+	default:
+		gw := gzipWriterPool.Get().(*gzip.Writer) //nolint:errcheck
+		defer gzipWriterPool.Put(gw)
+		gw.Reset(&buf)
+		if _, err := gw.Write(body); err != nil {
+			return body, ""
+		}
+		if err := gw.Close(); err != nil {
+			return body, ""
+		}
+	}
+	return buf.Bytes(), encoding
+}
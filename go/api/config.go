@@ -0,0 +1,97 @@
+package api
+
+import (
+	"os"
+
+	"github.com/kodekoding/phastos/v2/go/config"
+	"github.com/kodekoding/phastos/v2/go/cron"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// WithConfig configures the App from a config.Config (see config.Load) instead of the env variables:
+// server, API timeout, timezone, pprof, cron, CORS, log level, database, redis and notifications.
+// The options passed after it override its values. With a config.Reloadable (config.Watcher), the log
+// level follows the reloads.
+//
+//	cfg, err := config.Watch[ServiceConfig]()
+//	app := api.NewApp(api.WithConfig(cfg))
+//	go cfg.Run(ctx)
+func WithConfig(provider config.Provider) Options {
+	return func(app *App) {
+		cfg := provider.Framework()
+		app.appConfig = cfg
+
+		srv := cfg.Server
+		if srv.Port != 0 {
+			app.Port = srv.Port
+		}
+		if srv.ReadTimeout != 0 {
+			app.ReadTimeout = srv.ReadTimeout
+		}
+		if srv.WriteTimeout != 0 {
+			app.WriteTimeout = srv.WriteTimeout
+		}
+		if srv.MaxHeaderByte != 0 {
+			app.MaxHeaderByte = srv.MaxHeaderByte
+		}
+		if srv.CertFile != "" {
+			app.CertFile, app.KeyFile = srv.CertFile, srv.KeyFile
+		}
+		if srv.EncryptionKey != "" {
+			app.EncryptionKey = srv.EncryptionKey
+		}
+		if srv.ShutdownDelay != 0 {
+			app.ShutdownDelay = srv.ShutdownDelay
+		}
+		if srv.GRPCPort != 0 && app.grpcCfg != nil {
+			app.grpcCfg.port = srv.GRPCPort
+		}
+
+		if cfg.App.Name != "" && os.Getenv("APP_NAME") == "" {
+			// the logger, the tracer and the server banner read the service name from APP_NAME
+			_ = os.Setenv("APP_NAME", cfg.App.Name)
+		}
+		if cfg.App.Version != "" {
+			appVersion = cfg.App.Version
+			app.Version = appVersion
+		}
+		if cfg.App.Timezone != "" {
+			app.timezoneRegion = cfg.App.Timezone
+		}
+		if cfg.App.APITimeout != nil {
+			app.apiTimeout = *cfg.App.APITimeout
+		}
+		if cfg.App.Pprof != nil {
+			app.pprofEnabled = *cfg.App.Pprof
+		}
+
+		if cfg.Cron.Enabled {
+			timezone := cfg.Cron.Timezone
+			if timezone == "" {
+				timezone = "Asia/Jakarta"
+			}
+			cronOpts := []cron.Options{cron.WithTimeZone(timezone)}
+			if cfg.Cron.TimeoutProcess > 0 {
+				cronOpts = append(cronOpts, cron.WithTimeoutProcess(cfg.Cron.TimeoutProcess))
+			}
+			app.cron = cron.New(cronOpts...)
+		}
+
+		setLogLevel(cfg.Log.Level)
+		if reloadable, ok := provider.(config.Reloadable); ok {
+			reloadable.OnReload(func(cfg *config.Config) {
+				setLogLevel(cfg.Log.Level)
+			})
+		}
+	}
+}
+
+func setLogLevel(level string) {
+	if level == "" {
+		return
+	}
+	if err := plog.SetLevel(level); err != nil {
+		log := plog.Get()
+		log.Warn().Err(err).Msg("[PHASTOS][CONFIG] invalid log level")
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/config"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// reloadableConfig is a config.Reloadable whose reloads are triggered by the test
+type reloadableConfig struct {
+	config.Config
+	listeners []func(*config.Config)
+}
+
+func (c *reloadableConfig) OnReload(fn func(*config.Config)) {
+	c.listeners = append(c.listeners, fn)
+}
+
+func TestWithConfig(t *testing.T) {
+	defer func() { _ = plog.SetLevel("") }()
+
+	apiTimeout, pprof := 0, false
+	cfg := &reloadableConfig{}
+	cfg.App = config.App{Timezone: "UTC", APITimeout: &apiTimeout, Pprof: &pprof}
+	cfg.Server.Port = 9100
+	cfg.Server.ReadTimeout = 10
+	cfg.Cron = config.Cron{Enabled: true, Timezone: "UTC", TimeoutProcess: 5}
+	cfg.Log.Level = "warn"
+
+	app := NewApp(WithConfig(cfg), WriteTimeout(7))
+	assert.Equal(t, 9100, app.Port)
+	assert.Equal(t, 10, app.ReadTimeout)
+	assert.Equal(t, 7, app.WriteTimeout, "the options after WithConfig override it")
+	assert.Equal(t, 0, app.apiTimeout)
+	assert.False(t, app.pprofEnabled)
+	assert.Equal(t, "UTC", app.timezoneRegion)
+	assert.NotNil(t, app.cron)
+	assert.Equal(t, zerolog.WarnLevel, plog.Get().GetLevel())
+
+	require.Len(t, cfg.listeners, 1)
+	cfg.Log.Level = "error"
+	cfg.listeners[0](&cfg.Config)
+	assert.Equal(t, zerolog.ErrorLevel, plog.Get().GetLevel())
+}
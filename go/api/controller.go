@@ -43,6 +43,7 @@ type Route struct {
 	SubRoutes      []Route
 	Doc            *RouteDoc
 	PathParamTypes []PathParamType
+	Policy         RoutePolicy
 }
 
 type RouteDoc struct {
@@ -84,6 +85,7 @@ type MiddlewareInfo struct {
 	Description    string
 	SecurityScheme *SecuritySchemeDoc
 	Headers        []HeaderDoc
+	ErrorResponses []ErrorResponseDoc
 }
 
 type RouteOption func(*Route)
@@ -360,6 +362,18 @@ func WithMiddlewareDescription(desc string) MiddlewareOption {
 	}
 }
 
+// WithMiddlewareErrorResponse documents an error response of the middleware, e.g.
+// 429 RATE_LIMITED of a rate limiter, on the routes it applies to
+func WithMiddlewareErrorResponse(statusCode int, code, description string) MiddlewareOption {
+	return func(m *MiddlewareInfo) {
+		m.ErrorResponses = append(m.ErrorResponses, ErrorResponseDoc{
+			StatusCode:  statusCode,
+			Code:        code,
+			Description: description,
+		})
+	}
+}
+
 // stripPathParamTypes removes type annotations from path param patterns.
 // E.g., "/{id:int64}/recap/{month}" → "/{id}/recap/{month}"
 func stripPathParamTypes(path string) string {
@@ -439,25 +453,25 @@ func (app *App) initRequest(r *http.Request) *Request {
 		switch contentType {
 		case ContentJSON:
 			if err := getBodyFromJSON(r, i); err != nil {
-				return BadRequest(err.Error(), ErrParsedBodyCode)
+				return bodyError(r, err)
 			}
 		case ContentURLEncoded:
 			if err := parseFormRequest(r); err != nil {
-				return BadRequest(err.Error(), ErrParsedBodyCode)
+				return bodyError(r, err)
 			}
 			if err := doHandleDecodeSchema(r, i); err != nil {
 				return BadRequest(err.Error(), ErrDecodeBodyCode)
 			}
 		case ContentFormData:
 			if err := parseMultiPartFormRequest(r, 32<<20); err != nil {
-				return BadRequest(err.Error(), ErrParsedBodyCode)
+				return bodyError(r, err)
 			}
 			if err := doHandleDecodeSchema(r, i); err != nil {
 				return BadRequest(err.Error(), ErrDecodeBodyCode)
 			}
 		default:
 			if err := getBodyFromJSON(r, i); err != nil {
-				return BadRequest(err.Error(), ErrParsedBodyCode)
+				return bodyError(r, err)
 			}
 		}
 		return app.requestValidator(i)
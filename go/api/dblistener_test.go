@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+func TestApp_StopDBListenerWhilePostgresIsUnreachable(t *testing.T) {
+	t.Setenv("DATABASE_CONN_STRING_MASTER", "postgres://127.0.0.1:1/phastos?sslmode=disable&connect_timeout=1")
+	app := NewApp(WithTimezone("UTC"), WithDBListener(database.WithReconnectInterval(10*time.Millisecond, 50*time.Millisecond)))
+	require.NoError(t, app.DBListener().Subscribe("orders", func(ctx context.Context, n *database.Notification) error { return nil }))
+
+	stop := app.startDBListener()
+	time.Sleep(100 * time.Millisecond) // Start is waiting for the connection
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stopping the DB listener must not wait for PostgreSQL")
+	}
+}
@@ -0,0 +1,483 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gojson "github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+)
+
+const (
+	ContentMsgPack = "application/msgpack"
+	ContentXML     = "application/xml"
+	ContentCSV     = "text/csv"
+	ContentNDJSON  = "application/x-ndjson"
+)
+
+type (
+	// Encoder serializes a response body for a media type selected from the Accept header.
+	Encoder interface {
+		ContentType() string
+		Encode(w io.Writer, v any) error
+	}
+
+	// ListEncoder is implemented by encoders that only make sense for list data (CSV, NDJSON).
+	// They receive Response.Data without the pagination envelope; non-list data and errors
+	// are sent as JSON.
+	ListEncoder interface {
+		Encoder
+		ListOnly()
+	}
+
+	// StreamEncoder writes list items incrementally, calling flush periodically, instead of
+	// buffering the whole body (compression is skipped for streamed responses).
+	StreamEncoder interface {
+		Encoder
+		Stream(w io.Writer, flush func(), v any) error
+	}
+
+	// JSONEncoder encodes with Marshal (encoding/json when nil).
+	JSONEncoder struct {
+		Marshal func(v any) ([]byte, error)
+	}
+
+	// MsgPackEncoder encodes with MessagePack, honoring json struct tags.
+	MsgPackEncoder struct{}
+
+	// XMLEncoder encodes with encoding/xml, wrapping the payload in a <response> root.
+	XMLEncoder struct{}
+
+	// CSVEncoder encodes a slice of structs / maps / []string with a header row. Column
+	// names come from the `csv` tag, then the `json` tag, then the field name.
+	CSVEncoder struct{}
+
+	// NDJSONEncoder writes one JSON document per slice element per line.
+	NDJSONEncoder struct {
+		// FlushEvery flushes the stream after the given number of items (default 100)
+		FlushEvery int
+	}
+
+	xmlEnvelope struct {
+		XMLName xml.Name `xml:"response"`
+		Data    any      `xml:"data"`
+	}
+
+	// paginatedEnvelope and messageEnvelope keep the JSON shape of the former map bodies
+	// while staying encodable by XML / MessagePack.
+	paginatedEnvelope struct {
+		Data     any `json:"data" xml:"data" msgpack:"data"`
+		Metadata any `json:"metadata" xml:"metadata" msgpack:"metadata"`
+	}
+
+	messageEnvelope struct {
+		Message string `json:"message" xml:"message" msgpack:"message"`
+	}
+)
+
+var errNotList = errors.New("data is not a list")
+
+// builtinEncoders returns the encoders of an App by media type, before WithEncoder
+func builtinEncoders() map[string]Encoder {
+	return map[string]Encoder{
+		ContentJSON:             JSONEncoder{},
+		ContentMsgPack:          MsgPackEncoder{},
+		"application/x-msgpack": MsgPackEncoder{},
+		ContentXML:              XMLEncoder{},
+		"text/xml":              XMLEncoder{},
+		ContentCSV:              CSVEncoder{},
+		ContentNDJSON:           NDJSONEncoder{},
+		"application/jsonl":     NDJSONEncoder{},
+	}
+}
+
+// WithEncoder registers (or replaces) the encoder used by the App when a client accepts mediaType.
+// Registering ContentJSON also replaces the default encoder of the App.
+func WithEncoder(mediaType string, enc Encoder) Options {
+	return func(app *App) {
+		app.responseOptions.setEncoder(mediaType, enc)
+	}
+}
+
+// WithContentNegotiation selects the response encoder from the Accept header
+// (JSON, MessagePack, XML, CSV, NDJSON and any encoder added with WithEncoder).
+// Without it every response is JSON.
+func WithContentNegotiation() Options {
+	return func(app *App) {
+		app.responseOptions.negotiation = true
+	}
+}
+
+// WithFastJSON replaces encoding/json with goccy/go-json for the response bodies of the App.
+func WithFastJSON() Options {
+	return func(app *App) {
+		app.responseOptions.setEncoder(ContentJSON, JSONEncoder{Marshal: gojson.Marshal})
+	}
+}
+
+func (o *responseOptions) setEncoder(mediaType string, enc Encoder) {
+	if enc == nil {
+		return
+	}
+	mediaType = strings.ToLower(mediaType)
+	o.encoders[mediaType] = enc
+	if mediaType == ContentJSON {
+		o.jsonEncoder = enc
+	}
+}
+
+// negotiateEncoder returns the registered encoder with the highest q-value in accept.
+// List-only encoders are skipped when isList is false. Falls back to JSON.
+func (o *responseOptions) negotiateEncoder(accept string, isList bool) Encoder {
+	if !o.negotiation || accept == "" {
+		return o.jsonEncoder
+	}
+
+	var (
+		best      Encoder
+		bestType  string
+		bestQ     float64
+		anyAccept bool
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptPart(part)
+		if q <= 0 || mediaType == "" {
+			continue
+		}
+		var enc Encoder
+		switch {
+		case mediaType == "*/*" || mediaType == "application/*":
+			anyAccept = true
+			enc = o.jsonEncoder
+		default:
+			enc = o.encoders[mediaType]
+		}
+		if enc == nil || q <= bestQ {
+			continue
+		}
+		if _, listOnly := enc.(ListEncoder); listOnly && !isList {
+			continue
+		}
+		best, bestType, bestQ = enc, mediaType, q
+	}
+	// browsers accept XML below HTML next to */*, they get JSON rather than XML
+	if best == nil || (anyAccept && bestQ < 1 && (bestType == ContentXML || bestType == "text/xml")) {
+		return o.jsonEncoder
+	}
+	return best
+}
+
+func parseAcceptPart(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return mediaType, q
+}
+
+// isListData reports whether v is a slice / array (excluding []byte) usable by list encoders.
+func isListData(v any) bool {
+	if v == nil {
+		return false
+	}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	switch val.Kind() {
+	case reflect.Slice:
+		return val.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+func (JSONEncoder) ContentType() string { return ContentJSON }
+
+func (e JSONEncoder) Encode(w io.Writer, v any) error {
+	marshal := e.Marshal
+	if marshal == nil {
+		marshal = json.Marshal
+	}
+	b, err := marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "phastos.api.JSONEncoder.Encode")
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (MsgPackEncoder) ContentType() string { return ContentMsgPack }
+
+func (MsgPackEncoder) Encode(w io.Writer, v any) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(w)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return errors.Wrap(err, "phastos.api.MsgPackEncoder.Encode")
+	}
+	return nil
+}
+
+func (XMLEncoder) ContentType() string { return ContentXML }
+
+func (XMLEncoder) Encode(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	if err := xml.NewEncoder(w).Encode(xmlEnvelope{Data: v}); err != nil {
+		return errors.Wrap(err, "phastos.api.XMLEncoder.Encode")
+	}
+	return nil
+}
+
+func (CSVEncoder) ContentType() string { return ContentCSV }
+
+func (CSVEncoder) ListOnly() {}
+
+func (CSVEncoder) Encode(w io.Writer, v any) error {
+	if !isListData(v) {
+		return errNotList
+	}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	writer := csv.NewWriter(w)
+
+	var header []string
+	var row func(item reflect.Value) []string
+
+	elemType := val.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	switch elemType.Kind() {
+	case reflect.Struct:
+		var fields []int
+		for i := 0; i < elemType.NumField(); i++ {
+			field := elemType.Field(i)
+			name := csvColumnName(field)
+			if !field.IsExported() || name == "" {
+				continue
+			}
+			fields = append(fields, i)
+			header = append(header, name)
+		}
+		row = func(item reflect.Value) []string {
+			record := make([]string, len(fields))
+			if !item.IsValid() {
+				return record
+			}
+			for i, idx := range fields {
+				record[i] = csvValue(item.Field(idx))
+			}
+			return record
+		}
+	case reflect.Map:
+		var keys []reflect.Value
+		if val.Len() > 0 {
+			if first := reflect.Indirect(val.Index(0)); first.Kind() == reflect.Map {
+				keys = first.MapKeys()
+				sort.Slice(keys, func(i, j int) bool {
+					return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+				})
+			}
+		}
+		for _, key := range keys {
+			header = append(header, fmt.Sprint(key.Interface()))
+		}
+		row = func(item reflect.Value) []string {
+			record := make([]string, len(keys))
+			if !item.IsValid() {
+				return record
+			}
+			for i, key := range keys {
+				record[i] = csvValue(item.MapIndex(key))
+			}
+			return record
+		}
+	case reflect.Slice, reflect.Array:
+		row = func(item reflect.Value) []string {
+			if !item.IsValid() {
+				return nil
+			}
+			record := make([]string, item.Len())
+			for i := range record {
+				record[i] = csvValue(item.Index(i))
+			}
+			return record
+		}
+	default:
+		header = []string{"value"}
+		row = func(item reflect.Value) []string {
+			return []string{csvValue(item)}
+		}
+	}
+
+	if header != nil {
+		if err := writer.Write(header); err != nil {
+			return errors.Wrap(err, "phastos.api.CSVEncoder.Encode")
+		}
+	}
+	for i := 0; i < val.Len(); i++ {
+		item := val.Index(i)
+		for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+			if item.IsNil() {
+				item = reflect.Value{}
+				break
+			}
+			item = item.Elem()
+		}
+		if err := writer.Write(row(item)); err != nil {
+			return errors.Wrap(err, "phastos.api.CSVEncoder.Encode")
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvColumnName(field reflect.StructField) string {
+	for _, tagName := range []string{"csv", "json"} {
+		if tag, ok := field.Tag.Lookup(tagName); ok {
+			name := strings.Split(tag, ",")[0]
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
+
+func csvValue(val reflect.Value) string {
+	for val.IsValid() && (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) {
+		if val.IsNil() {
+			return ""
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() {
+		return ""
+	}
+	switch v := val.Interface().(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+	switch val.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		b, _ := json.Marshal(val.Interface())
+		return string(b)
+	}
+	return fmt.Sprint(val.Interface())
+}
+
+func (NDJSONEncoder) ContentType() string { return ContentNDJSON }
+
+func (NDJSONEncoder) ListOnly() {}
+
+func (e NDJSONEncoder) Encode(w io.Writer, v any) error {
+	return e.Stream(w, nil, v)
+}
+
+func (e NDJSONEncoder) Stream(w io.Writer, flush func(), v any) error {
+	if !isListData(v) {
+		return errNotList
+	}
+	flushEvery := e.FlushEvery
+	if flushEvery <= 0 {
+		flushEvery = 100
+	}
+	val := reflect.Indirect(reflect.ValueOf(v))
+	enc := json.NewEncoder(w)
+	for i := 0; i < val.Len(); i++ {
+		if err := enc.Encode(val.Index(i).Interface()); err != nil {
+			return errors.Wrap(err, "phastos.api.NDJSONEncoder.Stream")
+		}
+		if flush != nil && (i+1)%flushEvery == 0 {
+			flush()
+		}
+	}
+	if flush != nil {
+		flush()
+	}
+	return nil
+}
+
+// encodedBody is the negotiated response body shared by Response.Send and fastSendResponse.
+type encodedBody struct {
+	buf             *bytes.Buffer
+	body            []byte
+	contentType     string
+	contentEncoding string
+	// stream is set when the negotiated encoder streams streamData instead of buffering it
+	stream     StreamEncoder
+	streamData any
+}
+
+// encodeResponseBody encodes payload with the encoder negotiated from accept. List encoders
+// receive listData instead of payload; problem details are always JSON. Encoding failures
+// fall back to JSON.
+func (o *responseOptions) encodeResponseBody(payload, listData any, isProblem bool, accept, acceptEncoding string) *encodedBody {
+	enc := o.jsonEncoder
+	if !isProblem {
+		enc = o.negotiateEncoder(accept, isListData(listData))
+	}
+	if _, listOnly := enc.(ListEncoder); listOnly {
+		payload = listData
+	}
+	if streamer, ok := enc.(StreamEncoder); ok {
+		return &encodedBody{contentType: enc.ContentType(), stream: streamer, streamData: payload}
+	}
+
+	result := &encodedBody{buf: common.GetBuffer(nil), contentType: enc.ContentType()}
+	if err := enc.Encode(result.buf, payload); err != nil {
+		result.buf.Reset()
+		result.contentType = o.jsonEncoder.ContentType()
+		_ = o.jsonEncoder.Encode(result.buf, payload)
+	}
+	if isProblem {
+		result.contentType = ContentProblemJSON
+	}
+	result.body, result.contentEncoding = o.compressBody(result.buf.Bytes(), acceptEncoding)
+	return result
+}
+
+func (b *encodedBody) release() {
+	if b.buf != nil {
+		common.PutBuffer(b.buf)
+		b.buf = nil
+	}
+}
+
+// varyHeader returns the Vary header value for negotiated responses ("" when nothing is negotiated).
+func (o *responseOptions) varyHeader() string {
+	switch {
+	case o.negotiation && o.compressionMinSize > 0:
+		return "Accept, Accept-Encoding"
+	case o.negotiation:
+		return "Accept"
+	case o.compressionMinSize > 0:
+		return "Accept-Encoding"
+	}
+	return ""
+}
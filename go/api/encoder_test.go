@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+type encoderTestUser struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name" csv:"full_name"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Tags      []string  `json:"tags"`
+}
+
+// enableNegotiation returns the response options of an App with content negotiation
+func enableNegotiation(opts ...Options) *responseOptions {
+	return NewApp(append([]Options{WithContentNegotiation()}, opts...)...).responseOptions
+}
+
+func sendWithHeaders(options *responseOptions, resp *Response, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	resp.Negotiate(r).options = options
+	resp.Send(w)
+	ReleaseResponse(resp)
+	return w
+}
+
+func TestNegotiateEncoder(t *testing.T) {
+	t.Run("should always use JSON when negotiation is disabled", func(t *testing.T) {
+		assert.IsType(t, JSONEncoder{}, NewApp().responseOptions.negotiateEncoder(ContentXML, true))
+	})
+
+	options := enableNegotiation()
+	tests := []struct {
+		name   string
+		accept string
+		isList bool
+		want   Encoder
+	}{
+		{"empty accept", "", true, JSONEncoder{}},
+		{"wildcard", "*/*", true, JSONEncoder{}},
+		{"exact", "application/msgpack", false, MsgPackEncoder{}},
+		{"alias", "text/xml", false, XMLEncoder{}},
+		{"highest q wins", "application/json;q=0.5, application/xml;q=0.9", false, XMLEncoder{}},
+		{"list only encoder for list", "text/csv", true, CSVEncoder{}},
+		{"list only encoder skipped for non list", "text/csv", false, JSONEncoder{}},
+		{"unknown falls back to JSON", "application/pdf", true, JSONEncoder{}},
+		{"q zero ignored", "application/xml;q=0", true, JSONEncoder{}},
+		{"browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false, JSONEncoder{}},
+		{"browser wildcard first", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*", false, JSONEncoder{}},
+		{"xml below wildcard", "application/xml, */*;q=0.1", false, XMLEncoder{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.IsType(t, tt.want, options.negotiateEncoder(tt.accept, tt.isList))
+		})
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	users := []*encoderTestUser{
+		{ID: 1, Name: "John, Doe", Secret: "x", CreatedAt: createdAt, Tags: []string{"a"}},
+		nil,
+	}
+
+	t.Run("csv from structs", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, CSVEncoder{}.Encode(&buf, users))
+		assert.Equal(t, "id,full_name,created_at,tags\n1,\"John, Doe\",2026-01-02T03:04:05Z,\"[\"\"a\"\"]\"\n,,,\n", buf.String())
+	})
+
+	t.Run("csv from maps and slices", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, CSVEncoder{}.Encode(&buf, []map[string]any{{"b": 2, "a": "x"}}))
+		assert.Equal(t, "a,b\nx,2\n", buf.String())
+
+		buf.Reset()
+		require.NoError(t, CSVEncoder{}.Encode(&buf, [][]string{{"a", "b"}, {"c", "d"}}))
+		assert.Equal(t, "a,b\nc,d\n", buf.String())
+
+		assert.ErrorIs(t, CSVEncoder{}.Encode(&buf, map[string]int{"a": 1}), errNotList)
+	})
+
+	t.Run("ndjson streams items", func(t *testing.T) {
+		var buf bytes.Buffer
+		flushes := 0
+		require.NoError(t, NDJSONEncoder{FlushEvery: 1}.Stream(&buf, func() { flushes++ }, []int{1, 2, 3}))
+		assert.Equal(t, "1\n2\n3\n", buf.String())
+		assert.Equal(t, 4, flushes)
+	})
+
+	t.Run("xml wraps payload", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, XMLEncoder{}.Encode(&buf, messageEnvelope{Message: "ok"}))
+		assert.Contains(t, buf.String(), "<response><data><message>ok</message></data></response>")
+	})
+
+	t.Run("msgpack honors json tags", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, MsgPackEncoder{}.Encode(&buf, users[0]))
+		var decoded map[string]any
+		require.NoError(t, msgpack.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, "John, Doe", decoded["name"])
+		assert.NotContains(t, decoded, "Secret")
+	})
+}
+
+func TestResponse_SendNegotiated(t *testing.T) {
+	options := enableNegotiation()
+	list := []encoderTestUser{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	t.Run("csv uses list without pagination envelope", func(t *testing.T) {
+		resp := NewResponse().SetData(&database.SelectResponse{
+			Data:             list,
+			ResponseMetaData: &database.ResponseMetaData{TotalData: 2},
+		}, true)
+		w := sendWithHeaders(options, resp, map[string]string{"Accept": ContentCSV})
+		assert.Equal(t, ContentCSV, w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "id,full_name,created_at,tags\n1,a,"))
+	})
+
+	t.Run("ndjson is streamed", func(t *testing.T) {
+		w := sendWithHeaders(options, NewResponse().SetData(list), map[string]string{"Accept": ContentNDJSON})
+		assert.Equal(t, ContentNDJSON, w.Header().Get("Content-Type"))
+		assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+		assert.True(t, w.Flushed)
+	})
+
+	t.Run("errors use JSON for list only encoders", func(t *testing.T) {
+		w := sendWithHeaders(options, NewResponse().SetError(NotFound("not found", "NOT_FOUND")), map[string]string{"Accept": ContentCSV})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, ContentJSON, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `"code":"NOT_FOUND"`)
+	})
+
+	t.Run("paginated JSON keeps the envelope shape", func(t *testing.T) {
+		resp := NewResponse().SetData(&database.SelectResponse{
+			Data:             []int{1},
+			ResponseMetaData: &database.ResponseMetaData{TotalData: 1},
+		}, true)
+		w := sendWithHeaders(options, resp, nil)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, []any{float64(1)}, body["data"])
+		assert.NotNil(t, body["metadata"])
+	})
+
+	t.Run("fast JSON encoder", func(t *testing.T) {
+		options := enableNegotiation(WithFastJSON())
+		assert.NotNil(t, options.jsonEncoder.(JSONEncoder).Marshal) //nolint:errcheck
+		w := sendWithHeaders(options, NewResponse().SetMessage("hello"), nil)
+		assert.JSONEq(t, `{"message":"hello"}`, w.Body.String())
+		assert.Nil(t, NewApp().responseOptions.jsonEncoder.(JSONEncoder).Marshal, "the encoders of another App are kept") //nolint:errcheck
+	})
+
+	t.Run("failed encoding falls back to the JSON encoder of the App", func(t *testing.T) {
+		options := enableNegotiation()
+		var calls int
+		options.jsonEncoder = JSONEncoder{Marshal: func(v any) ([]byte, error) {
+			calls++
+			return json.Marshal(v)
+		}}
+		w := sendWithHeaders(options, NewResponse().SetData(map[string]int{"id": 1}), map[string]string{"Accept": ContentXML})
+		assert.Equal(t, ContentJSON, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `"id":1`)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("encoder of the App", func(t *testing.T) {
+		options := enableNegotiation(WithEncoder("Application/Vnd.Custom", XMLEncoder{}))
+		w := sendWithHeaders(options, NewResponse().SetMessage("hello"), map[string]string{"Accept": "application/vnd.custom"})
+		assert.Equal(t, ContentXML, w.Header().Get("Content-Type"))
+	})
+}
+
+func TestResponse_SendCompressed(t *testing.T) {
+	options := NewApp(WithCompression(64)).responseOptions
+	large := strings.Repeat("phastos ", 50)
+
+	t.Run("gzip above threshold", func(t *testing.T) {
+		w := sendWithHeaders(options, NewResponse().SetMessage(large), map[string]string{"Accept-Encoding": "gzip, deflate"})
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		reader, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), large)
+	})
+
+	t.Run("brotli preferred", func(t *testing.T) {
+		w := sendWithHeaders(options, NewResponse().SetMessage(large), map[string]string{"Accept-Encoding": "gzip, br"})
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		body, err := io.ReadAll(brotli.NewReader(w.Body))
+		require.NoError(t, err)
+		assert.Contains(t, string(body), large)
+	})
+
+	t.Run("below threshold or not accepted", func(t *testing.T) {
+		w := sendWithHeaders(options, NewResponse().SetMessage("small"), map[string]string{"Accept-Encoding": "gzip"})
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+		w = sendWithHeaders(options, NewResponse().SetMessage(large), map[string]string{"Accept-Encoding": "gzip;q=0, identity"})
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+		w = sendWithHeaders(options, NewResponse().SetMessage(large), map[string]string{"Accept-Encoding": "gzip;q=0, *"})
+		assert.Empty(t, w.Header().Get("Content-Encoding"), "* does not cover a refused coding")
+
+		w = sendWithHeaders(options, NewResponse().SetMessage(large), map[string]string{"Accept-Encoding": "br;q=0, *"})
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	})
+}
+
+func TestFastSendResponse_Negotiated(t *testing.T) {
+	options := enableNegotiation(WithCompression(1))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Accept", ContentMsgPack)
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	resp := NewResponse().SetData(map[string]int{"id": 1})
+	resp.options = options
+	fastSendResponse(ctx, resp)
+	ReleaseResponse(resp)
+
+	assert.Equal(t, ContentMsgPack, string(ctx.Response.Header.ContentType()))
+	assert.Equal(t, "gzip", string(ctx.Response.Header.Peek("Content-Encoding")))
+	body, err := ctx.Response.BodyGunzip()
+	require.NoError(t, err)
+	var decoded map[string]int
+	require.NoError(t, msgpack.Unmarshal(body, &decoded))
+	assert.Equal(t, 1, decoded["id"])
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Accept", ContentNDJSON)
+	resp = NewResponse().SetData([]int{1, 2})
+	resp.options = options
+	fastSendResponse(ctx, resp)
+	ReleaseResponse(resp)
+	assert.Equal(t, ContentNDJSON, string(ctx.Response.Header.ContentType()))
+	assert.True(t, ctx.Response.IsBodyStream())
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	custerr "github.com/kodekoding/phastos/v2/go/error"
+)
+
+// ErrorMapper converts err into an *HttpError. Return ok = false to let the next mapper
+// (or the built-in database error mapping) handle err.
+type ErrorMapper func(err error) (httpErr *HttpError, ok bool)
+
+type errorRegistryEntry struct {
+	Code    string
+	Message string
+	opts    []ErrorOption
+}
+
+var (
+	errorRegistryMu    sync.RWMutex
+	errorMappers       []ErrorMapper
+	constraintRegistry = defaultConstraints()
+	sqlStateRegistry   = map[string]errorRegistryEntry{}
+)
+
+// defaultConstraints returns the constraints mapped before RegisterConstraint existed, kept so
+// their error codes do not change. RegisterConstraint overrides them.
+func defaultConstraints() map[string]errorRegistryEntry {
+	return map[string]errorRegistryEntry{
+		"users_email_key":                                 {Code: "EMAIL_ALREADY_EXISTS", Message: "Email already registered"},
+		"users_ktp_no_key":                                {Code: "NIK_ALREADY_EXISTS", Message: "NIK already exists"},
+		"clients_code_key":                                {Code: "CLIENT_CODE_EXISTS", Message: "Client code already exists"},
+		"clients_email_key":                               {Code: "CLIENT_EMAIL_EXISTS", Message: "Client email already exists"},
+		"accounts_alias_name_key":                         {Code: "ALIAS_NAME_EXISTS", Message: "Alias name already exists"},
+		"client_locations_code_key":                       {Code: "LOCATION_CODE_EXISTS", Message: "Location code already exists"},
+		"payroll_codes_code_key":                          {Code: "PAYROLL_CODE_EXISTS", Message: "Payroll code already exists"},
+		"unique_employee_id_string":                       {Code: "EMPLOYEE_ID_EXISTS", Message: "Employee ID already exists"},
+		"idx_job_levels_name_unique":                      {Code: "JOB_LEVEL_NAME_EXISTS", Message: "Job level name already exists"},
+		"unique_shift_name":                               {Code: "SHIFT_NAME_EXISTS", Message: "Shift name already exists"},
+		"employee_period_payslips":                        {Code: "PAYSLIP_PERIOD_EXISTS", Message: "Payslip for this period already exists"},
+		"unique_employee_and_date_idx":                    {Code: "PAYSLIP_PERIOD_EXISTS", Message: "Payslip for this period already exists"},
+		"unique_account_position_level_combination":       {Code: "POSITION_LEVEL_COMBINATION_EXISTS", Message: "Position-level combination already exists"},
+		"user_group_privillege_user_group_id_menu_id_key": {Code: "DUPLICATE_GROUP_PRIVILEGE", Message: "Duplicate group privilege"},
+	}
+}
+
+// RegisterErrorMapper adds a custom mapper consulted by Response.SetError for every error
+// that is not already an *HttpError. Mappers run in registration order, first match wins.
+func RegisterErrorMapper(mapper ErrorMapper) {
+	if mapper == nil {
+		return
+	}
+	errorRegistryMu.Lock()
+	defer errorRegistryMu.Unlock()
+	errorMappers = append(errorMappers, mapper)
+}
+
+// RegisterSentinelError maps every error matching target (errors.Is) to httpErr.
+//
+//	api.RegisterSentinelError(repository.ErrUserNotFound, api.NotFound("User not found", "USER_NOT_FOUND"))
+func RegisterSentinelError(target error, httpErr *HttpError) {
+	RegisterErrorMapper(func(err error) (*HttpError, bool) {
+		if errors.Is(err, target) {
+			return cloneHttpError(httpErr), true
+		}
+		return nil, false
+	})
+}
+
+// RegisterErrorType maps every error of type T (errors.As) using fn.
+//
+//	api.RegisterErrorType(func(err *usecase.QuotaError) *api.HttpError {
+//	    return api.TooManyRequest(err.Error(), "QUOTA_EXCEEDED")
+//	})
+func RegisterErrorType[T error](fn func(err T) *HttpError) {
+	RegisterErrorMapper(func(err error) (*HttpError, bool) {
+		var target T
+		if errors.As(err, &target) {
+			if httpErr := fn(target); httpErr != nil {
+				return httpErr, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// RegisterConstraint maps a database constraint / unique key name (Postgres constraint,
+// MySQL key name without the table prefix) to a specific error code and message.
+// The status follows the violation (409 for unique, 422 for check) unless overridden
+// with WithErrorStatus.
+//
+//	api.RegisterConstraint("users_email_key", "EMAIL_ALREADY_EXISTS", "Email already registered")
+func RegisterConstraint(constraint, code, message string, opts ...ErrorOption) {
+	errorRegistryMu.Lock()
+	defer errorRegistryMu.Unlock()
+	constraintRegistry[constraint] = errorRegistryEntry{Code: code, Message: message, opts: opts}
+}
+
+// RegisterSQLState maps a driver error code — Postgres SQLSTATE (e.g. "23503") or MySQL
+// error number (e.g. "1452") — to an error code and message. Codes that are not mapped by
+// the database layer are treated as 500 unless a status is given with WithErrorStatus.
+//
+//	api.RegisterSQLState("23503", "REFERENCE_NOT_FOUND", "Referenced data not found", api.WithErrorStatus(http.StatusUnprocessableEntity))
+func RegisterSQLState(state, code, message string, opts ...ErrorOption) {
+	errorRegistryMu.Lock()
+	defer errorRegistryMu.Unlock()
+	sqlStateRegistry[state] = errorRegistryEntry{Code: code, Message: message, opts: opts}
+}
+
+// ResetErrorMappers removes every registered mapper, constraint and SQL state, keeping the built-in
+// constraints. It is mainly useful in tests.
+func ResetErrorMappers() {
+	errorRegistryMu.Lock()
+	defer errorRegistryMu.Unlock()
+	errorMappers = nil
+	constraintRegistry = defaultConstraints()
+	sqlStateRegistry = map[string]errorRegistryEntry{}
+}
+
+// mapError runs the registered mappers, then maps database errors surfaced as
+// *custerr.RequestError (constraint → SQL state → generic by status).
+func mapError(err error) (*HttpError, bool) {
+	errorRegistryMu.RLock()
+	mappers := errorMappers
+	errorRegistryMu.RUnlock()
+
+	for _, mapper := range mappers {
+		if httpErr, ok := mapper(err); ok && httpErr != nil {
+			return httpErr, true
+		}
+	}
+
+	var reqErr *custerr.RequestError
+	if !errors.As(err, &reqErr) {
+		return nil, false
+	}
+
+	status := reqErr.GetCode()
+	constraint, _ := reqErr.GetData()["constraint"].(string)
+	sqlState, _ := reqErr.GetData()["sql_state"].(string)
+
+	errorRegistryMu.RLock()
+	entry, found := constraintRegistry[constraint]
+	if !found || constraint == "" {
+		entry, found = sqlStateRegistry[sqlState]
+	}
+	errorRegistryMu.RUnlock()
+
+	if found {
+		if status != http.StatusConflict && status != http.StatusUnprocessableEntity {
+			status = http.StatusInternalServerError
+		}
+		opts := append([]ErrorOption{WithErrorStatus(status), WithErrorCode(entry.Code), WithErrorMessage(entry.Message)}, entry.opts...)
+		return NewErr(opts...), true
+	}
+
+	switch status {
+	case http.StatusConflict:
+		return ConflictError("Data already exists", "DATA_CONFLICT"), true
+	case http.StatusUnprocessableEntity:
+		return UnprocessableEntity("Data validation failed", "CONSTRAINT_VIOLATION"), true
+	}
+	return nil, false
+}
+
+func cloneHttpError(httpErr *HttpError) *HttpError {
+	clone := *httpErr
+	return &clone
+}
+
+// problemTypeSlug turns an error code into the last segment of a problem type URI (DATA_CONFLICT → data-conflict).
+func problemTypeSlug(code string) string {
+	return strings.ReplaceAll(strings.ToLower(code), "_", "-")
+}
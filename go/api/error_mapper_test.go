@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	custerr "github.com/kodekoding/phastos/v2/go/error"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+type quotaError struct {
+	limit int
+}
+
+func (e *quotaError) Error() string { return fmt.Sprintf("quota %d exceeded", e.limit) }
+
+func dbError(status int, constraint, sqlState string) error {
+	reqErr := custerr.New(assert.AnError).SetCode(status)
+	reqErr.AppendData("constraint", constraint)
+	reqErr.AppendData("sql_state", sqlState)
+	return errors.Wrap(reqErr, "repository.Insert")
+}
+
+func TestErrorMapper_Sentinel(t *testing.T) {
+	t.Cleanup(ResetErrorMappers)
+	notFound := NotFound("User not found", "USER_NOT_FOUND")
+	RegisterSentinelError(errUserNotFound, notFound)
+
+	resp := NewResponse().SetError(errors.Wrap(errUserNotFound, "usecase.GetUser"))
+	defer ReleaseResponse(resp)
+	require.NotNil(t, resp.InternalError)
+	assert.Equal(t, http.StatusNotFound, resp.InternalError.Status)
+	assert.Equal(t, "USER_NOT_FOUND", resp.InternalError.Code)
+	assert.NotSame(t, notFound, resp.InternalError)
+}
+
+func TestErrorMapper_Type(t *testing.T) {
+	t.Cleanup(ResetErrorMappers)
+	RegisterErrorType(func(err *quotaError) *HttpError {
+		return TooManyRequest(err.Error(), "QUOTA_EXCEEDED")
+	})
+
+	resp := NewResponse().SetError(errors.Wrap(&quotaError{limit: 10}, "usecase.Upload"))
+	defer ReleaseResponse(resp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.InternalError.Status)
+	assert.Equal(t, "quota 10 exceeded", resp.InternalError.Message)
+}
+
+func TestErrorMapper_CustomMapperOrder(t *testing.T) {
+	t.Cleanup(ResetErrorMappers)
+	RegisterErrorMapper(nil)
+	RegisterErrorMapper(func(err error) (*HttpError, bool) { return nil, false })
+	RegisterErrorMapper(func(err error) (*HttpError, bool) {
+		return BadRequest("first", "FIRST"), true
+	})
+	RegisterErrorMapper(func(err error) (*HttpError, bool) {
+		return BadRequest("second", "SECOND"), true
+	})
+
+	resp := NewResponse().SetError(dbError(http.StatusConflict, "users_email_key", "23505"))
+	defer ReleaseResponse(resp)
+	assert.Equal(t, "FIRST", resp.InternalError.Code)
+}
+
+func TestErrorMapper_DatabaseErrors(t *testing.T) {
+	t.Cleanup(ResetErrorMappers)
+	RegisterConstraint("users_email_key", "EMAIL_ALREADY_EXISTS", "Email already registered")
+	RegisterConstraint("chk_age", "INVALID_AGE", "Age is invalid", WithErrorStatus(http.StatusBadRequest))
+	RegisterSQLState("23503", "REFERENCE_NOT_FOUND", "Referenced data not found", WithErrorStatus(http.StatusUnprocessableEntity))
+	RegisterSQLState("1062", "DUPLICATE", "Duplicate data")
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"postgres unique constraint", dbError(http.StatusConflict, "users_email_key", "23505"), http.StatusConflict, "EMAIL_ALREADY_EXISTS"},
+		{"mysql duplicate key uses same constraint", dbError(http.StatusConflict, "users_email_key", "1062"), http.StatusConflict, "EMAIL_ALREADY_EXISTS"},
+		{"status override", dbError(http.StatusUnprocessableEntity, "chk_age", "23514"), http.StatusBadRequest, "INVALID_AGE"},
+		{"sql state unmapped by database layer", dbError(http.StatusInternalServerError, "orders_user_id_fkey", "23503"), http.StatusUnprocessableEntity, "REFERENCE_NOT_FOUND"},
+		{"sql state fallback without constraint", dbError(http.StatusConflict, "", "1062"), http.StatusConflict, "DUPLICATE"},
+		{"built-in constraint", dbError(http.StatusConflict, "clients_code_key", "23505"), http.StatusConflict, "CLIENT_CODE_EXISTS"},
+		{"unregistered conflict", dbError(http.StatusConflict, "other_key", "23505"), http.StatusConflict, "DATA_CONFLICT"},
+		{"unregistered check", dbError(http.StatusUnprocessableEntity, "other_check", "3819"), http.StatusUnprocessableEntity, "CONSTRAINT_VIOLATION"},
+		{"unregistered other", dbError(http.StatusInternalServerError, "", "42P01"), http.StatusInternalServerError, "INTERNAL_SERVER_ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := NewResponse().SetError(tt.err)
+			defer ReleaseResponse(resp)
+			assert.Equal(t, tt.status, resp.InternalError.Status)
+			assert.Equal(t, tt.code, resp.InternalError.Code)
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	app := NewApp(WithProblemDetails("https://errors.example.com/"))
+
+	t.Run("should render validation errors as problem+json", func(t *testing.T) {
+		resp := NewResponse().SetError(NewErr(
+			WithErrorCode("VALIDATION_ERROR"),
+			WithErrorMessage("validation error"),
+			WithErrorStatus(http.StatusUnprocessableEntity),
+			WithErrorData([]*ValidationError{{Field: "User.Email", Tag: "required"}}),
+		))
+		resp.TraceId = "trace-1"
+		resp.instance = "/users"
+		resp.options = app.responseOptions
+		w := httptest.NewRecorder()
+		resp.Send(w)
+		ReleaseResponse(resp)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, ContentProblemJSON, w.Header().Get("Content-Type"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "https://errors.example.com/validation-error", body["type"])
+		assert.Equal(t, "Unprocessable Entity", body["title"])
+		assert.Equal(t, float64(422), body["status"])
+		assert.Equal(t, "validation error", body["detail"])
+		assert.Equal(t, "/users", body["instance"])
+		assert.Equal(t, "trace-1", body["trace_id"])
+		require.Len(t, body["errors"], 1)
+		assert.Nil(t, body["data"])
+	})
+
+	t.Run("should write problem for the errors of the App", func(t *testing.T) {
+		app := NewApp(WithProblemDetails())
+		w := httptest.NewRecorder()
+		app.WriteHttpError(w, httptest.NewRequest(http.MethodGet, "/users", nil), Unauthorized("invalid token", "UNAUTHORIZED"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, ContentProblemJSON, w.Header().Get("Content-Type"))
+		var problem ProblemDetails
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, "invalid token", problem.Detail)
+		assert.Equal(t, "/users", problem.Instance)
+	})
+
+	t.Run("should keep the settings per App", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		resp := NewResponse().SetError(NotFound("User not found", "USER_NOT_FOUND"))
+		NewApp().handleResponseError(resp, r, "trace-3", r.Context())
+		w := httptest.NewRecorder()
+		resp.Send(w)
+		ReleaseResponse(resp)
+		assert.NotEqual(t, ContentProblemJSON, w.Header().Get("Content-Type"), "the problem details of another App are not used")
+
+		w = httptest.NewRecorder()
+		Unauthorized("invalid token", "UNAUTHORIZED").Write(w)
+		assert.NotEqual(t, ContentProblemJSON, w.Header().Get("Content-Type"))
+	})
+
+	t.Run("should fill instance from request path", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/users/1?x=1", nil)
+		resp := NewResponse().SetError(NotFound("User not found", "USER_NOT_FOUND"))
+		app.handleResponseError(resp, r, "trace-2", r.Context())
+		w := httptest.NewRecorder()
+		resp.Send(w)
+		ReleaseResponse(resp)
+
+		var problem ProblemDetails
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, "/users/1", problem.Instance)
+		assert.Equal(t, http.StatusNotFound, problem.Status)
+	})
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"strings"
 )
 
 type HttpError struct {
@@ -15,11 +16,55 @@ type HttpError struct {
 
 type ErrorOption func(*HttpError)
 
+// ProblemDetails is the RFC 7807 (application/problem+json) representation of an HttpError,
+// rendered instead of the default shape when the App is created WithProblemDetails.
+type ProblemDetails struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	TraceId  string      `json:"trace_id,omitempty"`
+	Errors   interface{} `json:"errors,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+const ContentProblemJSON = "application/problem+json"
+
 func (e *HttpError) Write(w http.ResponseWriter) {
 	w.WriteHeader(e.Status)
 	WriteJson(w, e)
 }
 
+// Problem converts the error to RFC 7807 problem details of type "about:blank". Field-level
+// validation errors (Data of type []*ValidationError) are exposed as "errors".
+func (e *HttpError) Problem(instance string) *ProblemDetails {
+	return e.problem(instance, "")
+}
+
+// problem converts the error to problem details, typed from its code under typeBaseURI when given
+func (e *HttpError) problem(instance, typeBaseURI string) *ProblemDetails {
+	problem := &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.Status),
+		Status:   e.Status,
+		Detail:   e.Message,
+		Instance: instance,
+		Code:     e.Code,
+		TraceId:  e.TraceId,
+	}
+	if typeBaseURI != "" && e.Code != "" {
+		problem.Type = strings.TrimSuffix(typeBaseURI, "/") + "/" + problemTypeSlug(e.Code)
+	}
+	if validationErrs, ok := e.Data.([]*ValidationError); ok {
+		problem.Errors = validationErrs
+	} else {
+		problem.Data = e.Data
+	}
+	return problem
+}
+
 func (e *HttpError) Error() string {
 	return e.Message
 }
@@ -138,29 +183,28 @@ func TooManyRequest(message, code string) *HttpError {
 	}
 }
 
-type constraintRegistryEntry struct {
-	Code    string
-	Message string
-}
-
-// constraintRegistry maps PostgreSQL constraint names to specific error codes and messages.
-// When a constraint violation is detected, SetError looks up the constraint name here
-// before falling back to generic DATA_CONFLICT / CONSTRAINT_VIOLATION.
-var constraintRegistry = map[string]constraintRegistryEntry{
-	"users_email_key":                                 {Code: "EMAIL_ALREADY_EXISTS", Message: "Email already registered"},
-	"users_ktp_no_key":                                {Code: "NIK_ALREADY_EXISTS", Message: "NIK already exists"},
-	"clients_code_key":                                {Code: "CLIENT_CODE_EXISTS", Message: "Client code already exists"},
-	"clients_email_key":                               {Code: "CLIENT_EMAIL_EXISTS", Message: "Client email already exists"},
-	"accounts_alias_name_key":                         {Code: "ALIAS_NAME_EXISTS", Message: "Alias name already exists"},
-	"client_locations_code_key":                       {Code: "LOCATION_CODE_EXISTS", Message: "Location code already exists"},
-	"payroll_codes_code_key":                          {Code: "PAYROLL_CODE_EXISTS", Message: "Payroll code already exists"},
-	"user_group_privillege_user_group_id_menu_id_key": {Code: "DUPLICATE_GROUP_PRIVILEGE", Message: "Duplicate group privilege"},
-	"unique_employee_id_string":                       {Code: "EMPLOYEE_ID_EXISTS", Message: "Employee ID already exists"},
-	"idx_job_levels_name_unique":                      {Code: "JOB_LEVEL_NAME_EXISTS", Message: "Job level name already exists"},
-	"unique_account_position_level_combination":       {Code: "POSITION_LEVEL_COMBINATION_EXISTS", Message: "Position-level combination already exists"},
-	"unique_shift_name":                               {Code: "SHIFT_NAME_EXISTS", Message: "Shift name already exists"},
-	"employee_period_payslips":                        {Code: "PAYSLIP_PERIOD_EXISTS", Message: "Payslip for this period already exists"},
-	"unique_employee_and_date_idx":                    {Code: "PAYSLIP_PERIOD_EXISTS", Message: "Payslip for this period already exists"},
+func PayloadTooLarge(message, code string) *HttpError {
+	return &HttpError{
+		Code:    code,
+		Message: message,
+		Status:  413,
+	}
+}
+
+func ServiceUnavailable(message, code string) *HttpError {
+	return &HttpError{
+		Code:    code,
+		Message: message,
+		Status:  503,
+	}
+}
+
+func GatewayTimeout(message, code string) *HttpError {
+	return &HttpError{
+		Code:    code,
+		Message: message,
+		Status:  504,
+	}
 }
 
 func ConflictError(message, code string) *HttpError {
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+)
+
+// --- net/http adapters for the fasthttp-native path ---
+// They let FastHttpApp run Controller routes and the net/http middlewares (JWTAuth, StaticAuth,
+// rate limiter, ...) of the middlewares package. Adapted handlers pay for the conversion to
+// *http.Request; FastRoute handlers are unaffected.
+
+// fastContextKey is the UserValue holding the context.Context of a fasthttp request
+const fastContextKey = "phastos_context"
+
+// FastContext returns the context.Context of a fasthttp request, carrying the values set by the
+// adapted net/http middlewares (e.g. the JWT claims) and FastJWTAuth.
+func FastContext(ctx *fasthttp.RequestCtx) context.Context {
+	if c, ok := ctx.UserValue(fastContextKey).(context.Context); ok {
+		return c
+	}
+	return cachedBackground
+}
+
+// setFastContext replaces the context.Context returned by FastContext
+func setFastContext(ctx *fasthttp.RequestCtx, c context.Context) {
+	ctx.SetUserValue(fastContextKey, c)
+}
+
+// FastAdaptMiddleware runs a net/http middleware on the fasthttp-native path.
+// The headers it sets are kept, the values it adds to the request context are available
+// through FastContext, and a middleware that responds without calling next (e.g. 401) ends the request.
+func FastAdaptMiddleware(mw func(http.Handler) http.Handler) FastMiddleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			r, err := fastHTTPRequest(ctx)
+			if err != nil {
+				fastBadRequest(ctx, err)
+				return
+			}
+			w := newFastResponseWriter(ctx)
+			mw(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				w.flushHeader()
+				setFastContext(ctx, r.Context())
+				next(ctx)
+			})).ServeHTTP(w, r)
+		}
+	}
+}
+
+// FastAdaptHandler runs a net/http handler on the fasthttp-native path.
+func FastAdaptHandler(h http.Handler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		r, err := fastHTTPRequest(ctx)
+		if err != nil {
+			fastBadRequest(ctx, err)
+			return
+		}
+		h.ServeHTTP(newFastResponseWriter(ctx), r)
+	}
+}
+
+// fastRouteHandler runs the net/http handler of a Controller route registered on path, exposing
+// the path params matched by the fastRouter as chi URL params (Request.GetParams, HandlerV2 binding)
+func fastRouteHandler(path string, h http.Handler) fasthttp.RequestHandler {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if param, kind := parseFastSegment(segment); kind != fastSegmentStatic {
+			names = append(names, param.name)
+		}
+	}
+	if len(names) == 0 {
+		return FastAdaptHandler(h)
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		r, err := fastHTTPRequest(ctx)
+		if err != nil {
+			fastBadRequest(ctx, err)
+			return
+		}
+		rctx := chi.NewRouteContext()
+		for _, name := range names {
+			value, _ := ctx.UserValue(name).(string)
+			rctx.URLParams.Add(name, value)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		h.ServeHTTP(newFastResponseWriter(ctx), r)
+	}
+}
+
+// fastHTTPRequest converts a fasthttp request to a *http.Request carrying FastContext
+func fastHTTPRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+	r := new(http.Request)
+	if err := fasthttpadaptor.ConvertRequest(ctx, r, true); err != nil {
+		return nil, err
+	}
+	// fastInitHandler only sets the generated request ID on the response
+	if r.Header.Get(common.RequestIDHeader) == "" {
+		if requestId := ctx.Response.Header.Peek(common.RequestIDHeader); len(requestId) > 0 {
+			r.Header.Set(common.RequestIDHeader, string(requestId))
+		}
+	}
+	return r.WithContext(FastContext(ctx)), nil
+}
+
+func fastBadRequest(ctx *fasthttp.RequestCtx, err error) {
+	response := NewResponse().SetError(BadRequest(err.Error(), "ERROR_PARSING_REQUEST"))
+	response.TraceId = string(ctx.Response.Header.Peek(common.RequestIDHeader))
+	fastSendResponse(ctx, response)
+	ReleaseResponse(response)
+}
+
+// fastResponseWriter is a http.ResponseWriter writing to a fasthttp response
+type fastResponseWriter struct {
+	ctx         *fasthttp.RequestCtx
+	header      http.Header
+	wroteHeader bool
+}
+
+func newFastResponseWriter(ctx *fasthttp.RequestCtx) *fastResponseWriter {
+	return &fastResponseWriter{ctx: ctx, header: make(http.Header)}
+}
+
+func (w *fastResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fastResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.flushHeader()
+	w.ctx.SetStatusCode(statusCode)
+}
+
+func (w *fastResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ctx.Write(b)
+}
+
+// Flush is a no-op, fasthttp sends the body once the handler returns
+func (w *fastResponseWriter) Flush() {}
+
+// flushHeader copies the headers set so far to the fasthttp response
+func (w *fastResponseWriter) flushHeader() {
+	for key, values := range w.header {
+		w.ctx.Response.Header.Del(key)
+		for _, value := range values {
+			w.ctx.Response.Header.Add(key, value)
+		}
+	}
+}
+
+// fastStreamWriter is the http.ResponseWriter of a fasthttp body stream, whose headers are
+// already sent. A failed write or flush means the client is gone and cancels the request context.
+type fastStreamWriter struct {
+	header http.Header
+	w      *bufio.Writer
+	cancel context.CancelFunc
+}
+
+func (w *fastStreamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fastStreamWriter) WriteHeader(int) {}
+
+func (w *fastStreamWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err != nil {
+		w.cancel()
+	}
+	return n, err
+}
+
+func (w *fastStreamWriter) Flush() {
+	if err := w.w.Flush(); err != nil {
+		w.cancel()
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+)
+
+type fastParityController struct{}
+
+type fastParityContextKey struct{}
+
+func (c *fastParityController) GetConfig() ControllerConfig {
+	return ControllerConfig{
+		Path: "/items",
+		Routes: []Route{
+			NewRoute(http.MethodPut, c.update, WithPath("/{id:int64}"), WithRequest(handler2TestPayload{}), WithResponse(map[string]any{})),
+			NewRoute(http.MethodGet, c.get, WithPath("/{id}")),
+			NewRoute(http.MethodGet, c.whoami, WithPath("/whoami"), WithMiddleware(testContextMiddleware)),
+		},
+	}
+}
+
+func (c *fastParityController) update(ctx context.Context) (any, error) {
+	payload := phastosctx.RequestBody[handler2TestPayload](ctx)
+	return map[string]any{"id": phastosctx.PathParam[int64](ctx, "id"), "name": payload.Name}, nil
+}
+
+func (c *fastParityController) get(req Request, ctx context.Context) *Response {
+	return NewResponse().SetData(map[string]string{"id": req.GetParams("id")})
+}
+
+func (c *fastParityController) whoami(req Request, ctx context.Context) *Response {
+	return NewResponse().SetData(map[string]any{"user": ctx.Value(fastParityContextKey{})})
+}
+
+// testContextMiddleware is a net/http middleware adding a context value and a header, or refusing the request
+func testContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		if user == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":"NO_USER"}`))
+			return
+		}
+		w.Header().Set("X-Seen-By", "middleware")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fastParityContextKey{}, user)))
+	})
+}
+
+func serveFast(handler fasthttp.RequestHandler, method, uri, body string, headers map[string]string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if body != "" {
+		ctx.Request.Header.SetContentType(ContentJSON)
+		ctx.Request.SetBodyString(body)
+	}
+	for k, v := range headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	handler(ctx)
+	return ctx
+}
+
+func TestFastHttpApp_Controller(t *testing.T) {
+	app := NewFastHttpApp(WithTimezone("UTC"), WithOpenAPI())
+	app.Init()
+	app.AddController(&fastParityController{})
+	handler := app.Handler()
+	assert.Equal(t, 4, app.TotalEndpoints) // /ping + 3 routes
+
+	t.Run("HandlerV2 binding", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodPut, "/v1/items/42", `{"name":"item-1"}`, nil)
+		assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"id":42,"name":"item-1"}`, string(ctx.Response.Body()))
+		assert.NotEmpty(t, ctx.Response.Header.Peek("X-Trace-ID"))
+	})
+
+	t.Run("typed path param", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodPut, "/v1/items/abc", `{"name":"item-1"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "ERR_INVALID_PATH_PARAM")
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodPut, "/v1/items/42", `{"value":1}`, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, ctx.Response.StatusCode())
+	})
+
+	t.Run("legacy handler params", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodGet, "/v1/items/abc", "", nil)
+		assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"id":"abc"}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("net/http middleware", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodGet, "/v1/items/whoami", "", map[string]string{"X-User": "alice"})
+		assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"user":"alice"}`, string(ctx.Response.Body()))
+		assert.Equal(t, "middleware", string(ctx.Response.Header.Peek("X-Seen-By")))
+
+		ctx = serveFast(handler, http.MethodGet, "/v1/items/whoami", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"code":"NO_USER"}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		ctx := serveFast(handler, http.MethodGet, "/docs/openapi.json", "", nil)
+		assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+		var spec map[string]any
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &spec))
+		assert.Contains(t, spec["paths"], "/v1/items/{id}")
+
+		assert.NotNil(t, app.OpenAPISpec().Paths.Find("/v1/items/whoami"))
+	})
+}
+
+func TestFastHttpApp_AddController_Unsupported(t *testing.T) {
+	app := NewFastHttpApp(WithTimezone("UTC"))
+	app.Init()
+	assert.Panics(t, func() { app.AddController("not a controller") })
+}
+
+func TestFastAdaptMiddleware(t *testing.T) {
+	var user any
+	handler := FastAdaptMiddleware(testContextMiddleware)(func(ctx *fasthttp.RequestCtx) {
+		user = FastContext(ctx).Value(fastParityContextKey{})
+		ctx.SetStatusCode(http.StatusNoContent)
+	})
+
+	ctx := serveFast(handler, http.MethodGet, "/", "", map[string]string{"X-User": "bob"})
+	assert.Equal(t, "bob", user)
+	assert.Equal(t, http.StatusNoContent, ctx.Response.StatusCode())
+	assert.Equal(t, "middleware", string(ctx.Response.Header.Peek("X-Seen-By")))
+}
+
+func TestFastHttpApp_SSE(t *testing.T) {
+	app := NewFastHttpApp(WithTimezone("UTC"), WithSSE())
+	app.Init()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close() //nolint:errcheck
+	go func() { _ = fasthttp.Serve(ln, app.Handler()) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) { return ln.Dial() },
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://fast/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Client-ID", "client-1")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "event: connected") || strings.HasPrefix(line, "id:"), line)
+}
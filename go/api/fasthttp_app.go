@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/schema"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -20,6 +22,7 @@ import (
 	"github.com/kodekoding/phastos/v2/go/common"
 	"github.com/kodekoding/phastos/v2/go/helper"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/sse"
 )
 
 // fastRequestPool reduces GC pressure by recycling *FastRequest objects.
@@ -243,147 +246,16 @@ func (r *FastRoute) GetVersionedPath(controllerPath string) string {
 // fastDecoder is a gorilla/schema decoder instance for the fasthttp path.
 var fastDecoder = schema.NewDecoder()
 
-// --- Lightweight fasthttp router ---
-// Instead of depending on an external router package, we implement a simple
-// radix-tree-free router that uses a map of exact paths + a list of param routes.
-// For the benchmark use case this is extremely fast. For production, you can
-// swap in github.com/fasthttp/router or any fasthttp-compatible router.
-
-// routeEntry stores a registered route.
-type routeEntry struct {
-	method  string
-	path    string // may contain :param segments
-	handler fasthttp.RequestHandler
-}
-
-// fastRouter is a minimal fasthttp router supporting exact and :param paths.
-type fastRouter struct {
-	exactRoutes map[string]fasthttp.RequestHandler // "GET /v1/json" -> handler
-	paramRoutes []routeEntry                       // routes with :param, checked sequentially
-	notFound    fasthttp.RequestHandler
-}
-
-var notFoundJSON = func() []byte {
-	b, _ := json.Marshal(NotFound("route not found", "ROUTE_NOT_FOUND"))
-	return b
-}()
-
-func newFastRouter() *fastRouter {
-	return &fastRouter{
-		exactRoutes: make(map[string]fasthttp.RequestHandler),
-		notFound: func(ctx *fasthttp.RequestCtx) {
-			ctx.SetStatusCode(http.StatusNotFound)
-			ctx.SetContentType("application/json")
-			ctx.SetBody(notFoundJSON)
-		},
-	}
-}
-
-// Handle registers a route. Path may contain :param segments (e.g. "/v1/path/:id").
-func (r *fastRouter) Handle(method, path string, handler fasthttp.RequestHandler) {
-	if !strings.Contains(path, ":") && !strings.Contains(path, "{") {
-		// Exact path — no params — use map for O(1) lookup
-		r.exactRoutes[method+" "+path] = handler
-		return
-	}
-	// Parametric path — use sequential scan (fast for small route counts)
-	r.paramRoutes = append(r.paramRoutes, routeEntry{
-		method:  method,
-		path:    path,
-		handler: handler,
-	})
-}
-
-// ServeHTTP implements fasthttp.RequestHandler.
-func (r *fastRouter) ServeHTTP(ctx *fasthttp.RequestCtx) {
-	// Build lookup key on the stack — zero allocation for exact match path.
-	method := ctx.Method()
-	path := ctx.URI().Path()
-	var key [128]byte
-	n := copy(key[:], method)
-	key[n] = ' '
-	n++
-	n += copy(key[n:], path)
-
-	// O(1) exact match (string() from stack buffer, no escape → no alloc)
-	if h, ok := r.exactRoutes[string(key[:n])]; ok {
-		h(ctx)
-		return
-	}
-
-	// Parametric path: need string for matching functions
-	pathStr := string(path)
-
-	methodStr := string(method)
-
-	// Slower path: parametric match
-	for _, route := range r.paramRoutes {
-		if route.method != methodStr {
-			continue
-		}
-		if matchParamPath(route.path, pathStr, ctx) {
-			route.handler(ctx)
-			return
-		}
-	}
-
-	r.notFound(ctx)
-}
-
-// matchParamPath matches a pattern like "/v1/path/:id" against an actual path.
-// It sets UserValues directly on the ctx during matching, avoiding map allocation.
-// Returns true if the path matches the pattern.
-func matchParamPath(pattern, path string, ctx *fasthttp.RequestCtx) bool {
-	// Fast check: same number of segments
-	pi := 0 // position in pattern
-	li := 0 // last '/' position in path
-	for i := 0; i <= len(path); i++ {
-		if i == len(path) || path[i] == '/' {
-			// Extract path segment: path[li:i]
-			// Find corresponding pattern segment
-			pj := pi
-			for pj < len(pattern) && pattern[pj] != '/' {
-				pj++
-			}
-			// pattern segment is pattern[pi:pj]
-			if pj-pi == 0 && i-li == 0 {
-				// Both empty (leading /)
-				pi = pj + 1
-				li = i + 1
-				continue
-			}
-			if pj-pi > 0 && pattern[pi] == ':' || pattern[pi] == '{' {
-				// Param segment — set UserValue
-				paramName := pattern[pi+1 : pj]
-				if pattern[pi] == '{' {
-					paramName = strings.TrimSuffix(paramName, "}")
-				}
-				ctx.SetUserValue(paramName, path[li:i])
-			} else {
-				// Literal segment — must match exactly
-				if pj-pi != i-li || pattern[pi:pj] != path[li:i] {
-					return false
-				}
-			}
-			pi = pj + 1
-			li = i + 1
-		}
-	}
-	// Check that pattern is also exhausted
-	for pi < len(pattern) {
-		if pattern[pi] != '/' {
-			return false
-		}
-		pi++
-	}
-	return true
-}
-
 // FastHttpApp is a native fasthttp application that bypasses net/http entirely.
 // It provides the same Phastos API surface (Request/Response, middleware chain,
 // controller pattern) but with zero net/http overhead.
+//
+// Besides FastController, it accepts the Controller/Route/RouteDoc definitions of App
+// (HandlerV2 auto-binding, typed path params, route policies, OpenAPI docs and net/http
+// middlewares); those routes go through the net/http adapters of fasthttp_adapter.go.
 type FastHttpApp struct {
 	router             *fastRouter
+	std                *App // Controller routes, OpenAPI registry and SSE hub
 	apiTimeout         int
 	pprofEnabled       bool
 	middlewares        map[string]any
@@ -392,23 +264,35 @@ type FastHttpApp struct {
 	skipLogPaths       map[string]struct{}
 	sfActive           bool
 	syncMode           bool
+	sseOnce            sync.Once
 	TotalEndpoints     int
 }
 
 // NewFastHttpApp creates a new native fasthttp application.
-func NewFastHttpApp() *FastHttpApp {
+// The App options (WithOpenAPI, WithSSE, WithAPITimeout, WithGlobalMiddleware, ...) configure
+// the Controller routes; the API timeout defaults to 0 (sync handlers).
+func NewFastHttpApp(opts ...Options) *FastHttpApp {
 	app := &FastHttpApp{
 		middlewares:  make(map[string]any),
 		pprofEnabled: false,
-		apiTimeout:   0,
 	}
 
+	app.std = NewApp(append([]Options{WithAPITimeout(0), WithPprof(false)}, opts...)...)
+	app.apiTimeout = app.std.apiTimeout
+	app.skipLogPaths = app.std.skipLogPaths
+
 	if val := os.Getenv("SINGLEFLIGHT_ACTIVE"); val != "" {
 		if parsed, err := strconv.ParseBool(val); err == nil {
 			app.sfActive = parsed
 		}
 	}
 	app.syncMode = app.apiTimeout == 0 && !app.sfActive
+	app.std.sfActive = app.sfActive
+	app.std.syncMode = app.syncMode
+	app.std.routeMounter = func(method, path string, handler http.Handler) {
+		app.router.Handle(method, path, fastRouteHandler(path, handler))
+		app.TotalEndpoints++
+	}
 
 	return app
 }
@@ -466,12 +350,44 @@ func (app *FastHttpApp) registerFastRoutes(prefix string, parentMiddlewares []Fa
 	}
 }
 
-// AddController registers all routes from a FastController.
-func (app *FastHttpApp) AddController(ctrl FastController) {
+// AddController registers all routes from a FastController, or from a Controller
+// (the same definitions as App.AddController).
+func (app *FastHttpApp) AddController(ctrl any) {
 	app.flushPendingMiddlewares()
 
-	config := ctrl.GetConfig()
-	app.registerFastRoutes(config.Path, config.Middlewares, config.Routes)
+	switch c := ctrl.(type) {
+	case FastController:
+		config := c.GetConfig()
+		app.registerFastRoutes(config.Path, config.Middlewares, config.Routes)
+	case Controller:
+		app.std.AddController(c)
+	default:
+		panic(fmt.Sprintf("phastos.api.FastHttpApp.AddController: %T is neither a FastController nor a Controller", ctrl))
+	}
+}
+
+// AddControllers registers the Controllers of ctrls.
+func (app *FastHttpApp) AddControllers(ctrls Controllers) {
+	for _, ctrl := range ctrls.Register() {
+		app.AddController(ctrl)
+	}
+}
+
+// OpenAPISpec returns the OpenAPI document of the Controller routes.
+func (app *FastHttpApp) OpenAPISpec() *openapi3.T {
+	return app.std.OpenAPISpec()
+}
+
+// SSE returns the SSE hub enabled by WithSSE, served at /events.
+func (app *FastHttpApp) SSE() sse.Events {
+	return app.std.SSE()
+}
+
+// Stop stops the SSE hub started by Handler.
+func (app *FastHttpApp) Stop() {
+	if app.std.sseEvent != nil {
+		app.std.sseEvent.Stop()
+	}
 }
 
 // flushPendingMiddlewares applies global middlewares and registers default routes.
@@ -492,6 +408,63 @@ func (app *FastHttpApp) initDefaultRoutes() {
 		ReleaseResponse(response)
 	})
 	app.TotalEndpoints++
+
+	if app.std.sseEvent != nil {
+		app.router.Handle("GET", "/events", app.fastSSEHandler)
+		app.router.Handle("GET", "/events/missed-msg", fastRouteHandler("/events/missed-msg", app.std.wrapHandler(app.std.sseMissedMessages)))
+		subscriptions := FastAdaptHandler(http.HandlerFunc(app.std.sseEvent.HandleSubscriptions))
+		app.router.Handle("POST", "/events/subscriptions", subscriptions)
+		app.router.Handle("DELETE", "/events/subscriptions", subscriptions)
+		app.TotalEndpoints += 4
+	}
+}
+
+// initDocsRoutes registers /docs/openapi.json and the Swagger UI at /docs, like App.BuildHandler.
+func (app *FastHttpApp) initDocsRoutes() {
+	spec, err := json.Marshal(app.std.buildOpenAPISpec())
+	if err != nil {
+		log := plog.Get()
+		log.Err(err).Msg("failed to encode openapi spec")
+		return
+	}
+
+	app.router.Handle("GET", "/docs/openapi.json", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("application/json")
+		ctx.SetBody(spec)
+	})
+	app.router.Handle("GET", "/docs", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.SetBodyString(openapiHTML)
+	})
+}
+
+// fastSSEHandler streams the SSE hub to a client with a fasthttp body stream writer.
+func (app *FastHttpApp) fastSSEHandler(ctx *fasthttp.RequestCtx) {
+	hub := app.std.sseEvent
+	r, err := fastHTTPRequest(ctx)
+	if err != nil {
+		fastBadRequest(ctx, err)
+		return
+	}
+	if authErr := hub.Authenticator().Authenticate(r); authErr != nil {
+		w := newFastResponseWriter(ctx)
+		authErr.Write(w)
+		return
+	}
+
+	header := make(http.Header)
+	sse.SetStreamHeaders(header)
+	w := newFastResponseWriter(ctx)
+	w.header = header
+	w.WriteHeader(http.StatusOK)
+
+	ctx.SetBodyStreamWriter(func(bw *bufio.Writer) {
+		// the stream runs beside the request logger, so Handle gets a logger of its own to update
+		logger := plog.Ctx(r.Context()).With().Logger()
+		streamCtx, cancel := context.WithCancel(logger.WithContext(r.Context()))
+		defer cancel()
+		hub.Handle(&fastStreamWriter{header: make(http.Header), w: bw, cancel: cancel}, r.WithContext(streamCtx))
+	})
 }
 
 // wrapFastHandler wraps a FastHandler into a fasthttp.RequestHandler.
@@ -517,8 +490,9 @@ func (app *FastHttpApp) wrapFastHandler(h FastHandler) fasthttp.RequestHandler {
 		defer req.release()
 
 		// Execute handler directly — no goroutine, no channel, no context.WithTimeout
-		response := h(*req, cachedBackground)
+		response := h(*req, FastContext(ctx))
 		response.TraceId = requestId
+		response.options = app.std.responseOptions
 
 		// Send response directly to fasthttp
 		fastSendResponse(ctx, response)
@@ -578,11 +552,11 @@ func fastSendResponse(ctx *fasthttp.RequestCtx, resp *Response) {
 		return
 	}
 
-	// JSON response
-	ctx.Response.Header.Set("Content-Type", "application/json")
-
-	var dataToMarshal any
+	// JSON response, or the encoder negotiated from the Accept header
+	var dataToMarshal, listData any
 	var responseStatus int
+	var isProblem bool
+	options := resp.renderOptions()
 
 	if resp.Err != nil {
 		var respErr *HttpError
@@ -592,6 +566,14 @@ func fastSendResponse(ctx *fasthttp.RequestCtx, resp *Response) {
 				respErr.TraceId = resp.TraceId
 			}
 			dataToMarshal = respErr
+			if options.problemDetails {
+				instance := resp.instance
+				if instance == "" {
+					instance = string(ctx.Path())
+				}
+				isProblem = true
+				dataToMarshal = respErr.problem(instance, options.problemTypeBaseURI)
+			}
 		}
 	} else {
 		responseStatus = resp.statusCode
@@ -599,24 +581,22 @@ func fastSendResponse(ctx *fasthttp.RequestCtx, resp *Response) {
 		if resp.Data != nil {
 			bodyContentAvailable = true
 			dataToMarshal = resp.Data
+			listData = resp.Data
 			if resp.MetaData != nil && resp.isPaginationData {
-				dataToMarshal = map[string]any{
-					jsonFieldData: resp.Data,
-					"metadata":    resp.MetaData,
-				}
+				dataToMarshal = paginatedEnvelope{Data: resp.Data, Metadata: resp.MetaData}
 			}
 		}
-		if resp.Message != "" && resp.Data == nil {
+		if resp.Message != "" && resp.Data == nil && !options.negotiation && options.compressionMinSize == 0 {
 			// Fast path: message-only response — skip json.Marshal and map allocation
+			ctx.Response.Header.Set("Content-Type", ContentJSON)
 			ctx.SetStatusCode(responseStatus)
 			ctx.SetBodyString(`{"message":"` + resp.Message + `"}`)
 			return
 		}
 		if resp.Message != "" {
 			bodyContentAvailable = true
-			dataToMarshal = map[string]string{
-				jsonFieldMessage: resp.Message,
-			}
+			listData = nil
+			dataToMarshal = messageEnvelope{Message: resp.Message}
 		}
 		if !bodyContentAvailable {
 			resp.statusCode = http.StatusNoContent
@@ -625,10 +605,30 @@ func fastSendResponse(ctx *fasthttp.RequestCtx, resp *Response) {
 	}
 
 	ctx.SetStatusCode(responseStatus)
-	if dataToMarshal != nil {
-		b, _ := json.Marshal(dataToMarshal)
-		ctx.SetBody(b)
+	if vary := options.varyHeader(); vary != "" {
+		ctx.Response.Header.Add("Vary", vary)
 	}
+	if dataToMarshal == nil {
+		ctx.Response.Header.Set("Content-Type", ContentJSON)
+		return
+	}
+
+	encoded := options.encodeResponseBody(dataToMarshal, listData, isProblem,
+		string(ctx.Request.Header.Peek("Accept")), string(ctx.Request.Header.Peek("Accept-Encoding")))
+	defer encoded.release()
+	ctx.Response.Header.Set("Content-Type", encoded.contentType)
+
+	if encoded.stream != nil {
+		stream, streamData := encoded.stream, encoded.streamData
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			_ = stream.Stream(w, func() { _ = w.Flush() }, streamData)
+		})
+		return
+	}
+	if encoded.contentEncoding != "" {
+		ctx.Response.Header.Set("Content-Encoding", encoded.contentEncoding)
+	}
+	ctx.SetBody(encoded.body)
 }
 
 // Handler returns the composed fasthttp.RequestHandler with all middlewares applied.
@@ -637,6 +637,13 @@ func fastSendResponse(ctx *fasthttp.RequestCtx, resp *Response) {
 func (app *FastHttpApp) Handler() fasthttp.RequestHandler {
 	app.flushPendingMiddlewares()
 
+	if app.std.enableOpenAPI {
+		app.initDocsRoutes()
+	}
+	if hub := app.std.sseEvent; hub != nil {
+		app.sseOnce.Do(func() { go hub.Run() })
+	}
+
 	// Start with the router as the innermost handler
 	var handler fasthttp.RequestHandler = app.router.ServeHTTP
 
@@ -757,6 +764,10 @@ func fastRequestLogger(next fasthttp.RequestHandler, skipPaths map[string]struct
 		if requestId == "" {
 			requestId = string(ctx.Request.Header.Peek("X-Request-ID"))
 		}
+		if requestId == "" {
+			// generated by fastInitHandler
+			requestId = string(ctx.Response.Header.Peek(common.RequestIDHeader))
+		}
 		ctx.Response.Header.Set(common.RequestIDHeader, requestId)
 
 		log.UpdateContext(func(c zerolog.Context) zerolog.Context {
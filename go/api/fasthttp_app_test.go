@@ -269,7 +269,7 @@ func TestNewFastRouter(t *testing.T) {
 	assert.NotNil(t, r)
 	assert.NotNil(t, r.exactRoutes)
 	assert.NotNil(t, r.notFound)
-	assert.Empty(t, r.paramRoutes)
+	assert.Empty(t, r.trees)
 }
 
 func TestFastRouter_Handle_ExactPath(t *testing.T) {
@@ -367,69 +367,115 @@ func TestFastRouter_Handle_MultipleExactPaths(t *testing.T) {
 	assert.Equal(t, "b", pathCalled)
 }
 
-// --- matchParamPath ---
+// --- fastRouter param matching ---
 
-func TestMatchParamPath(t *testing.T) {
+// matchRoute serves path on a router holding only pattern and reports whether it matched
+func matchRoute(pattern, path string) (*fasthttp.RequestCtx, bool) {
+	r := newFastRouter()
+	matched := false
+	r.Handle("GET", pattern, func(ctx *fasthttp.RequestCtx) { matched = true })
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(path)
+	ctx.Request.Header.SetMethod("GET")
+	r.ServeHTTP(ctx)
+	return ctx, matched
+}
+
+func TestFastRouter_ParamMatching(t *testing.T) {
 	t.Run("matches single param at end", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/users/:id", "/v1/users/42", ctx)
+		ctx, result := matchRoute("/v1/users/:id", "/v1/users/42")
 		assert.True(t, result)
-		assert.Equal(t, "42", string(ctx.UserValue("id").(string))) //nolint:errcheck
+		assert.Equal(t, "42", ctx.UserValue("id"))
 	})
 
 	t.Run("matches multiple params", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/:org/:repo", "/v1/github/api", ctx)
+		ctx, result := matchRoute("/v1/:org/:repo", "/v1/github/api")
 		assert.True(t, result)
-		assert.Equal(t, "github", string(ctx.UserValue("org").(string)))  //nolint:errcheck
-		assert.Equal(t, "api", string(ctx.UserValue("repo").(string)))    //nolint:errcheck
+		assert.Equal(t, "github", ctx.UserValue("org"))
+		assert.Equal(t, "api", ctx.UserValue("repo"))
 	})
 
 	t.Run("matches brace-style params", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/items/{id}", "/v1/items/99", ctx)
+		ctx, result := matchRoute("/v1/items/{id}", "/v1/items/99")
 		assert.True(t, result)
-		assert.Equal(t, "99", string(ctx.UserValue("id").(string))) //nolint:errcheck
+		assert.Equal(t, "99", ctx.UserValue("id"))
 	})
 
 	t.Run("rejects non-matching path", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/users/:id", "/v1/items/42", ctx)
+		_, result := matchRoute("/v1/users/:id", "/v1/items/42")
 		assert.False(t, result)
 	})
 
 	t.Run("rejects different segment count", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/users/:id", "/v1/users", ctx)
+		_, result := matchRoute("/v1/users/:id", "/v1/users")
+		assert.False(t, result)
+		_, result = matchRoute("/v1/users/:id", "/v1/users/42/posts")
 		assert.False(t, result)
 	})
 
-	t.Run("rejects empty path vs non-empty pattern", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/v1/users/:id", "", ctx)
+	t.Run("rejects empty param segment", func(t *testing.T) {
+		_, result := matchRoute("/v1/users/:id", "/v1/users/")
 		assert.False(t, result)
 	})
 
-	t.Run("matches root path", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/", "/", ctx)
+	t.Run("matches literal segments before param", func(t *testing.T) {
+		ctx, result := matchRoute("/api/v1/users/:id", "/api/v1/users/5")
 		assert.True(t, result)
+		assert.Equal(t, "5", ctx.UserValue("id"))
 	})
 
-	t.Run("matches literal segments before param", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/api/v1/users/:id", "/api/v1/users/5", ctx)
+	t.Run("rejects wrong literal segment", func(t *testing.T) {
+		_, result := matchRoute("/api/v1/users/:id", "/api/v2/users/5")
+		assert.False(t, result)
+	})
+
+	t.Run("matches catch-all", func(t *testing.T) {
+		ctx, result := matchRoute("/files/*path", "/files/a/b/c.txt")
+		assert.True(t, result)
+		assert.Equal(t, "a/b/c.txt", ctx.UserValue("path"))
+
+		ctx, result = matchRoute("/static/*", "/static/app.js")
 		assert.True(t, result)
-		assert.Equal(t, "5", string(ctx.UserValue("id").(string))) //nolint:errcheck
+		assert.Equal(t, "app.js", ctx.UserValue("*"))
 	})
 
-	t.Run("rejects wrong literal segment", func(t *testing.T) {
-		ctx := &fasthttp.RequestCtx{}
-		result := matchParamPath("/api/v1/users/:id", "/api/v2/users/5", ctx)
+	t.Run("validates typed params", func(t *testing.T) {
+		ctx, result := matchRoute("/v1/users/{id:int}", "/v1/users/42")
+		assert.True(t, result)
+		assert.Equal(t, "42", ctx.UserValue("id"))
+
+		ctx, result = matchRoute("/v1/users/{id:int}", "/v1/users/abc")
 		assert.False(t, result)
+		assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "ERR_INVALID_PATH_PARAM")
 	})
 }
 
+func TestFastRouter_StaticBeforeParam(t *testing.T) {
+	r := newFastRouter()
+	var called string
+	r.Handle("GET", "/v1/users/:id", func(ctx *fasthttp.RequestCtx) { called = "param" })
+	r.Handle("GET", "/v1/users/:id/posts", func(ctx *fasthttp.RequestCtx) { called = "posts" })
+	r.Handle("GET", "/v1/users/me/posts", func(ctx *fasthttp.RequestCtx) { called = "me" })
+	r.Handle("GET", "/v1/*rest", func(ctx *fasthttp.RequestCtx) { called = "catch-all" })
+
+	for path, expected := range map[string]string{
+		"/v1/users/7":        "param",
+		"/v1/users/me":       "param", // backtracks from the static "me" branch
+		"/v1/users/me/posts": "me",
+		"/v1/users/7/posts":  "posts",
+		"/v1/orders/1":       "catch-all",
+	} {
+		called = ""
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetMethod("GET")
+		r.ServeHTTP(ctx)
+		assert.Equal(t, expected, called, path)
+	}
+}
+
 // --- FastRoute.GetVersionedPath ---
 
 func TestFastRoute_GetVersionedPath(t *testing.T) {
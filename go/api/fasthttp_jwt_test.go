@@ -11,6 +11,7 @@ import (
 	"github.com/valyala/fasthttp"
 
 	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
 	"github.com/kodekoding/phastos/v2/go/entity"
 )
 
@@ -91,8 +92,10 @@ func TestFastJWTAuth_ValidToken(t *testing.T) {
 	defer os.Setenv(common.EnvJWTSigningKey, originalKey)
 
 	nextCalled := false
+	var claims *entity.JWTClaimData
 	next := func(ctx *fasthttp.RequestCtx) {
 		nextCalled = true
+		claims = phastosctx.GetJWT(FastContext(ctx))
 	}
 
 	middleware := FastJWTAuth(next)
@@ -113,6 +116,7 @@ func TestFastJWTAuth_ValidToken(t *testing.T) {
 	middleware(ctx)
 
 	assert.True(t, nextCalled, "next handler should be called with valid token")
+	assert.NotNil(t, claims, "the claims should be available through FastContext")
 }
 
 // TestFastJWTAuth_ExpiredToken tests that expired token returns 401.
@@ -1,18 +1,15 @@
 package api
 
 import (
-	"encoding/json"
 	"os"
 	"strings"
 	"sync"
 
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 	"golang.org/x/time/rate"
 
 	"github.com/kodekoding/phastos/v2/go/common"
-	"github.com/kodekoding/phastos/v2/go/entity"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
 )
 
 // --- FastStaticAuth is the fasthttp-native version of StaticAuth middleware ---
@@ -67,45 +64,13 @@ func FastJWTAuth(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 			return
 		}
 
-		if os.Getenv(common.EnvJWTSigningKey) == "" {
-			err := errors.New("JWT Signing Key is nil")
-			newError := Unauthorized(err.Error(), "INVALID_KEY")
-			newError.TraceId = traceId
-			response := NewResponse().SetError(newError)
-			fastSendResponse(ctx, response)
-			ReleaseResponse(response)
+		result, jwtErr := ParseJWT(token)
+		if jwtErr != nil {
+			fastJWTUnauthorized(ctx, traceId, jwtErr.Message, jwtErr.Code)
 			return
 		}
-
-		var keyFunc jwt.Keyfunc = func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != "HS256" {
-				return nil, errors.Errorf("unexpected jwt signing method=%v", token.Header["alg"])
-			}
-			return []byte(os.Getenv(common.EnvJWTSigningKey)), nil
-		}
-
-		tokenClient := strings.TrimSpace(token)
-		data := jwt.MapClaims{}
-
-		tokenData, errToken := jwt.ParseWithClaims(tokenClient, data, keyFunc)
-		if errToken != nil {
-			fastJWTUnauthorized(ctx, traceId, errToken.Error(), "INVALID_CLAIMS")
-			return
-		}
-		if !tokenData.Valid {
-			fastJWTUnauthorized(ctx, traceId, "Token is not valid", "TOKEN_NOT_VALID")
-			return
-		}
-
-		claimByte, _ := json.Marshal(tokenData.Claims)
-		var result entity.JWTClaimData
-		if err := json.Unmarshal(claimByte, &result); err != nil {
-			fastJWTUnauthorized(ctx, traceId, "invalid struct claim", "INVALID_STRUCT_CLAIM")
-			return
-		}
-
-		result.Token = token
-		ctx.SetUserValue("jwt_claim", &result)
+		ctx.SetUserValue("jwt_claim", result)
+		setFastContext(ctx, phastosctx.WithJWT(FastContext(ctx), result))
 
 		next(ctx)
 	}
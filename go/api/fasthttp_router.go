@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// --- fasthttp radix router ---
+// Static paths are looked up in a map first (zero allocation). Paths with parameters go
+// through a per-method tree of path segments where static children win over a parameter,
+// and a parameter wins over a catch-all, backtracking when a branch does not match.
+//
+// Supported segments:
+//   - /users/:id or /users/{id}  any segment, stored as the "id" UserValue
+//   - /users/{id:int}            typed (int, uint, float64, bool ... as in Route paths), 400 when invalid
+//   - /files/*path or /files/*   the rest of the path, stored as the "path" (or "*") UserValue
+
+// maxFastParams is the number of path parameters matched without allocating
+const maxFastParams = 8
+
+// fastParam is a parameter of a registered path
+type fastParam struct {
+	name     string
+	typ      PathParamType
+	catchAll bool
+}
+
+// fastNode is a node of the routing tree, one per path segment
+type fastNode struct {
+	static   map[string]*fastNode
+	param    *fastNode
+	catchAll *fastNode
+	handler  fasthttp.RequestHandler
+	params   []fastParam // parameters of the path ending at this node
+}
+
+// fastRouter is the fasthttp router of FastHttpApp.
+type fastRouter struct {
+	exactRoutes map[string]fasthttp.RequestHandler // "GET /v1/json" -> handler
+	trees       map[string]*fastNode               // method -> tree of the paths with parameters
+	notFound    fasthttp.RequestHandler
+}
+
+var notFoundJSON = func() []byte {
+	b, _ := json.Marshal(NotFound("route not found", "ROUTE_NOT_FOUND"))
+	return b
+}()
+
+func newFastRouter() *fastRouter {
+	return &fastRouter{
+		exactRoutes: make(map[string]fasthttp.RequestHandler),
+		trees:       make(map[string]*fastNode),
+		notFound: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(http.StatusNotFound)
+			ctx.SetContentType("application/json")
+			ctx.SetBody(notFoundJSON)
+		},
+	}
+}
+
+// Handle registers a route. Registering the same method and path again replaces the handler.
+func (r *fastRouter) Handle(method, path string, handler fasthttp.RequestHandler) {
+	if !strings.ContainsAny(path, ":{*") {
+		// Exact path — no params — use map for O(1) lookup
+		r.exactRoutes[method+" "+path] = handler
+		return
+	}
+
+	root, ok := r.trees[method]
+	if !ok {
+		root = &fastNode{}
+		r.trees[method] = root
+	}
+
+	node := root
+	var params []fastParam
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range segments {
+		param, kind := parseFastSegment(segment)
+		switch kind {
+		case fastSegmentCatchAll:
+			if i != len(segments)-1 {
+				panic("phastos.api.fastRouter: catch-all must be the last segment of " + path)
+			}
+			if node.catchAll == nil {
+				node.catchAll = &fastNode{}
+			}
+			node = node.catchAll
+			params = append(params, param)
+		case fastSegmentParam:
+			if node.param == nil {
+				node.param = &fastNode{}
+			}
+			node = node.param
+			params = append(params, param)
+		default:
+			if node.static == nil {
+				node.static = make(map[string]*fastNode)
+			}
+			child, exists := node.static[segment]
+			if !exists {
+				child = &fastNode{}
+				node.static[segment] = child
+			}
+			node = child
+		}
+	}
+	if len(params) > maxFastParams {
+		panic("phastos.api.fastRouter: too many path parameters in " + path)
+	}
+	node.handler = handler
+	node.params = params
+}
+
+type fastSegmentKind int
+
+const (
+	fastSegmentStatic fastSegmentKind = iota
+	fastSegmentParam
+	fastSegmentCatchAll
+)
+
+// parseFastSegment parses :name, {name}, {name:type}, *name and * segments
+func parseFastSegment(segment string) (fastParam, fastSegmentKind) {
+	switch {
+	case strings.HasPrefix(segment, "*"):
+		name := segment[1:]
+		if name == "" {
+			name = "*"
+		}
+		return fastParam{name: name, catchAll: true}, fastSegmentCatchAll
+	case strings.HasPrefix(segment, ":"):
+		return fastParam{name: segment[1:]}, fastSegmentParam
+	case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+		name := segment[1 : len(segment)-1]
+		param := fastParam{name: name}
+		if idx := strings.IndexByte(name, ':'); idx != -1 {
+			param.name = name[:idx]
+			param.typ = parsePathParamType(name[idx+1:])
+		}
+		return param, fastSegmentParam
+	}
+	return fastParam{}, fastSegmentStatic
+}
+
+// ServeHTTP implements fasthttp.RequestHandler.
+func (r *fastRouter) ServeHTTP(ctx *fasthttp.RequestCtx) {
+	// Build lookup key on the stack — zero allocation for exact match path.
+	method := ctx.Method()
+	path := ctx.URI().Path()
+	var key [128]byte
+	if len(method)+1+len(path) <= len(key) {
+		n := copy(key[:], method)
+		key[n] = ' '
+		n++
+		n += copy(key[n:], path)
+
+		// O(1) exact match (string() from stack buffer, no escape → no alloc)
+		if h, ok := r.exactRoutes[string(key[:n])]; ok {
+			h(ctx)
+			return
+		}
+	} else if h, ok := r.exactRoutes[string(method)+" "+string(path)]; ok {
+		h(ctx)
+		return
+	}
+
+	root, ok := r.trees[string(method)]
+	if !ok {
+		r.notFound(ctx)
+		return
+	}
+
+	var buf [maxFastParams]string
+	node, values := root.lookup(strings.TrimPrefix(string(path), "/"), buf[:0])
+	if node == nil {
+		r.notFound(ctx)
+		return
+	}
+
+	for i, param := range node.params {
+		if err := validatePathParam(values[i], param.typ); err != nil {
+			response := NewResponse().SetError(err)
+			response.TraceId = string(ctx.Response.Header.Peek("X-Request-ID"))
+			fastSendResponse(ctx, response)
+			ReleaseResponse(response)
+			return
+		}
+		ctx.SetUserValue(param.name, values[i])
+	}
+	node.handler(ctx)
+}
+
+// lookup returns the node with a handler matching path (without its leading slash) and the
+// values of its parameters, in order
+func (n *fastNode) lookup(path string, values []string) (*fastNode, []string) {
+	segment, rest, last := path, "", true
+	if idx := strings.IndexByte(path, '/'); idx != -1 {
+		segment, rest, last = path[:idx], path[idx+1:], false
+	}
+
+	if child, ok := n.static[segment]; ok {
+		if last {
+			if child.handler != nil {
+				return child, values
+			}
+		} else if node, matched := child.lookup(rest, values); node != nil {
+			return node, matched
+		}
+	}
+
+	if n.param != nil && segment != "" {
+		if last {
+			if n.param.handler != nil {
+				return n.param, append(values, segment)
+			}
+		} else if node, matched := n.param.lookup(rest, append(values, segment)); node != nil {
+			return node, matched
+		}
+	}
+
+	if n.catchAll != nil && n.catchAll.handler != nil {
+		return n.catchAll, append(values, path)
+	}
+	return nil, values
+}
@@ -36,11 +36,17 @@ func serveFastHTTPs(config *server.Config, secure bool) error {
 		return err
 	}
 	defer func() { _ = ln.Close() }()
+	ln, stopGRPCServer, err := listenGRPC(config, ln)
+	if err != nil {
+		return err
+	}
 
 	// graceful‑shutdown helper – re‑use the existing WaitTermSig logic.
 	stop := WaitTermSig(config.Ctx, func(ctx context.Context) error {
 		<-ctx.Done()
+		config.DrainBeforeShutdown()
 		_ = ln.Close()
+		stopGRPCServer()
 		return nil
 	})
 
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+type (
+	// GRPCOptions configures the gRPC server hosted by the App
+	GRPCOptions func(*grpcConfig)
+
+	grpcConfig struct {
+		port          int
+		serverOpts    []grpc.ServerOption
+		unary         []grpc.UnaryServerInterceptor
+		stream        []grpc.StreamServerInterceptor
+		jwt           bool
+		publicMethods map[string]struct{}
+		reflection    bool
+	}
+)
+
+// WithGRPC hosts a gRPC server next to the HTTP server, on the HTTP port by default (see WithGRPCPort).
+// Every call runs through the same stack as HTTP requests: request ID, request logger, panic recovery
+// with notification, tracing, optional JWT auth and HttpError to gRPC status mapping.
+// The gRPC health service reports NOT_SERVING once shutdown starts and server reflection is registered.
+//
+//	app := api.NewApp(api.WithGRPC(api.WithGRPCPort(9000), api.WithGRPCJWT()))
+//	pb.RegisterOrderServiceServer(app.GRPC(), orderServer)
+func WithGRPC(opts ...GRPCOptions) Options {
+	return func(app *App) {
+		cfg := &grpcConfig{
+			publicMethods: make(map[string]struct{}),
+			reflection:    true,
+		}
+		for _, opt := range opts {
+			opt(cfg)
+		}
+		app.grpcCfg = cfg
+		app.grpcServer, app.grpcHealth = newGRPCServer(cfg)
+	}
+}
+
+// WithGRPCPort serves gRPC on its own port. Without it, gRPC shares the HTTP port (plaintext HTTP/2 only).
+func WithGRPCPort(port int) GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.port = port
+	}
+}
+
+// WithGRPCServerOptions passes options (credentials, keepalive, message size ...) to grpc.NewServer
+func WithGRPCServerOptions(opts ...grpc.ServerOption) GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.serverOpts = append(cfg.serverOpts, opts...)
+	}
+}
+
+// WithGRPCUnaryInterceptors appends unary interceptors, they run after the built-in ones
+func WithGRPCUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.unary = append(cfg.unary, interceptors...)
+	}
+}
+
+// WithGRPCStreamInterceptors appends stream interceptors, they run after the built-in ones
+func WithGRPCStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.stream = append(cfg.stream, interceptors...)
+	}
+}
+
+// WithGRPCJWT requires a valid JWT (authorization metadata, "Bearer <token>") on every method except
+// publicMethods (full method names, e.g. "/order.v1.OrderService/ListPublicOrders"), health and reflection.
+// The claims are available through context.GetJWT(ctx).
+func WithGRPCJWT(publicMethods ...string) GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.jwt = true
+		for _, method := range publicMethods {
+			cfg.publicMethods[method] = struct{}{}
+		}
+	}
+}
+
+// WithoutGRPCReflection does not register the server reflection service
+func WithoutGRPCReflection() GRPCOptions {
+	return func(cfg *grpcConfig) {
+		cfg.reflection = false
+	}
+}
+
+func newGRPCServer(cfg *grpcConfig) (*grpc.Server, *grpchealth.Server) {
+	stack := &grpcStack{jwt: cfg.jwt, publicMethods: cfg.publicMethods}
+	serverOpts := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{stack.unary}, cfg.unary...)...),
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{stack.stream}, cfg.stream...)...),
+	}, cfg.serverOpts...)
+
+	grpcServer := grpc.NewServer(serverOpts...)
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if cfg.reflection {
+		reflection.Register(grpcServer)
+	}
+	return grpcServer, healthServer
+}
+
+// GRPC returns the gRPC server to register the services on
+func (app *App) GRPC() *grpc.Server {
+	if app.grpcServer == nil {
+		log := plog.Get()
+		log.Fatal().Msg("gRPC server not initialized, use WithGRPC()")
+	}
+	return app.grpcServer
+}
+
+// attachGRPC hands the gRPC server to the transport config; the health service reports
+// NOT_SERVING as soon as shutdown starts, like /readyz
+func (app *App) attachGRPC() {
+	if app.grpcServer == nil {
+		return
+	}
+	app.Config.GRPCServer = app.grpcServer
+	app.Config.GRPCPort = app.grpcCfg.port
+
+	beforeShutdown := app.BeforeShutdown
+	app.BeforeShutdown = func() {
+		app.grpcHealth.Shutdown()
+		if beforeShutdown != nil {
+			beforeShutdown()
+		}
+	}
+}
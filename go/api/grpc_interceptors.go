@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	sgw "github.com/ashwanthkumar/slack-go-webhook"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/entity"
+	"github.com/kodekoding/phastos/v2/go/helper"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/monitoring"
+)
+
+const (
+	grpcRequestIDMetadata     = "x-request-id"
+	grpcAuthorizationMetadata = "authorization"
+)
+
+// grpcCodes maps HttpError statuses to gRPC codes; other 4xx are FailedPrecondition, 5xx Internal
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusMethodNotAllowed:      codes.Unimplemented,
+	http.StatusRequestTimeout:        codes.DeadlineExceeded,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	499:                              codes.Canceled,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// grpcStack is the gRPC counterpart of the HTTP middleware stack
+type grpcStack struct {
+	jwt           bool
+	publicMethods map[string]struct{}
+}
+
+func (s *grpcStack) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	err = s.serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		var handlerErr error
+		resp, handlerErr = handler(ctx, req)
+		return handlerErr
+	})
+	return resp, err
+}
+
+func (s *grpcStack) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.serve(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// serve runs call with request ID, logger, panic recovery, tracing, JWT auth and error mapping
+func (s *grpcStack) serve(ctx context.Context, fullMethod string, call func(ctx context.Context) error) (err error) {
+	requestId := grpcRequestID(ctx)
+	ctx = common.WithRequestID(ctx, requestId)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDMetadata, requestId))
+
+	log := plog.Get().With().Str("request_id", requestId).Logger()
+	ctx = log.WithContext(ctx)
+
+	if !isGRPCInfraMethod(fullMethod) {
+		start := time.Now()
+		log.Info().Str("grpc_method", fullMethod).Msg("Incoming gRPC Request")
+		defer func() {
+			log.Info().
+				Str("grpc_method", fullMethod).
+				Str("grpc_code", status.Code(err).String()).
+				Dur("elapsed_ms", time.Since(start)).
+				Msg("gRPC Request Finished")
+		}()
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			grpcPanicRecover(ctx, fullMethod, requestId, rec)
+			err = status.Error(codes.Internal, "Internal Server Error")
+		}
+	}()
+
+	ctx, span := monitoring.StartSpan(ctx, fullMethod)
+	defer span.End()
+	span.SetAttributes(attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", fullMethod))
+
+	if s.requiresJWT(fullMethod) {
+		jwtData, jwtErr := grpcAuthenticate(ctx)
+		if jwtErr != nil {
+			return GRPCStatus(jwtErr, requestId).Err()
+		}
+		ctx = phastosctx.WithJWT(ctx, jwtData)
+	}
+
+	if err = call(ctx); err != nil {
+		grpcStatus := GRPCStatus(err, requestId)
+		if grpcStatus.Code() == codes.Internal {
+			log.Err(err).Str("grpc_method", fullMethod).Msg("gRPC handler failed")
+		}
+		span.SetAttributes(attribute.String("rpc.grpc.status_code", grpcStatus.Code().String()))
+		return grpcStatus.Err()
+	}
+	return nil
+}
+
+func (s *grpcStack) requiresJWT(fullMethod string) bool {
+	if !s.jwt || isGRPCInfraMethod(fullMethod) {
+		return false
+	}
+	_, public := s.publicMethods[fullMethod]
+	return !public
+}
+
+// GRPCStatus converts a handler error to a gRPC status the way Response.SetError converts it
+// to an HTTP response: HttpError and the registered error mappers give the code and the message,
+// anything else is Internal without leaking the message. The HttpError code and the trace ID
+// travel as an errdetails.ErrorInfo (Reason = code).
+func GRPCStatus(err error, traceId string) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	if grpcStatus, ok := status.FromError(err); ok {
+		return grpcStatus
+	}
+
+	httpErr, ok := errors.Cause(err).(*HttpError)
+	if !ok {
+		httpErr, ok = mapError(err)
+	}
+	if !ok {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return status.New(codes.DeadlineExceeded, err.Error())
+		case errors.Is(err, context.Canceled):
+			return status.New(codes.Canceled, err.Error())
+		}
+		httpErr = InternalServerError("Internal Server Error", "INTERNAL_SERVER_ERROR")
+	}
+
+	code, found := grpcCodes[httpErr.Status]
+	switch {
+	case found:
+	case httpErr.Status >= http.StatusInternalServerError:
+		code = codes.Internal
+	case httpErr.Status >= http.StatusBadRequest:
+		code = codes.FailedPrecondition
+	default:
+		code = codes.Unknown
+	}
+
+	message := httpErr.Message
+	if code == codes.Internal {
+		message = "Internal Server Error"
+	}
+	grpcStatus := status.New(code, message)
+	if httpErr.Code == "" {
+		return grpcStatus
+	}
+	info := &errdetails.ErrorInfo{Reason: httpErr.Code, Domain: grpcErrorDomain()}
+	if traceId != "" {
+		info.Metadata = map[string]string{"trace_id": traceId}
+	}
+	if detailed, detailErr := grpcStatus.WithDetails(info); detailErr == nil {
+		return detailed
+	}
+	return grpcStatus
+}
+
+func grpcErrorDomain() string {
+	if name := os.Getenv("APP_NAME"); name != "" {
+		return name
+	}
+	return "phastos"
+}
+
+func grpcRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcRequestIDMetadata); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return helper.GenerateFastID()
+}
+
+func grpcAuthenticate(ctx context.Context) (*entity.JWTClaimData, *HttpError) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(grpcAuthorizationMetadata)
+	if len(values) == 0 || values[0] == "" {
+		return nil, Unauthorized(common.ErrInvalidTokenMessage, common.ErrInvalidTokenCode)
+	}
+	return ParseJWT(strings.TrimPrefix(values[0], "Bearer "))
+}
+
+// isGRPCInfraMethod reports the health and reflection methods, which are public and not logged
+func isGRPCInfraMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") || strings.HasPrefix(fullMethod, "/grpc.reflection.")
+}
+
+func grpcPanicRecover(ctx context.Context, fullMethod, traceId string, rec any) {
+	log := plog.Get()
+	marshalErr, _ := json.Marshal(rec)
+	notifDetail := new(sgw.Attachment)
+
+	clientIP := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP = p.Addr.String()
+	}
+	notifDetail.AddField(sgw.Field{
+		Title: "IP",
+		Value: clientIP,
+		Short: true,
+	}).AddField(sgw.Field{
+		Title: "gRPC Method",
+		Value: fullMethod,
+		Short: true,
+	}).AddField(sgw.Field{
+		Title: "StackTrace",
+		Value: string(debug.Stack()),
+	}).AddField(sgw.Field{
+		Title: "Error",
+		Value: string(marshalErr),
+	}).AddField(sgw.Field{
+		Title: "App Version",
+		Value: appVersion,
+		Short: true,
+	})
+
+	sendPanicNotification(ctx, traceId, notifDetail)
+	log.Error().Any("data", notifDetail).Msg("got panic at gRPC handler")
+}
+
+// grpcServerStream carries the context built by the interceptor to the stream handler
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/soheilhy/cmux"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/server"
+)
+
+// grpcStopTimeout bounds GracefulStop, long-lived streams are cut after it
+const grpcStopTimeout = 10 * time.Second
+
+// listenGRPC starts config.GRPCServer, on its own port or multiplexed on httpListener,
+// and returns the listener the HTTP server must serve plus the function stopping gRPC
+// once the HTTP server is shut down.
+func listenGRPC(config *server.Config, httpListener net.Listener) (net.Listener, func(), error) {
+	if config.GRPCServer == nil {
+		return httpListener, func() {}, nil
+	}
+	log := plog.Get()
+
+	if config.GRPCPort != 0 && config.GRPCPort != config.Port {
+		grpcListener, err := net.Listen("tcp4", fmt.Sprintf(":%d", config.GRPCPort))
+		if err != nil {
+			return nil, nil, err
+		}
+		go serveGRPC(config.GRPCServer, grpcListener)
+		log.Info().Int("port", config.GRPCPort).Msg("[PHASTOS][GRPC] gRPC server is running")
+		return httpListener, func() { stopGRPC(config.GRPCServer) }, nil
+	}
+
+	// same port: route HTTP/2 requests with content-type application/grpc to gRPC, the rest to HTTP
+	mux := cmux.New(httpListener)
+	grpcListener := mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	muxHttpListener := mux.Match(cmux.Any())
+	go serveGRPC(config.GRPCServer, grpcListener)
+	go func() {
+		if err := mux.Serve(); err != nil && !isClosedConnError(err) {
+			log.Err(err).Msg("[PHASTOS][GRPC] connection multiplexer stopped")
+		}
+	}()
+	log.Info().Int("port", config.Port).Msg("[PHASTOS][GRPC] gRPC server is running on the HTTP port")
+
+	return muxHttpListener, func() {
+		stopGRPC(config.GRPCServer)
+		mux.Close()
+	}, nil
+}
+
+func serveGRPC(grpcServer server.GRPC, listener net.Listener) {
+	if err := grpcServer.Serve(listener); err != nil && !isClosedConnError(err) {
+		log := plog.Get()
+		log.Fatal().Err(err).Msg("Failed to Serve gRPC")
+	}
+}
+
+// stopGRPC waits for the running RPCs, then cuts the remaining streams after grpcStopTimeout
+func stopGRPC(grpcServer server.GRPC) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(grpcStopTimeout):
+		grpcServer.Stop()
+	}
+}
+
+func isClosedConnError(err error) bool {
+	return err == cmux.ErrListenerClosed || err == cmux.ErrServerClosed || strings.Contains(err.Error(), "use of closed network connection")
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/server"
+)
+
+const grpcTestMethod = "/order.v1.OrderService/GetOrder"
+
+func grpcTestToken(t *testing.T) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user123",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, err := token.SignedString([]byte("test-secret-key"))
+	require.NoError(t, err)
+	return tokenString
+}
+
+func callUnary(stack *grpcStack, ctx context.Context, handler grpc.UnaryHandler) (any, error) {
+	return stack.unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: grpcTestMethod}, handler)
+}
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+		reason  string
+	}{
+		{"not found", NotFound("order not found", "ORDER_NOT_FOUND"), codes.NotFound, "order not found", "ORDER_NOT_FOUND"},
+		{"wrapped bad request", errors.Wrap(BadRequest("invalid id", "INVALID_ID"), "phastos.api.GetOrder"), codes.InvalidArgument, "invalid id", "INVALID_ID"},
+		{"unauthorized", Unauthorized("invalid token", "INVALID_TOKEN"), codes.Unauthenticated, "invalid token", "INVALID_TOKEN"},
+		{"too many requests", NewErr(WithErrorStatus(http.StatusTooManyRequests), WithErrorMessage("slow down"), WithErrorCode("RATE_LIMITED")), codes.ResourceExhausted, "slow down", "RATE_LIMITED"},
+		{"internal hides message", InternalServerError("db password leaked", "DB_ERROR"), codes.Internal, "Internal Server Error", "DB_ERROR"},
+		{"plain error", errors.New("boom"), codes.Internal, "Internal Server Error", "INTERNAL_SERVER_ERROR"},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, context.DeadlineExceeded.Error(), ""},
+		{"grpc status", status.Error(codes.Aborted, "aborted"), codes.Aborted, "aborted", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := GRPCStatus(tt.err, "trace-123")
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, tt.message, st.Message())
+			if tt.reason == "" {
+				assert.Empty(t, st.Details())
+				return
+			}
+			require.Len(t, st.Details(), 1)
+			info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+			require.True(t, ok)
+			assert.Equal(t, tt.reason, info.Reason)
+			assert.Equal(t, "trace-123", info.Metadata["trace_id"])
+		})
+	}
+}
+
+func TestGRPCStack_RequestID(t *testing.T) {
+	stack := &grpcStack{}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcRequestIDMetadata, "req-1"))
+
+	var requestId string
+	_, err := callUnary(stack, ctx, func(ctx context.Context, req any) (any, error) {
+		requestId = common.GetRequestID(ctx)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "req-1", requestId)
+}
+
+func TestGRPCStack_JWT(t *testing.T) {
+	originalKey := os.Getenv(common.EnvJWTSigningKey)
+	os.Setenv(common.EnvJWTSigningKey, "test-secret-key")
+	defer os.Setenv(common.EnvJWTSigningKey, originalKey)
+
+	stack := &grpcStack{jwt: true, publicMethods: map[string]struct{}{"/order.v1.OrderService/ListPublic": {}}}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return phastosctx.GetJWT(ctx), nil
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		_, err := callUnary(stack, context.Background(), handler)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer invalid"))
+		_, err := callUnary(stack, ctx, handler)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+grpcTestToken(t)))
+		resp, err := callUnary(stack, ctx, handler)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+	})
+
+	t.Run("public method", func(t *testing.T) {
+		_, err := stack.unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/order.v1.OrderService/ListPublic"},
+			func(ctx context.Context, req any) (any, error) { return nil, nil })
+		assert.NoError(t, err)
+	})
+
+	t.Run("health is public", func(t *testing.T) {
+		assert.False(t, stack.requiresJWT("/grpc.health.v1.Health/Check"))
+	})
+}
+
+func TestGRPCStack_Panic(t *testing.T) {
+	stack := &grpcStack{}
+	_, err := callUnary(stack, context.Background(), func(ctx context.Context, req any) (any, error) {
+		panic("something went wrong")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCStack_HandlerError(t *testing.T) {
+	stack := &grpcStack{}
+	_, err := callUnary(stack, context.Background(), func(ctx context.Context, req any) (any, error) {
+		return nil, NotFound("order not found", "ORDER_NOT_FOUND")
+	})
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "order not found", st.Message())
+}
+
+func TestListenGRPC_SamePort(t *testing.T) {
+	grpcServer, healthServer := newGRPCServer(&grpcConfig{publicMethods: map[string]struct{}{}, reflection: true})
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	config := &server.Config{Port: port, GRPCServer: grpcServer}
+
+	httpListener, stop, err := listenGRPC(config, listener)
+	require.NoError(t, err)
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go func() { _ = httpServer.Serve(httpListener) }()
+	defer func() {
+		_ = httpServer.Close()
+		stop()
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/ping")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	check, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check.Status)
+
+	healthServer.Shutdown()
+	check, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check.Status)
+}
+
+func TestWithGRPC(t *testing.T) {
+	app := NewApp(WithGRPC(WithGRPCPort(9090), WithGRPCJWT("/order.v1.OrderService/ListPublic")))
+	assert.NotNil(t, app.GRPC())
+	assert.True(t, app.grpcCfg.jwt)
+	assert.Contains(t, app.grpcCfg.publicMethods, "/order.v1.OrderService/ListPublic")
+
+	app.Config = &server.Config{}
+	app.attachGRPC()
+	assert.Equal(t, 9090, app.Config.GRPCPort)
+	assert.NotNil(t, app.Config.GRPCServer)
+	assert.NotNil(t, app.BeforeShutdown)
+}
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
 	"github.com/kodekoding/phastos/v2/go/common"
@@ -41,6 +44,16 @@ func (w *WrittenResponseWriter) Flush() {
 	}
 }
 
+// Hijack implements http.Hijacker for WebSocket upgrades
+func (w *WrittenResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("phastos.api.Hijack: response writer does not support hijacking")
+	}
+	w.written = true
+	return hijacker.Hijack()
+}
+
 func (w *WrittenResponseWriter) Written() bool {
 	return w.written
 }
@@ -81,6 +94,14 @@ func InitHandler(router http.Handler) http.Handler {
 // It contains paths that should skip the requestLogger middleware entirely.
 var skipLogPaths map[string]struct{}
 
+// defaultSkipLogPaths are never logged: the ping endpoint, the health probes and the metrics scrape.
+var defaultSkipLogPaths = map[string]struct{}{
+	"/ping":    {},
+	"/healthz": {},
+	"/readyz":  {},
+	"/metrics": {},
+}
+
 // setSkipLogPaths is called by App.Init() to pass the configured skip paths
 // into the requestLogger middleware closure.
 func setSkipLogPaths(paths map[string]struct{}) {
@@ -105,8 +126,8 @@ func requestLogger(next http.Handler) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check configurable skip paths (/ping is always skipped)
-		if r.URL.Path == "/ping" {
+		// Check configurable skip paths (/ping and the health probes are always skipped)
+		if _, skip := defaultSkipLogPaths[r.URL.Path]; skip {
 			next.ServeHTTP(w, r)
 			return
 		}
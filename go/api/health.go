@@ -0,0 +1,78 @@
+package api
+
+import (
+	"time"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/health"
+)
+
+const (
+	healthCheckDBMaster   = "database.master"
+	healthCheckDBFollower = "database.follower"
+	healthCheckRedis      = "redis"
+	healthCheckSSE        = "sse"
+	healthCheckWebSocket  = "websocket"
+	healthCheckCron       = "cron"
+)
+
+// WithShutdownDelay keeps the server running for delay after the termination signal while
+// /readyz already fails, so Kubernetes removes the pod from the endpoints before the
+// listener is closed. Set it to roughly the readiness probe period.
+func WithShutdownDelay(delay time.Duration) Options {
+	return func(app *App) {
+		app.ShutdownDelay = delay
+	}
+}
+
+// RegisterHealthCheck adds a check to the readiness probe (/readyz). Use health.WithLiveness()
+// to also run it in the liveness probe (/healthz). Registering an existing name replaces the check,
+// including the built-in ones (database.master, database.follower, redis, sse, websocket, cron).
+//
+//	app.RegisterHealthCheck("payment-gateway", health.CheckerFunc(pingGateway), health.WithTimeout(time.Second), health.WithOptional())
+func (app *App) RegisterHealthCheck(name string, checker health.Checker, opts ...health.CheckOption) {
+	app.health.Register(name, checker, opts...)
+}
+
+// Health returns the health check registry behind /healthz and /readyz
+func (app *App) Health() *health.Registry {
+	return app.health
+}
+
+// registerDefaultHealthChecks adds the checks of the resources loaded by the App, unless
+// a check with the same name was registered already.
+func (app *App) registerDefaultHealthChecks() {
+	register := func(name string, checker health.Checker) {
+		if !app.health.Has(name) {
+			app.health.Register(name, checker)
+		}
+	}
+
+	if db, ok := app.db.(*database.SQL); ok && db != nil {
+		register(healthCheckDBMaster, health.DBMaster(db))
+		register(healthCheckDBFollower, health.DBFollower(db))
+	}
+	if app.cache != nil {
+		register(healthCheckRedis, health.Redis(app.cache))
+	}
+	if app.sseEvent != nil {
+		register(healthCheckSSE, health.SSEHub(app.sseEvent))
+	}
+	if app.wsHub != nil {
+		register(healthCheckWebSocket, health.WebSocketHub(app.wsHub))
+	}
+	if app.cron != nil {
+		register(healthCheckCron, health.Cron(app.cron))
+	}
+}
+
+// failReadinessOnShutdown makes /readyz fail as soon as graceful shutdown starts.
+func (app *App) failReadinessOnShutdown() {
+	beforeShutdown := app.BeforeShutdown
+	app.BeforeShutdown = func() {
+		app.health.SetShuttingDown()
+		if beforeShutdown != nil {
+			beforeShutdown()
+		}
+	}
+}
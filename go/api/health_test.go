@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kodekoding/phastos/v2/go/health"
+)
+
+func TestApp_HealthProbes(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"))
+	app.RegisterHealthCheck("payment", health.CheckerFunc(func(context.Context) error {
+		return errors.New("gateway down")
+	}))
+	app.RegisterHealthCheck("self", health.CheckerFunc(func(context.Context) error { return nil }), health.WithLiveness())
+	app.Init()
+	app.flushPendingMiddlewares()
+
+	rr := httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "gateway down")
+
+	rr = httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"self"`)
+	assert.NotContains(t, rr.Body.String(), "payment")
+}
+
+func TestApp_ReadinessFailsOnShutdown(t *testing.T) {
+	var hookCalled bool
+	app := NewApp(WithTimezone("UTC"), WithShutdownDelay(10*time.Millisecond))
+	app.BeforeShutdown = func() { hookCalled = true }
+	app.failReadinessOnShutdown()
+	app.Init()
+	app.flushPendingMiddlewares()
+
+	rr := httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	start := time.Now()
+	app.Config.DrainBeforeShutdown()
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.True(t, hookCalled)
+	assert.True(t, app.Health().IsShuttingDown())
+
+	rr = httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), health.ErrShuttingDown.Error())
+}
+
+func TestApp_RegisterDefaultHealthChecks_KeepsCustomChecks(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"))
+	app.sseEvent = nil
+	app.RegisterHealthCheck("cron", health.CheckerFunc(func(context.Context) error { return nil }))
+	app.registerDefaultHealthChecks()
+
+	report := app.Health().Readiness(context.Background())
+	assert.Equal(t, health.StatusPass, report.Status)
+	assert.Len(t, report.Checks, 1)
+}
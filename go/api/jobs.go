@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kodekoding/phastos/v2/go/jobs"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const jobsAdminPath = "/admin/jobs"
+
+// WithJobs runs the background jobs of app.Jobs() with the App: the workers start with Start and
+// stop with it, letting the running jobs finish. The jobs are stored in the Redis cache of the App,
+// in process (lost on restart) without Redis. The handlers get the App context (cache,
+// notifications) with the request ID and the JWT claims of the request enqueuing the job.
+func WithJobs(opts ...jobs.Options) Options {
+	return func(app *App) {
+		app.jobsEnabled = true
+		app.jobsOpts = append(app.jobsOpts, opts...)
+	}
+}
+
+// WithJobsAdmin serves the states of the jobs of WithJobs at /admin/jobs (see jobs.AdminHandler)
+// behind middlewares, e.g. an admin authorization
+func WithJobsAdmin(middlewares ...func(http.Handler) http.Handler) Options {
+	return func(app *App) {
+		app.jobsAdmin = true
+		app.jobsAdminMiddlewares = middlewares
+	}
+}
+
+// Jobs returns the jobs Manager of WithJobs, to register the handlers and enqueue the jobs
+func (app *App) Jobs() *jobs.Manager {
+	if app.jobs == nil {
+		log := plog.Get()
+		log.Fatal().Msg("Jobs not initialized")
+	}
+	return app.jobs
+}
+
+// initJobs creates the jobs Manager, once the cache is loaded
+func (app *App) initJobs() {
+	if !app.jobsEnabled || app.jobs != nil {
+		return
+	}
+	var broker jobs.Broker
+	if app.cache != nil {
+		broker = jobs.NewRedisBroker(app.cache)
+	} else {
+		log := plog.Get()
+		log.Warn().Msg("[PHASTOS][JOBS] No Redis cache configured, the jobs are kept in process and lost on restart")
+		broker = jobs.NewMemoryBroker()
+	}
+	app.jobs = jobs.New(broker, app.jobsOpts...)
+}
+
+// mountJobsAdmin serves jobs.AdminHandler at /admin/jobs
+func (app *App) mountJobsAdmin() {
+	if app.jobs == nil || !app.jobsAdmin {
+		return
+	}
+	handler := http.StripPrefix(jobsAdminPath, jobs.AdminHandler(app.jobs))
+	for i := len(app.jobsAdminMiddlewares) - 1; i >= 0; i-- {
+		handler = app.jobsAdminMiddlewares[i](handler)
+	}
+	app.Http.Mount(jobsAdminPath, handler)
+}
+
+// startJobs runs the workers with the App context, returning the function stopping them
+func (app *App) startJobs() (stop func()) {
+	ctx, cancel := context.WithCancel(app.Ctx)
+	done := make(chan struct{})
+	go func() {
+		app.jobs.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
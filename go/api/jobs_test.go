@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/jobs"
+)
+
+func TestApp_WithJobs(t *testing.T) {
+	var authorized bool
+	admin := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorized = true
+			next.ServeHTTP(w, r)
+		})
+	}
+	app := NewApp(WithTimezone("UTC"), WithJobs(jobs.WithQueues("emails")), WithJobsAdmin(admin))
+	app.Init()
+	app.flushPendingMiddlewares()
+	assert.Equal(t, []string{"emails"}, app.Jobs().Queues())
+
+	job, err := app.Jobs().Enqueue(context.Background(), "welcome", map[string]string{"email": "a@b.c"}, jobs.WithQueue("emails"))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(InitHandler(app.Http))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/admin/jobs/emails/" + job.ID)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, authorized)
+	var got jobs.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, job.ID, got.ID)
+	assert.Equal(t, jobs.StateQueued, got.State)
+
+	resp, err = http.Get(server.URL + "/admin/jobs/")
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
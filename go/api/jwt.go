@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	"github.com/kodekoding/phastos/v2/go/entity"
+)
+
+// ParseJWT validates an HS256 token signed with JWT_SIGNING_KEY and returns its claims.
+// The error is a 401 HttpError (INVALID_KEY, INVALID_CLAIMS, TOKEN_NOT_VALID or INVALID_STRUCT_CLAIM),
+// shared by the HTTP, fasthttp and gRPC JWT authentication.
+func ParseJWT(token string) (*entity.JWTClaimData, *HttpError) {
+	signingKey := os.Getenv(common.EnvJWTSigningKey)
+	if signingKey == "" {
+		return nil, Unauthorized("JWT Signing Key is nil", "INVALID_KEY")
+	}
+
+	var keyFunc jwt.Keyfunc = func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != "HS256" {
+			return nil, errors.Errorf("unexpected jwt signing method=%v", token.Header["alg"])
+		}
+		return []byte(signingKey), nil
+	}
+
+	tokenData, errToken := jwt.ParseWithClaims(strings.TrimSpace(token), jwt.MapClaims{}, keyFunc)
+	if errToken != nil {
+		return nil, Unauthorized(errToken.Error(), "INVALID_CLAIMS")
+	}
+	if !tokenData.Valid {
+		return nil, Unauthorized("Token is not valid", "TOKEN_NOT_VALID")
+	}
+
+	claimByte, _ := json.Marshal(tokenData.Claims)
+	var result entity.JWTClaimData
+	if err := json.Unmarshal(claimByte, &result); err != nil {
+		return nil, Unauthorized("invalid struct claim", "INVALID_STRUCT_CLAIM")
+	}
+	result.Token = token
+	return &result, nil
+}
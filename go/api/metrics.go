@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/kodekoding/phastos/v2/go/database"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+// WithMetrics serves the Prometheus metrics at /metrics and turns on the built-in
+// instrumentation: HTTP requests by route pattern, database and Redis pools and latency,
+// cache hit/miss, cron runs, SSE clients and importer throughput.
+// Custom metrics are registered with metrics.Factory() or metrics.Register().
+func WithMetrics() Options {
+	return func(app *App) {
+		app.metricsEnabled = true
+		metrics.Enable()
+	}
+}
+
+// registerResourceMetrics exposes the pool stats of the resources loaded by the App
+func (app *App) registerResourceMetrics() {
+	if !app.metricsEnabled {
+		return
+	}
+	log := plog.Get()
+	if db, ok := app.db.(*database.SQL); ok && db != nil {
+		if err := db.RegisterMetrics(); err != nil {
+			log.Warn().Err(err).Msg("[PHASTOS][METRICS] failed to register database pool metrics")
+		}
+	}
+	if app.cache != nil {
+		if err := app.cache.RegisterMetrics("default"); err != nil {
+			log.Warn().Err(err).Msg("[PHASTOS][METRICS] failed to register redis pool metrics")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+func TestApp_WithMetrics(t *testing.T) {
+	t.Cleanup(metrics.Disable)
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0), WithMetrics())
+	app.Init()
+	app.AddController(&metricsTestController{})
+
+	for _, id := range []string{"7", "8"} {
+		rr := httptest.NewRecorder()
+		app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/orders/"+id, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `phastos_http_requests_total{method="GET",route="/v1/orders/{id}",status="200"} 2`)
+}
+
+func TestApp_WithoutMetrics_NoEndpoint(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"))
+	app.Init()
+	app.flushPendingMiddlewares()
+
+	rr := httptest.NewRecorder()
+	app.Http.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+type metricsTestController struct{}
+
+func (c *metricsTestController) GetConfig() ControllerConfig {
+	return ControllerConfig{
+		Path: "/orders",
+		Routes: []Route{
+			NewRoute("GET", HandlerV2(func(ctx context.Context) (any, error) {
+				return "ok", nil
+			}), WithPath("/{id}")),
+		},
+	}
+}
@@ -18,6 +18,27 @@ func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func PanicHandler(next http.Handler) http.Handler {
+	return recoverPanic(next, func(w http.ResponseWriter, _ *http.Request, err *HttpError) {
+		err.Write(w)
+	})
+}
+
+// panicHandler recovers the panics of the App handlers, answering with the error format of the App
+func (app *App) panicHandler(next http.Handler) http.Handler {
+	return recoverPanic(next, app.WriteHttpError)
+}
+
+// WriteHttpError writes err in the error format of the App (problem details, negotiated encoder).
+// Pass it to the middlewares writing their own errors, e.g. middlewares.WithIdempotencyErrorWriter.
+func (app *App) WriteHttpError(w http.ResponseWriter, r *http.Request, err *HttpError) {
+	response := NewResponse().SetHTTPError(err)
+	response.Negotiate(r)
+	response.options = app.responseOptions
+	response.Send(w)
+	ReleaseResponse(response)
+}
+
+func recoverPanic(next http.Handler, write func(w http.ResponseWriter, r *http.Request, err *HttpError)) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
@@ -29,9 +50,7 @@ func PanicHandler(next http.Handler) http.Handler {
 					middleware.PrintPrettyStack(rvr)
 				}
 
-				w.WriteHeader(http.StatusInternalServerError)
-				err := InternalServerError("server error", "SERVER_ERROR")
-				err.Write(w)
+				write(w, r, InternalServerError("server error", "SERVER_ERROR"))
 			}
 		}()
 
@@ -7,6 +7,15 @@ import (
 )
 
 func (app *App) loadNotification() {
+	if app.notifProvided {
+		return
+	}
+	if app.appConfig != nil {
+		if cfg := app.appConfig.Notifications; cfg.Slack != nil || cfg.Telegram != nil || cfg.FCM != nil {
+			app.WrapToApp(notifications.New(notifications.WithConfig(cfg)))
+			return
+		}
+	}
 	slackWebhookURL := os.Getenv("NOTIFICATIONS_SLACK_WEBHOOK_URL")
 	var notifOptions []notifications.Options
 	if slackWebhookURL != "" {
@@ -113,6 +113,12 @@ func autoTag(path string, prefixCounts map[string]int) string {
 	return strings.Join(parts, " ")
 }
 
+// OpenAPISpec returns the OpenAPI 3.0.3 spec generated from the registered routes, the one
+// served at /docs/openapi.json with WithOpenAPI
+func (app *App) OpenAPISpec() *openapi3.T {
+	return app.buildOpenAPISpec()
+}
+
 // buildOpenAPISpec generates an OpenAPI 3.0.3 spec from all registered routes.
 func (app *App) buildOpenAPISpec() *openapi3.T {
 	spec := &openapi3.T{
@@ -226,13 +232,19 @@ func (app *App) buildOperation(item *openapi3.PathItem, entry routeRegistryEntry
 		}
 	}
 
+	if extensions := policyExtensions(entry.Policy); len(extensions) > 0 {
+		operation.Extensions = extensions
+	}
+
 	if entry.Doc.RequestType != nil {
 		schemaRef := app.schemaRefOrValue(entry.Doc.RequestType, schemaNames)
-		operation.RequestBody = &openapi3.RequestBodyRef{
-			Value: openapi3.NewRequestBody().
-				WithJSONSchemaRef(schemaRef).
-				WithRequired(true),
+		requestBody := openapi3.NewRequestBody().WithRequired(true)
+		if hasFileFields(entry.Doc.RequestType) {
+			requestBody.WithContent(openapi3.NewContentWithSchemaRef(schemaRef, []string{ContentFormData}))
+		} else {
+			requestBody.WithJSONSchemaRef(schemaRef)
 		}
+		operation.RequestBody = &openapi3.RequestBodyRef{Value: requestBody}
 	}
 
 	if entry.Doc.SelectResponseType != nil {
@@ -275,6 +287,11 @@ func (app *App) buildOperation(item *openapi3.PathItem, entry routeRegistryEntry
 			)
 		}
 	}
+	if entry.Policy.RateLimit != nil && !entry.Policy.RateLimit.Limit.IsZero() {
+		if tooMany := operation.Responses.Status(429); tooMany != nil && tooMany.Value != nil {
+			tooMany.Value.Headers = rateLimitHeaders()
+		}
+	}
 
 	// Security
 	if entry.Doc.Security != nil {
@@ -352,7 +369,7 @@ func (app *App) generateSchema(model any) *openapi3.Schema {
 
 		name := strings.Split(jsonTag, ",")[0]
 		if name == "" {
-			name = field.Name
+			name = formFieldName(field)
 		}
 
 		propSchema := app.fieldToSchema(field)
@@ -381,6 +398,12 @@ func (app *App) fieldToSchema(field reflect.StructField) *openapi3.Schema {
 	}
 
 	// Special types
+	if t == fileHeaderType {
+		return &openapi3.Schema{
+			Type:   schemaTypeString,
+			Format: "binary",
+		}
+	}
 	if t == reflect.TypeOf(time.Time{}) {
 		return &openapi3.Schema{
 			Type:   schemaTypeString,
@@ -467,12 +490,29 @@ func autoErrorResponses(entry routeRegistryEntry) []ErrorResponseDoc {
 		})
 	}
 
+	if entry.Policy.MaxBodyBytes > 0 {
+		resp = append(resp, ErrorResponseDoc{
+			StatusCode:  413,
+			Code:        "REQUEST_BODY_TOO_LARGE",
+			Description: "Request body exceeds the route limit",
+		})
+	}
+
+	resp = append(resp, ErrorResponseDoc{
+		StatusCode:  422,
+		Code:        "UNPROCESSABLE_ENTITY",
+		Description: "Business logic / processing error",
+	})
+
+	if entry.Policy.RateLimit != nil && !entry.Policy.RateLimit.Limit.IsZero() {
+		resp = append(resp, ErrorResponseDoc{
+			StatusCode:  429,
+			Code:        "RATE_LIMITED",
+			Description: "Rate limit of the route exceeded (" + entry.Policy.RateLimit.Limit.Policy() + ")",
+		})
+	}
+
 	resp = append(resp,
-		ErrorResponseDoc{
-			StatusCode:  422,
-			Code:        "UNPROCESSABLE_ENTITY",
-			Description: "Business logic / processing error",
-		},
 		ErrorResponseDoc{
 			StatusCode:  500,
 			Code:        "INTERNAL_SERVER_ERROR",
@@ -480,6 +520,21 @@ func autoErrorResponses(entry routeRegistryEntry) []ErrorResponseDoc {
 		},
 	)
 
+	if entry.Policy.MaxConcurrency > 0 {
+		resp = append(resp, ErrorResponseDoc{
+			StatusCode:  503,
+			Code:        "ROUTE_OVERLOADED",
+			Description: "Too many concurrent requests",
+		})
+	}
+	if entry.Policy.Timeout > 0 {
+		resp = append(resp, ErrorResponseDoc{
+			StatusCode:  504,
+			Code:        "REQUEST_TIMEOUT",
+			Description: "Request did not complete within the route timeout",
+		})
+	}
+
 	return resp
 }
 
@@ -568,3 +623,20 @@ func generateSelectResponseSchema(itemRef *openapi3.SchemaRef) *openapi3.Schema
 
 	return schema
 }
+
+// rateLimitHeaders documents the headers of the responses rejected by a rate limit
+func rateLimitHeaders() openapi3.Headers {
+	header := func(description string) *openapi3.HeaderRef {
+		return &openapi3.HeaderRef{Value: &openapi3.Header{Parameter: openapi3.Parameter{
+			Description: description,
+			Schema:      openapi3.NewStringSchema().NewRef(),
+		}}}
+	}
+	return openapi3.Headers{
+		"Retry-After":         header("Seconds before the next request is allowed"),
+		"RateLimit-Limit":     header("Requests allowed at once"),
+		"RateLimit-Remaining": header("Requests left at once"),
+		"RateLimit-Reset":     header("Seconds before the whole quota is back"),
+		"RateLimit-Policy":    header("Quota and its window in seconds, e.g. 10;w=60"),
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"runtime/debug"
@@ -62,24 +63,30 @@ func panicRecover(r *http.Request, traceId string, uniqueKey ...string) {
 			Short: true,
 		})
 
-		asyncCtx := context2.CreateAsyncContext(r.Context())
-		go func() {
-			notif := context2.GetNotif(asyncCtx)
-			if notif == nil {
-				return
-			}
-			allNotifPlatform := notif.GetAllPlatform()
-			for _, service := range allNotifPlatform {
-				if service.IsActive() {
-					service.SetTraceId(traceId)
+		sendPanicNotification(r.Context(), traceId, notifDetail)
+		log.Error().Any("data", notifDetail).Msg("got panic at handler")
+	}
+}
 
-					if err := service.Send(asyncCtx, "your API is panic !!", notifDetail); err != nil {
-						log.Error().Msgf("error when send %s notifications: %s", service.Type(), err.Error())
-					}
-				}
+// sendPanicNotification sends the panic detail to every active notification platform in the background
+func sendPanicNotification(ctx context.Context, traceId string, notifDetail *sgw.Attachment) {
+	log := plog.Get()
+	asyncCtx := context2.CreateAsyncContext(ctx)
+	go func() {
+		notif := context2.GetNotif(asyncCtx)
+		if notif == nil {
+			return
+		}
+		allNotifPlatform := notif.GetAllPlatform()
+		for _, service := range allNotifPlatform {
+			if service.IsActive() {
+				service.SetTraceId(traceId)
 
+				if err := service.Send(asyncCtx, "your API is panic !!", notifDetail); err != nil {
+					log.Error().Msgf("error when send %s notifications: %s", service.Type(), err.Error())
+				}
 			}
-		}()
-		log.Error().Any("data", notifDetail).Msg("got panic at handler")
-	}
+
+		}
+	}()
 }
@@ -1,21 +1,93 @@
 package api
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/kodekoding/phastos/v2/go/cache"
 	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/entity"
+	"github.com/kodekoding/phastos/v2/go/env"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/notifications"
 )
 
+// WithDatabase uses db instead of connecting to DATABASE_CONN_STRING_MASTER, e.g. a fake in tests
+func WithDatabase(db database.ISQL) Options {
+	return func(app *App) {
+		app.db = db
+		app.trx = db.GetTransaction()
+	}
+}
+
+// WithCache uses c as the cache of the requests (cache.GetCacheFromContext) instead of
+// connecting to REDIS_CONN_STRING
+func WithCache(c cache.Caches) Options {
+	return func(app *App) {
+		app.cacheProvided = true
+		app.useCache(c)
+	}
+}
+
+// useCache puts c in the request context; the Redis store behind it is checked by the health
+// endpoint and exposed on the metrics
+func (app *App) useCache(c cache.Caches) {
+	switch driver := c.(type) {
+	case *cache.Store:
+		app.cache = driver
+		app.WrapToApp(driver)
+	case *cache.TwoTier:
+		app.cache = driver.L2()
+		app.WrapToApp(driver)
+	default:
+		app.WrapToApp(&cacheWrapper{cache: c})
+	}
+}
+
+// WithNotifications uses platforms instead of the ones configured from NOTIFICATIONS_* env
+func WithNotifications(platforms notifications.Platforms) Options {
+	return func(app *App) {
+		app.notifProvided = true
+		app.WrapToApp(platforms)
+	}
+}
+
+// cacheWrapper puts a Caches that is not a *cache.Store in the request context
+type cacheWrapper struct {
+	cache cache.Caches
+}
+
+func (w *cacheWrapper) WrapToHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), entity.CacheContext{}, w.cache)
+		*request = *request.WithContext(ctx)
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (w *cacheWrapper) WrapToContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, entity.CacheContext{}, w.cache)
+}
+
 func (app *App) loadResources() {
 	log := plog.Get()
 	var err error
 
 	// load DB + transactions
+	if app.db == nil && app.appConfig != nil && app.appConfig.Database.Master.DSN(app.appConfig.Database.Master.Engine) != "" {
+		app.db, err = database.ConnectWithConfig(&app.appConfig.Database)
+		if err != nil {
+			log.Fatal().Msgf("Can't load database: %s", err.Error())
+		}
+		app.trx = app.db.GetTransaction()
+	}
+
 	connString := os.Getenv("DATABASE_CONN_STRING_MASTER")
-	if connString != "" {
+	if connString != "" && app.db == nil {
 		app.db, err = database.Connect()
 		if err != nil {
 			log.Fatal().Msgf("Can't load database: %s", err.Error())
@@ -25,15 +97,34 @@ func (app *App) loadResources() {
 		app.trx = app.db.GetTransaction()
 	}
 
+	if redisCfg := app.configuredRedis(); !app.cacheProvided && redisCfg != nil {
+		app.openCache(cache.WithConfig(*redisCfg))
+		app.cacheProvided = true
+	}
+
 	redisConnection := os.Getenv("REDIS_CONN_STRING")
-	if redisConnection != "" {
+	cacheDriver := os.Getenv("CACHE_DRIVER")
+	sentinelMaster := os.Getenv("REDIS_SENTINEL_MASTER")
+	clusterAddresses, _ := env.Lookup[[]string]("REDIS_CLUSTER_ADDRESSES", nil)
+	redisConfigured := redisConnection != "" || sentinelMaster != "" || len(clusterAddresses) > 0
+	if (redisConfigured || cacheDriver == cache.DriverMemory) && !app.cacheProvided && app.cache == nil {
 		redisTimeout, _ := strconv.Atoi(os.Getenv("REDIS_TIMEOUT"))
 		redisMaxActive, _ := strconv.Atoi(os.Getenv("REDIS_MAX_ACTIVE"))
 		redisMaxIdle, _ := strconv.Atoi(os.Getenv("REDIS_MAX_IDLE"))
 		redisMaxRetry, _ := strconv.Atoi(os.Getenv("REDIS_MAX_RETRY"))
 		redisDB, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		l1MaxEntries, _ := strconv.Atoi(os.Getenv("CACHE_L1_MAX_ENTRIES"))
+		l1TTL, _ := strconv.Atoi(os.Getenv("CACHE_L1_TTL"))
+		sentinelAddresses, _ := env.Lookup[[]string]("REDIS_SENTINEL_ADDRESSES", nil)
+		redisTLS, _ := env.Lookup("REDIS_TLS", false)
+		redisTLSSkipVerify, _ := env.Lookup("REDIS_TLS_SKIP_VERIFY", false)
+		streamMaxLen, _ := env.Lookup("REDIS_STREAM_MAX_LEN", 0)
+		streamRetention, _ := env.Lookup("REDIS_STREAM_RETENTION", 0)
 
-		cacheService := cache.New(
+		cacheOpts := []cache.Options{
+			cache.WithDriver(cacheDriver),
+			cache.WithL1MaxEntries(l1MaxEntries),
+			cache.WithL1TTL(l1TTL),
 			cache.WithAddress(redisConnection),
 			cache.WithDatabaseNo(redisDB),
 			cache.WithTimeout(redisTimeout),
@@ -42,8 +133,56 @@ func (app *App) loadResources() {
 			cache.WithMaxRetry(redisMaxRetry),
 			cache.WithPassword(os.Getenv("REDIS_PASSWORD")),
 			cache.WithUsername(os.Getenv("REDIS_USERNAME")),
-		)
+			cache.WithSentinel(sentinelMaster, sentinelAddresses...),
+			cache.WithSentinelAuth(os.Getenv("REDIS_SENTINEL_USERNAME"), os.Getenv("REDIS_SENTINEL_PASSWORD")),
+			cache.WithCluster(clusterAddresses...),
+			cache.WithStreamMaxLen(streamMaxLen),
+			cache.WithStreamRetention(time.Duration(streamRetention) * time.Second),
+		}
+		if codecName := os.Getenv("REDIS_CODEC"); codecName != "" {
+			codec, err := cache.CodecByName(codecName)
+			if err != nil {
+				log := plog.Get()
+				log.Fatal().Msgf("Can't load cache: %s", err.Error())
+			}
+			cacheOpts = append(cacheOpts, cache.WithCodec(codec))
+		}
+		if compressionName := os.Getenv("REDIS_COMPRESSION"); compressionName != "" {
+			compression, err := cache.CompressionByName(compressionName)
+			if err != nil {
+				log := plog.Get()
+				log.Fatal().Msgf("Can't load cache: %s", err.Error())
+			}
+			compressionThreshold, _ := env.Lookup("REDIS_COMPRESSION_THRESHOLD", 0)
+			cacheOpts = append(cacheOpts, cache.WithCompression(compression, compressionThreshold))
+		}
+		if redisTLS {
+			cacheOpts = append(cacheOpts, cache.WithTLS(nil), cache.WithTLSCAFile(os.Getenv("REDIS_TLS_CA_FILE")))
+			if redisTLSSkipVerify {
+				cacheOpts = append(cacheOpts, cache.WithTLSSkipVerify())
+			}
+		}
+		app.openCache(cacheOpts...)
+	}
+}
+
+// configuredRedis returns the redis section of the config when it configures a cache
+func (app *App) configuredRedis() *cache.RedisCfg {
+	if app.appConfig == nil {
+		return nil
+	}
+	cfg := &app.appConfig.Redis
+	if cfg.Address == "" && cfg.MasterName == "" && len(cfg.ClusterAddresses) == 0 && cfg.Driver != cache.DriverMemory {
+		return nil
+	}
+	return cfg
+}
 
-		app.WrapToApp(cacheService)
+func (app *App) openCache(options ...cache.Options) {
+	cacheService, err := cache.Open(options...)
+	if err != nil {
+		log := plog.Get()
+		log.Fatal().Msgf("Can't load cache: %s", err.Error())
 	}
+	app.useCache(cacheService)
 }
@@ -1,17 +1,18 @@
 package api
 
 import (
+	"bufio"
 	contextpkg "context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"sync"
 
 	sgw "github.com/ashwanthkumar/slack-go-webhook"
 	"github.com/kodekoding/phastos/v2/go/common"
-	custerr "github.com/kodekoding/phastos/v2/go/error"
 	"github.com/pkg/errors"
 
 	"github.com/kodekoding/phastos/v2/go/context"
@@ -29,6 +30,24 @@ var responsePool = sync.Pool{
 	},
 }
 
+// responseOptions are the settings of an App rendering its error responses. Every Response sent
+// by an App carries the options of that App, so the Apps of one process do not share them.
+type responseOptions struct {
+	problemDetails     bool
+	problemTypeBaseURI string
+	negotiation        bool               // WithContentNegotiation
+	compressionMinSize int                // minimum body size compressed by WithCompression (0 = disabled)
+	encoders           map[string]Encoder // by media type, see WithEncoder
+	jsonEncoder        Encoder
+}
+
+// defaultResponseOptions render the Responses sent outside of an App
+var defaultResponseOptions = newResponseOptions()
+
+func newResponseOptions() *responseOptions {
+	return &responseOptions{encoders: builtinEncoders(), jsonEncoder: JSONEncoder{}}
+}
+
 type ResponseRecorder struct {
 	http.ResponseWriter
 	StatusCode int
@@ -44,6 +63,16 @@ func (r *ResponseRecorder) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
+// Hijack implements http.Hijacker so WebSocket upgrades pass through the request logger
+func (r *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("phastos.api.Hijack: response writer does not support hijacking")
+	}
+	r.StatusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
 type Response struct {
 	Message          string `json:"message,omitempty"`
 	Data             any    `json:"data,omitempty"`
@@ -57,6 +86,10 @@ type Response struct {
 	fileContentType  string
 	fileDownloadName string
 	customHeader     map[string]string
+	instance         string
+	options          *responseOptions
+	accept           string
+	acceptEncoding   string
 }
 
 // NewResponse creates a new Response from the sync.Pool.
@@ -86,6 +119,10 @@ func (resp *Response) reset() {
 	resp.fileData = nil
 	resp.fileContentType = ""
 	resp.fileDownloadName = ""
+	resp.instance = ""
+	resp.options = nil
+	resp.accept = ""
+	resp.acceptEncoding = ""
 	if resp.customHeader != nil {
 		for k := range resp.customHeader {
 			delete(resp.customHeader, k)
@@ -93,6 +130,19 @@ func (resp *Response) reset() {
 	}
 }
 
+// clone returns a copy of resp from the pool, for a Response shared by several requests
+// (singleflight) that each send and release their own
+func (resp *Response) clone() *Response {
+	c := NewResponse()
+	customHeader := c.customHeader
+	*c = *resp
+	c.customHeader = customHeader
+	for k, v := range resp.customHeader {
+		c.SetCustomHeader(k, v)
+	}
+	return c
+}
+
 func (resp *Response) SetMessage(msg string) *Response {
 	resp.Message = msg
 	return resp
@@ -183,12 +233,11 @@ func (resp *Response) Send(w http.ResponseWriter) {
 		return
 	}
 
-	// default JSON response
-	w.Header().Set("Content-Type", "application/json")
-	var b []byte
-
-	var dataToMarshal any
+	// default JSON response, or the encoder negotiated from the Accept header
+	var dataToMarshal, listData any
 	var responseStatus int
+	var isProblem bool
+	options := resp.renderOptions()
 	if resp.Err != nil {
 		var respErr *HttpError
 		if errors.As(errors.Cause(resp.Err), &respErr) {
@@ -197,6 +246,10 @@ func (resp *Response) Send(w http.ResponseWriter) {
 				respErr.TraceId = resp.TraceId
 			}
 			dataToMarshal = respErr
+			if options.problemDetails {
+				isProblem = true
+				dataToMarshal = respErr.problem(resp.instance, options.problemTypeBaseURI)
+			}
 		}
 	} else {
 		responseStatus = resp.statusCode
@@ -204,20 +257,16 @@ func (resp *Response) Send(w http.ResponseWriter) {
 		if resp.Data != nil {
 			bodyContentAvailable = true
 			dataToMarshal = resp.Data
+			listData = resp.Data
 			if resp.MetaData != nil && resp.isPaginationData {
-				dataToMarshal = map[string]any{
-					jsonFieldData: resp.Data,
-					"metadata":    resp.MetaData,
-				}
+				dataToMarshal = paginatedEnvelope{Data: resp.Data, Metadata: resp.MetaData}
 			}
 		}
 
 		if resp.Message != "" {
 			bodyContentAvailable = true
-
-			dataToMarshal = map[string]string{
-				jsonFieldMessage: resp.Message,
-			}
+			listData = nil
+			dataToMarshal = messageEnvelope{Message: resp.Message}
 		}
 
 		if !bodyContentAvailable {
@@ -225,42 +274,58 @@ func (resp *Response) Send(w http.ResponseWriter) {
 		}
 	}
 
+	encoded := options.encodeResponseBody(dataToMarshal, listData, isProblem, resp.accept, resp.acceptEncoding)
+	defer encoded.release()
+	if vary := options.varyHeader(); vary != "" {
+		w.Header().Add("Vary", vary)
+	}
+	w.Header().Set("Content-Type", encoded.contentType)
+
+	if encoded.stream != nil {
+		w.WriteHeader(responseStatus)
+		flush := func() {}
+		if flusher, ok := w.(http.Flusher); ok {
+			flush = flusher.Flush
+		}
+		_ = encoded.stream.Stream(w, flush, encoded.streamData)
+		return
+	}
+
+	if encoded.contentEncoding != "" {
+		w.Header().Set("Content-Encoding", encoded.contentEncoding)
+	}
 	w.WriteHeader(responseStatus)
-	b, _ = json.Marshal(dataToMarshal)
+	_, _ = w.Write(encoded.body)
+}
 
-	_, _ = w.Write(b)
+// renderOptions returns the options of the App sending resp
+func (resp *Response) renderOptions() *responseOptions {
+	if resp.options == nil {
+		return defaultResponseOptions
+	}
+	return resp.options
+}
+
+// Negotiate records the request headers used to pick the response encoder (Accept),
+// the compression (Accept-Encoding) and the problem details instance. The App calls it
+// before Send; call it yourself when sending a Response from a custom http.Handler.
+func (resp *Response) Negotiate(r *http.Request) *Response {
+	resp.accept = r.Header.Get("Accept")
+	resp.acceptEncoding = r.Header.Get("Accept-Encoding")
+	resp.instance = r.URL.Path
+	return resp
 }
 
 func (resp *Response) SetError(err error) *Response {
 	causeErr := errors.Cause(err)
 
 	if httpErr, ok := causeErr.(*HttpError); ok {
-		resp.InternalError = httpErr
-		resp.Err = httpErr
-		if httpErr.Status == http.StatusInternalServerError {
-			resp.Err = errors.New("Internal Server Error")
-		}
-		return resp
+		return resp.setMappedError(httpErr)
 	}
 
-	// Handle constraint violations from database layer
-	if reqErr, ok := causeErr.(*custerr.RequestError); ok {
-		switch reqErr.GetCode() {
-		case http.StatusConflict:
-			constraint, _ := reqErr.GetData()["constraint"].(string)
-			if entry, found := constraintRegistry[constraint]; found {
-				resp.InternalError = ConflictError(entry.Message, entry.Code)
-				resp.Err = resp.InternalError
-				return resp
-			}
-			resp.InternalError = ConflictError("Data already exists", "DATA_CONFLICT")
-			resp.Err = resp.InternalError
-			return resp
-		case http.StatusUnprocessableEntity:
-			resp.InternalError = UnprocessableEntity("Data validation failed", "CONSTRAINT_VIOLATION")
-			resp.Err = resp.InternalError
-			return resp
-		}
+	// Registered mappers (sentinels, custom types) and database constraint violations
+	if httpErr, ok := mapError(err); ok {
+		return resp.setMappedError(httpErr)
 	}
 
 	resp.InternalError = NewErr(WithErrorCode("INTERNAL_SERVER_ERROR"), WithErrorMessage(err.Error()))
@@ -268,6 +333,15 @@ func (resp *Response) SetError(err error) *Response {
 	return resp
 }
 
+func (resp *Response) setMappedError(httpErr *HttpError) *Response {
+	resp.InternalError = httpErr
+	resp.Err = httpErr
+	if httpErr.Status == http.StatusInternalServerError {
+		resp.Err = errors.New("Internal Server Error")
+	}
+	return resp
+}
+
 func (resp *Response) SetHTTPError(err *HttpError) *Response {
 	resp.Err = err
 	return resp
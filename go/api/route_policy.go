@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
+)
+
+// RoutePolicy controls how a single route is executed. Timeout and Singleflight fall back to
+// the App settings (WithAPITimeout, SINGLEFLIGHT_ACTIVE) when unset; zero limits are disabled.
+type RoutePolicy struct {
+	Timeout        time.Duration
+	MaxBodyBytes   int64
+	MaxConcurrency int
+	Singleflight   bool
+	RateLimit      *RouteRateLimit
+}
+
+// RouteRateLimit is the rate limit of a route, see WithRateLimit
+type RouteRateLimit struct {
+	Limit ratelimit.Limit
+	// Key returns the client the requests are counted for (default client IP)
+	Key func(r *http.Request) string
+}
+
+// policyHandler carries the RoutePolicy of a route to wrapHandler.
+type policyHandler struct {
+	handler any
+	policy  RoutePolicy
+}
+
+func (p RoutePolicy) isZero() bool {
+	return p == RoutePolicy{}
+}
+
+// WithTimeout overrides the App API timeout for the route. Slow handlers are answered with 504 REQUEST_TIMEOUT.
+func WithTimeout(timeout time.Duration) RouteOption {
+	return func(r *Route) {
+		r.Policy.Timeout = timeout
+	}
+}
+
+// WithMaxBodyBytes limits the request body size of the route. Bigger bodies are rejected
+// with 413 REQUEST_BODY_TOO_LARGE.
+func WithMaxBodyBytes(limit int64) RouteOption {
+	return func(r *Route) {
+		r.Policy.MaxBodyBytes = limit
+	}
+}
+
+// WithMaxConcurrency limits the number of requests handled concurrently by the route.
+// Requests above the limit are rejected right away with 503 ROUTE_OVERLOADED.
+func WithMaxConcurrency(limit int) RouteOption {
+	return func(r *Route) {
+		r.Policy.MaxConcurrency = limit
+	}
+}
+
+// WithSingleflight collapses identical concurrent requests (method, path, query, client IP and
+// Authorization) into one handler call. Only use it for idempotent reads.
+func WithSingleflight() RouteOption {
+	return func(r *Route) {
+		r.Policy.Singleflight = true
+	}
+}
+
+// WithRateLimit limits the requests of each client to the route, counted in the Redis cache of the
+// App (shared by the instances) or in process without it. The client is the IP address unless key
+// is given, e.g. the API key or a JWT claim. Requests above the limit are rejected with 429
+// RATE_LIMITED and Retry-After.
+//
+//	api.WithRateLimit(ratelimit.PerMinute(10).WithBurst(3))
+func WithRateLimit(limit ratelimit.Limit, key ...func(r *http.Request) string) RouteOption {
+	return func(r *Route) {
+		r.Policy.RateLimit = &RouteRateLimit{Limit: limit}
+		if len(key) > 0 {
+			r.Policy.RateLimit.Key = key[0]
+		}
+	}
+}
+
+// WithRateLimiter sets the limiter counting the requests of the routes with WithRateLimit, instead
+// of the Redis cache of the App
+func WithRateLimiter(limiter ratelimit.Limiter) Options {
+	return func(app *App) {
+		app.rateLimiter = limiter
+	}
+}
+
+// routeRateLimiter returns the limiter of the routes, created on first use once the cache is loaded
+func (app *App) routeRateLimiter() ratelimit.Limiter {
+	if app.rateLimiter == nil {
+		if app.cache != nil {
+			app.rateLimiter = ratelimit.NewRedisLimiter(app.cache, ratelimit.NewMemoryLimiter())
+		} else {
+			app.rateLimiter = ratelimit.NewMemoryLimiter()
+		}
+	}
+	return app.rateLimiter
+}
+
+// allowRequest counts r against the rate limit of its route, answering it with 429 when it is
+// over. A failing limiter lets the request through.
+func (app *App) allowRequest(w http.ResponseWriter, r *http.Request, limiter ratelimit.Limiter, rateLimit *RouteRateLimit) bool {
+	client := clientIP(r)
+	if rateLimit.Key != nil {
+		client = rateLimit.Key(r)
+	}
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+
+	res, err := limiter.Allow(r.Context(), "route:"+r.Method+" "+route+":"+client, rateLimit.Limit)
+	if err != nil {
+		log := plog.Ctx(r.Context())
+		log.Warn().Err(err).Msg("[PHASTOS][RATELIMIT] limiter unavailable, skipping rate limit")
+		return true
+	}
+	ratelimit.SetHeaders(w.Header(), res)
+	if !res.Allowed {
+		app.writeError(w, r, TooManyRequest("rate limit exceeded", "RATE_LIMITED"))
+		return false
+	}
+	return true
+}
+
+// clientIP returns the first X-Forwarded-For address, X-Real-Ip or the remote address of r
+func clientIP(r *http.Request) string {
+	ip := r.Header.Get("X-Forwarded-For")
+	if ip == "" {
+		ip = r.Header.Get("X-Real-Ip")
+	}
+	if ip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	if idx := strings.Index(ip, ","); idx != -1 {
+		ip = strings.TrimSpace(ip[:idx])
+	}
+	return ip
+}
+
+// routeTimeout returns the effective timeout of a route.
+func (app *App) routeTimeout(policy RoutePolicy) time.Duration {
+	if policy.Timeout > 0 {
+		return policy.Timeout
+	}
+	return time.Second * time.Duration(app.apiTimeout)
+}
+
+// routeSingleflight reports whether singleflight is active for a request of a route. The key of a
+// request has no body, so SINGLEFLIGHT_ACTIVE only applies to the reads (GET and HEAD).
+func (app *App) routeSingleflight(method string, policy RoutePolicy) bool {
+	if policy.Singleflight {
+		return true
+	}
+	return app.sfActive && (method == http.MethodGet || method == http.MethodHead)
+}
+
+// withRoutePolicy enforces the rate limit, the body limit and the concurrency limit of a route in
+// front of next.
+func (app *App) withRoutePolicy(policy RoutePolicy, next http.HandlerFunc) http.HandlerFunc {
+	var slots chan struct{}
+	if policy.MaxConcurrency > 0 {
+		slots = make(chan struct{}, policy.MaxConcurrency)
+	}
+	var limiter ratelimit.Limiter
+	if policy.RateLimit != nil && !policy.RateLimit.Limit.IsZero() {
+		limiter = app.routeRateLimiter()
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil && !app.allowRequest(w, r, limiter, policy.RateLimit) {
+			return
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				app.writeError(w, r, ServiceUnavailable("too many concurrent requests, try again later", "ROUTE_OVERLOADED"))
+				return
+			}
+		}
+
+		if policy.MaxBodyBytes > 0 && r.Body != nil {
+			if r.ContentLength > policy.MaxBodyBytes {
+				app.writeError(w, r, bodyError(r, &http.MaxBytesError{Limit: policy.MaxBodyBytes}))
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, policy.MaxBodyBytes), limit: policy.MaxBodyBytes}
+		}
+
+		next(w, r)
+	}
+}
+
+// runHandlerV2 calls h with the singleflight and timeout of the route applied.
+func (app *App) runHandlerV2(ctx context.Context, r *http.Request, requestId string, h HandlerV2, policy RoutePolicy) (any, error) {
+	call := h
+	if app.routeSingleflight(r.Method, policy) {
+		key := generateUniqueRequestKey(r)
+		call = func(ctx context.Context) (any, error) {
+			result, err, shared := app.sf.Do(key, func() (any, error) {
+				return h(ctx)
+			})
+			// every request of a shared call sends and releases its own copy of the Response
+			if resp, ok := result.(*Response); ok && shared {
+				result = resp.clone()
+			}
+			return result, err
+		}
+	}
+
+	timeout := app.routeTimeout(policy)
+	if timeout <= 0 {
+		return call(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type handlerResult struct {
+		data any
+		err  error
+	}
+	done := make(chan handlerResult, 1)
+	go func() {
+		result := handlerResult{err: InternalServerError("internal error", "INTERNAL_ERROR")}
+		defer func() { done <- result }()
+		defer panicRecover(r, requestId)
+		result.data, result.err = call(timeoutCtx)
+	}()
+
+	select {
+	case result := <-done:
+		return result.data, result.err
+	case <-timeoutCtx.Done():
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+			return nil, timeoutError(timeout)
+		}
+		return nil, timeoutCtx.Err()
+	}
+}
+
+func timeoutError(timeout time.Duration) *HttpError {
+	return GatewayTimeout(fmt.Sprintf("request did not complete within %s", timeout), "REQUEST_TIMEOUT")
+}
+
+// writeError sends err as the response of r.
+func (app *App) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	requestId := r.Header.Get(common.RequestIDHeader)
+	if requestId == "" {
+		requestId = r.Header.Get("X-Request-ID")
+	}
+	w.Header().Set("X-Trace-ID", requestId)
+
+	resp := NewResponse().SetError(err)
+	app.handleResponseError(resp, r, requestId, r.Context())
+	resp.Send(w)
+	ReleaseResponse(resp)
+}
+
+// policyExtensions describes the policy of a route as OpenAPI x- extensions.
+func policyExtensions(policy RoutePolicy) map[string]any {
+	extensions := map[string]any{}
+	if policy.Timeout > 0 {
+		extensions["x-timeout-ms"] = policy.Timeout.Milliseconds()
+	}
+	if policy.MaxBodyBytes > 0 {
+		extensions["x-max-body-bytes"] = policy.MaxBodyBytes
+	}
+	if policy.MaxConcurrency > 0 {
+		extensions["x-max-concurrency"] = policy.MaxConcurrency
+	}
+	if policy.Singleflight {
+		extensions["x-singleflight"] = true
+	}
+	if policy.RateLimit != nil && !policy.RateLimit.Limit.IsZero() {
+		limit := policy.RateLimit.Limit
+		extensions["x-rate-limit"] = map[string]any{
+			"requests":  limit.Requests,
+			"period_ms": limit.Period.Milliseconds(),
+			"policy":    limit.Policy(),
+		}
+	}
+	return extensions
+}
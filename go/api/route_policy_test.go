@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
+)
+
+func TestRouteOptions_SetPolicy(t *testing.T) {
+	route := NewRoute("GET", HandlerV2(func(ctx context.Context) (any, error) { return nil, nil }),
+		WithPath("/policy"),
+		WithTimeout(2*time.Second),
+		WithMaxBodyBytes(1024),
+		WithMaxConcurrency(4),
+		WithSingleflight(),
+	)
+
+	assert.Equal(t, RoutePolicy{Timeout: 2 * time.Second, MaxBodyBytes: 1024, MaxConcurrency: 4, Singleflight: true}, route.Policy)
+	assert.False(t, route.Policy.isZero())
+	assert.True(t, RoutePolicy{}.isZero())
+}
+
+func TestRoutePolicy_V2Timeout_Returns504(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	handler := app.wrapHandler(policyHandler{
+		handler: HandlerV2(func(ctx context.Context) (any, error) {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			return "late", nil
+		}),
+		policy: RoutePolicy{Timeout: 20 * time.Millisecond},
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/slow", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "REQUEST_TIMEOUT")
+}
+
+func TestRoutePolicy_LegacyTimeout_Returns504(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	handler := app.wrapHandler(policyHandler{
+		handler: func(req Request, ctx context.Context) *Response {
+			time.Sleep(200 * time.Millisecond)
+			return NewResponse().SetData("late")
+		},
+		policy: RoutePolicy{Timeout: 20 * time.Millisecond},
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/slow", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "REQUEST_TIMEOUT")
+}
+
+func TestRoutePolicy_V2Panic_Recovered(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	handler := app.wrapHandler(policyHandler{
+		handler: HandlerV2(func(ctx context.Context) (any, error) { panic("boom") }),
+		policy:  RoutePolicy{Timeout: time.Second},
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRoutePolicy_MaxConcurrency_Returns503(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := app.wrapHandler(policyHandler{
+		handler: HandlerV2(func(ctx context.Context) (any, error) {
+			close(started)
+			<-release
+			return "ok", nil
+		}),
+		policy: RoutePolicy{MaxConcurrency: 1},
+	})
+
+	var wg sync.WaitGroup
+	first := httptest.NewRecorder()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler(first, httptest.NewRequest(http.MethodGet, "/v1/busy", nil))
+	}()
+	<-started
+
+	second := httptest.NewRecorder()
+	handler(second, httptest.NewRequest(http.MethodGet, "/v1/busy", nil))
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, second.Code)
+	assert.Contains(t, second.Body.String(), "ROUTE_OVERLOADED")
+	assert.Equal(t, "1", second.Header().Get("Retry-After"))
+}
+
+func TestRoutePolicy_LegacyMaxBodyBytes_Returns413(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	handler := app.wrapHandler(policyHandler{
+		handler: func(req Request, ctx context.Context) *Response {
+			payload := new(handler2TestPayload)
+			if err := req.GetBody(payload); err != nil {
+				return NewResponse().SetError(err)
+			}
+			return NewResponse().SetData(payload)
+		},
+		policy: RoutePolicy{MaxBodyBytes: 8},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/legacy", strings.NewReader(`{"name":"a very long name","value":1}`))
+	req.Header.Set("Content-Type", ContentJSON)
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "REQUEST_BODY_TOO_LARGE")
+}
+
+func TestRoutePolicy_Singleflight(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	var calls int32
+	release := make(chan struct{})
+	handler := app.wrapHandler(policyHandler{
+		handler: HandlerV2(func(ctx context.Context) (any, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return "shared", nil
+		}),
+		policy: RoutePolicy{Singleflight: true},
+	})
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, 5)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler(w, httptest.NewRequest(http.MethodGet, "/v1/shared?id=1", nil))
+		}(recorders[i])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, w := range recorders {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "shared")
+	}
+}
+
+func TestRoutePolicy_SingleflightApp(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(5))
+	app.Init()
+	app.sfActive = true
+
+	var calls int32
+	handler := app.wrapHandler(HandlerV2(func(ctx context.Context) (any, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		return NewResponse().SetData("shared").SetCustomHeader("X-Shared", "true"), nil
+	}))
+	send := func(method string) []*httptest.ResponseRecorder {
+		var wg sync.WaitGroup
+		recorders := make([]*httptest.ResponseRecorder, 5)
+		for i := range recorders {
+			recorders[i] = httptest.NewRecorder()
+			wg.Add(1)
+			go func(w *httptest.ResponseRecorder) {
+				defer wg.Done()
+				handler(w, httptest.NewRequest(method, "/v1/shared", nil))
+			}(recorders[i])
+		}
+		wg.Wait()
+		return recorders
+	}
+
+	for _, w := range send(http.MethodGet) {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "shared", "every request sends its own copy of the Response")
+		assert.Equal(t, "true", w.Header().Get("X-Shared"))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	send(http.MethodPost)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls), "SINGLEFLIGHT_ACTIVE does not collapse the writes")
+}
+
+func TestRoutePolicy_OpenAPIExtensions(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.routeRegistry = append(app.routeRegistry, routeRegistryEntry{
+		Method: http.MethodPost,
+		Path:   "/v1/policy",
+		Doc:    &RouteDoc{},
+		Policy: RoutePolicy{Timeout: 1500 * time.Millisecond, MaxBodyBytes: 1024, MaxConcurrency: 2, Singleflight: true},
+	})
+
+	spec := app.buildOpenAPISpec()
+	operation := spec.Paths.Find("/v1/policy").Post
+	require.NotNil(t, operation)
+	assert.Equal(t, int64(1500), operation.Extensions["x-timeout-ms"])
+	assert.Equal(t, int64(1024), operation.Extensions["x-max-body-bytes"])
+	assert.Equal(t, 2, operation.Extensions["x-max-concurrency"])
+	assert.Equal(t, true, operation.Extensions["x-singleflight"])
+	for _, status := range []int{413, 503, 504} {
+		assert.NotNil(t, operation.Responses.Status(status), status)
+	}
+}
+
+func TestRoutePolicy_RateLimit_Returns429(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	app.Init()
+
+	handler := app.wrapHandler(policyHandler{
+		handler: HandlerV2(func(ctx context.Context) (any, error) { return "ok", nil }),
+		policy:  RoutePolicy{RateLimit: &RouteRateLimit{Limit: ratelimit.PerMinute(2)}},
+	})
+	serve := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/otp", nil)
+		r.Header.Set("X-Forwarded-For", ip)
+		handler(w, r)
+		return w
+	}
+
+	first := serve("10.0.0.1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", first.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1").Code)
+
+	limited := serve("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Contains(t, limited.Body.String(), "RATE_LIMITED")
+	assert.Equal(t, "30", limited.Header().Get("Retry-After"))
+	assert.Equal(t, "0", limited.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2").Code, "the clients are limited apart")
+}
+
+func TestRoutePolicy_RateLimit_OpenAPI(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithAPITimeout(0))
+	route := NewRoute(http.MethodPost, HandlerV2(func(ctx context.Context) (any, error) { return nil, nil }),
+		WithPath("/v1/otp"),
+		WithRateLimit(ratelimit.PerMinute(10).WithBurst(3)),
+	)
+	app.routeRegistry = append(app.routeRegistry, routeRegistryEntry{
+		Method: http.MethodPost,
+		Path:   "/v1/otp",
+		Doc:    &RouteDoc{},
+		Policy: route.Policy,
+	})
+
+	spec := app.buildOpenAPISpec()
+	operation := spec.Paths.Find("/v1/otp").Post
+	require.NotNil(t, operation)
+	assert.Equal(t, map[string]any{"requests": 10, "period_ms": int64(60000), "policy": "3;w=18"}, operation.Extensions["x-rate-limit"])
+	tooMany := operation.Responses.Status(http.StatusTooManyRequests)
+	require.NotNil(t, tooMany)
+	assert.Contains(t, tooMany.Value.Headers, "Retry-After")
+	assert.Contains(t, tooMany.Value.Headers, "RateLimit-Remaining")
+}
@@ -39,10 +39,16 @@ func serveHTTPs(config *server.Config, secure bool) error {
 	if err != nil {
 		return err
 	}
+	listener, stopGRPCServer, err := listenGRPC(config, listener)
+	if err != nil {
+		return err
+	}
 
 	sign := WaitTermSig(config.Ctx, func(ctx context.Context) error {
 
 		<-ctx.Done()
+		config.DrainBeforeShutdown()
+		defer stopGRPCServer()
 
 		stopped := make(chan struct{})
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
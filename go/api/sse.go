@@ -0,0 +1,29 @@
+package api
+
+import (
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/sse"
+)
+
+// WithSSEBackplane shares the SSE hub of WithSSE between the instances of the App through its Redis
+// cache: the messages reach the clients of every instance, the replay buffers are kept in Redis and
+// a message ID is sent once. Without Redis the hub serves the clients of this instance only.
+func WithSSEBackplane(opts ...sse.RedisBackplaneOption) Options {
+	return func(app *App) {
+		app.sseBackplane = true
+		app.sseBackplaneOpts = append(app.sseBackplaneOpts, opts...)
+	}
+}
+
+// initSSEBackplane sets the Redis backplane of the SSE hub, once the cache is loaded
+func (app *App) initSSEBackplane() {
+	if !app.sseBackplane || app.sseEvent == nil {
+		return
+	}
+	if app.cache == nil {
+		log := plog.Get()
+		log.Warn().Msg("[PHASTOS][SSE] No Redis cache configured, the SSE hub serves the clients of this instance only")
+		return
+	}
+	app.sseEvent.SetBackplane(sse.NewRedisBackplane(app.cache, app.sseBackplaneOpts...))
+}
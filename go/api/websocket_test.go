@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/ws"
+)
+
+func TestApp_WithWebSocket(t *testing.T) {
+	app := NewApp(WithTimezone("UTC"), WithWebSocket())
+	app.WebSocket().On("echo", func(ctx context.Context, client *ws.Client, message *ws.Message) error {
+		return client.Send(&ws.Message{Event: "echo", Data: message.Data})
+	})
+	app.Init()
+	app.flushPendingMiddlewares()
+	assert.Equal(t, 2, app.TotalEndpoints)
+
+	// through InitHandler and the request logger, which must let the upgrade hijack the connection
+	server := httptest.NewServer(InitHandler(app.Http))
+	defer server.Close()
+	defer app.WebSocket().Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msg ws.Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, ws.EventConnected, msg.Event)
+
+	require.NoError(t, conn.WriteJSON(ws.Message{Event: "echo", Data: "hello"}))
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "echo", msg.Event)
+	assert.Equal(t, "hello", msg.Data)
+}
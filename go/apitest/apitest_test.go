@@ -0,0 +1,244 @@
+package apitest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/api"
+	"github.com/kodekoding/phastos/v2/go/cache"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/middlewares"
+)
+
+type (
+	user struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+
+	createUserRequest struct {
+		Name string `json:"name"`
+	}
+
+	userController struct {
+		db database.ISQL
+	}
+)
+
+func (c *userController) GetConfig() api.ControllerConfig {
+	jwtAuth := []func(http.Handler) http.Handler{middlewares.JWTAuth}
+	return api.ControllerConfig{
+		Path: "/users",
+		Routes: []api.Route{
+			{Method: http.MethodGet, Path: "/{id}", Version: 1, Handler: c.get, Doc: &api.RouteDoc{ResponseType: user{}}},
+			{Method: http.MethodPost, Path: "", Version: 1, Handler: c.create, Doc: &api.RouteDoc{RequestType: createUserRequest{}, ResponseType: user{}}},
+			{Method: http.MethodGet, Path: "/me", Version: 1, Handler: c.me, Middlewares: &jwtAuth, Doc: &api.RouteDoc{ResponseType: user{}}},
+			{Method: http.MethodGet, Path: "/broken", Version: 1, Handler: c.broken, Doc: &api.RouteDoc{ResponseType: user{}}},
+		},
+	}
+}
+
+func (c *userController) get(req api.Request, ctx context.Context) *api.Response {
+	var result user
+	err := cache.GetCacheFromContext(ctx).Get(ctx, "user:"+req.GetParams("id"), &result, func(ctx context.Context) (any, int64, error) {
+		err := c.db.Read(ctx, &database.QueryOpts{BaseQuery: "SELECT id, name FROM users WHERE id = ?", Result: &result})
+		return result, 60, err
+	})
+	if err != nil {
+		return api.NewResponse().SetError(api.NewErr(api.WithErrorStatus(http.StatusNotFound), api.WithErrorCode("USER_NOT_FOUND"), api.WithErrorMessage("user not found")))
+	}
+	return api.NewResponse().SetData(result)
+}
+
+func (c *userController) create(req api.Request, ctx context.Context) *api.Response {
+	var payload createUserRequest
+	if err := req.GetBody(&payload); err != nil {
+		return api.NewResponse().SetError(err)
+	}
+	resp, err := c.db.Write(ctx, &database.QueryOpts{CUDRequest: &database.CUDConstructData{
+		TableName: "users",
+		Action:    "insert",
+		Cols:      []string{"name"},
+		Values:    []interface{}{payload.Name},
+	}})
+	if err != nil {
+		return api.NewResponse().SetError(err)
+	}
+	_ = phastosctx.GetNotif(ctx).Slack().Send(ctx, "user created: "+payload.Name, nil)
+	return api.NewResponse().SetStatusCode(http.StatusCreated).SetData(user{ID: resp.LastInsertID, Name: payload.Name})
+}
+
+func (c *userController) me(req api.Request, ctx context.Context) *api.Response {
+	claims := phastosctx.GetJWT(ctx)
+	data, _ := claims.Data.(map[string]interface{})
+	return api.NewResponse().SetData(user{ID: int64(data["id"].(float64)), Name: fmt.Sprint(data["name"])})
+}
+
+// broken returns a body that does not match its documented response
+func (c *userController) broken(req api.Request, ctx context.Context) *api.Response {
+	return api.NewResponse().SetData(map[string]interface{}{"id": "not-a-number"})
+}
+
+func newTestApp(t *testing.T, opts ...Option) *App {
+	ta := New(t, opts...)
+	ta.AddController(&userController{db: ta.DB()})
+	return ta
+}
+
+func TestApp_ReadThroughCache(t *testing.T) {
+	ta := newTestApp(t)
+	ta.FakeDB().OnRead("FROM users", user{ID: 1, Name: "alice"})
+
+	ta.GET("/v1/users/1").
+		ExpectStatus(http.StatusOK).
+		ExpectJSONPath("id", 1).
+		ExpectJSONPath("name", "alice")
+
+	var cached user
+	require.NoError(t, ta.Cache().Get(context.Background(), "user:1", &cached))
+	assert.Equal(t, "alice", cached.Name)
+
+	// the second request is served from the cache
+	ta.GET("/v1/users/1").ExpectStatus(http.StatusOK)
+	assert.Len(t, ta.FakeDB().Reads(), 1)
+
+	// unstubbed reads return sql.ErrNoRows
+	newTestApp(t).GET("/v1/users/2").
+		ExpectStatus(http.StatusNotFound).
+		ExpectJSONPath("code", "USER_NOT_FOUND")
+}
+
+func TestApp_WriteAndNotify(t *testing.T) {
+	ta := newTestApp(t)
+
+	var created user
+	ta.POST("/v1/users").
+		WithJSON(createUserRequest{Name: "bob"}).
+		ExpectStatus(http.StatusCreated).
+		DecodeJSON(&created)
+	assert.Equal(t, user{ID: 1, Name: "bob"}, created)
+
+	writes := ta.FakeDB().Writes()
+	require.Len(t, writes, 1)
+	assert.Equal(t, "users", writes[0].CUDRequest.TableName)
+
+	sent := ta.Notifier().Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "slack", sent[0].Platform)
+	assert.Equal(t, "user created: bob", sent[0].Text)
+}
+
+func TestApp_JWT(t *testing.T) {
+	ta := newTestApp(t)
+
+	ta.GET("/v1/users/me").
+		WithJWT(map[string]interface{}{"id": 7, "name": "carol"}).
+		ExpectStatus(http.StatusOK).
+		ExpectJSON(user{ID: 7, Name: "carol"})
+
+	ta.GET("/v1/users/me").ExpectStatus(http.StatusUnauthorized)
+
+	expired := jwt.MapClaims{"Data": map[string]interface{}{"id": 7}, "exp": 1}
+	ta.GET("/v1/users/me").WithJWT(expired).ExpectStatus(http.StatusUnauthorized)
+}
+
+func TestApp_ExpectGolden(t *testing.T) {
+	dir := t.TempDir()
+	ta := newTestApp(t, WithGoldenDir(dir))
+	ta.FakeDB().OnRead("FROM users", user{ID: 1, Name: "alice"})
+
+	t.Setenv(EnvUpdateGolden, "true")
+	ta.GET("/v1/users/1").ExpectGolden("get_user")
+	ta.GET("/v1/users/1").ExpectGolden("get_user_masked", "name")
+	golden, err := os.ReadFile(filepath.Join(dir, "get_user.golden"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"id\": 1,\n  \"name\": \"alice\"\n}\n", string(golden))
+
+	t.Setenv(EnvUpdateGolden, "")
+	ta.GET("/v1/users/1").ExpectGolden("get_user")
+	masked, err := os.ReadFile(filepath.Join(dir, "get_user_masked.golden"))
+	require.NoError(t, err)
+	assert.Contains(t, string(masked), `"name": "<ignored>"`)
+
+	mock := &recordingT{TB: t}
+	newTestApp(t, WithGoldenDir(dir)).GET("/v1/users/9").T(mock).ExpectGolden("get_user")
+	assert.True(t, mock.failed, "a different body must not match the golden file")
+}
+
+func TestApp_OpenAPIValidation(t *testing.T) {
+	ta := newTestApp(t)
+
+	mock := &recordingT{TB: t}
+	ta.GET("/v1/users/broken").T(mock).ExpectStatus(http.StatusOK)
+	assert.True(t, mock.failed, "a body not matching the documented response must fail")
+
+	mock = &recordingT{TB: t}
+	ta.FakeDB().OnRead("FROM users", user{ID: 1, Name: "alice"})
+	ta.GET("/v1/users/1").T(mock).ExpectStatus(http.StatusOK)
+	assert.False(t, mock.failed, mock.errors)
+
+	// disabled
+	ta = newTestApp(t, WithoutOpenAPIValidation())
+	mock = &recordingT{TB: t}
+	ta.GET("/v1/users/broken").T(mock).ExpectStatus(http.StatusOK)
+	assert.False(t, mock.failed, mock.errors)
+}
+
+func TestMemoryCache_Streams(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+	require.NoError(t, c.XGroupCreateMkStream(ctx, "orders", "workers", "0"))
+	assert.Error(t, c.XGroupCreateMkStream(ctx, "orders", "workers", "0"))
+
+	first, err := c.XAdd(ctx, "orders", map[string]string{"id": "1"})
+	require.NoError(t, err)
+	_, err = c.XAdd(ctx, "orders", map[string]string{"id": "2"})
+	require.NoError(t, err)
+
+	messages, err := c.XReadGroup(ctx, "workers", "w1", []string{"orders"}, []string{">"}, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, first, messages[0].Messages[0].ID)
+
+	acked, err := c.XAck(ctx, "orders", "workers", first)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), acked)
+	acked, err = c.XAck(ctx, "orders", "workers", first)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), acked, "the message is no longer pending")
+
+	messages, err = c.XReadGroup(ctx, "workers", "w1", []string{"orders"}, []string{">"}, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "2", messages[0].Messages[0].Values["id"])
+
+	messages, err = c.XReadGroup(ctx, "workers", "w1", []string{"orders"}, []string{">"}, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+// recordingT records the failures instead of failing the test
+type recordingT struct {
+	testing.TB
+	failed bool
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) FailNow() {
+	r.failed = true
+}
@@ -0,0 +1,200 @@
+// Package apitest runs a Phastos App in memory for handler tests: fake database, cache and
+// notifications, signed JWTs, a fluent request builder, golden files and response validation
+// against the OpenAPI spec the App generates.
+//
+//	ta := apitest.New(t)
+//	ta.AddController(user.NewController(ta.DB()))
+//	ta.GET("/v1/users").WithJWT(claims).ExpectStatus(200).ExpectJSONPath("data.0.id", 1)
+package apitest
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/kodekoding/phastos/v2/go/api"
+	"github.com/kodekoding/phastos/v2/go/common"
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+const (
+	// DefaultSigningKey signs the tokens of WithJWT unless WithSigningKey is used
+	DefaultSigningKey = "apitest-signing-key"
+	// DefaultGoldenDir holds the golden files of ExpectGolden
+	DefaultGoldenDir = "testdata"
+	// EnvUpdateGolden rewrites the golden files instead of comparing them when set to true
+	EnvUpdateGolden = "APITEST_UPDATE"
+)
+
+type (
+	// Option configures the in-memory App
+	Option func(*config)
+
+	config struct {
+		appOptions      []api.Options
+		db              database.ISQL
+		signingKey      string
+		goldenDir       string
+		validateOpenAPI bool
+	}
+
+	// App is a Phastos App served in memory, with fake resources
+	App struct {
+		*api.App
+		t          testing.TB
+		db         database.ISQL
+		fakeDB     *FakeDB
+		cache      *MemoryCache
+		notifier   *FakeNotifier
+		signingKey string
+		goldenDir  string
+		validator  *openAPIValidator
+		handler    http.Handler
+	}
+)
+
+// WithAppOptions passes options to api.NewApp (WithAPITimeout, WithProblemDetails ...)
+func WithAppOptions(opts ...api.Options) Option {
+	return func(cfg *config) {
+		cfg.appOptions = append(cfg.appOptions, opts...)
+	}
+}
+
+// WithDatabase replaces the FakeDB, e.g. with a database/mocks MockISQL
+func WithDatabase(db database.ISQL) Option {
+	return func(cfg *config) {
+		cfg.db = db
+	}
+}
+
+// WithSigningKey sets JWT_SIGNING_KEY for the test (default DefaultSigningKey)
+func WithSigningKey(key string) Option {
+	return func(cfg *config) {
+		cfg.signingKey = key
+	}
+}
+
+// WithGoldenDir sets the directory of the golden files (default testdata)
+func WithGoldenDir(dir string) Option {
+	return func(cfg *config) {
+		cfg.goldenDir = dir
+	}
+}
+
+// WithoutOpenAPIValidation does not validate the responses against the OpenAPI spec
+func WithoutOpenAPIValidation() Option {
+	return func(cfg *config) {
+		cfg.validateOpenAPI = false
+	}
+}
+
+// New creates and initializes an App with a FakeDB, a MemoryCache and a FakeNotifier in place
+// of the resources loaded from env. Controllers are added with AddController, the handler is
+// built on the first request.
+func New(t testing.TB, opts ...Option) *App {
+	t.Helper()
+	cfg := &config{
+		signingKey:      DefaultSigningKey,
+		goldenDir:       DefaultGoldenDir,
+		validateOpenAPI: true,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	t.Setenv(common.EnvJWTSigningKey, cfg.signingKey)
+
+	ta := &App{
+		t:          t,
+		cache:      NewMemoryCache(),
+		notifier:   NewFakeNotifier(),
+		signingKey: cfg.signingKey,
+		goldenDir:  cfg.goldenDir,
+	}
+	ta.db = cfg.db
+	if ta.db == nil {
+		ta.fakeDB = NewFakeDB()
+		ta.db = ta.fakeDB
+	}
+
+	appOptions := []api.Options{
+		api.WithDatabase(ta.db),
+		api.WithCache(ta.cache),
+		api.WithNotifications(ta.notifier),
+		api.WithPprof(false),
+	}
+	ta.App = api.NewApp(append(appOptions, cfg.appOptions...)...)
+	ta.App.Init()
+
+	if cfg.validateOpenAPI {
+		ta.validator = &openAPIValidator{app: ta.App}
+	}
+	return ta
+}
+
+// DB returns the database given to the App, the FakeDB unless WithDatabase is used
+func (ta *App) DB() database.ISQL {
+	return ta.db
+}
+
+// FakeDB returns the fake database, nil when WithDatabase is used
+func (ta *App) FakeDB() *FakeDB {
+	return ta.fakeDB
+}
+
+// Cache returns the in-memory cache of the requests
+func (ta *App) Cache() *MemoryCache {
+	return ta.cache
+}
+
+// Notifier returns the fake notification platforms, recording every notification sent
+func (ta *App) Notifier() *FakeNotifier {
+	return ta.notifier
+}
+
+// Handler returns the HTTP handler of the App, the one Start serves
+func (ta *App) Handler() http.Handler {
+	if ta.handler == nil {
+		ta.handler = ta.App.BuildHandler()
+	}
+	return ta.handler
+}
+
+// GET starts a GET request
+func (ta *App) GET(path string) *Request {
+	return ta.NewRequest(http.MethodGet, path)
+}
+
+// POST starts a POST request
+func (ta *App) POST(path string) *Request {
+	return ta.NewRequest(http.MethodPost, path)
+}
+
+// PUT starts a PUT request
+func (ta *App) PUT(path string) *Request {
+	return ta.NewRequest(http.MethodPut, path)
+}
+
+// PATCH starts a PATCH request
+func (ta *App) PATCH(path string) *Request {
+	return ta.NewRequest(http.MethodPatch, path)
+}
+
+// DELETE starts a DELETE request
+func (ta *App) DELETE(path string) *Request {
+	return ta.NewRequest(http.MethodDelete, path)
+}
+
+// NewRequest starts a request with any method
+func (ta *App) NewRequest(method, path string) *Request {
+	return &Request{
+		app:    ta,
+		t:      ta.t,
+		method: method,
+		path:   path,
+		header: make(http.Header),
+	}
+}
+
+func updateGolden() bool {
+	return os.Getenv(EnvUpdateGolden) == "true" || os.Getenv(EnvUpdateGolden) == "1"
+}
@@ -0,0 +1,191 @@
+package apitest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+type (
+	// FakeDB is an in-memory database.ISQL for the Read / Write helpers of the repositories.
+	// Reads return the stubbed result of the first query fragment found in the base query,
+	// sql.ErrNoRows otherwise. Writes succeed with an incrementing LastInsertID unless stubbed.
+	// Raw queries (Get, Select, Exec ...) go to the embedded ISQL, e.g. a database/mocks MockISQL,
+	// and panic when it is nil.
+	FakeDB struct {
+		database.ISQL
+		mu           sync.Mutex
+		readStubs    []readStub
+		writeStubs   []writeStub
+		reads        []*database.QueryOpts
+		writes       []*database.QueryOpts
+		lastInsertID int64
+		trx          *FakeTransaction
+	}
+
+	readStub struct {
+		fragment string
+		result   any
+		err      error
+	}
+
+	writeStub struct {
+		table    string
+		action   string
+		response *database.CUDResponse
+		err      error
+	}
+
+	// FakeTransaction counts the transactions of a FakeDB, Begin returns a nil *sqlx.Tx
+	FakeTransaction struct {
+		mu        sync.Mutex
+		begins    int
+		commits   int
+		rollbacks int
+	}
+)
+
+// NewFakeDB creates an empty FakeDB
+func NewFakeDB() *FakeDB {
+	return &FakeDB{trx: new(FakeTransaction)}
+}
+
+// OnRead returns result (copied through JSON into QueryOpts.Result) for the reads whose base
+// query contains fragment, case-insensitively. Later stubs win over earlier ones.
+func (db *FakeDB) OnRead(fragment string, result any) *FakeDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readStubs = append([]readStub{{fragment: strings.ToLower(fragment), result: result}}, db.readStubs...)
+	return db
+}
+
+// OnReadError fails the reads whose base query contains fragment
+func (db *FakeDB) OnReadError(fragment string, err error) *FakeDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readStubs = append([]readStub{{fragment: strings.ToLower(fragment), err: err}}, db.readStubs...)
+	return db
+}
+
+// OnWrite answers the writes to table with action (database/action constants, empty for any)
+func (db *FakeDB) OnWrite(table, action string, response *database.CUDResponse, err error) *FakeDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.writeStubs = append([]writeStub{{table: table, action: action, response: response, err: err}}, db.writeStubs...)
+	return db
+}
+
+// Reads returns the options of every Read, in order
+func (db *FakeDB) Reads() []*database.QueryOpts {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]*database.QueryOpts(nil), db.reads...)
+}
+
+// Writes returns the options of every Write, in order
+func (db *FakeDB) Writes() []*database.QueryOpts {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]*database.QueryOpts(nil), db.writes...)
+}
+
+// Transaction returns the fake transaction of GetTransaction
+func (db *FakeDB) Transaction() *FakeTransaction {
+	return db.trx
+}
+
+// Read implements database.ISQL
+func (db *FakeDB) Read(ctx context.Context, opts *database.QueryOpts, additionalParams ...interface{}) error {
+	db.mu.Lock()
+	db.reads = append(db.reads, opts)
+	stubs := db.readStubs
+	db.mu.Unlock()
+
+	query := strings.ToLower(opts.BaseQuery)
+	for _, stub := range stubs {
+		if !strings.Contains(query, stub.fragment) {
+			continue
+		}
+		if stub.err != nil {
+			return stub.err
+		}
+		if opts.Result == nil {
+			return nil
+		}
+		raw, err := json.Marshal(stub.result)
+		if err != nil {
+			return errors.Wrap(err, "phastos.apitest.FakeDB.Read.MarshalStub")
+		}
+		return errors.Wrap(json.Unmarshal(raw, opts.Result), "phastos.apitest.FakeDB.Read.UnmarshalResult")
+	}
+	return sql.ErrNoRows
+}
+
+// Write implements database.ISQL
+func (db *FakeDB) Write(ctx context.Context, opts *database.QueryOpts, isSoftDelete ...bool) (*database.CUDResponse, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.writes = append(db.writes, opts)
+
+	var table, action string
+	if opts.CUDRequest != nil {
+		table, action = opts.CUDRequest.TableName, opts.CUDRequest.Action
+	}
+	for _, stub := range db.writeStubs {
+		if stub.table != table || (stub.action != "" && stub.action != action) {
+			continue
+		}
+		return stub.response, stub.err
+	}
+
+	db.lastInsertID++
+	return &database.CUDResponse{Status: true, RowsAffected: 1, LastInsertID: db.lastInsertID}, nil
+}
+
+// GetTransaction implements database.ISQL
+func (db *FakeDB) GetTransaction() database.Transactions {
+	return db.trx
+}
+
+// CachedRebind implements database.ISQL, the query is returned as is
+func (db *FakeDB) CachedRebind(query string) string {
+	return query
+}
+
+// Rebind implements database.ISQL, the query is returned as is
+func (db *FakeDB) Rebind(query string) string {
+	return query
+}
+
+// Begin implements database.Transactions
+func (t *FakeTransaction) Begin() (*sqlx.Tx, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.begins++
+	return nil, nil
+}
+
+// Finish implements database.Transactions, counting a rollback when errTransaction is set
+func (t *FakeTransaction) Finish(tx *sqlx.Tx, errTransaction error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if errTransaction != nil {
+		t.rollbacks++
+		return
+	}
+	t.commits++
+}
+
+// Counts returns the number of begins, commits and rollbacks
+func (t *FakeTransaction) Counts() (begins, commits, rollbacks int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.begins, t.commits, t.rollbacks
+}
@@ -0,0 +1,133 @@
+package apitest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/kodekoding/phastos/v2/go/entity"
+	"github.com/kodekoding/phastos/v2/go/notifications"
+)
+
+type (
+	// FakeNotifier is a notifications.Platforms whose Telegram, Slack and FCM record what they send
+	FakeNotifier struct {
+		telegram *FakeAction
+		slack    *FakeAction
+		fcm      *FakeAction
+	}
+
+	// FakeAction is an active notifications.Action recording every notification
+	FakeAction struct {
+		platform    string
+		mu          sync.Mutex
+		traceId     string
+		destination interface{}
+		sent        []SentNotification
+	}
+
+	// SentNotification is a notification recorded by a FakeAction
+	SentNotification struct {
+		Platform    string
+		Text        string
+		Attachment  interface{}
+		TraceId     string
+		Destination interface{}
+	}
+)
+
+// NewFakeNotifier creates a FakeNotifier with the three platforms active
+func NewFakeNotifier() *FakeNotifier {
+	return &FakeNotifier{
+		telegram: &FakeAction{platform: "telegram"},
+		slack:    &FakeAction{platform: "slack"},
+		fcm:      &FakeAction{platform: "fcm"},
+	}
+}
+
+// Sent returns the notifications of every platform
+func (n *FakeNotifier) Sent() []SentNotification {
+	var sent []SentNotification
+	for _, action := range []*FakeAction{n.telegram, n.slack, n.fcm} {
+		sent = append(sent, action.Sent()...)
+	}
+	return sent
+}
+
+// Reset forgets the recorded notifications
+func (n *FakeNotifier) Reset() {
+	for _, action := range []*FakeAction{n.telegram, n.slack, n.fcm} {
+		action.mu.Lock()
+		action.sent = nil
+		action.mu.Unlock()
+	}
+}
+
+func (n *FakeNotifier) Telegram() notifications.Action {
+	return n.telegram
+}
+
+func (n *FakeNotifier) Slack() notifications.Action {
+	return n.slack
+}
+
+func (n *FakeNotifier) FCM() notifications.Action {
+	return n.fcm
+}
+
+func (n *FakeNotifier) GetAllPlatform() []notifications.Action {
+	return []notifications.Action{n.telegram, n.slack, n.fcm}
+}
+
+func (n *FakeNotifier) WrapToHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), entity.NotifPlatformContext{}, n)
+		*request = *request.WithContext(ctx)
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (n *FakeNotifier) WrapToContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, entity.NotifPlatformContext{}, n)
+}
+
+// Sent returns the notifications of this platform
+func (a *FakeAction) Sent() []SentNotification {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]SentNotification(nil), a.sent...)
+}
+
+func (a *FakeAction) Send(ctx context.Context, text string, attachment interface{}) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = append(a.sent, SentNotification{
+		Platform:    a.platform,
+		Text:        text,
+		Attachment:  attachment,
+		TraceId:     a.traceId,
+		Destination: a.destination,
+	})
+	return nil
+}
+
+func (a *FakeAction) IsActive() bool {
+	return true
+}
+
+func (a *FakeAction) Type() string {
+	return a.platform
+}
+
+func (a *FakeAction) SetTraceId(traceId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.traceId = traceId
+}
+
+func (a *FakeAction) SetDestination(destination interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.destination = destination
+}
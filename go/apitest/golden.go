@@ -0,0 +1,53 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// goldenMask replaces the volatile values of a golden file
+const goldenMask = "<ignored>"
+
+// goldenSnapshot indents a JSON body and masks its trace_id and ignorePaths, other bodies are kept as is
+func goldenSnapshot(body []byte, ignorePaths []string) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body, nil
+	}
+	setJSONPath(doc, "trace_id", goldenMask)
+	for _, path := range ignorePaths {
+		setJSONPath(doc, path, goldenMask)
+	}
+	var snapshot bytes.Buffer
+	encoder := json.NewEncoder(&snapshot)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, errors.Wrap(err, "phastos.apitest.goldenSnapshot")
+	}
+	return snapshot.Bytes(), nil
+}
+
+// compareGolden compares snapshot with dir/name.golden, or writes it when EnvUpdateGolden is set
+func compareGolden(dir, name string, snapshot []byte) error {
+	path := filepath.Join(dir, name+".golden")
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return errors.Wrap(err, "phastos.apitest.compareGolden.MkdirAll")
+		}
+		return errors.Wrap(os.WriteFile(path, snapshot, 0o644), "phastos.apitest.compareGolden.WriteFile")
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "phastos.apitest.compareGolden: run with %s=true to create it", EnvUpdateGolden)
+	}
+	if !bytes.Equal(expected, snapshot) {
+		return errors.Errorf("%s differs (run with %s=true to update it)\nexpected:\n%s\nactual:\n%s", path, EnvUpdateGolden, expected, snapshot)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package apitest
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lookupJSONPath returns the value at a dot separated path of keys and array indexes
+// (data.0.id), decoded the way encoding/json decodes into an interface{}
+func lookupJSONPath(body []byte, path string) (any, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, errors.Wrapf(err, "phastos.apitest.lookupJSONPath: body is not JSON: %s", body)
+	}
+	if path == "" || path == "." {
+		return doc, nil
+	}
+
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[segment]
+			if !ok {
+				return nil, errors.Errorf("phastos.apitest.lookupJSONPath: %s not found", path)
+			}
+			current = value
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, errors.Errorf("phastos.apitest.lookupJSONPath: index %s of %s out of range (len %d)", segment, path, len(node))
+			}
+			current = node[index]
+		default:
+			return nil, errors.Errorf("phastos.apitest.lookupJSONPath: %s not found, %s is not an object or array", path, segment)
+		}
+	}
+	return current, nil
+}
+
+// setJSONPath replaces the value at path when it exists, used to mask volatile fields
+func setJSONPath(doc any, path string, value any) {
+	segments := strings.Split(path, ".")
+	current := doc
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch node := current.(type) {
+		case map[string]any:
+			if _, ok := node[segment]; !ok {
+				return
+			}
+			if last {
+				node[segment] = value
+				return
+			}
+			current = node[segment]
+		case []any:
+			if segment == "*" {
+				for _, item := range node {
+					setJSONPath(item, strings.Join(segments[i+1:], "."), value)
+				}
+				return
+			}
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return
+			}
+			if last {
+				node[index] = value
+				return
+			}
+			current = node[index]
+		default:
+			return
+		}
+	}
+}
+
+// normalizeJSON round-trips v through JSON so expected values compare with decoded ones
+// (int with float64, structs with maps)
+func normalizeJSON(v any) any {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var normalized any
+	if err = json.Unmarshal(raw, &normalized); err != nil {
+		return v
+	}
+	return normalized
+}
@@ -0,0 +1,11 @@
+package apitest
+
+import "github.com/kodekoding/phastos/v2/go/cache"
+
+// MemoryCache is the in-memory cache.Caches of the test App, with values, hashes and consumer groups
+type MemoryCache = cache.Memory
+
+// NewMemoryCache creates an empty MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return cache.NewMemory()
+}
@@ -0,0 +1,130 @@
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/api"
+)
+
+// openAPIValidator checks the responses against the spec the App generates from its RouteDoc.
+// Routes, statuses and content types the spec does not document are not validated.
+type openAPIValidator struct {
+	app  *api.App
+	once sync.Once
+	spec *openapi3.T
+	err  error
+}
+
+// load builds the spec once all the routes are registered, round-tripping it through the
+// loader so the component references resolve
+func (v *openAPIValidator) load() (*openapi3.T, error) {
+	v.once.Do(func() {
+		raw, err := json.Marshal(v.app.OpenAPISpec())
+		if err != nil {
+			v.err = errors.Wrap(err, "phastos.apitest.openAPIValidator.MarshalSpec")
+			return
+		}
+		v.spec, err = openapi3.NewLoader().LoadFromData(raw)
+		if err != nil {
+			v.err = errors.Wrap(err, "phastos.apitest.openAPIValidator.LoadSpec")
+		}
+	})
+	return v.spec, v.err
+}
+
+func (v *openAPIValidator) validate(req *http.Request, rec *httptest.ResponseRecorder) error {
+	spec, err := v.load()
+	if err != nil {
+		return err
+	}
+
+	path, pathItem, pathParams := matchPath(spec, req.URL.Path)
+	if pathItem == nil {
+		return nil
+	}
+	operation := pathItem.GetOperation(req.Method)
+	if operation == nil || operation.Responses == nil {
+		return nil
+	}
+	response := operation.Responses.Status(rec.Code)
+	if response == nil || response.Value == nil {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if len(response.Value.Content) > 0 && response.Value.Content.Get(mediaType) == nil {
+		return nil
+	}
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route: &routers.Route{
+				Spec:      spec,
+				Path:      path,
+				PathItem:  pathItem,
+				Method:    req.Method,
+				Operation: operation,
+			},
+		},
+		Status:  rec.Code,
+		Header:  rec.Header(),
+		Body:    io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+		Options: &openapi3filter.Options{IncludeResponseStatus: true},
+	}
+	return openapi3filter.ValidateResponse(context.Background(), input)
+}
+
+// matchPath finds the spec path of a request path, chi-style parameters ({id}, {id:[0-9]+})
+// matching any segment; static paths win over parameterized ones
+func matchPath(spec *openapi3.T, requestPath string) (string, *openapi3.PathItem, map[string]string) {
+	requestSegments := strings.Split(strings.Trim(requestPath, "/"), "/")
+
+	var (
+		bestPath   string
+		bestItem   *openapi3.PathItem
+		bestParams map[string]string
+		bestScore  = -1
+	)
+	for path, item := range spec.Paths.Map() {
+		segments := strings.Split(strings.Trim(path, "/"), "/")
+		if len(segments) != len(requestSegments) {
+			continue
+		}
+		params := make(map[string]string)
+		score := 0
+		matched := true
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+				if idx := strings.Index(name, ":"); idx >= 0 {
+					name = name[:idx]
+				}
+				params[name] = requestSegments[i]
+				continue
+			}
+			if segment != requestSegments[i] {
+				matched = false
+				break
+			}
+			score++
+		}
+		if matched && score > bestScore {
+			bestPath, bestItem, bestParams, bestScore = path, item, params, score
+		}
+	}
+	return bestPath, bestItem, bestParams
+}
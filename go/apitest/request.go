@@ -0,0 +1,246 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Request is built fluently and sent on the first Expect* (or Do) call
+type Request struct {
+	app     *App
+	t       testing.TB
+	method  string
+	path    string
+	header  http.Header
+	query   url.Values
+	body    []byte
+	bodyErr error
+	rec     *httptest.ResponseRecorder
+}
+
+// T reports the failures of this request on t, e.g. the *testing.T of a subtest
+func (r *Request) T(t testing.TB) *Request {
+	r.t = t
+	return r
+}
+
+// WithHeader sets a request header
+func (r *Request) WithHeader(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// WithQuery adds a query parameter
+func (r *Request) WithQuery(key, value string) *Request {
+	if r.query == nil {
+		r.query = make(url.Values)
+	}
+	r.query.Add(key, value)
+	return r
+}
+
+// WithToken sends token as a bearer token as is, e.g. an expired or malformed one
+func (r *Request) WithToken(token string) *Request {
+	return r.WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithJWT sends a token signed with the test signing key. claims is used as is when it is a
+// jwt.Claims, otherwise it becomes the Data of the token (context.GetJWT(ctx).Data) with a
+// one hour expiry.
+func (r *Request) WithJWT(claims any) *Request {
+	jwtClaims, ok := claims.(jwt.Claims)
+	if !ok {
+		jwtClaims = jwt.MapClaims{
+			"Data": claims,
+			"exp":  time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims).SignedString([]byte(r.app.signingKey))
+	if err != nil {
+		r.bodyErr = err
+		return r
+	}
+	return r.WithToken(token)
+}
+
+// WithJSON sends body encoded as JSON
+func (r *Request) WithJSON(body any) *Request {
+	r.body, r.bodyErr = json.Marshal(body)
+	r.header.Set("Content-Type", "application/json")
+	return r
+}
+
+// WithBody sends a raw body with its content type
+func (r *Request) WithBody(contentType string, body []byte) *Request {
+	r.body = body
+	r.header.Set("Content-Type", contentType)
+	return r
+}
+
+// WithForm sends fields as application/x-www-form-urlencoded
+func (r *Request) WithForm(fields url.Values) *Request {
+	return r.WithBody("application/x-www-form-urlencoded", []byte(fields.Encode()))
+}
+
+// WithMultipart sends fields and files (field name to file name and content) as multipart/form-data
+func (r *Request) WithMultipart(fields map[string]string, files map[string]File) *Request {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			r.bodyErr = err
+			return r
+		}
+	}
+	for name, file := range files {
+		part, err := writer.CreateFormFile(name, file.Name)
+		if err != nil {
+			r.bodyErr = err
+			return r
+		}
+		if _, err = part.Write(file.Content); err != nil {
+			r.bodyErr = err
+			return r
+		}
+	}
+	if err := writer.Close(); err != nil {
+		r.bodyErr = err
+		return r
+	}
+	return r.WithBody(writer.FormDataContentType(), buf.Bytes())
+}
+
+// File is an uploaded file of WithMultipart
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// Do sends the request once and returns the recorded response. The response is validated
+// against the OpenAPI spec of the App unless WithoutOpenAPIValidation is used.
+func (r *Request) Do() *httptest.ResponseRecorder {
+	r.t.Helper()
+	if r.rec != nil {
+		return r.rec
+	}
+	require.NoError(r.t, r.bodyErr, "apitest: building %s %s", r.method, r.path)
+
+	target := r.path
+	if len(r.query) > 0 {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req := httptest.NewRequest(r.method, target, body)
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+
+	r.rec = httptest.NewRecorder()
+	r.app.Handler().ServeHTTP(r.rec, req)
+
+	if r.app.validator != nil {
+		if err := r.app.validator.validate(req, r.rec); err != nil {
+			assert.Fail(r.t, "apitest: response does not match the OpenAPI spec", "%s %s: %s", r.method, r.path, err)
+		}
+	}
+	return r.rec
+}
+
+// Status returns the status code of the response
+func (r *Request) Status() int {
+	return r.Do().Code
+}
+
+// Body returns the body of the response
+func (r *Request) Body() []byte {
+	return r.Do().Body.Bytes()
+}
+
+// DecodeJSON decodes the body of the response into v
+func (r *Request) DecodeJSON(v any) *Request {
+	r.t.Helper()
+	require.NoError(r.t, json.Unmarshal(r.Body(), v), "apitest: decoding %s", r.Body())
+	return r
+}
+
+// ExpectStatus asserts the status code of the response
+func (r *Request) ExpectStatus(status int) *Request {
+	r.t.Helper()
+	rec := r.Do()
+	assert.Equal(r.t, status, rec.Code, "apitest: status of %s %s, body: %s", r.method, r.path, rec.Body.String())
+	return r
+}
+
+// ExpectHeader asserts a response header
+func (r *Request) ExpectHeader(key, value string) *Request {
+	r.t.Helper()
+	assert.Equal(r.t, value, r.Do().Header().Get(key), "apitest: header %s of %s %s", key, r.method, r.path)
+	return r
+}
+
+// ExpectJSONPath asserts the value at path (dot separated keys and indexes, e.g. data.0.id).
+// Numbers are compared by value, so 1 matches 1.0.
+func (r *Request) ExpectJSONPath(path string, expected any) *Request {
+	r.t.Helper()
+	actual, err := lookupJSONPath(r.Body(), path)
+	if !assert.NoError(r.t, err, "apitest: %s %s", r.method, r.path) {
+		return r
+	}
+	assert.Equal(r.t, normalizeJSON(expected), actual, "apitest: %s of %s %s", path, r.method, r.path)
+	return r
+}
+
+// ExpectJSONPathExists asserts that path is present in the response
+func (r *Request) ExpectJSONPathExists(path string) *Request {
+	r.t.Helper()
+	_, err := lookupJSONPath(r.Body(), path)
+	assert.NoError(r.t, err, "apitest: %s %s", r.method, r.path)
+	return r
+}
+
+// ExpectJSON asserts the whole response body, compared as JSON
+func (r *Request) ExpectJSON(expected any) *Request {
+	r.t.Helper()
+	var actual any
+	if !assert.NoError(r.t, json.Unmarshal(r.Body(), &actual), "apitest: decoding %s", r.Body()) {
+		return r
+	}
+	assert.Equal(r.t, normalizeJSON(expected), actual, "apitest: body of %s %s", r.method, r.path)
+	return r
+}
+
+// ExpectGolden compares the response body with testdata/<name>.golden (JSON is indented and
+// its trace_id replaced). Run the test with APITEST_UPDATE=true to write the file.
+// ignorePaths are replaced like trace_id, for volatile fields (created_at, data.0.id ...).
+func (r *Request) ExpectGolden(name string, ignorePaths ...string) *Request {
+	r.t.Helper()
+	snapshot, err := goldenSnapshot(r.Body(), ignorePaths)
+	if !assert.NoError(r.t, err, "apitest: golden %s", name) {
+		return r
+	}
+	if err = compareGolden(r.app.goldenDir, name, snapshot); err != nil {
+		assert.Fail(r.t, fmt.Sprintf("apitest: golden %s of %s %s", name, r.method, r.path), err.Error())
+	}
+	return r
+}
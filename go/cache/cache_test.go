@@ -96,7 +96,7 @@ func TestOptionFunctions(t *testing.T) {
 	assert.Equal(t, "admin", cfg.Username)
 	
 	WithDatabaseNo(2)(&cfg)
-	assert.Equal(t, 2, cfg.dbNo)
+	assert.Equal(t, 2, cfg.DB)
 }
 
 func TestStoreWrapToContext(t *testing.T) {
@@ -0,0 +1,451 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	clusterSlots           = 16384
+	clusterMaxRedirects    = 5
+	clusterRefreshInterval = time.Second
+)
+
+// Cluster is the Handler of a Redis Cluster. Its connections send every command to the master owning
+// the slot of its keys and follow the MOVED and ASK redirections. DEL, UNLINK and EXISTS on keys of
+// several slots are split per slot; the other multi-key commands need the keys in one slot, e.g.
+// sharing a hash tag: "{user:42}:profile" and "{user:42}:settings".
+type Cluster struct {
+	cfg   RedisCfg
+	seeds []string
+
+	mu          sync.RWMutex
+	slots       []string // master address of each slot, empty until the first refresh
+	pools       map[string]*redigo.Pool
+	refreshedAt time.Time
+}
+
+func newCluster(cfg RedisCfg) *Cluster {
+	return &Cluster{cfg: cfg, seeds: cfg.ClusterAddresses, pools: make(map[string]*redigo.Pool)}
+}
+
+// Get returns a connection routing the commands, which never fails: the errors are returned by Do
+func (c *Cluster) Get() redigo.Conn {
+	return &clusterConn{cluster: c, ctx: context.Background()}
+}
+
+// GetContext implements Handler
+func (c *Cluster) GetContext(ctx context.Context) (redigo.Conn, error) {
+	return &clusterConn{cluster: c, ctx: ctx}, nil
+}
+
+// Close closes the connections to the nodes
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for address, pool := range c.pools {
+		_ = pool.Close()
+		delete(c.pools, address)
+	}
+	return nil
+}
+
+func (c *Cluster) pool(address string) *redigo.Pool {
+	c.mu.RLock()
+	pool, ok := c.pools[address]
+	c.mu.RUnlock()
+	if ok {
+		return pool
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool, ok = c.pools[address]; !ok {
+		pool = newPool(&c.cfg, func() (string, error) { return address, nil })
+		c.pools[address] = pool
+	}
+	return pool
+}
+
+// Masters returns the addresses of the masters owning slots
+func (c *Cluster) Masters(ctx context.Context) ([]string, error) {
+	if err := c.ensureSlots(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seen := make(map[string]bool)
+	var masters []string
+	for _, address := range c.slots {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			masters = append(masters, address)
+		}
+	}
+	return masters, nil
+}
+
+// NodeConn returns a connection to the node at address, e.g. to SCAN its keys
+func (c *Cluster) NodeConn(ctx context.Context, address string) (redigo.Conn, error) {
+	return c.pool(address).GetContext(ctx)
+}
+
+func (c *Cluster) ensureSlots(ctx context.Context) error {
+	c.mu.RLock()
+	loaded := c.slots != nil
+	c.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return c.refresh(ctx)
+}
+
+// refresh loads the slots from CLUSTER SLOTS, asking the known masters then the seeds
+func (c *Cluster) refresh(ctx context.Context) error {
+	c.mu.RLock()
+	candidates := make([]string, 0, len(c.pools)+len(c.seeds))
+	for address := range c.pools {
+		candidates = append(candidates, address)
+	}
+	c.mu.RUnlock()
+	candidates = append(candidates, c.seeds...)
+
+	lastErr := errors.New("no cluster address")
+	for _, address := range candidates {
+		slots, err := c.loadSlots(ctx, address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.refreshedAt = time.Now()
+		c.mu.Unlock()
+		return nil
+	}
+	return errors.Wrap(lastErr, "phastos.cache.redis.Cluster.Refresh")
+}
+
+func (c *Cluster) loadSlots(ctx context.Context, address string) ([]string, error) {
+	conn, err := c.pool(address).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+	ranges, err := redigo.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		// [start, end, [host, port, id], replicas...]
+		fields, err := redigo.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, errors.New("invalid CLUSTER SLOTS reply")
+		}
+		start, _ := redigo.Int(fields[0], nil)
+		end, _ := redigo.Int(fields[1], nil)
+		node, err := redigo.Values(fields[2], nil)
+		if err != nil || len(node) < 2 || start < 0 || end >= clusterSlots {
+			return nil, errors.New("invalid CLUSTER SLOTS reply")
+		}
+		host, _ := redigo.String(node[0], nil)
+		port, _ := redigo.Int(node[1], nil)
+		if host == "" {
+			// the node answering, which does not know its own address
+			host, _, _ = net.SplitHostPort(address)
+		}
+		master := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = master
+		}
+	}
+	return slots, nil
+}
+
+// nodeOf returns the master of slot, any node when slot < 0
+func (c *Cluster) nodeOf(ctx context.Context, slot int) (string, error) {
+	if err := c.ensureSlots(ctx); err != nil {
+		return "", err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if slot >= 0 && c.slots[slot] != "" {
+		return c.slots[slot], nil
+	}
+	for _, address := range c.slots {
+		if address != "" {
+			return address, nil
+		}
+	}
+	return c.seeds[0], nil
+}
+
+// moved records the new master of slot and reloads the slots, at most once per clusterRefreshInterval
+func (c *Cluster) moved(ctx context.Context, slot int, address string) {
+	c.mu.Lock()
+	if c.slots != nil {
+		c.slots[slot] = address
+	}
+	stale := time.Since(c.refreshedAt) > clusterRefreshInterval
+	c.mu.Unlock()
+	if stale {
+		_ = c.refresh(ctx)
+	}
+}
+
+// do runs the command on the master of slot, following the redirections
+func (c *Cluster) do(ctx context.Context, slot int, commandName string, args ...any) (any, error) {
+	address, err := c.nodeOf(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	asking := false
+	for i := 0; i < clusterMaxRedirects; i++ {
+		conn, err := c.pool(address).GetContext(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.cache.redis.Cluster.GetContext")
+		}
+		if asking {
+			_, err = conn.Do("ASKING")
+		}
+		var reply any
+		if err == nil {
+			reply, err = conn.Do(commandName, args...)
+		}
+		_ = conn.Close()
+
+		var redisErr redigo.Error
+		if !errors.As(err, &redisErr) {
+			return reply, err
+		}
+		kind, rest, _ := strings.Cut(string(redisErr), " ")
+		switch kind {
+		case "MOVED", "ASK":
+			// MOVED 3999 127.0.0.1:6381
+			slotStr, target, _ := strings.Cut(rest, " ")
+			movedSlot, _ := strconv.Atoi(slotStr)
+			address, asking = target, kind == "ASK"
+			if kind == "MOVED" {
+				c.moved(ctx, movedSlot, target)
+			}
+		case "TRYAGAIN", "CLUSTERDOWN":
+			time.Sleep(100 * time.Millisecond)
+		default:
+			return reply, err
+		}
+	}
+	return nil, errors.Errorf("phastos.cache.redis.Cluster: too many redirections for %s", commandName)
+}
+
+// clusterConn routes the commands of Do per key. The commands of Send are pipelined on the node of the
+// first key sent, e.g. the HSETs of one hash or a SUBSCRIBE, until their replies are received.
+type clusterConn struct {
+	cluster *Cluster
+	ctx     context.Context
+
+	pipeline     redigo.Conn
+	pipelineSlot int
+	pending      int // replies of the pipeline not received yet
+}
+
+func (c *clusterConn) Close() error {
+	if c.pipeline != nil {
+		return c.pipeline.Close()
+	}
+	return nil
+}
+
+func (c *clusterConn) Err() error {
+	if c.pipeline != nil {
+		return c.pipeline.Err()
+	}
+	return nil
+}
+
+func (c *clusterConn) Do(commandName string, args ...any) (any, error) {
+	if c.pipeline != nil && (commandName == "" || c.pending > 0) {
+		// flush and receive the pipelined commands
+		c.pending = 0
+		return c.pipeline.Do(commandName, args...)
+	}
+
+	keys := commandKeys(commandName, args)
+	switch strings.ToUpper(commandName) {
+	case "DEL", "UNLINK", "EXISTS":
+		if bySlot := groupBySlot(keys); len(bySlot) > 1 {
+			var total int64
+			for _, slotKeys := range bySlot {
+				n, err := redigo.Int64(c.cluster.do(c.ctx, keySlot(slotKeys[0]), commandName, toArgs(slotKeys)...))
+				if err != nil {
+					return total, err
+				}
+				total += n
+			}
+			return total, nil
+		}
+	}
+
+	slot := -1
+	if len(keys) > 0 {
+		slot = keySlot(keys[0])
+	}
+	return c.cluster.do(c.ctx, slot, commandName, args...)
+}
+
+func (c *clusterConn) Send(commandName string, args ...any) error {
+	slot := -1
+	if keys := commandKeys(commandName, args); len(keys) > 0 {
+		slot = keySlot(keys[0])
+	}
+	if c.pipeline == nil {
+		address, err := c.cluster.nodeOf(c.ctx, slot)
+		if err != nil {
+			return err
+		}
+		if c.pipeline, err = c.cluster.pool(address).GetContext(c.ctx); err != nil {
+			c.pipeline = nil
+			return errors.Wrap(err, "phastos.cache.redis.Cluster.GetContext")
+		}
+		c.pipelineSlot = slot
+	} else if slot >= 0 && c.pipelineSlot >= 0 && slot != c.pipelineSlot {
+		return errors.Errorf("phastos.cache.redis.Cluster: pipelined %s on another slot", commandName)
+	}
+	if err := c.pipeline.Send(commandName, args...); err != nil {
+		return err
+	}
+	c.pending++
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	if c.pipeline == nil {
+		return nil
+	}
+	return c.pipeline.Flush()
+}
+
+func (c *clusterConn) Receive() (any, error) {
+	if c.pipeline == nil {
+		return nil, errors.New("phastos.cache.redis.Cluster: nothing sent")
+	}
+	if c.pending > 0 {
+		c.pending--
+	}
+	return c.pipeline.Receive()
+}
+
+// commandKeys returns the keys of a command
+func commandKeys(commandName string, args []any) []string {
+	switch strings.ToUpper(commandName) {
+	case "PING", "PUBLISH", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "SCAN", "INFO",
+		"ROLE", "CLUSTER", "ASKING", "ECHO", "SCRIPT", "":
+		return nil
+	case "EVAL", "EVALSHA":
+		if len(args) < 2 {
+			return nil
+		}
+		numKeys, _ := strconv.Atoi(argString(args[1]))
+		if numKeys <= 0 || len(args) < 2+numKeys {
+			return nil
+		}
+		return argStrings(args[2 : 2+numKeys])
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(argString(arg), "STREAMS") {
+				streams := args[i+1:]
+				return argStrings(streams[:len(streams)/2])
+			}
+		}
+		return nil
+	case "XGROUP":
+		if len(args) < 2 {
+			return nil
+		}
+		return []string{argString(args[1])}
+	case "DEL", "UNLINK", "EXISTS", "MGET":
+		return argStrings(args)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return []string{argString(args[0])}
+}
+
+func argString(arg any) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(arg)
+}
+
+func argStrings(args []any) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = argString(arg)
+	}
+	return strs
+}
+
+func toArgs(strs []string) []any {
+	args := make([]any, len(strs))
+	for i, str := range strs {
+		args[i] = str
+	}
+	return args
+}
+
+// groupBySlot groups keys per slot, keeping their order within a slot
+func groupBySlot(keys []string) [][]string {
+	index := make(map[int]int)
+	var groups [][]string
+	for _, key := range keys {
+		slot := keySlot(key)
+		i, ok := index[slot]
+		if !ok {
+			i = len(groups)
+			index[slot] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+// keySlot returns the cluster slot of key: the CRC16 of its hash tag ({...}), else of the key
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) of the cluster specification
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
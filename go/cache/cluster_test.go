@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNode is a Redis server of a fake topology, dialed through dialRedis
+type fakeNode struct {
+	redis *fakeRedis
+
+	mu        sync.Mutex
+	role      string         // ROLE reply
+	master    []string       // SENTINEL get-master-addr-by-name reply
+	owner     func(int) bool // slots served, nil: every slot
+	moved     string         // MOVED target of the slots not served
+	migrating map[int]string // ASK target of the slots migrating away
+	importing map[int]bool   // slots accepted after ASKING
+	slots     []any          // CLUSTER SLOTS reply
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{redis: newFakeRedis(), role: "master", migrating: map[int]string{}, importing: map[int]bool{}}
+}
+
+type fakeNodeConn struct {
+	redigo.Conn
+	node   *fakeNode
+	asking bool
+}
+
+func (c *fakeNodeConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	n := c.node
+	n.mu.Lock()
+	switch commandName {
+	case "PING":
+		n.mu.Unlock()
+		return "PONG", nil
+	case "ROLE":
+		defer n.mu.Unlock()
+		return []interface{}{[]byte(n.role)}, nil
+	case "SENTINEL":
+		defer n.mu.Unlock()
+		return []interface{}{[]byte(n.master[0]), []byte(n.master[1])}, nil
+	case "CLUSTER":
+		defer n.mu.Unlock()
+		return n.slots, nil
+	case "ASKING":
+		n.mu.Unlock()
+		c.asking = true
+		return "OK", nil
+	}
+
+	asking := c.asking
+	c.asking = false
+	if keys := commandKeys(commandName, args); len(keys) > 0 && n.owner != nil {
+		slot := keySlot(keys[0])
+		for _, key := range keys[1:] {
+			if keySlot(key) != slot {
+				n.mu.Unlock()
+				return nil, redigo.Error("CROSSSLOT Keys in request don't hash to the same slot")
+			}
+		}
+		if target, ok := n.migrating[slot]; ok {
+			n.mu.Unlock()
+			return nil, redigo.Error(fmt.Sprintf("ASK %d %s", slot, target))
+		}
+		if !n.owner(slot) && !(asking && n.importing[slot]) {
+			n.mu.Unlock()
+			return nil, redigo.Error(fmt.Sprintf("MOVED %d %s", slot, n.moved))
+		}
+	}
+	n.mu.Unlock()
+	return c.Conn.Do(commandName, args...)
+}
+
+// dialFakeNodes replaces dialRedis by the nodes of their address until the end of the test
+func dialFakeNodes(t *testing.T, nodes map[string]*fakeNode) {
+	dial := dialRedis
+	t.Cleanup(func() { dialRedis = dial })
+	dialRedis = func(network, address string, options ...redigo.DialOption) (redigo.Conn, error) {
+		node, ok := nodes[address]
+		if !ok {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("unknown address %s", address)}
+		}
+		return &fakeNodeConn{Conn: node.redis.Get(), node: node}, nil
+	}
+}
+
+func slotsRange(start, end int, address string) []any {
+	host, port, _ := net.SplitHostPort(address)
+	portNumber, _ := strconv.ParseInt(port, 10, 64)
+	return []any{int64(start), int64(end), []any{[]byte(host), portNumber, []byte("id")}}
+}
+
+// newFakeCluster returns 2 masters: A with the slots 0-8191 and B with 8192-16383
+func newFakeCluster(t *testing.T) (*Store, *fakeNode, *fakeNode) {
+	a, b := newFakeNode(), newFakeNode()
+	slots := []any{slotsRange(0, 8191, "10.0.0.1:7000"), slotsRange(8192, 16383, "10.0.0.2:7000")}
+	a.slots, b.slots = slots, slots
+	a.owner, a.moved = func(slot int) bool { return slot < 8192 }, "10.0.0.2:7000"
+	b.owner, b.moved = func(slot int) bool { return slot >= 8192 }, "10.0.0.1:7000"
+	dialFakeNodes(t, map[string]*fakeNode{"10.0.0.1:7000": a, "10.0.0.2:7000": b})
+
+	store := New(WithCluster("10.0.0.1:7000"), WithMaxRetry(1))
+	t.Cleanup(func() { _ = store.Pool.(*Cluster).Close() })
+	return store, a, b
+}
+
+// keyOn returns a key whose prefixed key hashes to the slots of the node
+func keyOn(t *testing.T, owner func(int) bool) string {
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if owner(keySlot(defaultPrefixKey + key)) {
+			return key
+		}
+	}
+	t.Fatal("no key found")
+	return ""
+}
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, 12739, keySlot("123456789"), "CRC16 XMODEM of the cluster specification")
+	assert.Equal(t, keySlot("user:42"), keySlot("{user:42}:profile"))
+	assert.Equal(t, keySlot("{user:42}:profile"), keySlot("phastos:{user:42}:settings"))
+	assert.Equal(t, keySlot("{}user"), keySlot("{}user"), "an empty hash tag hashes the whole key")
+	assert.NotEqual(t, keySlot("user"), keySlot("{}user"))
+
+	assert.Equal(t, []string{"a", "b"}, commandKeys("EVALSHA", []any{"sha", 2, "a", "b", "token"}))
+	assert.Equal(t, []string{"s1", "s2"}, commandKeys("XREADGROUP", []any{"GROUP", "g", "c", "STREAMS", "s1", "s2", ">", ">"}))
+	assert.Equal(t, []string{"stream"}, commandKeys("XGROUP", []any{"CREATE", "stream", "g", "$"}))
+	assert.Nil(t, commandKeys("PUBLISH", []any{"channel", "message"}))
+}
+
+func TestCluster_Routing(t *testing.T) {
+	ctx := context.Background()
+	store, a, b := newFakeCluster(t)
+
+	keyA, keyB := keyOn(t, a.owner), keyOn(t, b.owner)
+	require.NoError(t, store.Set(ctx, keyA, "on A"))
+	require.NoError(t, store.Set(ctx, keyB, "on B"))
+	assert.Equal(t, "on A", a.redis.values[defaultPrefixKey+keyA])
+	assert.Equal(t, "on B", b.redis.values[defaultPrefixKey+keyB])
+
+	var str string
+	require.NoError(t, store.Get(ctx, keyB, &str))
+	assert.Equal(t, "on B", str)
+
+	deleted, err := store.DeleteByPattern(ctx, "key:*")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, deleted, "every master is scanned")
+
+	require.NoError(t, store.WithTags("t").Set(ctx, keyA, "v"))
+	require.NoError(t, store.WithTags("t").Set(ctx, keyB, "v"))
+	deleted, err = store.InvalidateTags(ctx, "t")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, deleted, "the keys of several slots are deleted per slot")
+}
+
+func TestCluster_Redirections(t *testing.T) {
+	ctx := context.Background()
+	store, a, b := newFakeCluster(t)
+	keyA := keyOn(t, a.owner)
+	slot := keySlot(defaultPrefixKey + keyA)
+
+	// the slot migrates to B: A answers ASK, B accepts the command after ASKING
+	a.mu.Lock()
+	a.migrating[slot] = "10.0.0.2:7000"
+	a.mu.Unlock()
+	b.mu.Lock()
+	b.importing[slot] = true
+	b.mu.Unlock()
+	require.NoError(t, store.Set(ctx, keyA, "migrating"))
+	assert.Equal(t, "migrating", b.redis.values[defaultPrefixKey+keyA])
+
+	// the migration is done: A answers MOVED and the client learns the new owner
+	newOwner := func(s int) bool { return s == slot }
+	a.mu.Lock()
+	delete(a.migrating, slot)
+	ownerA := a.owner
+	a.owner = func(s int) bool { return ownerA(s) && s != slot }
+	a.mu.Unlock()
+	b.mu.Lock()
+	ownerB := b.owner
+	b.owner = func(s int) bool { return ownerB(s) || newOwner(s) }
+	b.mu.Unlock()
+
+	var str string
+	require.NoError(t, store.Get(ctx, keyA, &str))
+	assert.Equal(t, "migrating", str)
+	cluster := store.Pool.(*Cluster)
+	cluster.mu.RLock()
+	assert.Equal(t, "10.0.0.2:7000", cluster.slots[slot])
+	cluster.mu.RUnlock()
+}
+
+func TestSentinel_Failover(t *testing.T) {
+	ctx := context.Background()
+	sentinel, master, replica := newFakeNode(), newFakeNode(), newFakeNode()
+	sentinel.master = []string{"10.0.0.1", "6379"}
+	replica.role = "slave"
+	dialFakeNodes(t, map[string]*fakeNode{"10.0.0.9:26379": sentinel, "10.0.0.1:6379": master, "10.0.0.2:6379": replica})
+
+	store := New(WithSentinel("mymaster", "10.0.0.8:26379", "10.0.0.9:26379"), WithMaxRetry(1))
+	require.NoError(t, store.Set(ctx, "k", "before"))
+	assert.Equal(t, "before", master.redis.values["phastos:k"], "the unreachable sentinel is skipped")
+
+	// failover: the replica is promoted, the former master demoted
+	sentinel.mu.Lock()
+	sentinel.master = []string{"10.0.0.2", "6379"}
+	sentinel.mu.Unlock()
+	master.mu.Lock()
+	master.role = "slave"
+	master.mu.Unlock()
+	replica.mu.Lock()
+	replica.role = "master"
+	replica.mu.Unlock()
+
+	require.NoError(t, store.Set(ctx, "k", "after"))
+	assert.Equal(t, "after", replica.redis.values["phastos:k"])
+	assert.Equal(t, "before", master.redis.values["phastos:k"])
+}
+
+func TestRedisCfg_TLS(t *testing.T) {
+	cfg := RedisCfg{}
+	tlsConfig, err := cfg.tlsConfig()
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	cfg = RedisCfg{TLS: true, TLSSkipVerify: true}
+	tlsConfig, err = cfg.tlsConfig()
+	require.NoError(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+
+	cfg = RedisCfg{TLS: true, TLSCAFile: "testdata/missing.pem"}
+	_, err = cfg.dialOptions("", "")
+	assert.ErrorContains(t, err, "phastos.cache.redis.TLS.ReadCAFile")
+
+	assert.Equal(t, ModeCluster, (&RedisCfg{ClusterAddresses: []string{"a:1"}}).mode())
+	assert.Equal(t, ModeSentinel, (&RedisCfg{MasterName: "m"}).mode())
+	assert.Equal(t, ModeStandalone, (&RedisCfg{Address: "a:1"}).mode())
+}
@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codecs of WithCodec, by RedisCfg.Codec name
+const (
+	CodecJSON    = "json"
+	CodecMsgPack = "msgpack"
+	CodecGob     = "gob"
+	CodecProto   = "proto"
+)
+
+// Compression of the values of WithCompression
+type Compression byte
+
+// Compressions of WithCompression, by RedisCfg.Compression name ("gzip", "zstd")
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+)
+
+const (
+	// valueMagic starts the header of the values written with a codec or compressed: magic, codec ID,
+	// compression. The values without it are the strings as is and the JSON values of the releases
+	// before the codecs.
+	valueMagic      = 0x00
+	valueHeaderSize = 3
+	// rawCodecID marks a string stored as is in a value with a header
+	rawCodecID = 0
+
+	defaultCompressionThreshold = 1024
+)
+
+// Codec encodes the values of a Store, see WithCodec. The ID of a codec is written in the header of
+// its values, so values of different codecs can be read by any Store during a rollout.
+type Codec interface {
+	// ID identifies the codec in the stored values, 1-31 being reserved to the codecs of the package
+	ID() byte
+	Name() string
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, dest any) error
+}
+
+var (
+	// JSON encodes the values as encoding/json, the default. Its uncompressed values are written
+	// without header, as before the codecs.
+	JSON Codec = jsonCodec{}
+	// MsgPack encodes the values as MessagePack (vmihailenco/msgpack)
+	MsgPack Codec = msgpackCodec{}
+	// Gob encodes the values as encoding/gob
+	Gob Codec = gobCodec{}
+	// Proto encodes the proto.Message values as protobuf, decoding into a proto.Message destination
+	Proto Codec = protoCodec{}
+
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{}
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func init() {
+	for _, codec := range []Codec{JSON, MsgPack, Gob, Proto} {
+		codecs[codec.ID()] = codec
+	}
+}
+
+// RegisterCodec makes the values of codec readable by every Store and its name usable in
+// RedisCfg.Codec. Its ID must not be one of another codec.
+func RegisterCodec(codec Codec) error {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if codec.ID() == rawCodecID {
+		return errors.Wrap(errors.New("codec ID 0 is reserved"), "phastos.cache.RegisterCodec")
+	}
+	if existing, ok := codecs[codec.ID()]; ok && existing.Name() != codec.Name() {
+		return errors.Wrap(errors.Errorf("codec ID %d is used by %q", codec.ID(), existing.Name()), "phastos.cache.RegisterCodec")
+	}
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+// CodecByName returns the registered codec named name
+func CodecByName(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, errors.Wrap(errors.Errorf("unknown cache codec %q", name), "phastos.cache.CodecByName")
+}
+
+func codecByID(id byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, errors.Errorf("unknown cache codec ID %d", id)
+	}
+	return codec, nil
+}
+
+// String returns the name of c, as in RedisCfg.Compression
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// CompressionByName returns the compression named name ("", "none", "gzip" or "zstd")
+func CompressionByName(name string) (Compression, error) {
+	switch name {
+	case "", "none":
+		return NoCompression, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return NoCompression, errors.Wrap(errors.Errorf("unknown cache compression %q", name), "phastos.cache.CompressionByName")
+	}
+}
+
+type (
+	jsonCodec    struct{}
+	msgpackCodec struct{}
+	gobCodec     struct{}
+	protoCodec   struct{}
+)
+
+func (jsonCodec) ID() byte                              { return 1 }
+func (jsonCodec) Name() string                          { return CodecJSON }
+func (jsonCodec) Marshal(value any) ([]byte, error)     { return json.Marshal(value) }
+func (jsonCodec) Unmarshal(data []byte, dest any) error { return json.Unmarshal(data, dest) }
+
+func (msgpackCodec) ID() byte                              { return 2 }
+func (msgpackCodec) Name() string                          { return CodecMsgPack }
+func (msgpackCodec) Marshal(value any) ([]byte, error)     { return msgpack.Marshal(value) }
+func (msgpackCodec) Unmarshal(data []byte, dest any) error { return msgpack.Unmarshal(data, dest) }
+
+func (gobCodec) ID() byte     { return 3 }
+func (gobCodec) Name() string { return CodecGob }
+
+func (gobCodec) Marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, dest any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dest)
+}
+
+func (protoCodec) ID() byte     { return 4 }
+func (protoCodec) Name() string { return CodecProto }
+
+func (protoCodec) Marshal(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T is not a proto.Message", value)
+	}
+	return proto.Marshal(message)
+}
+
+func (protoCodec) Unmarshal(data []byte, dest any) error {
+	message, ok := dest.(proto.Message)
+	if !ok {
+		return errors.Errorf("%T is not a proto.Message", dest)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// WithCodec returns a Store writing the values that are not strings with codec, e.g. for the keys
+// of one feature:
+//
+//	err := store.WithCodec(cache.MsgPack).Set(ctx, "employees:page:1", page)
+//
+// The Store reads the values of every registered codec whatever its own.
+func (r *Store) WithCodec(codec Codec) *Store {
+	_ = RegisterCodec(codec)
+	encoded := *r
+	encoded.codec.codec = codec
+	return &encoded
+}
+
+// WithCompression returns a Store compressing the values of threshold bytes and more (default: the
+// threshold of the Store)
+func (r *Store) WithCompression(compression Compression, threshold ...int) *Store {
+	compressed := *r
+	compressed.codec.compression = compression
+	if len(threshold) > 0 && threshold[0] > 0 {
+		compressed.codec.threshold = threshold[0]
+	}
+	if compressed.codec.threshold <= 0 {
+		compressed.codec.threshold = defaultCompressionThreshold
+	}
+	return &compressed
+}
+
+// valueCodec encodes the values of a Store, see WithCodec and WithCompression
+type valueCodec struct {
+	codec       Codec // nil: JSON
+	compression Compression
+	threshold   int // size from which the values are compressed
+}
+
+// encode stores strings as is and the other values with the codec, both compressed from the
+// threshold. Uncompressed strings and JSON values are written without header.
+func (c valueCodec) encode(value any) (string, error) {
+	codecID := byte(rawCodecID)
+	var payload []byte
+	if str, isString := value.(string); isString {
+		payload = []byte(str)
+	} else {
+		codec := c.codec
+		if codec == nil {
+			codec = JSON
+		}
+		encoded, err := codec.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		payload = encoded
+		codecID = codec.ID()
+	}
+
+	compression := NoCompression
+	if c.compression != NoCompression && len(payload) >= c.threshold {
+		compressed, err := compress(c.compression, payload)
+		if err != nil {
+			return "", err
+		}
+		payload, compression = compressed, c.compression
+	}
+
+	headerless := codecID == rawCodecID || codecID == JSON.ID()
+	if compression == NoCompression && headerless && (len(payload) == 0 || payload[0] != valueMagic) {
+		return string(payload), nil
+	}
+	return string(append([]byte{valueMagic, codecID, byte(compression)}, payload...)), nil
+}
+
+// decodeValue fills a *string with a string value as is and decodes the other values into the
+// destination with the codec of their header, as JSON without header
+func decodeValue(value string, typeDestination any) error {
+	codecID, payload, err := unwrapValue(value)
+	if err != nil {
+		return err
+	}
+	if codecID == rawCodecID || codecID == JSON.ID() {
+		if strVal, isStringType := typeDestination.(*string); isStringType {
+			*strVal = string(payload)
+			return nil
+		}
+		return json.Unmarshal(payload, typeDestination)
+	}
+	codec, err := codecByID(codecID)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(payload, typeDestination)
+}
+
+// decodeString returns the stored text of a value: a string or JSON
+func decodeString(value string) (string, error) {
+	codecID, payload, err := unwrapValue(value)
+	if err != nil {
+		return "", err
+	}
+	if codecID != rawCodecID && codecID != JSON.ID() {
+		return "", errors.Errorf("value of cache codec ID %d is not text", codecID)
+	}
+	return string(payload), nil
+}
+
+// unwrapValue returns the codec ID and the uncompressed payload of value
+func unwrapValue(value string) (byte, []byte, error) {
+	if len(value) < valueHeaderSize || value[0] != valueMagic {
+		return rawCodecID, []byte(value), nil
+	}
+	codecID, compression, payload := value[1], Compression(value[2]), []byte(value[valueHeaderSize:])
+	if compression == NoCompression {
+		return codecID, payload, nil
+	}
+	payload, err := decompress(compression, payload)
+	return codecID, payload, err
+}
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, errors.Errorf("unknown cache compression %d", compression)
+	}
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case Gzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close() //nolint:errcheck
+		return io.ReadAll(reader)
+	case Zstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, errors.Errorf("unknown cache compression %d", compression)
+	}
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecEmployee struct {
+	ID   int
+	Name string
+	Tags []string
+}
+
+func TestStore_Codecs(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+	employee := codecEmployee{ID: 42, Name: "budi", Tags: []string{"payroll"}}
+
+	for _, codec := range []Codec{JSON, MsgPack, Gob} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			require.NoError(t, store.WithCodec(codec).Set(ctx, "employee", employee))
+			stored := redis.values["phastos:employee"]
+			if codec == JSON {
+				assert.JSONEq(t, `{"ID":42,"Name":"budi","Tags":["payroll"]}`, stored, "JSON is written without header")
+			} else {
+				assert.Equal(t, []byte{valueMagic, codec.ID(), byte(NoCompression)}, []byte(stored[:valueHeaderSize]))
+			}
+
+			var got codecEmployee
+			require.NoError(t, store.Get(ctx, "employee", &got), "the header selects the codec")
+			assert.Equal(t, employee, got)
+		})
+	}
+
+	require.NoError(t, store.WithCodec(Proto).Set(ctx, "name", wrapperspb.String("budi")))
+	var name wrapperspb.StringValue
+	require.NoError(t, store.Get(ctx, "name", &name))
+	assert.Equal(t, "budi", name.GetValue())
+	assert.Error(t, store.WithCodec(Proto).Set(ctx, "employee", employee), "Proto only encodes proto.Message")
+}
+
+func TestStore_Compression(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+	employees := make([]codecEmployee, 200)
+	for i := range employees {
+		employees[i] = codecEmployee{ID: i, Name: "employee", Tags: []string{"payroll", "attendance"}}
+	}
+	plain, err := encodeValue(employees)
+	require.NoError(t, err)
+
+	for _, compression := range []Compression{Gzip, Zstd} {
+		t.Run(compression.String(), func(t *testing.T) {
+			compressed := store.WithCodec(MsgPack).WithCompression(compression, 512)
+			require.NoError(t, compressed.Set(ctx, "employees", employees))
+			assert.Less(t, len(redis.values["phastos:employees"]), len(plain)/5)
+			var got []codecEmployee
+			require.NoError(t, store.Get(ctx, "employees", &got))
+			assert.Equal(t, employees, got)
+
+			require.NoError(t, compressed.Set(ctx, "small", codecEmployee{ID: 1}))
+			assert.Equal(t, byte(NoCompression), redis.values["phastos:small"][2], "values below the threshold are not compressed")
+
+			long := strings.Repeat("payslip ", 200)
+			require.NoError(t, compressed.HSet(ctx, "hash", "long", long))
+			var fields map[string]string
+			require.NoError(t, store.HGetAll(ctx, "hash", &fields))
+			assert.Equal(t, long, fields["long"])
+			var field string
+			require.NoError(t, store.HGet(ctx, "hash", "long", &field))
+			assert.Equal(t, long, field)
+		})
+	}
+}
+
+func TestStore_CodecCompatibility(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	// written by the releases before the codecs
+	redis.values["phastos:legacy"] = `{"ID":7,"Name":"ani"}`
+	redis.values["phastos:text"] = "plain"
+	var employee codecEmployee
+	require.NoError(t, store.WithCodec(MsgPack).Get(ctx, "legacy", &employee))
+	assert.Equal(t, codecEmployee{ID: 7, Name: "ani"}, employee)
+	var text string
+	require.NoError(t, store.Get(ctx, "text", &text))
+	assert.Equal(t, "plain", text)
+
+	// a string starting like a header keeps its bytes
+	require.NoError(t, store.Set(ctx, "binary", "\x00\x02\x00raw"))
+	require.NoError(t, store.Get(ctx, "binary", &text))
+	assert.Equal(t, "\x00\x02\x00raw", text)
+
+	var fallbackCalls int
+	fallback := func(ctx context.Context) (any, int64, error) {
+		fallbackCalls++
+		return codecEmployee{ID: 9}, 60, nil
+	}
+	var first, second codecEmployee
+	require.NoError(t, store.WithCodec(Gob).Get(ctx, "fallback", &first, fallback))
+	require.NoError(t, store.Get(ctx, "fallback", &second, fallback))
+	assert.Equal(t, codecEmployee{ID: 9}, first)
+	assert.Equal(t, codecEmployee{ID: 9}, second)
+	assert.Equal(t, 1, fallbackCalls)
+	assert.Equal(t, Gob.ID(), redis.values["phastos:fallback"][1])
+}
+
+func TestOpen_Codec(t *testing.T) {
+	_, err := Open(WithDriver(DriverMemory), WithConfig(RedisCfg{Driver: DriverMemory, Codec: "yaml"}))
+	assert.ErrorContains(t, err, `unknown cache codec "yaml"`)
+	_, err = Open(WithDriver(DriverMemory), WithCompression(Zstd), func(cfg *RedisCfg) { cfg.Compression = "lz4" })
+	assert.ErrorContains(t, err, `unknown cache compression "lz4"`)
+
+	cfg := RedisCfg{}
+	WithCodec(MsgPack)(&cfg)
+	WithCompression(Zstd, 2048)(&cfg)
+	codec, err := cfg.valueCodec()
+	require.NoError(t, err)
+	assert.Equal(t, valueCodec{codec: MsgPack, compression: Zstd, threshold: 2048}, codec)
+
+	assert.Error(t, RegisterCodec(fakeCodec{id: MsgPack.ID(), name: "other"}), "the IDs are unique")
+}
+
+type fakeCodec struct {
+	id   byte
+	name string
+}
+
+func (c fakeCodec) ID() byte                            { return c.id }
+func (c fakeCodec) Name() string                        { return c.name }
+func (fakeCodec) Marshal(any) ([]byte, error)           { return nil, nil }
+func (fakeCodec) Unmarshal(data []byte, dest any) error { return nil }
@@ -0,0 +1,556 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const (
+	defaultConsumerBatch         = 10
+	defaultConsumerBlock         = 5 * time.Second
+	defaultConsumerClaimIdle     = 30 * time.Second
+	defaultConsumerMaxDeliveries = 5
+	defaultDeadLetterMaxLen      = 10000
+)
+
+// ErrDeadLetter, wrapped in the error of a ConsumerHandler, moves the message to the dead-letter stream
+// at once instead of retrying it, e.g. for a message that cannot be decoded
+var ErrDeadLetter = errors.New("dead letter")
+
+type (
+	// ConsumerHandler processes a message of the stream, the message being retried while it fails
+	ConsumerHandler func(ctx context.Context, message *StreamData) error
+
+	// Consumer processes the messages of a stream as a member of a consumer group, see Run
+	Consumer struct {
+		store   *Store
+		stream  string
+		group   string
+		handler ConsumerHandler
+
+		name             string
+		workers          int
+		batch            int
+		block            time.Duration
+		claimIdle        time.Duration
+		maxDeliveries    int64
+		deadLetter       string
+		deadLetterMaxLen int
+		startID          string
+
+		mu       sync.Mutex
+		failures map[string]string   // last error of the messages to retry, reported in the dead-letter stream
+		inFlight map[string]struct{} // messages being handled, not claimed again by this consumer
+	}
+
+	// ConsumerOptions configures NewConsumer
+	ConsumerOptions func(*Consumer)
+
+	// consumerMessage is a message read or claimed, delivered deliveries times including this one
+	consumerMessage struct {
+		StreamData
+		deliveries int64
+	}
+)
+
+// NewConsumer creates the consumer of the group of stream, processing its messages with handler. The
+// stream name is not prefixed with REDIS_PREFIX_KEY, like in PublishStream: a stream is shared by the
+// services publishing and consuming it.
+//
+//	consumer := cache.NewConsumer(store, "orders", "invoicing", func(ctx context.Context, msg *cache.StreamData) error {
+//		return invoices.Create(ctx, msg.Values["order_id"])
+//	}, cache.WithConsumerWorkers(4))
+//	go consumer.Run(ctx)
+func NewConsumer(store *Store, stream, group string, handler ConsumerHandler, opts ...ConsumerOptions) *Consumer {
+	hostname, _ := os.Hostname()
+	c := &Consumer{
+		store:            store,
+		stream:           stream,
+		group:            group,
+		handler:          handler,
+		name:             fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		workers:          1,
+		batch:            defaultConsumerBatch,
+		block:            defaultConsumerBlock,
+		claimIdle:        defaultConsumerClaimIdle,
+		maxDeliveries:    defaultConsumerMaxDeliveries,
+		deadLetter:       stream + ":dlq",
+		deadLetterMaxLen: defaultDeadLetterMaxLen,
+		startID:          "0",
+		failures:         make(map[string]string),
+		inFlight:         make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithConsumerName sets the name of the consumer in the group (default hostname-pid), unique per instance
+func WithConsumerName(name string) ConsumerOptions {
+	return func(c *Consumer) {
+		c.name = name
+	}
+}
+
+// WithConsumerWorkers sets the number of messages processed concurrently (default 1)
+func WithConsumerWorkers(workers int) ConsumerOptions {
+	return func(c *Consumer) {
+		c.workers = max(workers, 1)
+	}
+}
+
+// WithConsumerBatch sets the number of messages read or claimed at once (default 10)
+func WithConsumerBatch(batch int) ConsumerOptions {
+	return func(c *Consumer) {
+		c.batch = max(batch, 1)
+	}
+}
+
+// WithConsumerBlock sets how long a read waits for new messages (default 5s), bounding the shutdown
+func WithConsumerBlock(block time.Duration) ConsumerOptions {
+	return func(c *Consumer) {
+		c.block = block
+	}
+}
+
+// WithConsumerClaimIdle sets how long a message stays pending before it is claimed for a retry
+// (default 30s): the messages failed and the messages of the crashed consumers. The idle time of a
+// message is reset (XCLAIM JUSTID) every half claim idle time while its handler runs, so a slow handler
+// is not run twice; a handler outliving the claim idle time while Redis is unreachable still can be,
+// by another consumer.
+func WithConsumerClaimIdle(idle time.Duration) ConsumerOptions {
+	return func(c *Consumer) {
+		c.claimIdle = idle
+	}
+}
+
+// WithConsumerMaxDeliveries sets how many times a message is delivered before it is moved to the
+// dead-letter stream (default 5)
+func WithConsumerMaxDeliveries(maxDeliveries int) ConsumerOptions {
+	return func(c *Consumer) {
+		c.maxDeliveries = int64(max(maxDeliveries, 1))
+	}
+}
+
+// WithConsumerDeadLetter sets the dead-letter stream (default "{stream}:dlq") and the number of entries
+// it keeps (default 10000, negative: unbounded)
+func WithConsumerDeadLetter(stream string, maxLen int) ConsumerOptions {
+	return func(c *Consumer) {
+		c.deadLetter = stream
+		c.deadLetterMaxLen = maxLen
+	}
+}
+
+// WithConsumerStartID sets where a group created by the consumer starts: "0" (default) for the
+// messages already in the stream, "$" for the new messages only
+func WithConsumerStartID(startID string) ConsumerOptions {
+	return func(c *Consumer) {
+		c.startID = startID
+	}
+}
+
+// Run processes the messages until ctx is done, then waits for the messages in process. The group is
+// created when missing. The new messages are read by the consumer and handled by the workers: a
+// message handled without error is acknowledged, a failed one stays pending and is claimed again
+// (XAUTOCLAIM) after the claim idle time, by this consumer or another one. So are the messages left
+// pending by a crashed consumer. A message delivered the maximum number of times, or failed with
+// ErrDeadLetter, is moved to the dead-letter stream with its error.
+//
+// The handlers run with a context not canceled with ctx, so a shutdown lets them finish. The messages
+// read but not handled yet stay pending, to be claimed after the restart.
+func (c *Consumer) Run(ctx context.Context) {
+	log := plog.Ctx(ctx)
+	log.Info().Str("stream", c.stream).Str("group", c.group).Str("consumer", c.name).Msg("[PHASTOS][CACHE] Stream consumer started")
+
+	messages := make(chan consumerMessage)
+	var workers sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for message := range messages {
+				c.process(ctx, message)
+			}
+		}()
+	}
+
+	var feeders sync.WaitGroup
+	feeders.Add(2)
+	go func() {
+		defer feeders.Done()
+		c.read(ctx, messages)
+	}()
+	go func() {
+		defer feeders.Done()
+		c.claim(ctx, messages)
+	}()
+	feeders.Wait()
+	close(messages)
+	workers.Wait()
+	log.Info().Str("stream", c.stream).Str("group", c.group).Msg("[PHASTOS][CACHE] Stream consumer stopped")
+}
+
+// read feeds the new messages, creating the group first and reconnecting with a backoff
+func (c *Consumer) read(ctx context.Context, messages chan<- consumerMessage) {
+	log := plog.Ctx(ctx)
+	backoff := 100 * time.Millisecond
+	grouped := false
+	for ctx.Err() == nil {
+		var batch []StreamData
+		var err error
+		if !grouped {
+			err = c.createGroup(ctx)
+			grouped = err == nil
+		}
+		if err == nil {
+			batch, err = c.readGroup(ctx)
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "NOGROUP") {
+				// the stream or the group was deleted
+				grouped = false
+			}
+			log.Warn().Err(err).Str("stream", c.stream).Str("group", c.group).Dur("retry_in", backoff).Msg("[PHASTOS][CACHE] Failed to read stream")
+			if !sleepContext(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = 100 * time.Millisecond
+
+		for _, message := range batch {
+			select {
+			case messages <- consumerMessage{StreamData: message, deliveries: 1}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// claim feeds the messages pending for longer than the claim idle time
+func (c *Consumer) claim(ctx context.Context, messages chan<- consumerMessage) {
+	log := plog.Ctx(ctx)
+	interval := max(c.claimIdle/2, 10*time.Millisecond)
+	for sleepContext(ctx, interval) {
+		cursor := "0-0"
+		for {
+			claimed, next, err := c.autoClaim(ctx, cursor)
+			if err != nil {
+				log.Warn().Err(err).Str("stream", c.stream).Str("group", c.group).Msg("[PHASTOS][CACHE] Failed to claim pending messages")
+				break
+			}
+			for _, message := range claimed {
+				if c.handling(message.ID) {
+					// claimed while its idle time could not be reset, it is still being handled
+					continue
+				}
+				select {
+				case messages <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if next == "0-0" {
+				break
+			}
+			cursor = next
+		}
+	}
+}
+
+// process handles a message, then acknowledges it, leaves it pending or moves it to the dead-letter stream
+func (c *Consumer) process(ctx context.Context, message consumerMessage) {
+	log := plog.Ctx(ctx)
+	ctx = context.WithoutCancel(ctx)
+	if message.deliveries > c.maxDeliveries {
+		// the previous deliveries did not return, e.g. a crash of the consumer
+		c.mu.Lock()
+		reason, ok := c.failures[message.ID]
+		c.mu.Unlock()
+		if !ok {
+			reason = "maximum deliveries exceeded"
+		}
+		c.moveToDeadLetter(ctx, message, reason)
+		return
+	}
+
+	c.mu.Lock()
+	c.inFlight[message.ID] = struct{}{}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.inFlight, message.ID)
+		c.mu.Unlock()
+	}()
+	stop := c.keepPending(ctx, message.ID)
+	err := c.handle(ctx, &message.StreamData)
+	stop()
+	if err == nil {
+		if err = c.ack(ctx, message.ID); err != nil {
+			log.Warn().Err(err).Str("stream", c.stream).Str("id", message.ID).Msg("[PHASTOS][CACHE] Failed to acknowledge message, it will be delivered again")
+		}
+		c.forget(message.ID)
+		return
+	}
+
+	if errors.Is(err, ErrDeadLetter) || message.deliveries >= c.maxDeliveries {
+		c.moveToDeadLetter(ctx, message, err.Error())
+		return
+	}
+	c.mu.Lock()
+	c.failures[message.ID] = err.Error()
+	c.mu.Unlock()
+	log.Warn().Err(err).Str("stream", c.stream).Str("id", message.ID).Int64("deliveries", message.deliveries).
+		Dur("retry_in", c.claimIdle).Msg("[PHASTOS][CACHE] Failed to process message, it will be retried")
+}
+
+// handle runs the handler, turning a panic into an error
+func (c *Consumer) handle(ctx context.Context, message *StreamData) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.Errorf("panic: %v", recovered)
+		}
+	}()
+	return c.handler(ctx, message)
+}
+
+// keepPending resets the idle time of the message every half claim idle time until stop is called,
+// so that it is not claimed while it is being handled
+func (c *Consumer) keepPending(ctx context.Context, id string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(max(c.claimIdle/2, 10*time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := c.do(ctx, func(conn redigo.Conn) error {
+					_, err := conn.Do("XCLAIM", c.stream, c.group, c.name, 0, id, "JUSTID")
+					return errors.Wrap(err, "phastos.cache.redis.Consumer.XCLAIM")
+				})
+				if err != nil {
+					log := plog.Ctx(ctx)
+					log.Warn().Err(err).Str("stream", c.stream).Str("id", id).Msg("[PHASTOS][CACHE] Failed to reset the idle time of the message in process")
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// handling reports whether the message is being handled by a worker of the consumer
+func (c *Consumer) handling(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.inFlight[id]
+	return ok
+}
+
+func (c *Consumer) forget(id string) {
+	c.mu.Lock()
+	delete(c.failures, id)
+	c.mu.Unlock()
+}
+
+// moveToDeadLetter appends the message with its error to the dead-letter stream and acknowledges it.
+// On failure it stays pending, to be moved again after the claim idle time.
+func (c *Consumer) moveToDeadLetter(ctx context.Context, message consumerMessage, reason string) {
+	log := plog.Ctx(ctx)
+	err := c.do(ctx, func(conn redigo.Conn) error {
+		args := []any{c.deadLetter}
+		if c.deadLetterMaxLen > 0 {
+			args = append(args, "MAXLEN", "~", c.deadLetterMaxLen)
+		}
+		args = append(args, "*")
+		for field, value := range message.Values {
+			args = append(args, field, value)
+		}
+		args = append(args,
+			"dlq_stream", c.stream,
+			"dlq_group", c.group,
+			"dlq_id", message.ID,
+			"dlq_deliveries", message.deliveries,
+			"dlq_error", reason,
+		)
+		if _, err := conn.Do("XADD", args...); err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.DeadLetter.XADD")
+		}
+		_, err := conn.Do("XACK", c.stream, c.group, message.ID)
+		return errors.Wrap(err, "phastos.cache.redis.Consumer.DeadLetter.XACK")
+	})
+	if err != nil {
+		log.Error().Err(err).Str("stream", c.stream).Str("id", message.ID).Msg("[PHASTOS][CACHE] Failed to move message to the dead-letter stream")
+		return
+	}
+	c.forget(message.ID)
+	log.Warn().Str("stream", c.stream).Str("id", message.ID).Str("dead_letter", c.deadLetter).Str("error", reason).
+		Int64("deliveries", message.deliveries).Msg("[PHASTOS][CACHE] Message moved to the dead-letter stream")
+}
+
+// createGroup creates the group and the stream, an existing group being kept
+func (c *Consumer) createGroup(ctx context.Context) error {
+	return c.do(ctx, func(conn redigo.Conn) error {
+		_, err := conn.Do("XGROUP", "CREATE", c.stream, c.group, c.startID, "MKSTREAM")
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.XGROUP")
+		}
+		return nil
+	})
+}
+
+// readGroup reads the new messages, waiting up to the block time
+func (c *Consumer) readGroup(ctx context.Context) ([]StreamData, error) {
+	var messages []StreamData
+	err := c.do(ctx, func(conn redigo.Conn) error {
+		args := []any{"GROUP", c.group, c.name, "COUNT", c.batch, "BLOCK", c.block.Milliseconds(), "STREAMS", c.stream, ">"}
+		reply, err := redigo.Values(doBlocking(conn, c.block, "XREADGROUP", args...))
+		if err != nil {
+			if errors.Is(err, redigo.ErrNil) {
+				return nil
+			}
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.XREADGROUP")
+		}
+		for _, stream := range reply {
+			entry, err := redigo.Values(stream, nil)
+			if err != nil || len(entry) < 2 {
+				return errors.New("phastos.cache.redis.Consumer.XREADGROUP: invalid stream entry format")
+			}
+			entries, err := streamEntries(entry[1])
+			if err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.Consumer.XREADGROUP")
+			}
+			messages = append(messages, entries...)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+// autoClaim claims the messages pending for longer than the claim idle time from cursor, returning
+// the next cursor, "0-0" at the end of the pending messages
+func (c *Consumer) autoClaim(ctx context.Context, cursor string) ([]consumerMessage, string, error) {
+	var claimed []consumerMessage
+	next := "0-0"
+	err := c.do(ctx, func(conn redigo.Conn) error {
+		reply, err := redigo.Values(conn.Do("XAUTOCLAIM", c.stream, c.group, c.name, c.claimIdle.Milliseconds(), cursor, "COUNT", c.batch))
+		if err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.XAUTOCLAIM")
+		}
+		if len(reply) < 2 {
+			return errors.New("phastos.cache.redis.Consumer.XAUTOCLAIM: invalid reply format")
+		}
+		if next, err = redigo.String(reply[0], nil); err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.XAUTOCLAIM")
+		}
+		entries, err := streamEntries(reply[1])
+		if err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.Consumer.XAUTOCLAIM")
+		}
+
+		for _, entry := range entries {
+			// XAUTOCLAIM counts the delivery, XPENDING returns the count
+			pending, err := redigo.Values(conn.Do("XPENDING", c.stream, c.group, entry.ID, entry.ID, 1))
+			if err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.Consumer.XPENDING")
+			}
+			if len(pending) == 0 {
+				// acknowledged meanwhile
+				continue
+			}
+			var id, consumer string
+			var idle, deliveries int64
+			details, _ := redigo.Values(pending[0], nil)
+			if _, err = redigo.Scan(details, &id, &consumer, &idle, &deliveries); err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.Consumer.XPENDING")
+			}
+			claimed = append(claimed, consumerMessage{StreamData: entry, deliveries: deliveries})
+		}
+		return nil
+	})
+	return claimed, next, err
+}
+
+func (c *Consumer) ack(ctx context.Context, id string) error {
+	return c.do(ctx, func(conn redigo.Conn) error {
+		_, err := conn.Do("XACK", c.stream, c.group, id)
+		return errors.Wrap(err, "phastos.cache.redis.Consumer.XACK")
+	})
+}
+
+// do runs fn with a connection of the store
+func (c *Consumer) do(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	conn, err := c.store.Pool.GetContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "phastos.cache.redis.Consumer.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+	return fn(conn)
+}
+
+// streamEntries parses the [[id, [field, value, ...]], ...] entries of a stream reply, skipping the
+// entries deleted from the stream (nil fields)
+func streamEntries(reply any) ([]StreamData, error) {
+	entries, err := redigo.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]StreamData, 0, len(entries))
+	for _, entry := range entries {
+		item, err := redigo.Values(entry, nil)
+		if err != nil || len(item) < 2 {
+			continue
+		}
+		id, err := redigo.String(item[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		if item[1] == nil {
+			continue
+		}
+		fields, err := redigo.StringMap(item[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, StreamData{ID: id, Values: fields})
+	}
+	return messages, nil
+}
+
+// doBlocking runs a blocking command, extending the read timeout of the connection by block
+func doBlocking(conn redigo.Conn, block time.Duration, commandName string, args ...any) (any, error) {
+	if _, ok := conn.(redigo.ConnWithTimeout); ok {
+		return redigo.DoWithTimeout(conn, block+time.Second, commandName, args...)
+	}
+	return conn.Do(commandName, args...)
+}
+
+// sleepContext waits for d, returning false when ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
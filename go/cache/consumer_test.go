@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_PublishStream(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+	store.streamMaxLen = 2
+
+	for _, order := range []string{"1", "2", "3"} {
+		id, err := store.PublishStream(ctx, "orders", map[string]any{"order_id": order, "items": []int{1, 2}})
+		require.NoError(t, err)
+		assert.NotEmpty(t, id)
+	}
+	values := redis.streamValues("orders")
+	require.Len(t, values, 2, "the stream is trimmed to StreamMaxLen")
+	assert.Equal(t, map[string]string{"order_id": "3", "items": "[1,2]"}, values[1])
+
+	_, err := store.PublishStream(ctx, "orders")
+	assert.Error(t, err)
+
+	store.streamRetention = time.Hour
+	assert.Equal(t, "MINID", store.streamTrim()[0])
+}
+
+func TestConsumer_RetryAndDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	for _, action := range []string{"ok", "retry", "poison", "invalid"} {
+		_, err := store.PublishStream(ctx, "orders", map[string]any{"action": action})
+		require.NoError(t, err)
+	}
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	consumer := NewConsumer(store, "orders", "invoicing", func(ctx context.Context, message *StreamData) error {
+		action := message.Values["action"]
+		mu.Lock()
+		calls[action]++
+		call := calls[action]
+		mu.Unlock()
+		switch {
+		case action == "retry" && call == 1:
+			return errors.New("temporary failure")
+		case action == "poison":
+			panic("cannot process")
+		case action == "invalid":
+			return errors.Wrap(ErrDeadLetter, "invalid action")
+		}
+		return nil
+	}, WithConsumerWorkers(2), WithConsumerClaimIdle(20*time.Millisecond), WithConsumerMaxDeliveries(3),
+		WithConsumerBlock(20*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(redis.streamValues("orders:dlq")) == 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	assert.Equal(t, map[string]int{"ok": 1, "retry": 2, "poison": 3, "invalid": 1}, calls)
+	mu.Unlock()
+
+	deadLetters := map[string]map[string]string{}
+	for _, values := range redis.streamValues("orders:dlq") {
+		deadLetters[values["action"]] = values
+	}
+	assert.Equal(t, "panic: cannot process", deadLetters["poison"]["dlq_error"])
+	assert.Equal(t, "3", deadLetters["poison"]["dlq_deliveries"])
+	assert.Equal(t, "invalid action: dead letter", deadLetters["invalid"]["dlq_error"])
+	assert.Equal(t, "1", deadLetters["invalid"]["dlq_deliveries"])
+	assert.Equal(t, "invoicing", deadLetters["invalid"]["dlq_group"])
+	assert.Empty(t, redis.streams["orders"].groups["invoicing"].pending, "every message is acknowledged")
+}
+
+func TestConsumer_SlowHandlerIsNotClaimed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+	_, err := store.PublishStream(ctx, "orders", map[string]any{"action": "slow"})
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	consumer := NewConsumer(store, "orders", "invoicing", func(ctx context.Context, message *StreamData) error {
+		calls.Add(1)
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	}, WithConsumerWorkers(2), WithConsumerClaimIdle(20*time.Millisecond), WithConsumerBlock(20*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		redis.mu.Lock()
+		defer redis.mu.Unlock()
+		return calls.Load() > 0 && len(redis.streams["orders"].groups["invoicing"].pending) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, int32(1), calls.Load(), "a handler slower than the claim idle time runs once")
+}
+
+func TestConsumer_GracefulShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+	_, err := store.PublishStream(ctx, "orders", map[string]any{"action": "slow"})
+	require.NoError(t, err)
+
+	started, finished := make(chan struct{}), make(chan struct{})
+	consumer := NewConsumer(store, "orders", "invoicing", func(ctx context.Context, message *StreamData) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+		return ctx.Err()
+	}, WithConsumerBlock(20*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Run returned before the message in process was handled")
+	}
+	assert.Empty(t, redis.streams["orders"].groups["invoicing"].pending, "the message handled during the shutdown is acknowledged")
+}
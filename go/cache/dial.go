@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// Topologies of RedisCfg, see RedisCfg.mode
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// dialRedis is injectable for testing the Sentinel and Cluster topologies without Redis
+var dialRedis = redigo.Dial
+
+// mode returns the topology of cfg: Cluster with ClusterAddresses, Sentinel with MasterName,
+// a single server otherwise
+func (cfg *RedisCfg) mode() string {
+	switch {
+	case len(cfg.ClusterAddresses) > 0:
+		return ModeCluster
+	case cfg.MasterName != "":
+		return ModeSentinel
+	default:
+		return ModeStandalone
+	}
+}
+
+// dialOptions returns the options of the connections authenticated as username: timeouts, ACL and TLS
+func (cfg *RedisCfg) dialOptions(username, password string) ([]redigo.DialOption, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	dialOpts := []redigo.DialOption{
+		redigo.DialConnectTimeout(timeout),
+		redigo.DialReadTimeout(timeout),
+		redigo.DialWriteTimeout(timeout),
+	}
+	if password != "" {
+		dialOpts = append(dialOpts, redigo.DialPassword(password))
+	}
+	if username != "" {
+		dialOpts = append(dialOpts, redigo.DialUsername(username))
+	}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		dialOpts = append(dialOpts, redigo.DialUseTLS(true), redigo.DialTLSConfig(tlsConfig))
+	}
+	return dialOpts, nil
+}
+
+// tlsConfig returns the TLS configuration of the connections, nil without TLS
+func (cfg *RedisCfg) tlsConfig() (*tls.Config, error) {
+	if cfg.TLSConfig != nil {
+		return cfg.TLSConfig, nil
+	}
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.TLSSkipVerify, //nolint:gosec // opt-in for self-signed test deployments
+	}
+	if cfg.TLSCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.cache.redis.TLS.ReadCAFile")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Wrap(errors.New("no certificate found"), "phastos.cache.redis.TLS.ReadCAFile")
+		}
+	}
+	return tlsConfig, nil
+}
+
+// WithSentinel connects to the master masterName of the Sentinel-managed deployment monitored by the
+// sentinels at addresses (host:port), instead of WithAddress
+func WithSentinel(masterName string, addresses ...string) Options {
+	return func(cfg *RedisCfg) {
+		cfg.MasterName = masterName
+		cfg.SentinelAddresses = addresses
+	}
+}
+
+// WithSentinelAuth authenticates to the sentinels, when they require other credentials than the master
+func WithSentinelAuth(username, password string) Options {
+	return func(cfg *RedisCfg) {
+		cfg.SentinelUsername = username
+		cfg.SentinelPassword = password
+	}
+}
+
+// WithCluster connects to the Redis Cluster of the seed nodes at addresses (host:port), instead of
+// WithAddress
+func WithCluster(addresses ...string) Options {
+	return func(cfg *RedisCfg) {
+		cfg.ClusterAddresses = addresses
+	}
+}
+
+// WithTLS connects with TLS, with the default configuration when tlsConfig is nil
+func WithTLS(tlsConfig *tls.Config) Options {
+	return func(cfg *RedisCfg) {
+		cfg.TLS = true
+		cfg.TLSConfig = tlsConfig
+	}
+}
+
+// WithTLSCAFile verifies the server certificates with the CA certificates of the PEM file caFile
+func WithTLSCAFile(caFile string) Options {
+	return func(cfg *RedisCfg) {
+		cfg.TLSCAFile = caFile
+	}
+}
+
+// WithTLSSkipVerify does not verify the server certificates, for self-signed test deployments only
+func WithTLSSkipVerify() Options {
+	return func(cfg *RedisCfg) {
+		cfg.TLSSkipVerify = true
+	}
+}
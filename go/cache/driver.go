@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Drivers of RedisCfg.Driver
+const (
+	DriverRedis   = "redis"
+	DriverMemory  = "memory"
+	DriverTwoTier = "two_tier"
+)
+
+// Open creates the Caches of the configured driver (WithDriver, RedisCfg.Driver):
+//   - DriverRedis (default): the Redis Store of New
+//   - DriverMemory: a Memory of WithL1MaxEntries keys, no Redis needed
+//   - DriverTwoTier: a TwoTier of a Memory in front of the Redis Store, its L1 keeping a key
+//     WithL1TTL seconds at most
+//
+// It fails on an unknown driver, codec or compression. As New, it does not fail when Redis is unreachable.
+func Open(options ...Options) (Caches, error) {
+	var cfg RedisCfg
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	if _, err := cfg.valueCodec(); err != nil {
+		return nil, errors.Wrap(err, "phastos.cache.Open")
+	}
+
+	var memoryOpts []MemoryOptions
+	if cfg.L1MaxEntries > 0 {
+		memoryOpts = append(memoryOpts, WithMaxEntries(cfg.L1MaxEntries))
+	}
+
+	switch cfg.Driver {
+	case "", DriverRedis:
+		return New(WithConfig(cfg)), nil
+	case DriverMemory:
+		return NewMemory(memoryOpts...), nil
+	case DriverTwoTier:
+		return NewTwoTier(NewMemory(memoryOpts...), New(WithConfig(cfg)), WithL1TTLCap(time.Duration(cfg.L1TTL)*time.Second)), nil
+	default:
+		return nil, errors.Wrap(errors.Errorf("unknown cache driver %q", cfg.Driver), "phastos.cache.Open")
+	}
+}
+
+func WithDriver(driver string) Options {
+	return func(cfg *RedisCfg) {
+		cfg.Driver = driver
+	}
+}
+
+func WithL1MaxEntries(maxEntries int) Options {
+	return func(cfg *RedisCfg) {
+		cfg.L1MaxEntries = maxEntries
+	}
+}
+
+func WithL1TTL(seconds int) Options {
+	return func(cfg *RedisCfg) {
+		cfg.L1TTL = seconds
+	}
+}
@@ -0,0 +1,431 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// fakeRedis is a Handler sharing its keys and PUBLISH/SUBSCRIBE between its connections, as one Redis
+// shared by several instances. TTLs are recorded, not applied.
+type fakeRedis struct {
+	mu          sync.Mutex
+	values      map[string]string
+	sets        map[string]map[string]bool
+	hashes      map[string]map[string]string
+	ttls        map[string]int64
+	subscribers map[*fakeRedisConn]string
+	streams     map[string]*fakeStream
+	gets        int
+}
+
+// fakeStream is a stream with its consumer groups
+type fakeStream struct {
+	seq     int64
+	entries []fakeEntry
+	groups  map[string]*fakeGroup
+}
+
+type fakeEntry struct {
+	id     string
+	fields []interface{}
+}
+
+type fakeGroup struct {
+	delivered int // entries delivered by ">"
+	pending   map[string]*fakePending
+}
+
+type fakePending struct {
+	consumer    string
+	deliveries  int64
+	deliveredAt time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		values:      make(map[string]string),
+		sets:        make(map[string]map[string]bool),
+		hashes:      make(map[string]map[string]string),
+		ttls:        make(map[string]int64),
+		subscribers: make(map[*fakeRedisConn]string),
+		streams:     make(map[string]*fakeStream),
+	}
+}
+
+func (f *fakeRedis) Get() redigo.Conn {
+	return &fakeRedisConn{redis: f, messages: make(chan any, 16), closed: make(chan struct{})}
+}
+
+func (f *fakeRedis) GetContext(ctx context.Context) (redigo.Conn, error) { return f.Get(), nil }
+
+// dropSubscribers closes the subscribed connections, as a lost connection
+func (f *fakeRedis) dropSubscribers() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.subscribers {
+		delete(f.subscribers, conn)
+		conn.closeOnce.Do(func() { close(conn.closed) })
+	}
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	_, isValue := f.values[key]
+	_, isSet := f.sets[key]
+	_, isHash := f.hashes[key]
+	return isValue || isSet || isHash
+}
+
+func (f *fakeRedis) del(key string) int64 {
+	if !f.exists(key) {
+		return 0
+	}
+	delete(f.values, key)
+	delete(f.sets, key)
+	delete(f.hashes, key)
+	delete(f.ttls, key)
+	return 1
+}
+
+type fakeRedisConn struct {
+	redis     *fakeRedis
+	messages  chan any
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *fakeRedisConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.redis.mu.Lock()
+	delete(c.redis.subscribers, c)
+	c.redis.mu.Unlock()
+	return nil
+}
+
+func (c *fakeRedisConn) Err() error { return nil }
+
+func (c *fakeRedisConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	f := c.redis
+	if commandName == "XREADGROUP" {
+		return f.xReadGroup(args)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := ""
+	if len(args) > 0 {
+		key, _ = args[0].(string)
+	}
+	switch commandName {
+	case "GET":
+		f.gets++
+		value, ok := f.values[key]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(value), nil
+	case "SET":
+		var nx bool
+		var ttl int64
+		for i := 2; i < len(args); i++ {
+			switch args[i] {
+			case "NX":
+				nx = true
+			case "EX":
+				ttl = fakeInt(args[i+1])
+			case "PX":
+				ttl = fakeInt(args[i+1]) / 1000
+			}
+		}
+		if nx && f.exists(key) {
+			return nil, nil
+		}
+		f.values[key] = args[1].(string)
+		delete(f.ttls, key)
+		if ttl > 0 {
+			f.ttls[key] = ttl
+		}
+		return "OK", nil
+	case "EVALSHA":
+		return nil, redigo.Error("NOSCRIPT No matching script")
+	case "EVAL":
+		// the compare-and-delete and compare-and-pexpire scripts of the locks
+		key = args[2].(string)
+		if f.values[key] != args[3].(string) {
+			return int64(0), nil
+		}
+		if strings.Contains(args[0].(string), "PEXPIRE") {
+			f.ttls[key] = fakeInt(args[4]) / 1000
+			return int64(1), nil
+		}
+		return f.del(key), nil
+	case "DEL", "UNLINK":
+		var deleted int64
+		for _, arg := range args {
+			deleted += f.del(arg.(string))
+		}
+		return deleted, nil
+	case "INCR":
+		n, _ := strconv.ParseInt(f.values[key], 10, 64)
+		f.values[key] = strconv.FormatInt(n+1, 10)
+		return n + 1, nil
+	case "EXISTS":
+		if f.exists(key) {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "SADD":
+		if f.sets[key] == nil {
+			f.sets[key] = make(map[string]bool)
+		}
+		f.sets[key][args[1].(string)] = true
+		return int64(1), nil
+	case "HSET":
+		if f.hashes[key] == nil {
+			f.hashes[key] = make(map[string]string)
+		}
+		f.hashes[key][args[1].(string)] = args[2].(string)
+		return int64(1), nil
+	case "HGET":
+		value, ok := f.hashes[key][args[1].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(value), nil
+	case "HGETALL":
+		var fields []interface{}
+		for field, value := range f.hashes[key] {
+			fields = append(fields, []byte(field), []byte(value))
+		}
+		return fields, nil
+	case "SMEMBERS":
+		var members []interface{}
+		for member := range f.sets[key] {
+			members = append(members, []byte(member))
+		}
+		return members, nil
+	case "TTL", "PTTL":
+		if !f.exists(key) {
+			return int64(-2), nil
+		}
+		if ttl, ok := f.ttls[key]; ok {
+			if commandName == "PTTL" {
+				return ttl * 1000, nil
+			}
+			return ttl, nil
+		}
+		return int64(-1), nil
+	case "EXPIRE":
+		f.ttls[key] = fakeInt(args[1])
+		return int64(1), nil
+	case "PERSIST":
+		delete(f.ttls, key)
+		return int64(1), nil
+	case "SCAN":
+		var keys []interface{}
+		for k := range f.values {
+			if matched, _ := path.Match(args[2].(string), k); matched {
+				keys = append(keys, []byte(k))
+			}
+		}
+		for k := range f.sets {
+			if matched, _ := path.Match(args[2].(string), k); matched {
+				keys = append(keys, []byte(k))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	case "XADD", "XGROUP", "XACK", "XAUTOCLAIM", "XCLAIM", "XPENDING":
+		return f.doStream(commandName, args)
+	case "PUBLISH":
+		for conn, channel := range f.subscribers {
+			if channel == key {
+				conn.messages <- []interface{}{[]byte("message"), []byte(channel), []byte(args[1].(string))}
+			}
+		}
+		return int64(len(f.subscribers)), nil
+	}
+	return nil, errors.New("unexpected command " + commandName)
+}
+
+func (c *fakeRedisConn) Send(commandName string, args ...interface{}) error {
+	if commandName != "SUBSCRIBE" {
+		return errors.New("unexpected command " + commandName)
+	}
+	channel := args[0].(string)
+	c.redis.mu.Lock()
+	c.redis.subscribers[c] = channel
+	c.redis.mu.Unlock()
+	c.messages <- []interface{}{[]byte("subscribe"), []byte(channel), int64(1)}
+	return nil
+}
+
+func (c *fakeRedisConn) Flush() error { return nil }
+
+func (c *fakeRedisConn) Receive() (interface{}, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-c.closed:
+		return nil, errors.New("use of closed network connection")
+	}
+}
+
+func fakeInt(arg interface{}) int64 {
+	n, _ := strconv.ParseInt(fmt.Sprint(arg), 10, 64)
+	return n
+}
+
+func (f *fakeRedis) doStream(commandName string, args []interface{}) (interface{}, error) {
+	key := args[0].(string)
+	stream := f.streams[key]
+	switch commandName {
+	case "XADD":
+		if stream == nil {
+			stream = &fakeStream{groups: make(map[string]*fakeGroup)}
+			f.streams[key] = stream
+		}
+		i := 1
+		maxLen := -1
+		if args[i] == "MAXLEN" {
+			maxLen = int(fakeInt(args[i+2]))
+			i += 3
+		}
+		if args[i] == "MINID" {
+			i += 3
+		}
+		stream.seq++
+		id := fmt.Sprintf("%d-%d", time.Now().UnixMilli(), stream.seq)
+		stream.entries = append(stream.entries, fakeEntry{id: id, fields: args[i+1:]})
+		if maxLen >= 0 && len(stream.entries) > maxLen {
+			trimmed := len(stream.entries) - maxLen
+			stream.entries = stream.entries[trimmed:]
+			for _, group := range stream.groups {
+				group.delivered = max(group.delivered-trimmed, 0)
+			}
+		}
+		return []byte(id), nil
+	case "XGROUP":
+		key = args[1].(string)
+		stream = f.streams[key]
+		if stream == nil {
+			stream = &fakeStream{groups: make(map[string]*fakeGroup)}
+			f.streams[key] = stream
+		}
+		group := args[2].(string)
+		if _, exists := stream.groups[group]; exists {
+			return nil, redigo.Error("BUSYGROUP Consumer Group name already exists")
+		}
+		stream.groups[group] = &fakeGroup{pending: make(map[string]*fakePending)}
+		if args[3] == "$" {
+			stream.groups[group].delivered = len(stream.entries)
+		}
+		return "OK", nil
+	}
+
+	if stream == nil || stream.groups[args[1].(string)] == nil {
+		return nil, redigo.Error("NOGROUP No such key or consumer group")
+	}
+	group := stream.groups[args[1].(string)]
+	switch commandName {
+	case "XACK":
+		if _, ok := group.pending[args[2].(string)]; !ok {
+			return int64(0), nil
+		}
+		delete(group.pending, args[2].(string))
+		return int64(1), nil
+	case "XCLAIM":
+		// JUSTID: the idle time is reset without counting a delivery
+		pending, ok := group.pending[args[4].(string)]
+		if !ok {
+			return []interface{}{}, nil
+		}
+		pending.consumer = args[2].(string)
+		pending.deliveredAt = time.Now()
+		return []interface{}{[]byte(args[4].(string))}, nil
+	case "XAUTOCLAIM":
+		consumer := args[2].(string)
+		minIdle := time.Duration(fakeInt(args[3])) * time.Millisecond
+		var claimed []interface{}
+		for _, entry := range stream.entries {
+			pending, ok := group.pending[entry.id]
+			if !ok || time.Since(pending.deliveredAt) < minIdle {
+				continue
+			}
+			pending.consumer = consumer
+			pending.deliveries++
+			pending.deliveredAt = time.Now()
+			claimed = append(claimed, entry.reply())
+		}
+		return []interface{}{[]byte("0-0"), claimed, []interface{}{}}, nil
+	default: // XPENDING key group id id 1
+		pending, ok := group.pending[args[2].(string)]
+		if !ok {
+			return []interface{}{}, nil
+		}
+		idle := time.Since(pending.deliveredAt).Milliseconds()
+		return []interface{}{[]interface{}{[]byte(args[2].(string)), []byte(pending.consumer), idle, pending.deliveries}}, nil
+	}
+}
+
+// xReadGroup implements XREADGROUP GROUP group consumer COUNT count BLOCK ms STREAMS key >, polling
+// during BLOCK
+func (f *fakeRedis) xReadGroup(args []interface{}) (interface{}, error) {
+	deadline := time.Now().Add(time.Duration(fakeInt(args[6])) * time.Millisecond)
+	for {
+		f.mu.Lock()
+		reply, err := f.readGroup(args[1].(string), args[2].(string), int(fakeInt(args[4])), args[8].(string))
+		f.mu.Unlock()
+		if err != nil || reply != nil || time.Now().After(deadline) {
+			return reply, err
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fakeRedis) readGroup(groupName, consumer string, count int, key string) (interface{}, error) {
+	stream := f.streams[key]
+	if stream == nil || stream.groups[groupName] == nil {
+		return nil, redigo.Error("NOGROUP No such key or consumer group")
+	}
+	group := stream.groups[groupName]
+	entries := stream.entries[group.delivered:]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	entries = entries[:min(count, len(entries))]
+	group.delivered += len(entries)
+	messages := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		group.pending[entry.id] = &fakePending{consumer: consumer, deliveries: 1, deliveredAt: time.Now()}
+		messages = append(messages, entry.reply())
+	}
+	return []interface{}{[]interface{}{[]byte(key), messages}}, nil
+}
+
+func (e fakeEntry) reply() interface{} {
+	fields := make([]interface{}, len(e.fields))
+	for i, field := range e.fields {
+		fields[i] = []byte(fmt.Sprint(field))
+	}
+	return []interface{}{[]byte(e.id), fields}
+}
+
+// streamValues returns the fields of the entries of a stream
+func (f *fakeRedis) streamValues(key string) []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var values []map[string]string
+	if stream := f.streams[key]; stream != nil {
+		for _, entry := range stream.entries {
+			fields, _ := redigo.StringMap(entry.reply().([]interface{})[1], nil)
+			values = append(values, fields)
+		}
+	}
+	return values
+}
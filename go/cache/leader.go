@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const defaultLeaderTTL = 15 * time.Second
+
+type (
+	// LeaderElection elects one leader among the instances campaigning on the same key, see Run
+	LeaderElection struct {
+		store    *Store
+		key      string
+		ttl      time.Duration
+		retry    time.Duration
+		lockOpts []LockOptions
+		leader   atomic.Bool
+	}
+
+	// LeaderOptions configures NewLeaderElection
+	LeaderOptions func(*LeaderElection)
+)
+
+// NewLeaderElection creates the election of key, its leader holding the lock "leader:{key}" of store
+func NewLeaderElection(store *Store, key string, opts ...LeaderOptions) *LeaderElection {
+	le := &LeaderElection{store: store, key: "leader:" + key, ttl: defaultLeaderTTL}
+	for _, opt := range opts {
+		opt(le)
+	}
+	if le.retry <= 0 {
+		le.retry = le.ttl / 3
+	}
+	return le
+}
+
+// WithLeaderTTL sets the TTL of the leader lock (default 15s): how long the other instances wait
+// for a leader that died without handing over
+func WithLeaderTTL(ttl time.Duration) LeaderOptions {
+	return func(le *LeaderElection) {
+		le.ttl = ttl
+	}
+}
+
+// WithLeaderRetry sets how often a follower campaigns (default a third of the TTL)
+func WithLeaderRetry(retry time.Duration) LeaderOptions {
+	return func(le *LeaderElection) {
+		le.retry = retry
+	}
+}
+
+// WithLeaderLockOptions adds options to the leader lock, e.g. WithRedlock
+func WithLeaderLockOptions(opts ...LockOptions) LeaderOptions {
+	return func(le *LeaderElection) {
+		le.lockOpts = append(le.lockOpts, opts...)
+	}
+}
+
+// IsLeader tells whether this instance is the leader
+func (le *LeaderElection) IsLeader() bool {
+	return le.leader.Load()
+}
+
+// Run campaigns until ctx is done and runs fn while this instance is the leader. The context of fn is
+// canceled when the leadership is lost; fn should return then. When ctx is canceled, the leadership
+// is handed over by releasing the lock, so another instance takes over without waiting for the TTL.
+// When fn returns on its own, the leadership is released and the election starts over.
+//
+//	election := cache.NewLeaderElection(store, "importer-finalizer")
+//	go election.Run(ctx, func(ctx context.Context) {
+//		finalizer.Run(ctx) // until ctx is done
+//	})
+func (le *LeaderElection) Run(ctx context.Context, fn func(ctx context.Context)) {
+	log := plog.Ctx(ctx)
+	for {
+		lock, err := le.store.Lock(ctx, le.key, le.ttl, le.lockOpts...)
+		if err == nil {
+			le.lead(ctx, lock, fn)
+		} else if ctx.Err() == nil && !errors.Is(err, ErrLockNotAcquired) {
+			log.Warn().Err(err).Str("key", le.key).Msg("[PHASTOS][CACHE] leader election failed, retrying")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(le.retry):
+		}
+	}
+}
+
+// lead runs fn until it returns, the lock is lost or ctx is done, then releases the lock
+func (le *LeaderElection) lead(ctx context.Context, lock Lock, fn func(ctx context.Context)) {
+	log := plog.Ctx(ctx)
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-leaderCtx.Done():
+		}
+	}()
+
+	le.leader.Store(true)
+	log.Info().Str("key", le.key).Int64("fence", lock.Fence()).Msg("[PHASTOS][CACHE] became the leader")
+	fn(leaderCtx)
+	le.leader.Store(false)
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), le.ttl)
+	defer cancelRelease()
+	if err := lock.Release(releaseCtx); err != nil {
+		log.Warn().Err(err).Str("key", le.key).Msg("[PHASTOS][CACHE] leadership lost")
+		return
+	}
+	log.Info().Str("key", le.key).Msg("[PHASTOS][CACHE] leadership handed over")
+}
@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+var (
+	// ErrLockNotAcquired is returned by Lock when the key is held by another owner until the wait ends
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	// ErrLockNotHeld is returned by Release and Refresh when the lock expired or was taken over
+	ErrLockNotHeld = errors.New("lock not held")
+)
+
+// releaseScript deletes the key only when it still holds the token of the owner
+var releaseScript = redigo.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// refreshScript extends the key only when it still holds the token of the owner
+var refreshScript = redigo.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+const (
+	defaultLockMinBackoff = 50 * time.Millisecond
+	defaultLockMaxBackoff = time.Second
+)
+
+type (
+	// Lock is a lock held on a key across instances, see Store.Lock
+	Lock interface {
+		// Key is the locked key, without the prefix
+		Key() string
+		// Token is the unique value of this acquisition: "{fence}:{random}"
+		Token() string
+		// Fence increases on every acquisition of the key, to reject the writes of a previous owner
+		// that lost the lock without knowing it (e.g. after a long GC pause)
+		Fence() int64
+		// Lost is closed on Release and when the lock could not be renewed: the work it protects must stop
+		Lost() <-chan struct{}
+		// Refresh extends the lock to its TTL, done periodically unless WithoutAutoRenew
+		Refresh(ctx context.Context) error
+		// Release stops the renewal and deletes the key if it is still held
+		Release(ctx context.Context) error
+	}
+
+	// LockOptions configures Store.Lock
+	LockOptions func(*lockConfig)
+
+	lockConfig struct {
+		wait       time.Duration
+		minBackoff time.Duration
+		maxBackoff time.Duration
+		autoRenew  bool
+		nodes      []*Store
+	}
+
+	redisLock struct {
+		key     string
+		fullKey string
+		token   string
+		fence   int64
+		ttl     time.Duration
+		nodes   []*Store
+
+		lost       chan struct{}
+		lostOnce   sync.Once
+		stopRenew  chan struct{}
+		renewDone  chan struct{}
+		releaseMtx sync.Mutex
+		released   bool
+	}
+)
+
+// WithLockWait retries the acquisition with an exponential backoff until wait elapses (or ctx is done),
+// instead of failing at once with ErrLockNotAcquired
+func WithLockWait(wait time.Duration) LockOptions {
+	return func(cfg *lockConfig) {
+		cfg.wait = wait
+	}
+}
+
+// WithLockBackoff sets the delays between the attempts of WithLockWait (default 50ms doubling up to 1s)
+func WithLockBackoff(minBackoff, maxBackoff time.Duration) LockOptions {
+	return func(cfg *lockConfig) {
+		cfg.minBackoff, cfg.maxBackoff = minBackoff, maxBackoff
+	}
+}
+
+// WithoutAutoRenew keeps the lock for its TTL only, Refresh extending it
+func WithoutAutoRenew() LockOptions {
+	return func(cfg *lockConfig) {
+		cfg.autoRenew = false
+	}
+}
+
+// WithRedlock acquires the lock on the store and on nodes, independent Redis instances, following
+// the Redlock algorithm: the lock is held when a majority of them granted it within its TTL
+func WithRedlock(nodes ...*Store) LockOptions {
+	return func(cfg *lockConfig) {
+		cfg.nodes = append(cfg.nodes, nodes...)
+	}
+}
+
+// Lock acquires key for ttl across the instances sharing the Redis store, renewing it every ttl/3
+// while held:
+//
+//	lock, err := store.Lock(ctx, "cron:daily-report", 30*time.Second, cache.WithLockWait(5*time.Second))
+//	if errors.Is(err, cache.ErrLockNotAcquired) {
+//		return nil // another instance runs it
+//	}
+//	defer lock.Release(ctx)
+func (r *Store) Lock(ctx context.Context, key string, ttl time.Duration, opts ...LockOptions) (Lock, error) {
+	cfg := lockConfig{minBackoff: defaultLockMinBackoff, maxBackoff: defaultLockMaxBackoff, autoRenew: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if ttl < time.Millisecond {
+		return nil, errors.Wrap(errors.New("ttl should be at least 1ms"), "phastos.cache.redis.Lock")
+	}
+
+	lock := &redisLock{
+		key:     key,
+		fullKey: fmt.Sprintf("%slock:%s", r.prefixKey, key),
+		ttl:     ttl,
+		nodes:   append([]*Store{r}, cfg.nodes...),
+		lost:    make(chan struct{}),
+	}
+
+	var deadline time.Time
+	if cfg.wait > 0 {
+		deadline = time.Now().Add(cfg.wait)
+	}
+	backoff := cfg.minBackoff
+	for {
+		acquired, err := lock.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if deadline.IsZero() || time.Now().Add(backoff).After(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > cfg.maxBackoff {
+			backoff = cfg.maxBackoff
+		}
+	}
+
+	if cfg.autoRenew {
+		lock.stopRenew = make(chan struct{})
+		lock.renewDone = make(chan struct{})
+		go lock.renew()
+	}
+	return lock, nil
+}
+
+// quorum is the number of nodes granting the lock for it to be held
+func (l *redisLock) quorum() int {
+	return len(l.nodes)/2 + 1
+}
+
+// acquire sets the key with a new token on the nodes, releasing the granted ones without a quorum
+func (l *redisLock) acquire(ctx context.Context) (bool, error) {
+	start := time.Now()
+	fence, err := l.nodes[0].nextFence(ctx, l.key)
+	if err != nil {
+		return false, err
+	}
+	l.fence = fence
+	l.token = strconv.FormatInt(fence, 10) + ":" + instanceID()
+
+	var granted int
+	var lastErr error
+	for _, node := range l.nodes {
+		ok, err := node.setLock(ctx, l.fullKey, l.token, l.ttl)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			granted++
+		}
+	}
+
+	// the clock drift of Redlock: 1% of the TTL plus 2ms
+	validity := l.ttl - time.Since(start) - l.ttl/100 - 2*time.Millisecond
+	if granted >= l.quorum() && validity > 0 {
+		return true, nil
+	}
+	l.releaseNodes(ctx)
+	if granted == 0 && lastErr != nil && len(l.nodes) == 1 {
+		return false, lastErr
+	}
+	return false, nil
+}
+
+func (r *Store) nextFence(ctx context.Context, key string) (int64, error) {
+	conn, err := r.Pool.GetContext(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "phastos.cache.redis.Lock.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+	fence, err := redigo.Int64(conn.Do("INCR", fmt.Sprintf("%slock:fence:%s", r.prefixKey, key)))
+	return fence, errors.Wrap(err, "phastos.cache.redis.Lock.INCR")
+}
+
+func (r *Store) setLock(ctx context.Context, fullKey, token string, ttl time.Duration) (bool, error) {
+	conn, err := r.Pool.GetContext(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "phastos.cache.redis.Lock.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+	_, err = redigo.String(conn.Do("SET", fullKey, token, "PX", ttl.Milliseconds(), "NX"))
+	if errors.Is(err, redigo.ErrNil) {
+		return false, nil
+	}
+	return err == nil, errors.Wrap(err, "phastos.cache.redis.Lock.SET")
+}
+
+// runScript runs script with the key and token of l on every node, returning the nodes where it
+// returned 1
+func (l *redisLock) runScript(ctx context.Context, script *redigo.Script, args ...any) int {
+	var done int
+	for _, node := range l.nodes {
+		conn, err := node.Pool.GetContext(ctx)
+		if err != nil {
+			continue
+		}
+		n, err := redigo.Int(script.Do(conn, append([]any{l.fullKey, l.token}, args...)...))
+		_ = conn.Close()
+		if err == nil && n == 1 {
+			done++
+		}
+	}
+	return done
+}
+
+func (l *redisLock) releaseNodes(ctx context.Context) int {
+	return l.runScript(ctx, releaseScript)
+}
+
+func (l *redisLock) Key() string           { return l.key }
+func (l *redisLock) Token() string         { return l.token }
+func (l *redisLock) Fence() int64          { return l.fence }
+func (l *redisLock) Lost() <-chan struct{} { return l.lost }
+
+func (l *redisLock) Refresh(ctx context.Context) error {
+	if l.runScript(ctx, refreshScript, l.ttl.Milliseconds()) < l.quorum() {
+		l.lostOnce.Do(func() { close(l.lost) })
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// renew refreshes the lock every ttl/3 until Release or until it is lost
+func (l *redisLock) renew() {
+	defer close(l.renewDone)
+	log := plog.Get()
+	ticker := time.NewTicker(max(l.ttl/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stopRenew:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3+time.Millisecond)
+			err := l.Refresh(ctx)
+			cancel()
+			if err != nil {
+				log.Warn().Str("key", l.key).Msg("[PHASTOS][CACHE] lock lost, it could not be renewed")
+				return
+			}
+		}
+	}
+}
+
+func (l *redisLock) Release(ctx context.Context) error {
+	l.releaseMtx.Lock()
+	defer l.releaseMtx.Unlock()
+	if l.released {
+		return nil
+	}
+	l.released = true
+	if l.stopRenew != nil {
+		close(l.stopRenew)
+		<-l.renewDone
+	}
+	released := l.releaseNodes(ctx)
+	l.lostOnce.Do(func() { close(l.lost) })
+	if released < l.quorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
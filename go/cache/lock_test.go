@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Lock(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	lock, err := store.Lock(ctx, "cron:report", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, lock.Token(), redis.values["phastos:lock:cron:report"])
+	assert.EqualValues(t, 1, lock.Fence())
+
+	_, err = store.Lock(ctx, "cron:report", time.Minute)
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+
+	redis.mu.Lock()
+	redis.values["phastos:lock:cron:report"] = "taken-over"
+	redis.mu.Unlock()
+	assert.ErrorIs(t, lock.Release(ctx), ErrLockNotHeld, "the token of another owner is not deleted")
+	assert.Equal(t, "taken-over", redis.values["phastos:lock:cron:report"])
+
+	redis.mu.Lock()
+	delete(redis.values, "phastos:lock:cron:report")
+	redis.mu.Unlock()
+	next, err := store.Lock(ctx, "cron:report", time.Minute)
+	require.NoError(t, err)
+	assert.Greater(t, next.Fence(), lock.Fence(), "the fence increases on every acquisition")
+	assert.NotEqual(t, lock.Token(), next.Token())
+	require.NoError(t, next.Release(ctx))
+	assert.NotContains(t, redis.values, "phastos:lock:cron:report")
+	assert.NoError(t, next.Release(ctx), "a second release is a no-op")
+}
+
+func TestStore_LockWait(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(newFakeRedis())
+
+	held, err := store.Lock(ctx, "job", time.Minute)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = held.Release(ctx)
+	}()
+
+	lock, err := store.Lock(ctx, "job", time.Minute, WithLockWait(time.Second), WithLockBackoff(5*time.Millisecond, 10*time.Millisecond))
+	require.NoError(t, err)
+	defer lock.Release(ctx) //nolint:errcheck
+
+	_, err = store.Lock(ctx, "job", time.Minute, WithLockWait(20*time.Millisecond), WithLockBackoff(5*time.Millisecond, 5*time.Millisecond))
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+}
+
+func TestStore_LockAutoRenew(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	lock, err := store.Lock(ctx, "job", 30*time.Millisecond)
+	require.NoError(t, err)
+
+	redis.mu.Lock()
+	redis.values["phastos:lock:job"] = "taken-over"
+	redis.mu.Unlock()
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("the lock is lost once it cannot be renewed")
+	}
+	assert.ErrorIs(t, lock.Release(ctx), ErrLockNotHeld)
+}
+
+func TestStore_Redlock(t *testing.T) {
+	ctx := context.Background()
+	nodes := []*fakeRedis{newFakeRedis(), newFakeRedis(), newFakeRedis()}
+	store := newFakeStore(nodes[0])
+	others := []*Store{newFakeStore(nodes[1]), newFakeStore(nodes[2])}
+
+	nodes[2].values["phastos:lock:job"] = "other owner"
+	lock, err := store.Lock(ctx, "job", time.Minute, WithRedlock(others...), WithoutAutoRenew())
+	require.NoError(t, err, "2 of 3 nodes are a quorum")
+	assert.Equal(t, lock.Token(), nodes[1].values["phastos:lock:job"])
+	require.NoError(t, lock.Release(ctx))
+
+	nodes[1].values["phastos:lock:job"] = "other owner"
+	_, err = store.Lock(ctx, "job", time.Minute, WithRedlock(others...))
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+	assert.NotContains(t, nodes[0].values, "phastos:lock:job", "the minority granted is released")
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := newFakeStore(newFakeRedis())
+
+	var leaders int32
+	var ran sync.WaitGroup
+	electionA := NewLeaderElection(store, "finalizer", WithLeaderTTL(time.Second), WithLeaderRetry(10*time.Millisecond))
+	electionB := NewLeaderElection(store, "finalizer", WithLeaderTTL(time.Second), WithLeaderRetry(10*time.Millisecond))
+	leading := func(ctx context.Context) {
+		assert.EqualValues(t, 1, atomic.AddInt32(&leaders, 1), "one leader at a time")
+		<-ctx.Done()
+		atomic.AddInt32(&leaders, -1)
+	}
+
+	ctxA, cancelA := context.WithCancel(ctx)
+	ran.Add(2)
+	go func() { defer ran.Done(); electionA.Run(ctxA, leading) }()
+	require.Eventually(t, electionA.IsLeader, time.Second, time.Millisecond)
+	go func() { defer ran.Done(); electionB.Run(ctx, leading) }()
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, electionB.IsLeader())
+
+	cancelA()
+	require.Eventually(t, electionB.IsLeader, time.Second, time.Millisecond, "the leadership is handed over")
+	assert.False(t, electionA.IsLeader())
+
+	cancel()
+	ran.Wait()
+	assert.False(t, electionB.IsLeader())
+}
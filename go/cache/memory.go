@@ -0,0 +1,417 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/kodekoding/phastos/v2/go/entity"
+)
+
+const (
+	defaultMemoryShards     = 16
+	defaultMemoryMaxEntries = 10000
+)
+
+type (
+	// Memory is an in-process Caches: a sharded LRU whose entries expire like the Redis keys.
+	// Values are stored encoded as with the Redis store (strings as is, JSON otherwise), so a Get
+	// never shares memory with the value given to Set. Streams are kept in process and never evicted.
+	Memory struct {
+		shards  []*memoryShard
+		sf      singleflight.Group
+		streams memoryStreams
+		now     func() time.Time
+	}
+
+	// MemoryOptions configures NewMemory
+	MemoryOptions func(*memoryConfig)
+
+	memoryConfig struct {
+		shards     int
+		maxEntries int
+	}
+
+	memoryShard struct {
+		mu         sync.Mutex
+		items      map[string]*list.Element
+		lru        *list.List // front = most recently used
+		maxEntries int
+	}
+
+	// memoryItem is a value or a hash
+	memoryItem struct {
+		key     string
+		value   string
+		hash    map[string]string
+		expires time.Time // zero: no expiry
+	}
+)
+
+// NewMemory creates an in-process cache holding at most WithMaxEntries keys (default 10000),
+// the least recently used keys being evicted first
+func NewMemory(opts ...MemoryOptions) *Memory {
+	cfg := memoryConfig{shards: defaultMemoryShards, maxEntries: defaultMemoryMaxEntries}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.shards < 1 {
+		cfg.shards = 1
+	}
+	perShard := cfg.maxEntries / cfg.shards
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	m := &Memory{shards: make([]*memoryShard, cfg.shards), now: time.Now}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{items: make(map[string]*list.Element), lru: list.New(), maxEntries: perShard}
+	}
+	m.streams.streams = make(map[string]*memoryStream)
+	return m
+}
+
+// WithMaxEntries sets the number of keys kept, spread over the shards
+func WithMaxEntries(maxEntries int) MemoryOptions {
+	return func(cfg *memoryConfig) {
+		cfg.maxEntries = maxEntries
+	}
+}
+
+// WithShards sets the number of independently locked shards (default 16)
+func WithShards(shards int) MemoryOptions {
+	return func(cfg *memoryConfig) {
+		cfg.shards = shards
+	}
+}
+
+func (m *Memory) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// getLocked returns the item of key, removing it when expired
+func (s *memoryShard) getLocked(key string, now time.Time) *memoryItem {
+	element, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	item := element.Value.(*memoryItem) //nolint:errcheck
+	if !item.expires.IsZero() && !now.Before(item.expires) {
+		s.removeLocked(element)
+		return nil
+	}
+	s.lru.MoveToFront(element)
+	return item
+}
+
+// putLocked stores item, evicting the least recently used items over the capacity
+func (s *memoryShard) putLocked(item *memoryItem) {
+	if element, ok := s.items[item.key]; ok {
+		element.Value = item
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.items[item.key] = s.lru.PushFront(item)
+	for s.lru.Len() > s.maxEntries {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+func (s *memoryShard) removeLocked(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.items, element.Value.(*memoryItem).key) //nolint:errcheck
+}
+
+func (m *Memory) expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
+
+// Len returns the number of keys, including the expired ones not evicted yet
+func (m *Memory) Len() int {
+	var n int
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Flush removes every key
+func (m *Memory) Flush() {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.mu.Unlock()
+	}
+}
+
+// getString returns the value of key
+func (m *Memory) getString(key string) (string, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item := s.getLocked(key, m.now()); item != nil && item.hash == nil {
+		return item.value, true
+	}
+	return "", false
+}
+
+// setString stores the encoded value of key for ttl, without expiry when ttl <= 0
+func (m *Memory) setString(key, value string, ttl time.Duration) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(&memoryItem{key: key, value: value, expires: m.expiresAt(ttl)})
+}
+
+// delete removes key, returning whether it existed
+func (m *Memory) delete(key string) bool {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.getLocked(key, m.now()) == nil {
+		return false
+	}
+	s.removeLocked(s.items[key])
+	return true
+}
+
+// Get implements Caches
+func (m *Memory) Get(ctx context.Context, key string, typeDestination any, fallbackFn ...FallbackFn) error {
+	if err := checkPointer(typeDestination, "phastos.cache.memory.Get"); err != nil {
+		return err
+	}
+	value, found := m.getString(key)
+	observeLookup("get", lookupErr(found))
+	if !found {
+		if len(fallbackFn) == 0 {
+			return ErrNotFound
+		}
+		var err error
+		value, err = m.fallback(ctx, key, fallbackFn[0], func(value string, ttl time.Duration) {
+			m.setString(key, value, ttl)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := decodeValue(value, typeDestination); err != nil {
+		return errors.Wrap(err, "phastos.cache.memory.Get.UnmarshalValueToTypeDestination")
+	}
+	return nil
+}
+
+// fallback runs fallbackFn once per key across concurrent callers and stores its result
+func (m *Memory) fallback(ctx context.Context, sfKey string, fallbackFn FallbackFn, store func(value string, ttl time.Duration)) (string, error) {
+	result, err, _ := m.sf.Do(sfKey, func() (any, error) {
+		fallbackResult, fallbackExpire, fallbackErr := fallbackFn(ctx)
+		if fallbackErr != nil {
+			return "", errors.Wrap(fallbackErr, "phastos.cache.memory.Get.FallbackFunction.Error")
+		}
+		value, err := encodeValue(fallbackResult)
+		if err != nil {
+			return "", errors.Wrap(err, "phastos.cache.memory.Get.FallbackFunction.FailedMarshalResult")
+		}
+		ttl := defaultExpire
+		if fallbackExpire > 0 {
+			ttl = time.Duration(fallbackExpire) * time.Second
+		}
+		store(value, ttl)
+		return value, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil //nolint:errcheck
+}
+
+// Set implements Caches, an expire <= 0 keeps the value until it is evicted
+func (m *Memory) Set(ctx context.Context, key string, value any, expire ...int) error {
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return errors.Wrap(err, "phastos.cache.memory.Set.MarshalValue")
+	}
+	m.setString(key, encoded, expireOf(expire))
+	return nil
+}
+
+// SetNX sets the value only when the key does not exist yet, returning false when it exists
+func (m *Memory) SetNX(ctx context.Context, key string, value any, expire int) (bool, error) {
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return false, errors.Wrap(err, "phastos.cache.memory.SetNX.MarshalValue")
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.getLocked(key, m.now()) != nil {
+		return false, nil
+	}
+	s.putLocked(&memoryItem{key: key, value: encoded, expires: m.expiresAt(time.Duration(expire) * time.Second)})
+	return true, nil
+}
+
+// Del implements Caches
+func (m *Memory) Del(ctx context.Context, key string) (int64, error) {
+	if m.delete(key) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// HSet implements Caches, expire applies to the whole hash as with EXPIRE
+func (m *Memory) HSet(ctx context.Context, key, field string, value any, expire ...int) error {
+	return m.HSetBulk(ctx, key, map[string]interface{}{field: value}, expire...)
+}
+
+// HSetBulk implements Caches
+func (m *Memory) HSetBulk(ctx context.Context, key string, fields map[string]interface{}, expire ...int) error {
+	values := make(map[string]string, len(fields))
+	for field, value := range fields {
+		encoded, err := encodeValue(value)
+		if err != nil {
+			return errors.Wrap(err, "phastos.cache.memory.HSet.MarshalValue")
+		}
+		values[field] = encoded
+	}
+	var ttl time.Duration
+	if len(expire) > 0 {
+		ttl = defaultExpire
+		if expire[0] > 0 {
+			ttl = time.Duration(expire[0]) * time.Second
+		}
+	}
+	m.hset(key, values, ttl)
+	return nil
+}
+
+// hset adds values to the hash of key, setting its expiry when ttl > 0
+func (m *Memory) hset(key string, values map[string]string, ttl time.Duration) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.getLocked(key, m.now())
+	if item == nil || item.hash == nil {
+		item = &memoryItem{key: key, hash: make(map[string]string)}
+	}
+	for field, value := range values {
+		item.hash[field] = value
+	}
+	if ttl > 0 {
+		item.expires = m.expiresAt(ttl)
+	}
+	s.putLocked(item)
+}
+
+// hash returns a copy of the hash of key
+func (m *Memory) hash(key string) map[string]string {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.getLocked(key, m.now())
+	if item == nil || item.hash == nil {
+		return nil
+	}
+	hash := make(map[string]string, len(item.hash))
+	for field, value := range item.hash {
+		hash[field] = value
+	}
+	return hash
+}
+
+// HGet implements Caches, a fallback result is stored in the hash and sets its expiry when it has none
+func (m *Memory) HGet(ctx context.Context, key, field string, typeDestination any, fallbackFn ...FallbackFn) error {
+	if err := checkPointer(typeDestination, "phastos.cache.memory.HGet"); err != nil {
+		return err
+	}
+	value, found := m.hash(key)[field]
+	observeLookup("hget", lookupErr(found))
+	if !found {
+		if len(fallbackFn) == 0 {
+			return ErrNotFound
+		}
+		var err error
+		value, err = m.fallback(ctx, fmt.Sprintf("%s:%s", key, field), fallbackFn[0], func(value string, ttl time.Duration) {
+			s := m.shard(key)
+			s.mu.Lock()
+			hasExpiry := false
+			if item := s.getLocked(key, m.now()); item != nil {
+				hasExpiry = !item.expires.IsZero()
+			}
+			s.mu.Unlock()
+			if hasExpiry {
+				ttl = 0
+			}
+			m.hset(key, map[string]string{field: value}, ttl)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := decodeValue(value, typeDestination); err != nil {
+		return errors.Wrap(err, "phastos.cache.memory.HGet.UnmarshalValueToTypeDestination")
+	}
+	return nil
+}
+
+// HDel implements Caches
+func (m *Memory) HDel(ctx context.Context, key, field string) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item := s.getLocked(key, m.now()); item != nil && item.hash != nil {
+		delete(item.hash, field)
+		if len(item.hash) == 0 {
+			s.removeLocked(s.items[key])
+		}
+	}
+	return nil
+}
+
+// HGetAll implements Caches, filling a *map[string]string or decoding the hash as JSON
+func (m *Memory) HGetAll(ctx context.Context, key string, dest interface{}) error {
+	if err := checkPointer(dest, "phastos.cache.memory.HGetAll"); err != nil {
+		return err
+	}
+	hash := m.hash(key)
+	if hash == nil {
+		hash = make(map[string]string)
+	}
+	if mapDest, ok := dest.(*map[string]string); ok {
+		*mapDest = hash
+		return nil
+	}
+	raw, _ := json.Marshal(hash)
+	return errors.Wrap(json.Unmarshal(raw, dest), "phastos.cache.memory.HGetAll.UnmarshalValueToTypeDestination")
+}
+
+func (m *Memory) WrapToHandler(next http.Handler) http.Handler {
+	return wrapCache(m, next)
+}
+
+func (m *Memory) WrapToContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, entity.CacheContext{}, m)
+}
+
+// lookupErr is the error of a lookup for observeLookup
+func lookupErr(found bool) error {
+	if found {
+		return nil
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// memoryStreams holds the streams of Memory
+	memoryStreams struct {
+		mu      sync.Mutex
+		streams map[string]*memoryStream
+		notify  chan struct{} // closed and replaced on every XAdd, waking the blocked XReadGroup
+	}
+
+	memoryStream struct {
+		seq      int64
+		messages []StreamData
+		groups   map[string]*memoryGroup
+	}
+
+	memoryGroup struct {
+		delivered int
+		pending   map[string]bool
+	}
+)
+
+func (s *memoryStreams) streamLocked(key string) *memoryStream {
+	stream, ok := s.streams[key]
+	if !ok {
+		stream = &memoryStream{groups: make(map[string]*memoryGroup)}
+		s.streams[key] = stream
+	}
+	return stream
+}
+
+func (s *memoryStreams) notifyLocked() chan struct{} {
+	if s.notify == nil {
+		s.notify = make(chan struct{})
+	}
+	return s.notify
+}
+
+// XAdd appends a message to a stream, returning its ID
+func (m *Memory) XAdd(ctx context.Context, streamKey string, values map[string]string) (string, error) {
+	s := &m.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stream := s.streamLocked(streamKey)
+	stream.seq++
+	id := fmt.Sprintf("%d-%d", m.now().UnixMilli(), stream.seq)
+	stream.messages = append(stream.messages, StreamData{ID: id, Values: values})
+
+	close(s.notifyLocked())
+	s.notify = nil
+	return id, nil
+}
+
+// XGroupCreateMkStream implements Caches, startID "$" skips the current messages
+func (m *Memory) XGroupCreateMkStream(ctx context.Context, streamKey, group, startID string) error {
+	s := &m.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stream := s.streamLocked(streamKey)
+	if _, exists := stream.groups[group]; exists {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+	consumerGroup := &memoryGroup{pending: make(map[string]bool)}
+	if startID == "$" {
+		consumerGroup.delivered = len(stream.messages)
+	}
+	stream.groups[group] = consumerGroup
+	return nil
+}
+
+// XReadGroup implements Caches for the ">" ID (new messages), waiting up to block for a message
+func (m *Memory) XReadGroup(ctx context.Context, group, consumer string, streams []string, ids []string, block time.Duration, count int64) ([]StreamMessages, error) {
+	var deadline <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		result, notify, err := m.readGroup(group, streams, count)
+		if err != nil || len(result) > 0 || deadline == nil {
+			return result, err
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			return []StreamMessages{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *Memory) readGroup(group string, streams []string, count int64) ([]StreamMessages, chan struct{}, error) {
+	s := &m.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []StreamMessages
+	for _, streamKey := range streams {
+		stream, ok := s.streams[streamKey]
+		var consumerGroup *memoryGroup
+		if ok {
+			consumerGroup, ok = stream.groups[group]
+		}
+		if !ok {
+			return nil, nil, errors.Errorf("NOGROUP No such key '%s' or consumer group '%s'", streamKey, group)
+		}
+		messages := stream.messages[consumerGroup.delivered:]
+		if count > 0 && int64(len(messages)) > count {
+			messages = messages[:count]
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		consumerGroup.delivered += len(messages)
+		for _, message := range messages {
+			consumerGroup.pending[message.ID] = true
+		}
+		result = append(result, StreamMessages{Stream: streamKey, Messages: append([]StreamData(nil), messages...)})
+	}
+	return result, s.notifyLocked(), nil
+}
+
+// XAck implements Caches
+func (m *Memory) XAck(ctx context.Context, streamKey, group, id string) (int64, error) {
+	s := &m.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stream, ok := s.streams[streamKey]; ok {
+		if consumerGroup, ok := stream.groups[group]; ok && consumerGroup.pending[id] {
+			delete(consumerGroup.pending, id)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_GetSet(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	var str string
+	assert.ErrorIs(t, m.Get(ctx, "missing", &str), ErrNotFound)
+	assert.Error(t, m.Get(ctx, "missing", str), "the destination must be a pointer")
+
+	require.NoError(t, m.Set(ctx, "str", "raw value"))
+	require.NoError(t, m.Get(ctx, "str", &str))
+	assert.Equal(t, "raw value", str)
+
+	type user struct{ Name string }
+	require.NoError(t, m.Set(ctx, "user", user{Name: "budi"}))
+	var got user
+	require.NoError(t, m.Get(ctx, "user", &got))
+	assert.Equal(t, "budi", got.Name)
+	require.NoError(t, m.Get(ctx, "user", &str))
+	assert.JSONEq(t, `{"Name":"budi"}`, str)
+
+	deleted, err := m.Del(ctx, "user")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, deleted)
+	deleted, _ = m.Del(ctx, "user")
+	assert.EqualValues(t, 0, deleted)
+}
+
+func TestMemory_TTL(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.Set(ctx, "short", "v", 10))
+	require.NoError(t, m.Set(ctx, "default", "v"))
+	require.NoError(t, m.Set(ctx, "forever", "v", 0))
+
+	now = now.Add(11 * time.Second)
+	var str string
+	assert.ErrorIs(t, m.Get(ctx, "short", &str), ErrNotFound)
+	assert.NoError(t, m.Get(ctx, "default", &str))
+
+	now = now.Add(defaultExpire)
+	assert.ErrorIs(t, m.Get(ctx, "default", &str), ErrNotFound)
+	assert.NoError(t, m.Get(ctx, "forever", &str))
+
+	acquired, err := m.SetNX(ctx, "lock", "a", 5)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, _ = m.SetNX(ctx, "lock", "b", 5)
+	assert.False(t, acquired)
+	now = now.Add(6 * time.Second)
+	acquired, _ = m.SetNX(ctx, "lock", "b", 5)
+	assert.True(t, acquired)
+}
+
+func TestMemory_LRUEviction(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(WithShards(1), WithMaxEntries(3))
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, m.Set(ctx, key, key))
+	}
+	var str string
+	require.NoError(t, m.Get(ctx, "a", &str), "a becomes the most recently used")
+	require.NoError(t, m.Set(ctx, "d", "d"))
+
+	assert.Equal(t, 3, m.Len())
+	assert.ErrorIs(t, m.Get(ctx, "b", &str), ErrNotFound, "b is the least recently used")
+	for _, key := range []string{"a", "c", "d"} {
+		assert.NoError(t, m.Get(ctx, key, &str), key)
+	}
+
+	m.Flush()
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestMemory_GetFallback(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	var calls int32
+	release := make(chan struct{})
+	fallback := func(ctx context.Context) (any, int64, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return map[string]int{"total": 3}, 0, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, m.Get(ctx, "report", &results[i], fallback))
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "concurrent misses share one fallback call")
+	for _, result := range results {
+		assert.Equal(t, 3, result["total"])
+	}
+
+	var cached string
+	require.NoError(t, m.Get(ctx, "report", &cached))
+	assert.JSONEq(t, `{"total":3}`, cached)
+
+	failing := func(ctx context.Context) (any, int64, error) { return nil, 0, errors.New("db down") }
+	err := m.Get(ctx, "other", &cached, failing)
+	assert.ErrorContains(t, err, "phastos.cache.memory.Get.FallbackFunction.Error")
+	assert.ErrorIs(t, m.Get(ctx, "other", &cached), ErrNotFound, "a failed fallback is not cached")
+}
+
+func TestMemory_Hash(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	var str string
+	assert.ErrorIs(t, m.HGet(ctx, "h", "f", &str), ErrNotFound)
+
+	require.NoError(t, m.HSet(ctx, "h", "name", "budi"))
+	require.NoError(t, m.HSetBulk(ctx, "h", map[string]interface{}{"age": 30}, 60))
+	require.NoError(t, m.HGet(ctx, "h", "name", &str))
+	assert.Equal(t, "budi", str)
+
+	var age int
+	require.NoError(t, m.HGet(ctx, "h", "age", &age))
+	assert.Equal(t, 30, age)
+
+	require.NoError(t, m.HGet(ctx, "h", "city", &str, func(ctx context.Context) (any, int64, error) {
+		return "Jakarta", 0, nil
+	}))
+	assert.Equal(t, "Jakarta", str)
+
+	all := map[string]string{}
+	require.NoError(t, m.HGetAll(ctx, "h", &all))
+	assert.Equal(t, map[string]string{"name": "budi", "age": "30", "city": "Jakarta"}, all)
+
+	require.NoError(t, m.HDel(ctx, "h", "name"))
+	assert.ErrorIs(t, m.HGet(ctx, "h", "name", &str), ErrNotFound)
+
+	assert.ErrorIs(t, m.Get(ctx, "h", &str), ErrNotFound, "a hash is not a string value")
+}
+
+func TestMemory_Streams(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, err := m.XReadGroup(ctx, "g", "c1", []string{"orders"}, []string{">"}, 0, 10)
+	assert.ErrorContains(t, err, "NOGROUP")
+
+	_, _ = m.XAdd(ctx, "orders", map[string]string{"id": "old"})
+	require.NoError(t, m.XGroupCreateMkStream(ctx, "orders", "g", "$"))
+	assert.ErrorContains(t, m.XGroupCreateMkStream(ctx, "orders", "g", "$"), "BUSYGROUP")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = m.XAdd(ctx, "orders", map[string]string{"id": "1"})
+	}()
+	result, err := m.XReadGroup(ctx, "g", "c1", []string{"orders"}, []string{">"}, time.Second, 10)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Len(t, result[0].Messages, 1)
+	assert.Equal(t, "1", result[0].Messages[0].Values["id"])
+
+	acked, err := m.XAck(ctx, "orders", "g", result[0].Messages[0].ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, acked)
+	acked, _ = m.XAck(ctx, "orders", "g", result[0].Messages[0].ID)
+	assert.EqualValues(t, 0, acked)
+
+	result, err = m.XReadGroup(ctx, "g", "c1", []string{"orders"}, []string{">"}, 10*time.Millisecond, 10)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestMemory_Context(t *testing.T) {
+	m := NewMemory()
+	assert.Same(t, m, GetCacheFromContext(m.WrapToContext(context.Background())))
+}
+
+func BenchmarkMemory_Get(b *testing.B) {
+	ctx := context.Background()
+	m := NewMemory()
+	for i := 0; i < 1000; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("key-%d", i), "value")
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var str string
+		i := 0
+		for pb.Next() {
+			_ = m.Get(ctx, fmt.Sprintf("key-%d", i%1000), &str)
+			i++
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+// RegisterMetrics exposes the connection pool stats of the store labelled pool=name
+// on the metrics registry. Command latency and hit/miss are recorded once metrics are enabled.
+func (r *Store) RegisterMetrics(name string) error {
+	pool, ok := r.Pool.(*redigo.Pool)
+	if !ok {
+		return nil
+	}
+	return errors.Wrap(metrics.RegisterRedisPool(name, pool), "phastos.cache.RegisterMetrics")
+}
+
+// observeLookup records a GET/HGET as hit or miss; connection errors are neither
+func observeLookup(operation string, err error) {
+	switch {
+	case err == nil:
+		metrics.ObserveCacheGet(operation, true)
+	case errors.Is(err, redigo.ErrNil):
+		metrics.ObserveCacheGet(operation, false)
+	}
+}
+
+// metricsConn records the latency of every command sent through a pooled connection
+type metricsConn struct {
+	redigo.Conn
+}
+
+func (c metricsConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		// redigo flushes pipelined commands with an empty command name
+		return c.Conn.Do(commandName, args...)
+	}
+	start := time.Now()
+	reply, err := c.Conn.Do(commandName, args...)
+	metrics.ObserveRedisCommand(commandName, start, err)
+	return reply, err
+}
+
+func (c metricsConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redigo.DoContext(c.Conn, ctx, commandName, args...)
+	if commandName != "" {
+		metrics.ObserveRedisCommand(commandName, start, err)
+	}
+	return reply, err
+}
+
+func (c metricsConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redigo.DoWithTimeout(c.Conn, timeout, commandName, args...)
+	if commandName != "" {
+		metrics.ObserveRedisCommand(commandName, start, err)
+	}
+	return reply, err
+}
+
+func (c metricsConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redigo.ReceiveContext(c.Conn, ctx)
+}
+
+func (c metricsConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redigo.ReceiveWithTimeout(c.Conn, timeout)
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+func TestStoreMetrics_CommandLatencyAndHitMiss(t *testing.T) {
+	metrics.Enable()
+	t.Cleanup(metrics.Disable)
+
+	sc := newStubConn()
+	store := newTestStore(sc)
+	store.Pool = &stubHandler{conn: metricsConn{Conn: sc}}
+	ctx := context.Background()
+
+	var dest string
+	assert.Error(t, store.Get(ctx, "metrics-key", &dest))
+	sc.responses["GET:phastos:metrics-key"] = "cached"
+	require.NoError(t, store.Get(ctx, "metrics-key", &dest))
+	require.NoError(t, store.Get(ctx, "metrics-key", &dest))
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+
+	assert.Contains(t, body, `phastos_cache_requests_total{operation="get",result="hit"} 2`)
+	assert.Contains(t, body, `phastos_cache_requests_total{operation="get",result="miss"} 1`)
+	assert.Contains(t, body, `phastos_redis_command_duration_seconds_count{command="GET",status="success"} 3`)
+}
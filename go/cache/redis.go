@@ -2,11 +2,13 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"reflect"
+	"strconv"
 	"time"
 
 	redigo "github.com/gomodule/redigo/redis"
@@ -24,6 +26,14 @@ type Store struct {
 	prefixKey string
 	maxRetry  int
 	sf        *singleflight.Group
+
+	rootPrefix string   // prefixKey of the Store a Namespace was created from, the prefix of the tag sets
+	tags       []string // tags of the keys written, see WithTags
+
+	streamMaxLen    int           // entries PublishStream keeps per stream, 0: unbounded
+	streamRetention time.Duration // age of the entries PublishStream keeps, instead of streamMaxLen
+
+	codec valueCodec // encoding of the values written, see WithCodec and WithCompression
 }
 
 type Options func(*RedisCfg)
@@ -38,8 +48,41 @@ type RedisCfg struct {
 	MaxActive int    `yaml:"max_active"`
 	Password  string `yaml:"password"`
 	Username  string `yaml:"username"`
-	MaxRetry  int
-	dbNo      int
+	MaxRetry  int    `yaml:"max_retry"`
+	DB        int    `yaml:"db"`
+
+	// Driver selects the backend of Open: DriverRedis (default), DriverMemory or DriverTwoTier
+	Driver string `yaml:"driver"`
+	// L1MaxEntries is the number of keys kept in process by DriverMemory and DriverTwoTier
+	L1MaxEntries int `yaml:"l1_max_entries"`
+	// L1TTL caps, in seconds, how long DriverTwoTier keeps a key in process
+	L1TTL int `yaml:"l1_ttl"`
+
+	// MasterName and SentinelAddresses connect to the master of a Sentinel-managed deployment
+	MasterName        string   `yaml:"master_name"`
+	SentinelAddresses []string `yaml:"sentinel_addresses"`
+	SentinelUsername  string   `yaml:"sentinel_username"`
+	SentinelPassword  string   `yaml:"sentinel_password"`
+	// ClusterAddresses are the seed nodes of a Redis Cluster
+	ClusterAddresses []string `yaml:"cluster_addresses"`
+
+	TLS           bool   `yaml:"tls"`
+	TLSSkipVerify bool   `yaml:"tls_skip_verify"`
+	TLSCAFile     string `yaml:"tls_ca_file"`
+	// TLSConfig overrides the TLS settings above, see WithTLS
+	TLSConfig *tls.Config `yaml:"-"`
+
+	// StreamMaxLen is the number of entries PublishStream keeps per stream (default 100, negative: unbounded)
+	StreamMaxLen int `yaml:"stream_max_len"`
+	// StreamRetention is, in seconds, the age of the entries PublishStream keeps, instead of StreamMaxLen
+	StreamRetention int `yaml:"stream_retention"`
+
+	// Codec encodes the values that are not strings: CodecJSON (default), CodecMsgPack, CodecGob,
+	// CodecProto or a codec of RegisterCodec
+	Codec string `yaml:"codec"`
+	// Compression compresses the values from CompressionThreshold bytes (default 1024): "gzip" or "zstd"
+	Compression          string `yaml:"compression"`
+	CompressionThreshold int    `yaml:"compression_threshold"`
 }
 
 type StreamData struct {
@@ -58,7 +101,10 @@ type Handler interface {
 	GetContext(context.Context) (redigo.Conn, error)
 }
 
-const defaultPrefixKey = "phastos:"
+const (
+	defaultPrefixKey    = "phastos:"
+	defaultStreamMaxLen = 100
+)
 
 type Caches interface {
 	Get(ctx context.Context, key string, typeDestination any, fallbackFn ...FallbackFn) error
@@ -92,45 +138,16 @@ func New(options ...Options) *Store {
 	if cfg.MaxRetry == 0 {
 		cfg.MaxRetry = 10
 	}
-
-	store := &Store{
-		Pool: &redigo.Pool{
-			MaxIdle:     cfg.MaxIdle,
-			MaxActive:   cfg.MaxActive,
-			IdleTimeout: time.Duration(cfg.Timeout) * time.Second,
-			Dial: func() (redigo.Conn, error) {
-				var dialOpts []redigo.DialOption
-
-				if cfg.Password != "" {
-					dialOpts = append(dialOpts, redigo.DialPassword(cfg.Password))
-				}
-				if cfg.Username != "" {
-					dialOpts = append(dialOpts, redigo.DialUsername(cfg.Username))
-				}
-
-				dialOpts = append(dialOpts, redigo.DialConnectTimeout(time.Duration(cfg.Timeout)*time.Second))
-				dialOpts = append(dialOpts, redigo.DialReadTimeout(time.Duration(cfg.Timeout)*time.Second))
-				dialOpts = append(dialOpts, redigo.DialWriteTimeout(time.Duration(cfg.Timeout)*time.Second))
-
-				dialOpts = append(dialOpts, redigo.DialDatabase(cfg.dbNo))
-
-				c, err := redigo.Dial("tcp", cfg.Address, dialOpts...)
-				if err != nil {
-					log.Fatal().Msgf("Can't connect to redis: %s", err.Error())
-				}
-				return c, nil
-			},
-			TestOnBorrow: func(c redigo.Conn, t time.Time) error {
-				_, err := redigo.String(c.Do("PING"))
-				return err
-			},
-		},
+	if cfg.StreamMaxLen == 0 {
+		cfg.StreamMaxLen = defaultStreamMaxLen
 	}
 
-	pool := store.Pool.Get()
-	if _, err := redigo.String(pool.Do("PING")); err != nil {
-		log.Fatal().Msg(fmt.Sprintf("Cannot connect to redis: %s", err.Error()))
-	}
+	store := &Store{Pool: newHandler(&cfg)}
+
+	// an unreachable redis does not stop the service: the commands fail until it is back
+	conn := store.Pool.Get()
+	_, pingErr := redigo.String(conn.Do("PING"))
+	_ = conn.Close()
 
 	prefixKey := os.Getenv("REDIS_PREFIX_KEY")
 	if prefixKey == "" {
@@ -139,20 +156,135 @@ func New(options ...Options) *Store {
 	store.prefixKey = prefixKey
 	store.maxRetry = cfg.MaxRetry
 	store.sf = &singleflight.Group{}
-	log.Info().Int("db", cfg.dbNo).Msg("Successful connect to redis")
+	store.streamMaxLen = max(cfg.StreamMaxLen, 0)
+	store.streamRetention = time.Duration(cfg.StreamRetention) * time.Second
+	var codecErr error
+	if store.codec, codecErr = cfg.valueCodec(); codecErr != nil {
+		log.Error().Err(codecErr).Msg("[PHASTOS][CACHE] Invalid codec, the values are written as JSON")
+	}
+	if pingErr != nil {
+		log.Error().Err(pingErr).Str("address", cfg.Address).Msg("[PHASTOS][CACHE] Cannot connect to redis, running degraded")
+		return store
+	}
+	log.Info().Int("db", cfg.DB).Str("mode", cfg.mode()).Msg("Successful connect to redis")
 
 	return store
 }
 
+// Key returns key prefixed like the keys of r, for the packages sending their own commands through Pool
+func (r *Store) Key(key string) string {
+	return r.prefixKey + key
+}
+
+// newHandler returns the connections of the topology of cfg
+func newHandler(cfg *RedisCfg) Handler {
+	switch cfg.mode() {
+	case ModeCluster:
+		return newCluster(*cfg)
+	case ModeSentinel:
+		return newSentinelPool(cfg)
+	}
+	return newPool(cfg, func() (string, error) { return cfg.Address, nil }, redigo.DialDatabase(cfg.DB))
+}
+
+// newPool returns a pool of connections to the server at address()
+func newPool(cfg *RedisCfg, address func() (string, error), extraDialOpts ...redigo.DialOption) *redigo.Pool {
+	return &redigo.Pool{
+		MaxIdle:     cfg.MaxIdle,
+		MaxActive:   cfg.MaxActive,
+		IdleTimeout: time.Duration(cfg.Timeout) * time.Second,
+		Dial: func() (redigo.Conn, error) {
+			addr, err := address()
+			if err != nil {
+				return nil, err
+			}
+			dialOpts, err := cfg.dialOptions(cfg.Username, cfg.Password)
+			if err != nil {
+				return nil, err
+			}
+			c, err := dialRedis("tcp", addr, append(dialOpts, extraDialOpts...)...)
+			if err != nil {
+				return nil, errors.Wrap(err, "phastos.cache.redis.Dial")
+			}
+			return metricsConn{Conn: c}, nil
+		},
+		TestOnBorrow: func(c redigo.Conn, t time.Time) error {
+			_, err := redigo.String(c.Do("PING"))
+			return err
+		},
+	}
+}
+
+// WithConfig uses every setting of c, e.g. loaded by the config package
+func WithConfig(c RedisCfg) Options {
+	return func(cfg *RedisCfg) {
+		*cfg = c
+	}
+}
+
 func WithAddress(address string) Options {
 	return func(cfg *RedisCfg) {
 		cfg.Address = address
 	}
 }
 
+// WithStreamMaxLen sets the number of entries PublishStream keeps per stream (default 100, negative:
+// unbounded)
+func WithStreamMaxLen(maxLen int) Options {
+	return func(cfg *RedisCfg) {
+		cfg.StreamMaxLen = maxLen
+	}
+}
+
+// WithStreamRetention makes PublishStream keep the entries younger than retention instead of the last
+// StreamMaxLen ones (Redis 6.2+)
+func WithStreamRetention(retention time.Duration) Options {
+	return func(cfg *RedisCfg) {
+		cfg.StreamRetention = int(retention.Seconds())
+	}
+}
+
+// WithCodec encodes the values that are not strings with codec instead of JSON, registering it (see
+// RegisterCodec). The values of every codec stay readable, so it can be changed on a running
+// deployment once every instance reads the new codec.
+func WithCodec(codec Codec) Options {
+	return func(cfg *RedisCfg) {
+		_ = RegisterCodec(codec)
+		cfg.Codec = codec.Name()
+	}
+}
+
+// WithCompression compresses the values of threshold bytes and more (default 1024)
+func WithCompression(compression Compression, threshold ...int) Options {
+	return func(cfg *RedisCfg) {
+		cfg.Compression = compression.String()
+		if len(threshold) > 0 {
+			cfg.CompressionThreshold = threshold[0]
+		}
+	}
+}
+
+// valueCodec returns the codec of the values of cfg
+func (cfg *RedisCfg) valueCodec() (valueCodec, error) {
+	codec := valueCodec{threshold: defaultCompressionThreshold}
+	if cfg.CompressionThreshold > 0 {
+		codec.threshold = cfg.CompressionThreshold
+	}
+	if cfg.Codec != "" {
+		named, err := CodecByName(cfg.Codec)
+		if err != nil {
+			return codec, err
+		}
+		codec.codec = named
+	}
+	compression, err := CompressionByName(cfg.Compression)
+	codec.compression = compression
+	return codec, err
+}
+
 func WithDatabaseNo(dbNo int) Options {
 	return func(cfg *RedisCfg) {
-		cfg.dbNo = dbNo
+		cfg.DB = dbNo
 	}
 }
 
@@ -224,6 +356,21 @@ func (r *Store) Get(ctx context.Context, key string, typeDestination any, fallba
 	if reflectVal.Kind() != reflect.Ptr {
 		return errors.Wrap(errors.New("type destination params should be a pointer"), "phastos.cache.redis.Get.CheckTypeDestinationParam")
 	}
+	resultStr, err := r.get(ctx, key, fallbackFn...)
+	if err != nil {
+		return err
+	}
+
+	if err = decodeValue(resultStr, typeDestination); err != nil {
+		unmarshalErr := errors.New(fmt.Sprintf("[CACHE][REDIS][GET] - Failed Unmarshal result %s with error: %s", resultStr, err.Error()))
+		return errors.Wrap(unmarshalErr, "phastos.cache.redis.Get.UnmarshalValueToTypeDestination")
+	}
+
+	return nil
+}
+
+// get returns the stored value of key, encoded
+func (r *Store) get(ctx context.Context, key string, fallbackFn ...FallbackFn) (string, error) {
 	wrapResult, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
 		segmentName := "Redis-Get"
 		if len(fallbackFn) > 0 {
@@ -239,6 +386,7 @@ func (r *Store) Get(ctx context.Context, key string, typeDestination any, fallba
 
 		defer conn.Close() //nolint:errcheck
 		resp, err := redigo.String(conn.Do("GET", fmt.Sprintf("%s%s", r.prefixKey, key)))
+		observeLookup("get", err)
 		if errors.Is(err, redigo.ErrNil) {
 			if len(fallbackFn) > 0 {
 				fallbackAction := fallbackFn[0]
@@ -250,25 +398,14 @@ func (r *Store) Get(ctx context.Context, key string, typeDestination any, fallba
 	})
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	resultStr, validStr := wrapResult.(string)
 	if !validStr {
-		return errors.New(fmt.Sprintf("[CACHE][REDIS] - Result is not valid: %v", wrapResult))
-	}
-
-	if strVal, isStringType := typeDestination.(*string); isStringType {
-		*strVal = resultStr
-		return nil
+		return "", errors.New(fmt.Sprintf("[CACHE][REDIS] - Result is not valid: %v", wrapResult))
 	}
-
-	if err = json.Unmarshal([]byte(resultStr), typeDestination); err != nil {
-		unmarshalErr := errors.New(fmt.Sprintf("[CACHE][REDIS][GET] - Failed Unmarshal result %s with error: %s", resultStr, err.Error()))
-		return errors.Wrap(unmarshalErr, "phastos.cache.redis.Get.UnmarshalValueToTypeDestination")
-	}
-
-	return nil
+	return resultStr, nil
 }
 
 func (r *Store) fallbackAction(ctx context.Context, key, field string, fallbackFn FallbackFn, span monitoring.Span, conn redigo.Conn) (string, error) {
@@ -288,16 +425,9 @@ func (r *Store) fallbackAction(ctx context.Context, key, field string, fallbackF
 			return "", errors.Wrap(fallbackErr, "phastos.cache.redis.Get.FallbackFunction.Error")
 		}
 
-		fallbackValue, isStringType := fallbackResult.(string)
-		var cacheValue string
-		if !isStringType {
-			byteFallbackResult, marshallErr := json.Marshal(fallbackResult)
-			if marshallErr != nil {
-				return "", errors.Wrap(marshallErr, "phastos.cache.redis.Get.FallbackFunction.FailedMarshalResult")
-			}
-			cacheValue = string(byteFallbackResult)
-		} else {
-			cacheValue = fallbackValue
+		cacheValue, marshallErr := r.codec.encode(fallbackResult)
+		if marshallErr != nil {
+			return "", errors.Wrap(marshallErr, "phastos.cache.redis.Get.FallbackFunction.FailedMarshalResult")
 		}
 		var setParams []any
 		key = fmt.Sprintf("%s%s", r.prefixKey, key)
@@ -335,6 +465,9 @@ func (r *Store) fallbackAction(ctx context.Context, key, field string, fallbackF
 		if _, err := conn.Do(redisCommand, setParams...); err != nil {
 			return "", errors.Wrap(err, fmt.Sprintf("phastos.cache.redis.fallbackAction.%s", redisCommand))
 		}
+		if err := r.addTags(conn, key, fallbackExpire); err != nil {
+			return "", err
+		}
 
 		if field != "" && fallbackExpire > 0 && !isHSETTTLAlreadyExist {
 			if _, err := conn.Do("EXPIRE", key, fallbackExpire); err != nil {
@@ -377,6 +510,9 @@ func (r *Store) Del(ctx context.Context, key string) (int64, error) {
 	return wrapResult.(int64), nil //nolint:errcheck
 }
 
+// PublishStream appends the fields of data to the stream streamName (not prefixed, see NewConsumer),
+// returning the ID of the entry. The stream is trimmed to the last StreamMaxLen entries, or to the
+// entries younger than StreamRetention.
 func (r *Store) PublishStream(ctx context.Context, streamName string, data ...map[string]any) (string, error) {
 	wrapResult, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
 		conn, err := r.Pool.GetContext(ctx)
@@ -389,12 +525,15 @@ func (r *Store) PublishStream(ctx context.Context, streamName string, data ...ma
 			return "", errors.New("please provide the data at least 1 data")
 		}
 
-		valueList := make([]any, 0, len(data)*2)
-		for key, value := range data {
-			valueList = append(valueList, key, value)
+		args := append([]any{streamName}, r.streamTrim()...)
+		args = append(args, "*")
+		for _, fields := range data {
+			for field, value := range fields {
+				args = append(args, field, streamValue(value))
+			}
 		}
 
-		messageID, err := redigo.String(conn.Do("XADD", streamName, "MAXLEN", "~", "100", valueList))
+		messageID, err := redigo.String(conn.Do("XADD", args...))
 		if err != nil {
 			return "", errors.Wrap(err, "infrastructure.cache.redis.PublishStream.XADD")
 		}
@@ -407,6 +546,30 @@ func (r *Store) PublishStream(ctx context.Context, streamName string, data ...ma
 	return wrapResult.(string), nil //nolint:errcheck
 }
 
+// streamTrim returns the trimming arguments of XADD
+func (r *Store) streamTrim() []any {
+	switch {
+	case r.streamRetention > 0:
+		minID := time.Now().Add(-r.streamRetention).UnixMilli()
+		return []any{"MINID", "~", strconv.FormatInt(minID, 10)}
+	case r.streamMaxLen > 0:
+		return []any{"MAXLEN", "~", r.streamMaxLen}
+	default:
+		return nil
+	}
+}
+
+// streamValue returns the value of a stream field: strings as is, other values as JSON
+func streamValue(value any) any {
+	switch v := value.(type) {
+	case string, []byte:
+		return v
+	default:
+		byteValue, _ := json.Marshal(v)
+		return string(byteValue)
+	}
+}
+
 func (r Store) SubscribeStream(ctx context.Context, streamName string, actionFn func(ctx context.Context, data *StreamData) error) {
 	log := plog.Ctx(ctx)
 	conn, err := r.Pool.GetContext(ctx)
@@ -496,13 +659,11 @@ func (r *Store) HSet(ctx context.Context, key, field string, value any, expire .
 		}
 		defer conn.Close() //nolint:errcheck
 
-		params := []any{key, field}
-		if val, isStringType := value.(string); isStringType {
-			params = append(params, val)
-		} else {
-			byteValue, _ := json.Marshal(value)
-			params = append(params, string(byteValue))
+		encoded, err := r.codec.encode(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.cache.redis.HSET.MarshalValue")
 		}
+		params := []any{key, field, encoded}
 
 		_, err = redigo.Int64(conn.Do("HSET", params...))
 		if err != nil {
@@ -523,7 +684,7 @@ func (r *Store) HSet(ctx context.Context, key, field string, value any, expire .
 				log.Err(err).Str("key", key).Str("field", field).Str("command", "HSET").Msg("Failed to set Expire")
 			}
 		}
-		return nil, nil
+		return nil, r.addTags(conn, key, int64(expireTime))
 	}); err != nil {
 		return err
 	}
@@ -552,6 +713,7 @@ func (r *Store) HGet(ctx context.Context, key, field string, typeDestination any
 		}
 		defer conn.Close() //nolint:errcheck
 		resp, err := redigo.String(conn.Do("HGET", fmt.Sprintf("%s%s", r.prefixKey, key), field))
+		observeLookup("hget", err)
 		if errors.Is(err, redigo.ErrNil) && len(fallbackFn) > 0 {
 			fallbackAction := fallbackFn[0]
 			return r.fallbackAction(ctx, key, field, fallbackAction, span, conn)
@@ -568,11 +730,7 @@ func (r *Store) HGet(ctx context.Context, key, field string, typeDestination any
 		return errors.New(fmt.Sprintf("[CACHE][REDIS][HGET] - Result is not valid: %v", wrapResult))
 	}
 
-	if strVal, isStringType := typeDestination.(*string); isStringType {
-		*strVal = resultStr
-		return nil
-	}
-	if err = json.Unmarshal([]byte(resultStr), typeDestination); err != nil {
+	if err = decodeValue(resultStr, typeDestination); err != nil {
 		unmarshalErr := errors.New(fmt.Sprintf("[CACHE][REDIS][HGET] - Failed Unmarshal result %s with error: %s", resultStr, err.Error()))
 		return errors.Wrap(unmarshalErr, "phastos.cache.redis.HGET.UnmarshalValueToTypeDestination")
 	}
@@ -616,12 +774,11 @@ func (r *Store) Set(ctx context.Context, key string, value any, expire ...int) e
 		var setParams []any
 		setParams = append(setParams, fmt.Sprintf("%s%s", r.prefixKey, key))
 
-		if val, isString := value.(string); isString {
-			setParams = append(setParams, val)
-		} else {
-			byteValue, _ := json.Marshal(value)
-			setParams = append(setParams, string(byteValue))
+		encoded, err := r.codec.encode(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.cache.redis.Set.MarshalValue")
 		}
+		setParams = append(setParams, encoded)
 		expireTime := int(10 * time.Minute.Seconds())
 		if len(expire) > 0 {
 			expireTime = expire[0]
@@ -630,7 +787,10 @@ func (r *Store) Set(ctx context.Context, key string, value any, expire ...int) e
 
 		setParams = append(setParams, "EX")
 		setParams = append(setParams, expireTime)
-		return redigo.String(conn.Do("SET", setParams...))
+		if _, err = redigo.String(conn.Do("SET", setParams...)); err != nil {
+			return nil, err
+		}
+		return nil, r.addTags(conn, setParams[0].(string), int64(expireTime)) //nolint:errcheck
 	})
 
 	if err != nil {
@@ -640,6 +800,40 @@ func (r *Store) Set(ctx context.Context, key string, value any, expire ...int) e
 	return nil
 }
 
+// SetNX sets the value only when the key does not exist yet (SET NX EX).
+// It returns false when the key already exists.
+func (r *Store) SetNX(ctx context.Context, key string, value any, expire int) (bool, error) {
+	wrapResult, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
+		_, span := monitoring.StartSpan(ctx, "Redis-SetNX")
+		defer span.End()
+		span.SetAttributes(attribute.String("key", key), attribute.Int("expire", expire))
+		conn, err := r.Pool.GetContext(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, "phastos.cache.redis.SetNX.GetContext")
+		}
+		defer conn.Close() //nolint:errcheck
+
+		cacheValue, err := r.codec.encode(value)
+		if err != nil {
+			return false, errors.Wrap(err, "phastos.cache.redis.SetNX.MarshalValue")
+		}
+
+		_, err = redigo.String(conn.Do("SET", fmt.Sprintf("%s%s", r.prefixKey, key), cacheValue, "EX", expire, "NX"))
+		if errors.Is(err, redigo.ErrNil) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "phastos.cache.redis.SetNX")
+		}
+		return true, r.addTags(conn, fmt.Sprintf("%s%s", r.prefixKey, key), int64(expire))
+	})
+	if err != nil {
+		return false, err
+	}
+	acquired, _ := wrapResult.(bool)
+	return acquired, nil
+}
+
 func (r *Store) WrapToHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		ctx := context.WithValue(request.Context(), entity.CacheContext{}, r)
@@ -680,6 +874,11 @@ func (r *Store) HGetAll(ctx context.Context, key string, dest interface{}) error
 	if !ok {
 		return errors.New("phastos.cache.redis.HGetAll: invalid result type")
 	}
+	for field, value := range resultMap {
+		if resultMap[field], err = decodeString(value); err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.HGetAll.DecodeField")
+		}
+	}
 	if m, ok := dest.(*map[string]string); ok {
 		*m = resultMap
 		return nil
@@ -702,12 +901,9 @@ func (r *Store) HSetBulk(ctx context.Context, key string, fields map[string]inte
 		defer func() { _ = conn.Close() }()
 		fullKey := fmt.Sprintf("%s%s", r.prefixKey, key)
 		for field, value := range fields {
-			var val string
-			if s, ok := value.(string); ok {
-				val = s
-			} else {
-				b, _ := json.Marshal(value)
-				val = string(b)
+			val, err := r.codec.encode(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "phastos.cache.redis.HSetBulk.MarshalValue")
 			}
 			if err := conn.Send("HSET", fullKey, field, val); err != nil {
 				return nil, err
@@ -730,8 +926,9 @@ func (r *Store) HSetBulk(ctx context.Context, key string, fields map[string]inte
 			if _, err := conn.Receive(); err != nil {
 				return nil, err
 			}
+			return nil, r.addTags(conn, fullKey, int64(expire[0]))
 		}
-		return nil, nil
+		return nil, r.addTags(conn, fullKey, 0)
 	})
 	return err
 }
@@ -370,6 +370,34 @@ func TestStoreSet_ConnectionError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestStoreSetNX_Acquired(t *testing.T) {
+	sc := newStubConn()
+	store := newTestStore(sc)
+
+	acquired, err := store.SetNX(context.Background(), "lock", map[string]string{"state": "busy"}, 30)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestStoreSetNX_AlreadyExists(t *testing.T) {
+	sc := newStubConn()
+	sc.responses["SET:phastos:lock"] = nil
+	store := newTestStore(sc)
+
+	acquired, err := store.SetNX(context.Background(), "lock", "busy", 30)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+}
+
+func TestStoreSetNX_ConnectionError(t *testing.T) {
+	sc := newStubConn()
+	store := &Store{Pool: &stubHandler{conn: sc, connErr: errors.New("connection refused")}, prefixKey: "phastos:", maxRetry: 2}
+
+	acquired, err := store.SetNX(context.Background(), "lock", "busy", 30)
+	assert.Error(t, err)
+	assert.False(t, acquired)
+}
+
 func TestStoreDel_Success(t *testing.T) {
 	sc := newStubConn()
 	sc.responses["DEL:phastos:key1"] = int64(2)
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// sentinel resolves the address of the current master from the sentinels
+type sentinel struct {
+	cfg *RedisCfg
+
+	mu        sync.Mutex
+	addresses []string // the last sentinel answering first
+}
+
+// newSentinelPool returns a pool of connections to the master monitored by the sentinels of cfg.
+// After a failover, the connections to the former master fail the role check on borrow and are
+// replaced by connections to the new master.
+func newSentinelPool(cfg *RedisCfg) *redigo.Pool {
+	s := &sentinel{cfg: cfg, addresses: append([]string(nil), cfg.SentinelAddresses...)}
+	pool := newPool(cfg, s.masterAddress, redigo.DialDatabase(cfg.DB))
+
+	dial := pool.Dial
+	pool.Dial = func() (redigo.Conn, error) {
+		c, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkMaster(c); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	pool.TestOnBorrow = func(c redigo.Conn, t time.Time) error {
+		return checkMaster(c)
+	}
+	return pool
+}
+
+// masterAddress asks the sentinels in turn for the address of the master
+func (s *sentinel) masterAddress() (string, error) {
+	s.mu.Lock()
+	addresses := append([]string(nil), s.addresses...)
+	s.mu.Unlock()
+
+	dialOpts, err := s.cfg.dialOptions(s.cfg.SentinelUsername, s.cfg.SentinelPassword)
+	if err != nil {
+		return "", err
+	}
+	lastErr := errors.New("no sentinel address")
+	for i, address := range addresses {
+		conn, err := dialRedis("tcp", address, dialOpts...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		master, err := redigo.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.cfg.MasterName))
+		_ = conn.Close()
+		if err != nil || len(master) != 2 {
+			if err == nil {
+				err = errors.Errorf("unknown master %q", s.cfg.MasterName)
+			}
+			lastErr = err
+			continue
+		}
+
+		if i > 0 {
+			s.mu.Lock()
+			s.addresses = append(append([]string{address}, addresses[:i]...), addresses[i+1:]...)
+			s.mu.Unlock()
+		}
+		return net.JoinHostPort(master[0], master[1]), nil
+	}
+	return "", errors.Wrap(lastErr, "phastos.cache.redis.Sentinel.MasterAddress")
+}
+
+// checkMaster fails when c is not connected to a master, e.g. to a master demoted by a failover
+func checkMaster(c redigo.Conn) error {
+	role, err := redigo.Values(c.Do("ROLE"))
+	if err != nil {
+		return errors.Wrap(err, "phastos.cache.redis.Sentinel.ROLE")
+	}
+	if len(role) == 0 {
+		return errors.New("phastos.cache.redis.Sentinel.ROLE: empty reply")
+	}
+	if kind, _ := redigo.String(role[0], nil); kind != "master" {
+		return errors.Errorf("phastos.cache.redis.Sentinel.ROLE: connected to a %s", kind)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kodekoding/phastos/v2/go/monitoring"
+)
+
+// deleteBatch is the number of keys of one UNLINK and the COUNT hint of SCAN
+const deleteBatch = 500
+
+// WithTags returns a Store adding the keys written by Set, SetNX, HSet, HSetBulk and the fallback of
+// Get and HGet to the Redis sets of tags, to be deleted together by InvalidateTags:
+//
+//	err := store.WithTags("employee:42", "client:7").Get(ctx, "employees:page:1", &page, fallbackFn)
+//	...
+//	deleted, err := store.InvalidateTags(ctx, "employee:42")
+//
+// A tag set expires with the longest-lived of its keys.
+func (r *Store) WithTags(tags ...string) *Store {
+	tagged := *r
+	tagged.tags = append(append([]string(nil), r.tags...), tags...)
+	return &tagged
+}
+
+// root returns the prefix of the Store the namespaces were created from
+func (r *Store) root() string {
+	if r.rootPrefix != "" {
+		return r.rootPrefix
+	}
+	return r.prefixKey
+}
+
+func (r *Store) tagKey(tag string) string {
+	return fmt.Sprintf("%stag:%s", r.root(), tag)
+}
+
+// addTags adds fullKey, stored for expire seconds (0: without expiry), to the sets of the tags of r
+func (r *Store) addTags(conn redigo.Conn, fullKey string, expire int64) error {
+	for _, tag := range r.tags {
+		tagKey := r.tagKey(tag)
+		existed, err := redigo.Bool(conn.Do("EXISTS", tagKey))
+		if err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.addTags.EXISTS")
+		}
+		if _, err = conn.Do("SADD", tagKey, fullKey); err != nil {
+			return errors.Wrap(err, "phastos.cache.redis.addTags.SADD")
+		}
+
+		if expire <= 0 {
+			if _, err = conn.Do("PERSIST", tagKey); err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.addTags.PERSIST")
+			}
+			continue
+		}
+		extend := !existed
+		if existed {
+			// -1: a key of the tag has no expiry, the set is kept
+			ttl, err := redigo.Int64(conn.Do("TTL", tagKey))
+			if err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.addTags.TTL")
+			}
+			extend = ttl >= 0 && ttl < expire
+		}
+		if extend {
+			if _, err = conn.Do("EXPIRE", tagKey, expire); err != nil {
+				return errors.Wrap(err, "phastos.cache.redis.addTags.EXPIRE")
+			}
+		}
+	}
+	return nil
+}
+
+// InvalidateTags deletes the keys written with any of tags (see WithTags) and the tag sets,
+// returning the number of keys deleted
+func (r *Store) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	wrapResult, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
+		_, span := monitoring.StartSpan(ctx, "Redis-InvalidateTags")
+		defer span.End()
+		span.SetAttributes(attribute.StringSlice("tags", tags))
+		conn, err := r.Pool.GetContext(ctx)
+		if err != nil {
+			return int64(0), errors.Wrap(err, "phastos.cache.redis.InvalidateTags.GetContext")
+		}
+		defer conn.Close() //nolint:errcheck
+
+		var deleted int64
+		for _, tag := range tags {
+			tagKey := r.tagKey(tag)
+			keys, err := redigo.Strings(conn.Do("SMEMBERS", tagKey))
+			if err != nil {
+				return deleted, errors.Wrap(err, "phastos.cache.redis.InvalidateTags.SMEMBERS")
+			}
+			count, err := unlink(conn, keys)
+			deleted += count
+			if err != nil {
+				return deleted, errors.Wrap(err, "phastos.cache.redis.InvalidateTags.UNLINK")
+			}
+			if _, err = conn.Do("DEL", tagKey); err != nil {
+				return deleted, errors.Wrap(err, "phastos.cache.redis.InvalidateTags.DEL")
+			}
+		}
+		return deleted, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return wrapResult.(int64), nil //nolint:errcheck
+}
+
+// DeleteByPattern deletes the keys matching the glob pattern (e.g. "employees:page:*") under the key
+// prefix, returning the number of keys deleted. It iterates with SCAN and deletes with UNLINK, so Redis
+// is never blocked, but the keys written meanwhile may be missed.
+func (r *Store) DeleteByPattern(ctx context.Context, pattern string) (int64, error) {
+	wrapResult, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
+		_, span := monitoring.StartSpan(ctx, "Redis-DeleteByPattern")
+		defer span.End()
+		span.SetAttributes(attribute.String("pattern", pattern))
+		conn, err := r.Pool.GetContext(ctx)
+		if err != nil {
+			return int64(0), errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.GetContext")
+		}
+		defer conn.Close() //nolint:errcheck
+
+		// SCAN iterates the keys of one node: every master of a cluster is scanned
+		cluster, isCluster := r.Pool.(*Cluster)
+		if !isCluster {
+			return scanUnlink(ctx, conn, conn, r.prefixKey+pattern)
+		}
+		masters, err := cluster.Masters(ctx)
+		if err != nil {
+			return int64(0), errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.Masters")
+		}
+		var deleted int64
+		for _, master := range masters {
+			nodeConn, err := cluster.NodeConn(ctx, master)
+			if err != nil {
+				return deleted, errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.NodeConn")
+			}
+			count, err := scanUnlink(ctx, nodeConn, conn, r.prefixKey+pattern)
+			_ = nodeConn.Close()
+			deleted += count
+			if err != nil {
+				return deleted, err
+			}
+		}
+		return deleted, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return wrapResult.(int64), nil //nolint:errcheck
+}
+
+// scanUnlink deletes the keys matching match returned by the SCAN of scanConn through conn
+func scanUnlink(ctx context.Context, scanConn, conn redigo.Conn, match string) (int64, error) {
+	var deleted int64
+	cursor := "0"
+	for {
+		reply, err := redigo.Values(scanConn.Do("SCAN", cursor, "MATCH", match, "COUNT", deleteBatch))
+		if err != nil {
+			return deleted, errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.SCAN")
+		}
+		var keys []string
+		if _, err = redigo.Scan(reply, &cursor, &keys); err != nil {
+			return deleted, errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.SCAN")
+		}
+		count, err := unlink(conn, keys)
+		deleted += count
+		if err != nil {
+			return deleted, errors.Wrap(err, "phastos.cache.redis.DeleteByPattern.UNLINK")
+		}
+		if cursor == "0" {
+			return deleted, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return deleted, err
+		}
+	}
+}
+
+// unlink deletes keys in batches without blocking Redis
+func unlink(conn redigo.Conn, keys []string) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(keys); start += deleteBatch {
+		end := min(start+deleteBatch, len(keys))
+		args := make([]any, 0, end-start)
+		for _, key := range keys[start:end] {
+			args = append(args, key)
+		}
+		count, err := redigo.Int64(conn.Do("UNLINK", args...))
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+	}
+	return deleted, nil
+}
+
+// Namespace returns the Store of the keys of namespace, which InvalidateNamespace deletes at once:
+// its keys are prefixed with the current version of the namespace, so bumping the version makes them
+// unreachable (they expire with their TTL). The tags of its keys are shared with r.
+//
+//	employees, err := store.Namespace(ctx, "employees")
+//	err = employees.Get(ctx, "page:1", &page, fallbackFn)
+//	...
+//	err = store.InvalidateNamespace(ctx, "employees")
+func (r *Store) Namespace(ctx context.Context, namespace string) (*Store, error) {
+	conn, err := r.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.cache.redis.Namespace.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	version, err := redigo.Int64(conn.Do("GET", r.namespaceKey(namespace)))
+	if err != nil && !errors.Is(err, redigo.ErrNil) {
+		return nil, errors.Wrap(err, "phastos.cache.redis.Namespace.GET")
+	}
+
+	namespaced := *r
+	namespaced.rootPrefix = r.root()
+	namespaced.prefixKey = fmt.Sprintf("%s%s:v%s:", r.prefixKey, namespace, strconv.FormatInt(version, 10))
+	return &namespaced, nil
+}
+
+// InvalidateNamespace makes the keys of namespace unreachable in O(1), the Stores returned by
+// Namespace afterwards using a new version. The Stores returned before keep the previous one.
+func (r *Store) InvalidateNamespace(ctx context.Context, namespace string) error {
+	_, err := r.wrapWithRetries(ctx, func(ctx context.Context) (result any, err error) {
+		conn, err := r.Pool.GetContext(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.cache.redis.InvalidateNamespace.GetContext")
+		}
+		defer conn.Close() //nolint:errcheck
+		_, err = conn.Do("INCR", r.namespaceKey(namespace))
+		return nil, errors.Wrap(err, "phastos.cache.redis.InvalidateNamespace.INCR")
+	})
+	return err
+}
+
+func (r *Store) namespaceKey(namespace string) string {
+	return fmt.Sprintf("%sns:%s", r.prefixKey, namespace)
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/singleflight"
+)
+
+func newFakeStore(redis *fakeRedis) *Store {
+	return &Store{Pool: redis, prefixKey: "phastos:", maxRetry: 1, sf: &singleflight.Group{}}
+}
+
+func TestStore_InvalidateTags(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	require.NoError(t, store.WithTags("employee:42", "client:7").Set(ctx, "employee:42:profile", "budi", 60))
+	require.NoError(t, store.WithTags("client:7").Set(ctx, "client:7:employees:page:1", "[]", 300))
+	var page string
+	require.NoError(t, store.WithTags("employee:42").Get(ctx, "employees:page:1", &page, func(ctx context.Context) (any, int64, error) {
+		return "[42]", 0, nil
+	}))
+	require.NoError(t, store.Set(ctx, "untagged", "v"))
+
+	assert.Len(t, redis.sets["phastos:tag:employee:42"], 2)
+	assert.EqualValues(t, 600, redis.ttls["phastos:tag:employee:42"], "the tag set lives as long as its longest key")
+	assert.EqualValues(t, 300, redis.ttls["phastos:tag:client:7"])
+
+	deleted, err := store.InvalidateTags(ctx, "employee:42")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, deleted)
+	assert.NotContains(t, redis.values, "phastos:employee:42:profile")
+	assert.NotContains(t, redis.values, "phastos:employees:page:1")
+	assert.NotContains(t, redis.sets, "phastos:tag:employee:42")
+	assert.Contains(t, redis.values, "phastos:client:7:employees:page:1")
+	assert.Contains(t, redis.values, "phastos:untagged")
+
+	require.NoError(t, store.WithTags("client:7").HSet(ctx, "client:7:settings", "theme", "dark"))
+	_, persistent := redis.ttls["phastos:tag:client:7"]
+	assert.False(t, persistent, "a key without expiry keeps its tag set")
+}
+
+func TestStore_DeleteByPattern(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	for _, key := range []string{"employees:page:1", "employees:page:2", "employees:count"} {
+		require.NoError(t, store.Set(ctx, key, "v"))
+	}
+	redis.values["other:employees:page:1"] = "not under the prefix"
+
+	deleted, err := store.DeleteByPattern(ctx, "employees:page:*")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, deleted)
+	assert.Contains(t, redis.values, "phastos:employees:count")
+	assert.Contains(t, redis.values, "other:employees:page:1")
+}
+
+func TestStore_Namespace(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	store := newFakeStore(redis)
+
+	employees, err := store.Namespace(ctx, "employees")
+	require.NoError(t, err)
+	require.NoError(t, employees.WithTags("employee:42").Set(ctx, "page:1", "v0"))
+	assert.Contains(t, redis.values, "phastos:employees:v0:page:1")
+	assert.Contains(t, redis.sets, "phastos:tag:employee:42", "the tags are shared with the parent store")
+
+	require.NoError(t, store.InvalidateNamespace(ctx, "employees"))
+	employees, err = store.Namespace(ctx, "employees")
+	require.NoError(t, err)
+	var page string
+	assert.ErrorIs(t, employees.Get(ctx, "page:1", &page), ErrNotFound)
+	require.NoError(t, employees.Set(ctx, "page:1", "v1"))
+	assert.Contains(t, redis.values, "phastos:employees:v1:page:1")
+}
@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/kodekoding/phastos/v2/go/entity"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const (
+	defaultL1TTL = time.Minute
+
+	invalidateChannel = "cache:invalidate"
+	invalidateAll     = "*"
+
+	generationStripes = 256
+)
+
+type (
+	// TwoTier is a Caches keeping the values read from the Redis store (L2) in a Memory (L1) for at
+	// most the L1 TTL. Its writes go to both tiers and are published on a Redis channel, which the
+	// TwoTier of the other instances subscribe to in order to drop the key from their L1.
+	// The hashes and streams are read from and written to L2 only.
+	//
+	// While the subscription is down, the L1 is flushed and bypassed, so the reads never outlive a
+	// missed invalidation.
+	TwoTier struct {
+		l1      *Memory
+		l2      *Store
+		ttl     time.Duration
+		channel string
+		id      string
+		sf      singleflight.Group
+
+		// generations counts the invalidations per stripe of keys, with flushes counted in
+		// flushGeneration; Get keeps a value read from L2 only when none arrived during the read
+		generations     [generationStripes]atomic.Uint64
+		flushGeneration atomic.Uint64
+
+		subscribed atomic.Bool
+		mu         sync.Mutex
+		conn       redigo.Conn // the subscription, closed by Close
+		closed     bool
+		done       chan struct{}
+	}
+
+	// TwoTierOptions configures NewTwoTier
+	TwoTierOptions func(*TwoTier)
+)
+
+// NewTwoTier creates a TwoTier of l1 in front of l2 and subscribes to the invalidations until Close
+func NewTwoTier(l1 *Memory, l2 *Store, opts ...TwoTierOptions) *TwoTier {
+	t := &TwoTier{
+		l1:      l1,
+		l2:      l2,
+		ttl:     defaultL1TTL,
+		channel: l2.prefixKey + invalidateChannel,
+		id:      instanceID(),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	go t.subscribe()
+	return t
+}
+
+// WithL1TTLCap sets how long a key is kept in L1 at most (default 1 minute); the L1 never keeps
+// a key longer than the expire of its Set or its remaining TTL in L2 either
+func WithL1TTLCap(ttl time.Duration) TwoTierOptions {
+	return func(t *TwoTier) {
+		if ttl > 0 {
+			t.ttl = ttl
+		}
+	}
+}
+
+func instanceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// L2 returns the Redis store behind the TwoTier
+func (t *TwoTier) L2() *Store {
+	return t.l2
+}
+
+// l1TTL returns the L1 expiry of a value stored in L2 for expire
+func (t *TwoTier) l1TTL(expire time.Duration) time.Duration {
+	if expire > 0 && expire < t.ttl {
+		return expire
+	}
+	return t.ttl
+}
+
+// stripe returns the invalidation counter of key
+func (t *TwoTier) stripe(key string) *atomic.Uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &t.generations[h.Sum32()%generationStripes]
+}
+
+// generation returns the invalidation count of key
+func (t *TwoTier) generation(key string) uint64 {
+	return t.stripe(key).Load() + t.flushGeneration.Load()
+}
+
+// keep stores the value of key read from L2 in L1 for the remaining TTL of the key in L2, capped
+// by the L1 TTL, unless key was invalidated since generation
+func (t *TwoTier) keep(ctx context.Context, key, value string, generation uint64) {
+	conn, err := t.l2.Pool.GetContext(ctx)
+	if err != nil {
+		return
+	}
+	pttl, err := redigo.Int64(conn.Do("PTTL", t.l2.prefixKey+key))
+	_ = conn.Close()
+	if err != nil || pttl == -2 {
+		return
+	}
+	ttl := t.ttl
+	if pttl > 0 {
+		ttl = t.l1TTL(time.Duration(pttl) * time.Millisecond)
+	}
+	if t.generation(key) != generation {
+		return
+	}
+	t.l1.setString(key, value, ttl)
+	if t.generation(key) != generation {
+		// an invalidation dropped key between the check and the write
+		t.l1.delete(key)
+	}
+}
+
+// Get implements Caches: L1 first, then L2 and the fallback with the semantics of Store.Get
+func (t *TwoTier) Get(ctx context.Context, key string, typeDestination any, fallbackFn ...FallbackFn) error {
+	if err := checkPointer(typeDestination, "phastos.cache.twotier.Get"); err != nil {
+		return err
+	}
+	useL1 := t.subscribed.Load()
+	if useL1 {
+		if value, found := t.l1.getString(key); found {
+			observeLookup("get_l1", nil)
+			return errors.Wrap(decodeValue(value, typeDestination), "phastos.cache.twotier.Get.UnmarshalValueToTypeDestination")
+		}
+		observeLookup("get_l1", ErrNotFound)
+	}
+
+	result, err, _ := t.sf.Do(key, func() (any, error) {
+		generation := t.generation(key)
+		value, err := t.l2.get(ctx, key, fallbackFn...)
+		if err != nil {
+			return "", err
+		}
+		if useL1 {
+			t.keep(ctx, key, value, generation)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = decodeValue(result.(string), typeDestination); err != nil { //nolint:errcheck
+		return errors.Wrap(err, "phastos.cache.twotier.Get.UnmarshalValueToTypeDestination")
+	}
+	return nil
+}
+
+// Set implements Caches
+func (t *TwoTier) Set(ctx context.Context, key string, value any, expire ...int) error {
+	if err := t.l2.Set(ctx, key, value, expire...); err != nil {
+		return err
+	}
+	t.invalidate(ctx, key)
+	if encoded, err := t.l2.codec.encode(value); err == nil && t.subscribed.Load() {
+		t.l1.setString(key, encoded, t.l1TTL(expireOf(expire)))
+	}
+	return nil
+}
+
+// SetNX sets the value only when the key does not exist yet in L2, returning false when it exists
+func (t *TwoTier) SetNX(ctx context.Context, key string, value any, expire int) (bool, error) {
+	acquired, err := t.l2.SetNX(ctx, key, value, expire)
+	if err != nil || !acquired {
+		return acquired, err
+	}
+	t.invalidate(ctx, key)
+	return true, nil
+}
+
+// Del implements Caches
+func (t *TwoTier) Del(ctx context.Context, key string) (int64, error) {
+	deleted, err := t.l2.Del(ctx, key)
+	if err != nil {
+		return deleted, err
+	}
+	t.invalidate(ctx, key)
+	return deleted, nil
+}
+
+// InvalidateTags deletes the keys of tags from L2 (see Store.WithTags) and flushes the L1 of all
+// the instances, which do not know the tags of their keys
+func (t *TwoTier) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	deleted, err := t.l2.InvalidateTags(ctx, tags...)
+	t.Flush(ctx)
+	return deleted, err
+}
+
+// DeleteByPattern deletes the keys matching pattern from L2 (see Store.DeleteByPattern) and flushes
+// the L1 of all the instances
+func (t *TwoTier) DeleteByPattern(ctx context.Context, pattern string) (int64, error) {
+	deleted, err := t.l2.DeleteByPattern(ctx, pattern)
+	t.Flush(ctx)
+	return deleted, err
+}
+
+// Flush drops every key of the L1 of all the instances, the keys of L2 are kept
+func (t *TwoTier) Flush(ctx context.Context) {
+	t.invalidate(ctx, invalidateAll)
+}
+
+// invalidate drops key from the local L1 and publishes it to the other instances
+func (t *TwoTier) invalidate(ctx context.Context, key string) {
+	t.drop(key)
+
+	conn, err := t.l2.Pool.GetContext(ctx)
+	if err == nil {
+		defer conn.Close() //nolint:errcheck
+		_, err = conn.Do("PUBLISH", t.channel, t.id+" "+key)
+	}
+	if err != nil {
+		// the other instances keep the key until the L1 TTL at most
+		log := plog.Ctx(ctx)
+		log.Warn().Err(err).Str("key", key).Msg("[PHASTOS][CACHE] failed to publish the invalidation")
+	}
+}
+
+func (t *TwoTier) drop(key string) {
+	if key == invalidateAll {
+		t.flushL1()
+		return
+	}
+	t.stripe(key).Add(1)
+	t.l1.delete(key)
+}
+
+// flushL1 drops every key of the local L1
+func (t *TwoTier) flushL1() {
+	t.flushGeneration.Add(1)
+	t.l1.Flush()
+}
+
+// subscribe receives the invalidations of the other instances, reconnecting with a backoff until Close
+func (t *TwoTier) subscribe() {
+	defer close(t.done)
+	log := plog.Get()
+	backoff := 100 * time.Millisecond
+	for {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+		conn := t.l2.Pool.Get()
+		t.conn = conn
+		t.mu.Unlock()
+
+		psc := redigo.PubSubConn{Conn: conn}
+		err := psc.Subscribe(t.channel)
+		_, withTimeout := conn.(redigo.ConnWithTimeout)
+		for err == nil {
+			var reply interface{}
+			if withTimeout {
+				// no read timeout, the channel may be quiet for long
+				reply = psc.ReceiveWithTimeout(0)
+			} else {
+				reply = psc.Receive()
+			}
+			switch msg := reply.(type) {
+			case redigo.Subscription:
+				if msg.Kind == "subscribe" {
+					// the invalidations missed while unsubscribed are unknown
+					t.flushL1()
+					t.subscribed.Store(true)
+					backoff = 100 * time.Millisecond
+				}
+			case redigo.Message:
+				if id, key, ok := strings.Cut(string(msg.Data), " "); ok && id != t.id {
+					t.drop(key)
+				}
+			case error:
+				err = msg
+			}
+		}
+		t.subscribed.Store(false)
+		t.flushL1()
+		_ = conn.Close()
+
+		t.mu.Lock()
+		closed := t.closed
+		t.mu.Unlock()
+		if closed {
+			return
+		}
+		log.Warn().Err(err).Str("channel", t.channel).Msg("[PHASTOS][CACHE] invalidation subscription lost, L1 bypassed until it is back")
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// Close stops the subscription to the invalidations, the L1 is bypassed afterwards
+func (t *TwoTier) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	if t.conn != nil {
+		_ = t.conn.Close()
+	}
+	t.mu.Unlock()
+	<-t.done
+	return nil
+}
+
+// HSet implements Caches on L2
+func (t *TwoTier) HSet(ctx context.Context, key, field string, value any, expire ...int) error {
+	return t.l2.HSet(ctx, key, field, value, expire...)
+}
+
+// HGet implements Caches on L2
+func (t *TwoTier) HGet(ctx context.Context, key, field string, typeDestination any, fallbackFn ...FallbackFn) error {
+	return t.l2.HGet(ctx, key, field, typeDestination, fallbackFn...)
+}
+
+// HDel implements Caches on L2
+func (t *TwoTier) HDel(ctx context.Context, key, field string) error {
+	return t.l2.HDel(ctx, key, field)
+}
+
+// HGetAll implements Caches on L2
+func (t *TwoTier) HGetAll(ctx context.Context, key string, dest interface{}) error {
+	return t.l2.HGetAll(ctx, key, dest)
+}
+
+// HSetBulk implements Caches on L2
+func (t *TwoTier) HSetBulk(ctx context.Context, key string, fields map[string]interface{}, expire ...int) error {
+	return t.l2.HSetBulk(ctx, key, fields, expire...)
+}
+
+// XGroupCreateMkStream implements Caches on L2
+func (t *TwoTier) XGroupCreateMkStream(ctx context.Context, streamKey, group, startID string) error {
+	return t.l2.XGroupCreateMkStream(ctx, streamKey, group, startID)
+}
+
+// XReadGroup implements Caches on L2
+func (t *TwoTier) XReadGroup(ctx context.Context, group, consumer string, streams []string, ids []string, block time.Duration, count int64) ([]StreamMessages, error) {
+	return t.l2.XReadGroup(ctx, group, consumer, streams, ids, block, count)
+}
+
+// XAck implements Caches on L2
+func (t *TwoTier) XAck(ctx context.Context, streamKey, group, id string) (int64, error) {
+	return t.l2.XAck(ctx, streamKey, group, id)
+}
+
+func (t *TwoTier) WrapToHandler(next http.Handler) http.Handler {
+	return wrapCache(t, next)
+}
+
+func (t *TwoTier) WrapToContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, entity.CacheContext{}, t)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/singleflight"
+)
+
+func newTestTwoTier(t *testing.T, redis *fakeRedis) *TwoTier {
+	store := &Store{Pool: redis, prefixKey: "phastos:", maxRetry: 1, sf: &singleflight.Group{}}
+	tt := NewTwoTier(NewMemory(), store, WithL1TTLCap(time.Minute))
+	t.Cleanup(func() { _ = tt.Close() })
+	require.Eventually(t, tt.subscribed.Load, time.Second, time.Millisecond)
+	return tt
+}
+
+func TestTwoTier_ReadsThroughL1(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	tt := newTestTwoTier(t, redis)
+
+	var str string
+	assert.ErrorIs(t, tt.Get(ctx, "missing", &str), ErrNotFound)
+
+	redis.values["phastos:user"] = `{"Name":"budi"}`
+	var got struct{ Name string }
+	require.NoError(t, tt.Get(ctx, "user", &got))
+	require.NoError(t, tt.Get(ctx, "user", &got))
+	assert.Equal(t, "budi", got.Name)
+	assert.Equal(t, 2, redis.gets, "the second read is served by L1")
+
+	require.NoError(t, tt.Get(ctx, "report", &str, func(ctx context.Context) (any, int64, error) {
+		return "fresh", 0, nil
+	}))
+	assert.Equal(t, "fresh", str)
+	assert.Equal(t, "fresh", redis.values["phastos:report"], "the fallback result is stored in L2")
+	require.NoError(t, tt.Get(ctx, "report", &str))
+	assert.Equal(t, 3, redis.gets)
+}
+
+func TestTwoTier_KeepsL2ValuesForTheirRemainingTTL(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	tt := newTestTwoTier(t, redis)
+	now := time.Now()
+	tt.l1.now = func() time.Time { return now }
+
+	redis.values["phastos:session"] = "s1"
+	redis.ttls["phastos:session"] = 2
+	var str string
+	require.NoError(t, tt.Get(ctx, "session", &str))
+	require.NoError(t, tt.Get(ctx, "session", &str))
+	assert.Equal(t, 1, redis.gets)
+
+	now = now.Add(3 * time.Second)
+	require.NoError(t, tt.Get(ctx, "session", &str))
+	assert.Equal(t, 2, redis.gets, "L1 does not outlive the TTL of the key in L2")
+}
+
+func TestTwoTier_SkipsL1WhenInvalidatedDuringTheRead(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	tt := newTestTwoTier(t, redis)
+
+	var str string
+	require.NoError(t, tt.Get(ctx, "report", &str, func(ctx context.Context) (any, int64, error) {
+		tt.drop("report") // an invalidation received while L2 is read
+		return "stale", 0, nil
+	}))
+	assert.Equal(t, "stale", str)
+	_, found := tt.l1.getString("report")
+	assert.False(t, found, "a value invalidated during the read is not kept in L1")
+}
+
+func TestTwoTier_InvalidatesOtherInstances(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	podA := newTestTwoTier(t, redis)
+	podB := newTestTwoTier(t, redis)
+
+	require.NoError(t, podA.Set(ctx, "price", "100"))
+	var price string
+	require.NoError(t, podB.Get(ctx, "price", &price))
+	assert.Equal(t, "100", price)
+
+	require.NoError(t, podA.Set(ctx, "price", "120"))
+	require.Eventually(t, func() bool {
+		_ = podB.Get(ctx, "price", &price)
+		return price == "120"
+	}, time.Second, time.Millisecond, "pod B drops its L1 copy")
+
+	_, err := podA.Del(ctx, "price")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return errors.Is(podB.Get(ctx, "price", &price), ErrNotFound)
+	}, time.Second, time.Millisecond)
+}
+
+func TestTwoTier_BypassesL1WhileUnsubscribed(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis()
+	tt := newTestTwoTier(t, redis)
+
+	redis.values["phastos:k"] = "v1"
+	var str string
+	require.NoError(t, tt.Get(ctx, "k", &str))
+
+	redis.dropSubscribers()
+	require.Eventually(t, func() bool { return !tt.subscribed.Load() }, time.Second, time.Millisecond)
+	redis.mu.Lock()
+	redis.values["phastos:k"] = "v2"
+	redis.mu.Unlock()
+	require.NoError(t, tt.Get(ctx, "k", &str))
+	assert.Equal(t, "v2", str, "a write missed while unsubscribed is not hidden by L1")
+
+	require.Eventually(t, tt.subscribed.Load, 2*time.Second, time.Millisecond, "the subscription is restored")
+	assert.Equal(t, 0, tt.l1.Len(), "L1 is flushed on resubscribe")
+}
+
+func TestNew_UnreachableRedis(t *testing.T) {
+	store := New(WithAddress("127.0.0.1:1"), WithTimeout(1), WithMaxRetry(1))
+	require.NotNil(t, store, "New boots degraded instead of exiting")
+
+	var str string
+	assert.Error(t, store.Get(context.Background(), "key", &str))
+}
+
+func TestOpen(t *testing.T) {
+	c, err := Open(WithDriver(DriverMemory), WithL1MaxEntries(10))
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, c)
+
+	_, err = Open(WithDriver("memcached"))
+	assert.ErrorContains(t, err, `unknown cache driver "memcached"`)
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/entity"
+)
+
+// ErrNotFound is returned by Get and HGet on a miss without fallback, by every driver.
+// It is redigo.ErrNil, so errors.Is(err, redigo.ErrNil) keeps working.
+var ErrNotFound = redigo.ErrNil
+
+// defaultExpire is the expiry of Set and of the fallback results without expire
+const defaultExpire = 10 * time.Minute
+
+// encodeValue stores strings as is and the other values as JSON, as the Redis store does without
+// codec
+func encodeValue(value any) (string, error) {
+	return valueCodec{}.encode(value)
+}
+
+func checkPointer(typeDestination any, funcName string) error {
+	if reflect.ValueOf(typeDestination).Kind() != reflect.Ptr {
+		return errors.Wrap(errors.New("type destination params should be a pointer"), funcName+".CheckTypeDestinationParam")
+	}
+	return nil
+}
+
+// expireOf returns the expiry of the optional expire argument of Set (seconds), defaultExpire without it
+func expireOf(expire []int) time.Duration {
+	if len(expire) > 0 {
+		return time.Duration(expire[0]) * time.Second
+	}
+	return defaultExpire
+}
+
+// wrapCache puts c in the request context (GetCacheFromContext)
+func wrapCache(c Caches, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), entity.CacheContext{}, c)
+		*request = *request.WithContext(ctx)
+
+		next.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,124 @@
+// Package config loads the typed configuration of a service from layered YAML files
+// (config.yaml, then config.{APPS_ENV}.yaml), .env files and environment variables, decrypts
+// its encrypted secrets and validates it with the `validate` tags.
+//
+// Config holds the settings of the framework subsystems and is wired by api.WithConfig;
+// embed it in the configuration struct of the service to add its own settings.
+package config
+
+import (
+	"github.com/kodekoding/phastos/v2/go/cache"
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/notifications"
+	"github.com/kodekoding/phastos/v2/go/server"
+)
+
+type (
+	// Config is the configuration of the framework subsystems.
+	//
+	//	type ServiceConfig struct {
+	//		config.Config `yaml:",inline"`
+	//		PaymentURL    string `yaml:"payment_url" validate:"required,url"`
+	//	}
+	Config struct {
+		App           App                  `yaml:"app"`
+		Server        server.Config        `yaml:"server"`
+		Database      database.SQLs        `yaml:"database"`
+		Redis         cache.RedisCfg       `yaml:"redis"`
+		Notifications notifications.Config `yaml:"notifications"`
+		Cron          Cron                 `yaml:"cron"`
+		CORS          CORS                 `yaml:"cors"`
+		Log           Log                  `yaml:"log"`
+		RateLimit     RateLimit            `yaml:"rate_limit"`
+	}
+
+	// App holds the settings of api.App that are not in server.Config
+	App struct {
+		Name       string `yaml:"name"`
+		Version    string `yaml:"version"`
+		Timezone   string `yaml:"timezone"`
+		APITimeout *int   `yaml:"api_timeout" validate:"omitempty,gte=0"` // seconds, 0 runs the handlers synchronously
+		Pprof      *bool  `yaml:"pprof" env:"PPROF_ENABLED"`
+	}
+
+	// Cron enables the cron scheduler of api.App
+	Cron struct {
+		Enabled        bool   `yaml:"enabled"`
+		Timezone       string `yaml:"timezone"`
+		TimeoutProcess int    `yaml:"timeout_process" env:"CRON_JOB_TIMEOUT_PROCESS" validate:"gte=0"` // minutes
+	}
+
+	// CORS holds the allowed origins and the additional allowed headers (comma-separated in env)
+	CORS struct {
+		Origins []string `yaml:"origins" env:"CORS_ORIGIN"`
+		Headers []string `yaml:"headers" env:"CORS_HEADER"`
+	}
+
+	// Log holds the level of the logger, reloaded at runtime
+	Log struct {
+		Level string `yaml:"level" reload:"true" validate:"omitempty,oneof=trace debug info warn error fatal panic disabled"`
+	}
+
+	// RateLimit holds the default rate of middlewares.NewRateLimiter (middlewares.WithRateConfig), reloaded at runtime
+	RateLimit struct {
+		RPS   float64 `yaml:"rps" reload:"true" validate:"gte=0"`
+		Burst int     `yaml:"burst" reload:"true" validate:"gte=0"`
+	}
+
+	// Provider is implemented by Config, by the structs embedding it and by Watcher
+	Provider interface {
+		Framework() *Config
+	}
+
+	// Reloadable is a Provider whose reloadable settings change at runtime, implemented by Watcher
+	Reloadable interface {
+		Provider
+		OnReload(fn func(cfg *Config))
+	}
+)
+
+// legacyEnv maps the settings of Config to the env variables read by the subsystems without config,
+// used when the variable derived from the path is not set
+var legacyEnv = map[string]string{
+	"app.name":                          "APP_NAME",
+	"app.version":                       "APP_VERSION",
+	"database.master.engine":            "DATABASE_ENGINE",
+	"database.master.conn_string":       "DATABASE_CONN_STRING_MASTER",
+	"database.master.max_open_conn":     "DATABASE_MAX_OPEN_CONN",
+	"database.master.max_idle_conn":     "DATABASE_MAX_IDLE_CONN",
+	"database.master.max_conn_lifetime": "DATABASE_CONN_MAX_LIFETIME",
+	"database.master.max_idle_time":     "DATABASE_CONN_MAX_IDLE_TIME",
+	"database.follower.conn_string":     "DATABASE_CONN_STRING_FOLLOWER",
+	"database.slow_query_threshold":     "DATABASE_SLOW_QUERY_THRESHOLD",
+	"redis.address":                     "REDIS_CONN_STRING",
+	"redis.timeout":                     "REDIS_TIMEOUT",
+	"redis.max_active":                  "REDIS_MAX_ACTIVE",
+	"redis.max_iddle":                   "REDIS_MAX_IDLE",
+	"redis.max_retry":                   "REDIS_MAX_RETRY",
+	"redis.db":                          "REDIS_DB",
+	"redis.password":                    "REDIS_PASSWORD",
+	"redis.username":                    "REDIS_USERNAME",
+	"redis.master_name":                 "REDIS_SENTINEL_MASTER",
+	"redis.sentinel_addresses":          "REDIS_SENTINEL_ADDRESSES",
+	"redis.sentinel_username":           "REDIS_SENTINEL_USERNAME",
+	"redis.sentinel_password":           "REDIS_SENTINEL_PASSWORD",
+	"redis.cluster_addresses":           "REDIS_CLUSTER_ADDRESSES",
+	"redis.tls":                         "REDIS_TLS",
+	"redis.tls_skip_verify":             "REDIS_TLS_SKIP_VERIFY",
+	"redis.tls_ca_file":                 "REDIS_TLS_CA_FILE",
+	"redis.stream_max_len":              "REDIS_STREAM_MAX_LEN",
+	"redis.stream_retention":            "REDIS_STREAM_RETENTION",
+	"redis.codec":                       "REDIS_CODEC",
+	"redis.compression":                 "REDIS_COMPRESSION",
+	"redis.compression_threshold":       "REDIS_COMPRESSION_THRESHOLD",
+	"redis.driver":                      "CACHE_DRIVER",
+	"redis.l1_max_entries":              "CACHE_L1_MAX_ENTRIES",
+	"redis.l1_ttl":                      "CACHE_L1_TTL",
+	"notifications.slack.webhook_url":   "NOTIFICATIONS_SLACK_WEBHOOK_URL",
+	"notifications.telegram.bot_token":  "NOTIFICATIONS_TELEGRAM_TOKEN",
+}
+
+// Framework returns c, so that the structs embedding Config are Providers
+func (c *Config) Framework() *Config {
+	return c
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEncryptionKey = "config-test-key"
+
+type serviceConfig struct {
+	Config     `yaml:",inline"`
+	PaymentURL string `yaml:"payment_url" validate:"required,url"`
+	Workers    int    `yaml:"workers" env:"ORDER_WORKERS"`
+}
+
+func TestLoad_Layers(t *testing.T) {
+	cfg, err := Load[serviceConfig](WithDir("testdata"), WithEnvironment("staging"), WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+
+	assert.Equal(t, "order-service", cfg.App.Name)
+	assert.Equal(t, 9000, cfg.Server.Port, "the environment file overrides the base file")
+	assert.Equal(t, 5, cfg.Server.ReadTimeout)
+	assert.Equal(t, 2*time.Second, cfg.Server.ShutdownDelay)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, 3, *cfg.App.APITimeout)
+	assert.Equal(t, []string{"https://app.example.com"}, cfg.CORS.Origins)
+	assert.Equal(t, "https://payment.example.com", cfg.PaymentURL)
+	assert.Equal(t, "s3cret", cfg.Database.Master.Password, "ENC() values are decrypted")
+	assert.Nil(t, cfg.Notifications.Slack)
+
+	assert.Same(t, &cfg.Config, cfg.Framework())
+}
+
+func TestLoad_Env(t *testing.T) {
+	t.Setenv("SERVER_PORT", "7000")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("ORDER_WORKERS", "4")
+	t.Setenv("CORS_ORIGIN", "https://a.example.com, https://b.example.com")
+	t.Setenv("REDIS_CONN_STRING", "cache:6379")
+	t.Setenv("NOTIFICATIONS_SLACK_WEBHOOK_URL", "https://hooks.slack.com/x")
+	t.Setenv("RATE_LIMIT_RPS", "2.5")
+
+	cfg, err := Load[serviceConfig](WithDir("testdata"), WithEnvironment("staging"), WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+
+	assert.Equal(t, 7000, cfg.Server.Port, "derived name")
+	assert.Equal(t, "warn", cfg.Log.Level)
+	assert.Equal(t, 4, cfg.Workers, "env tag")
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.Origins)
+	assert.Equal(t, "cache:6379", cfg.Redis.Address, "legacy name")
+	require.NotNil(t, cfg.Notifications.Slack, "pointers are allocated when one of their settings is set")
+	assert.Equal(t, "https://hooks.slack.com/x", cfg.Notifications.Slack.URL)
+	assert.Nil(t, cfg.Notifications.Telegram)
+	assert.Equal(t, 2.5, cfg.RateLimit.RPS)
+
+	t.Run("prefix", func(t *testing.T) {
+		t.Setenv("ORDER_SERVER_PORT", "7100")
+		cfg, err := Load[serviceConfig](WithDir("testdata"), WithEnvPrefix("order"), WithEncryptionKey(testEncryptionKey))
+		require.NoError(t, err)
+		assert.Equal(t, 7100, cfg.Server.Port)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("SERVER_PORT", "port")
+		_, err := Load[serviceConfig](WithDir("testdata"), WithEncryptionKey(testEncryptionKey))
+		assert.ErrorContains(t, err, "invalid SERVER_PORT")
+	})
+}
+
+func TestLoad_Errors(t *testing.T) {
+	t.Run("missing encryption key", func(t *testing.T) {
+		_, err := Load[serviceConfig](WithDir("testdata"), WithEncryptionKey(""))
+		assert.ErrorContains(t, err, "database.master.password is encrypted")
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "verbose")
+		_, err := Load[serviceConfig](WithDir("testdata"), WithEncryptionKey(testEncryptionKey))
+		assert.ErrorContains(t, err, "'Level' failed on the 'oneof' tag")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := Load[Config](WithDir("testdata"), WithEncryptionKey(testEncryptionKey), WithStrict())
+		assert.ErrorContains(t, err, "payment_url")
+	})
+
+	t.Run("no files", func(t *testing.T) {
+		cfg, err := Load[Config](WithDir(t.TempDir()))
+		require.NoError(t, err)
+		assert.Equal(t, 0, cfg.Server.Port)
+	})
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("server:\n  port: 8000\nlog:\n  level: info\nrate_limit:\n  rps: 10\n")
+
+	w, err := Watch[Config](WithDir(dir), WithEnvironment(""), WithReloadInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	reloaded := make(chan *Config, 1)
+	w.OnReload(func(cfg *Config) { reloaded <- cfg })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// the file system may not record a sub-second change of the modification time
+	write("server:\n  port: 9000\nlog:\n  level: debug\nrate_limit:\n  rps: 20\n")
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+
+	select {
+	case cfg := <-reloaded:
+		assert.Equal(t, "debug", cfg.Log.Level)
+		assert.Equal(t, 20.0, cfg.RateLimit.RPS)
+		assert.Equal(t, 8000, cfg.Server.Port, "the settings not tagged reload need a restart")
+	case <-time.After(2 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+	assert.Equal(t, "debug", w.Current().Log.Level)
+
+	t.Run("invalid keeps the current configuration", func(t *testing.T) {
+		write("log:\n  level: verbose\n")
+		changed, err := w.Reload()
+		assert.Error(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "debug", w.Current().Log.Level)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		write("server:\n  port: 9000\nlog:\n  level: debug\nrate_limit:\n  rps: 20\n")
+		changed, err := w.Reload()
+		require.NoError(t, err)
+		assert.False(t, changed)
+	})
+}
@@ -0,0 +1,422 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kodekoding/phastos/v2/go/env"
+	"github.com/kodekoding/phastos/v2/go/helper"
+)
+
+// EncryptionKeyEnv is the env variable holding the key of the ENC(...) values, see WithEncryptionKey
+const EncryptionKeyEnv = "CONFIG_ENCRYPTION_KEY"
+
+type (
+	// Options configures Load and Watch
+	Options func(*options)
+
+	options struct {
+		dir            string
+		name           string
+		environment    string
+		envPrefix      string
+		encryptionKey  string
+		dotEnv         []string
+		strict         bool
+		reloadInterval time.Duration
+	}
+)
+
+// WithDir sets the directory of the config files, default the working directory
+func WithDir(dir string) Options {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+// WithName sets the base name of the config files, default "config" (config.yaml, config.{env}.yaml)
+func WithName(name string) Options {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithEnvironment sets the environment whose file is layered on the base file, default env.ServiceEnv() (APPS_ENV)
+func WithEnvironment(environment string) Options {
+	return func(o *options) {
+		o.environment = environment
+	}
+}
+
+// WithEnvPrefix prefixes the env variables derived from the yaml paths: with "ORDER", server.port is read from ORDER_SERVER_PORT
+func WithEnvPrefix(prefix string) Options {
+	return func(o *options) {
+		o.envPrefix = strings.ToUpper(strings.TrimSuffix(prefix, "_"))
+	}
+}
+
+// WithEncryptionKey sets the key decrypting the ENC(...) values (helper.CryptoManager), default the CONFIG_ENCRYPTION_KEY env
+func WithEncryptionKey(key string) Options {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// WithDotEnv loads the given .env files (skipping the missing ones) before reading the env variables
+func WithDotEnv(paths ...string) Options {
+	return func(o *options) {
+		o.dotEnv = append(o.dotEnv, paths...)
+	}
+}
+
+// WithStrict fails on the yaml keys not matching a field, catching the typos in the config files
+func WithStrict() Options {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithReloadInterval sets how often Watcher checks the config files, default 5 seconds
+func WithReloadInterval(interval time.Duration) Options {
+	return func(o *options) {
+		o.reloadInterval = interval
+	}
+}
+
+func newOptions(opts []Options) *options {
+	o := &options{
+		name:           "config",
+		environment:    env.ServiceEnv(),
+		encryptionKey:  os.Getenv(EncryptionKeyEnv),
+		reloadInterval: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// files returns the config files layered in order, existing or not
+func (o *options) files() []string {
+	files := []string{o.file("")}
+	if o.environment != "" {
+		files = append(files, o.file(o.environment))
+	}
+	return files
+}
+
+// file returns the path of the base (environment "") or environment file, preferring .yaml over .yml
+func (o *options) file(environment string) string {
+	name := o.name
+	if environment != "" {
+		name += "." + environment
+	}
+	path := filepath.Join(o.dir, name+".yaml")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if yml := filepath.Join(o.dir, name+".yml"); fileExists(yml) {
+			return yml
+		}
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Load reads T from the config files, .env files and env variables, in order of precedence:
+//
+//  1. the env variables: the upper-cased yaml path joined with "_" (server.port -> SERVER_PORT,
+//     prefixed with WithEnvPrefix), then the names of the `env:"A,B"` tag, then the legacy variable
+//     of the framework settings (database.master.conn_string -> DATABASE_CONN_STRING_MASTER, ...)
+//  2. config.{APPS_ENV}.yaml
+//  3. config.yaml
+//
+// The string values written ENC(base64) are then decrypted, and T is validated with its `validate` tags.
+// The missing config files are skipped.
+func Load[T any](opts ...Options) (*T, error) {
+	return load[T](newOptions(opts))
+}
+
+func load[T any](o *options) (*T, error) {
+	cfg := new(T)
+	for _, path := range o.files() {
+		if err := decodeFile(path, cfg, o.strict); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range o.dotEnv {
+		if err := env.SetFromEnvFile(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "phastos.config.Load.SetFromEnvFile")
+		}
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+	if _, err := o.overlayEnv(v, "", ""); err != nil {
+		return nil, err
+	}
+
+	if err := o.decrypt(v); err != nil {
+		return nil, err
+	}
+
+	if v.Kind() == reflect.Struct {
+		if err := validator.New().Struct(cfg); err != nil {
+			return nil, errors.Wrap(err, "phastos.config.Load.Validate")
+		}
+	}
+	return cfg, nil
+}
+
+func decodeFile(path string, cfg any, strict bool) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "phastos.config.Load.ReadFile")
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(strict)
+	if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrapf(err, "phastos.config.Load.Decode: %s", path)
+	}
+	return nil
+}
+
+// yamlKey returns the yaml key of a struct field, whether it is inlined and whether it is skipped
+func yamlKey(field reflect.StructField) (key string, inline, skip bool) {
+	if !field.IsExported() {
+		return "", false, true
+	}
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false, true
+	}
+	key, flags, _ := strings.Cut(tag, ",")
+	inline = strings.Contains(flags, "inline")
+	if key == "" {
+		key = strings.ToLower(field.Name)
+	}
+	return key, inline, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// envNames returns the env variables of the setting at path, in order of precedence
+func (o *options) envNames(path string, tag reflect.StructTag) []string {
+	derived := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+	if o.envPrefix != "" {
+		derived = o.envPrefix + "_" + derived
+	}
+	names := []string{derived}
+	if names2 := tag.Get("env"); names2 != "" {
+		names = append(names, strings.Split(names2, ",")...)
+	}
+	if legacy, ok := legacyEnv[path]; ok {
+		names = append(names, legacy)
+	}
+	return names
+}
+
+// overlayEnv sets the settings of v having an env variable, returning whether any was set.
+// The nil pointers are only allocated when one of their settings is set.
+func (o *options) overlayEnv(v reflect.Value, path string, tag reflect.StructTag) (bool, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			elem.Elem().Set(v.Elem())
+		}
+		set, err := o.overlayEnv(elem.Elem(), path, tag)
+		if set {
+			v.Set(elem)
+		}
+		return set, err
+	case reflect.Struct:
+		var set bool
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, inline, skip := yamlKey(field)
+			if skip {
+				continue
+			}
+			fieldPath := path
+			if !inline {
+				fieldPath = joinPath(path, key)
+			}
+			fieldSet, err := o.overlayEnv(v.Field(i), fieldPath, field.Tag)
+			if err != nil {
+				return set, err
+			}
+			set = set || fieldSet
+		}
+		return set, nil
+	}
+
+	if path == "" || !isScalar(v.Type()) {
+		return false, nil
+	}
+	for _, name := range o.envNames(path, tag) {
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setScalar(v, raw); err != nil {
+			return false, errors.Wrapf(err, "phastos.config.Load: invalid %s", name)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// isScalar reports whether t is set from a single env variable
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setScalar parses raw into v: durations as "1m30s", slices as comma-separated values
+func setScalar(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		v.Set(reflect.ValueOf(values).Convert(v.Type()))
+	}
+	return nil
+}
+
+// decrypt replaces the ENC(base64) strings of v with their plaintext
+func (o *options) decrypt(v reflect.Value) error {
+	var crypto *helper.CryptoManager
+	var walk func(v reflect.Value, path string) error
+	walk = func(v reflect.Value, path string) error {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if !v.IsNil() {
+				return walk(v.Elem(), path)
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				key, inline, skip := yamlKey(v.Type().Field(i))
+				if skip {
+					continue
+				}
+				fieldPath := path
+				if !inline {
+					fieldPath = joinPath(path, key)
+				}
+				if err := walk(v.Field(i), fieldPath); err != nil {
+					return err
+				}
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				if err := walk(v.Index(i), path); err != nil {
+					return err
+				}
+			}
+		case reflect.Map:
+			if v.Type().Elem().Kind() != reflect.String {
+				return nil
+			}
+			for _, key := range v.MapKeys() {
+				value := reflect.New(v.Type().Elem()).Elem()
+				value.Set(v.MapIndex(key))
+				if err := walk(value, path); err != nil {
+					return err
+				}
+				v.SetMapIndex(key, value)
+			}
+		case reflect.String:
+			s := v.String()
+			if !strings.HasPrefix(s, "ENC(") || !strings.HasSuffix(s, ")") {
+				return nil
+			}
+			if o.encryptionKey == "" {
+				return errors.Errorf("phastos.config.Load: %s is encrypted but no encryption key is set (%s)", path, EncryptionKeyEnv)
+			}
+			if crypto == nil {
+				var err error
+				if crypto, err = helper.NewCryptoManager(o.encryptionKey); err != nil {
+					return errors.Wrap(err, "phastos.config.Load.NewCryptoManager")
+				}
+			}
+			plain, err := crypto.Decrypt(s[len("ENC(") : len(s)-1])
+			if err != nil {
+				return errors.Wrapf(err, "phastos.config.Load: decrypt %s", path)
+			}
+			v.SetString(plain)
+		}
+		return nil
+	}
+	return walk(v, "")
+}
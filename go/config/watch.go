@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// Watcher holds the configuration loaded by Watch and reloads it when its files change.
+//
+// Only the settings tagged `reload:"true"` (log.level, rate_limit.*, ...) are applied on reload; the
+// other changes are logged as needing a restart. A reload failing to load or validate keeps the
+// current configuration.
+type Watcher[T any] struct {
+	opts     *options
+	current  atomic.Pointer[T]
+	modTimes map[string]time.Time
+
+	mu        sync.Mutex
+	listeners []func(old, new *T)
+}
+
+// Watch loads T like Load and returns a Watcher reloading it, started with Run
+func Watch[T any](opts ...Options) (*Watcher[T], error) {
+	o := newOptions(opts)
+	cfg, err := load[T](o)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher[T]{opts: o}
+	w.current.Store(cfg)
+	w.modTimes = w.stat()
+	return w, nil
+}
+
+// Current returns the current configuration, to be read again after each reload
+func (w *Watcher[T]) Current() *T {
+	return w.current.Load()
+}
+
+// OnChange registers fn, called with the previous and the new configuration after each applied reload
+func (w *Watcher[T]) OnChange(fn func(old, new *T)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, fn)
+}
+
+// Framework returns the framework configuration of the current configuration, when T embeds Config
+func (w *Watcher[T]) Framework() *Config {
+	if provider, ok := any(w.Current()).(Provider); ok {
+		return provider.Framework()
+	}
+	return &Config{}
+}
+
+// OnReload registers fn, called with the framework configuration after each applied reload (Reloadable)
+func (w *Watcher[T]) OnReload(fn func(cfg *Config)) {
+	w.OnChange(func(_, _ *T) {
+		fn(w.Framework())
+	})
+}
+
+// Run checks the config files every reload interval and reloads them when they change, until ctx is done
+func (w *Watcher[T]) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTimes := w.stat()
+			if reflect.DeepEqual(modTimes, w.modTimes) {
+				continue
+			}
+			w.modTimes = modTimes
+			if _, err := w.Reload(); err != nil {
+				log := plog.Get()
+				log.Error().Err(err).Msg("[PHASTOS][CONFIG] reload failed, keeping the current configuration")
+			}
+		}
+	}
+}
+
+// Reload loads the configuration again and applies its reloadable settings, returning whether they changed
+func (w *Watcher[T]) Reload() (bool, error) {
+	fresh, err := load[T](w.opts)
+	if err != nil {
+		return false, err
+	}
+
+	old := w.Current()
+	next := new(T)
+	*next = *old
+	copyReloadable(reflect.ValueOf(next).Elem(), reflect.ValueOf(fresh).Elem(), false)
+
+	// fresh with the reloadable settings of old differs from old only by the settings needing a restart
+	probe := new(T)
+	*probe = *fresh
+	copyReloadable(reflect.ValueOf(probe).Elem(), reflect.ValueOf(old).Elem(), false)
+	if !reflect.DeepEqual(probe, old) {
+		log := plog.Get()
+		log.Warn().Msg("[PHASTOS][CONFIG] changed settings not tagged reload:\"true\" need a restart to apply")
+	}
+
+	if reflect.DeepEqual(next, old) {
+		return false, nil
+	}
+	w.current.Store(next)
+
+	w.mu.Lock()
+	listeners := append([]func(old, new *T){}, w.listeners...)
+	w.mu.Unlock()
+	for _, fn := range listeners {
+		fn(old, next)
+	}
+	return true, nil
+}
+
+// stat returns the modification times of the config and .env files, zero for the missing ones
+func (w *Watcher[T]) stat() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range append(w.opts.files(), w.opts.dotEnv...) {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		} else {
+			modTimes[path] = time.Time{}
+		}
+	}
+	return modTimes
+}
+
+// copyReloadable copies the settings tagged reload:"true" of src into dst, all of them when reload is set.
+// The pointers of dst are cloned before being written, dst being a shallow copy of the current configuration.
+func copyReloadable(dst, src reflect.Value, reload bool) {
+	if reload {
+		dst.Set(src)
+		return
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() || src.IsNil() {
+			return
+		}
+		clone := reflect.New(dst.Type().Elem())
+		clone.Elem().Set(dst.Elem())
+		copyReloadable(clone.Elem(), src.Elem(), false)
+		dst.Set(clone)
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			field := dst.Type().Field(i)
+			if _, _, skip := yamlKey(field); skip {
+				continue
+			}
+			copyReloadable(dst.Field(i), src.Field(i), field.Tag.Get("reload") == "true")
+		}
+	}
+}
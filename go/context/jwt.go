@@ -12,8 +12,12 @@ type (
 )
 
 func SetJWT(req *http.Request, jwtData *entity.JWTClaimData) {
-	ctx := context.WithValue(req.Context(), JwtContext{}, jwtData)
-	*req = *req.WithContext(ctx)
+	*req = *req.WithContext(WithJWT(req.Context(), jwtData))
+}
+
+// WithJWT returns a copy of ctx carrying the JWT claims, for transports without *http.Request (gRPC, workers)
+func WithJWT(ctx context.Context, jwtData *entity.JWTClaimData) context.Context {
+	return context.WithValue(ctx, JwtContext{}, jwtData)
 }
 
 func GetJWT(ctx context.Context) *entity.JWTClaimData {
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/kodekoding/phastos/v2/go/env"
 	helper2 "github.com/kodekoding/phastos/v2/go/helper"
+	"github.com/kodekoding/phastos/v2/go/metrics"
 	"github.com/rs/zerolog/log"
 
 	"github.com/robfig/cron/v3"
@@ -24,7 +26,8 @@ type (
 	}
 	Options func(*option)
 	option  struct {
-		timezone string
+		timezone       string
+		timeoutProcess int
 	}
 
 	HandlerFunc func(ctx context.Context) *Response
@@ -35,6 +38,8 @@ type (
 		wrapper      []Wrapper
 		ctx          context.Context
 		handlerList  map[string]cron.EntryID
+		running      atomic.Bool
+		timeout      int // handler timeout in minutes, CRON_JOB_TIMEOUT_PROCESS when 0
 	}
 
 	Wrapper interface {
@@ -62,6 +67,7 @@ func New(configOptions ...Options) *Engine {
 		engine:      scheduler,
 		ctx:         context.Background(),
 		handlerList: make(map[string]cron.EntryID),
+		timeout:     options.timeoutProcess,
 	}
 }
 
@@ -71,6 +77,13 @@ func WithTimeZone(timeZone string) Options {
 	}
 }
 
+// WithTimeoutProcess sets the timeout of every handler in minutes, instead of CRON_JOB_TIMEOUT_PROCESS
+func WithTimeoutProcess(minutes int) Options {
+	return func(c *option) {
+		c.timeoutProcess = minutes
+	}
+}
+
 func (eg *Engine) RegisterScheduler(pattern string, handler HandlerFunc) {
 	if eg.engine == nil {
 		log.Fatal().Msg("engine is nil")
@@ -113,12 +126,14 @@ func (eg *Engine) RemoveScheduler(pattern string) {
 }
 
 func (eg *Engine) wrapperCronHandler(pattern string, handler HandlerFunc) {
-	timeoutProcessEnv := os.Getenv("CRON_JOB_TIMEOUT_PROCESS")
-	if timeoutProcessEnv == "" {
-		timeoutProcessEnv = "1"
+	timeoutProcess := eg.timeout
+	if timeoutProcess == 0 {
+		timeoutProcessEnv := os.Getenv("CRON_JOB_TIMEOUT_PROCESS")
+		if timeoutProcessEnv == "" {
+			timeoutProcessEnv = "1"
+		}
+		timeoutProcess, _ = strconv.Atoi(timeoutProcessEnv)
 	}
-
-	timeoutProcess, _ := strconv.Atoi(timeoutProcessEnv)
 	ctx, cancel := context.WithTimeout(eg.ctx, time.Duration(timeoutProcess)*time.Minute)
 	defer cancel()
 
@@ -141,6 +156,7 @@ func (eg *Engine) wrapperCronHandler(pattern string, handler HandlerFunc) {
 
 	select {
 	case <-ctx.Done():
+		metrics.ObserveCronRun(pattern, start, true, nil)
 		end := time.Since(start)
 		_ = helper2.SendSlackNotification(ctx,
 			helper2.NotifMsgType(helper2.NotifWarnType),
@@ -150,6 +166,7 @@ func (eg *Engine) wrapperCronHandler(pattern string, handler HandlerFunc) {
 			}),
 		)
 	case resp := <-respChan:
+		metrics.ObserveCronRun(pattern, start, false, resp.err)
 		var notifType helper2.SentNotifParamOptions
 		notifType = helper2.NotifMsgType(helper2.NotifInfoType)
 		var msg = "Success"
@@ -193,9 +210,16 @@ func (eg *Engine) Start() {
 	}
 	log.Info().Int("handler", eg.handlerTotal).Msg("Cron Job / Scheduler is running")
 	eg.engine.Start()
+	eg.running.Store(true)
+}
+
+// IsRunning reports whether the scheduler was started and not stopped yet
+func (eg *Engine) IsRunning() bool {
+	return eg.running.Load()
 }
 
 func (eg *Engine) Stop() {
 	log.Info().Msg("Cron Job / Scheduler stopped")
+	eg.running.Store(false)
 	eg.engine.Stop()
 }
@@ -1,6 +1,8 @@
 package action
 
 import (
+	"reflect"
+
 	"github.com/kodekoding/phastos/v2/go/common"
 	"github.com/kodekoding/phastos/v2/go/database"
 )
@@ -14,6 +16,7 @@ type baseAction struct {
 	db           database.ISQL
 	tableName    string
 	isSoftDelete bool
+	modelType    reflect.Type
 }
 
 func NewBase(db database.ISQL, tableName string, isSoftDelete ...bool) *Base {
@@ -26,3 +29,14 @@ func NewBase(db database.ISQL, tableName string, isSoftDelete ...bool) *Base {
 		ReadRepo:   NewBaseRead(db, tableName, sofDelete),
 	}
 }
+
+// SetModel registers the entity type of the table on both the read and write
+// side, see baseAction.SetModel.
+func (b *Base) SetModel(model interface{}) {
+	if setter, ok := b.BaseWrites.(interface{ SetModel(interface{}) }); ok {
+		setter.SetModel(model)
+	}
+	if setter, ok := b.ReadRepo.(interface{ SetModel(interface{}) }); ok {
+		setter.SetModel(model)
+	}
+}
@@ -0,0 +1,240 @@
+package action
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+// HookType identifies the lifecycle point a hook is attached to.
+type HookType string
+
+const (
+	HookBeforeInsert HookType = "before_insert"
+	HookAfterInsert  HookType = "after_insert"
+	HookBeforeUpdate HookType = "before_update"
+	HookAfterUpdate  HookType = "after_update"
+	HookBeforeDelete HookType = "before_delete"
+	HookAfterDelete  HookType = "after_delete"
+	HookAfterFind    HookType = "after_find"
+
+	// AllTables registers a global hook that runs for every table.
+	AllTables = "*"
+)
+
+type (
+	// BeforeInserter is implemented by entities that need to prepare themselves
+	// (generate IDs, normalize fields, set audit columns) before INSERT.
+	BeforeInserter interface {
+		BeforeInsert(ctx context.Context) error
+	}
+
+	// AfterInserter is implemented by entities that react to a successful INSERT.
+	AfterInserter interface {
+		AfterInsert(ctx context.Context) error
+	}
+
+	// BeforeUpdater is implemented by entities that need to prepare themselves before UPDATE.
+	BeforeUpdater interface {
+		BeforeUpdate(ctx context.Context) error
+	}
+
+	// AfterUpdater is implemented by entities that react to a successful UPDATE.
+	AfterUpdater interface {
+		AfterUpdate(ctx context.Context) error
+	}
+
+	// AfterDeleter is implemented by the model registered via SetModel.
+	// Delete operations carry no entity, so the hook is invoked on a fresh
+	// instance of the model and the deleted id / condition can be read with
+	// HookEventFromContext.
+	AfterDeleter interface {
+		AfterDelete(ctx context.Context) error
+	}
+
+	// AfterFinder is implemented by entities that post-process rows loaded by
+	// GetList, GetDetail and GetDetailById (decrypting fields, computing values).
+	AfterFinder interface {
+		AfterFind(ctx context.Context) error
+	}
+
+	// HookEvent describes the operation passed to global (per-table) hooks.
+	HookEvent struct {
+		Type      HookType
+		TableName string
+		Action    string
+		// Data is the entity (or slice of entities for bulk operations / lists)
+		Data      interface{}
+		ID        interface{}
+		Condition map[string]interface{}
+		// Result is only filled for After* hooks on write operations
+		Result *database.CUDResponse
+	}
+
+	// HookFunc is a global hook registered per table via RegisterHook.
+	HookFunc func(ctx context.Context, event *HookEvent) error
+)
+
+type hookEventContextKey struct{}
+
+var (
+	hookMu       sync.RWMutex
+	hookRegistry = make(map[string]map[HookType][]HookFunc)
+)
+
+// RegisterHook attaches fn to the given lifecycle point of tableName. Use
+// AllTables to run the hook for every table (audit trail, cache busting,
+// search indexing). Hooks run in registration order; table-specific hooks run
+// after the global ones.
+func RegisterHook(tableName string, hookType HookType, fn HookFunc) {
+	if fn == nil {
+		return
+	}
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	if hookRegistry[tableName] == nil {
+		hookRegistry[tableName] = make(map[HookType][]HookFunc)
+	}
+	hookRegistry[tableName][hookType] = append(hookRegistry[tableName][hookType], fn)
+}
+
+// ResetHooks removes every registered global hook. It is mainly useful in tests.
+func ResetHooks() {
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	hookRegistry = make(map[string]map[HookType][]HookFunc)
+}
+
+// HookEventFromContext returns the event of the hook currently being executed.
+// It is available inside entity hooks (e.g. AfterDelete) that do not receive
+// the event as a parameter.
+func HookEventFromContext(ctx context.Context) *HookEvent {
+	event, _ := ctx.Value(hookEventContextKey{}).(*HookEvent)
+	return event
+}
+
+func getHooks(tableName string, hookType HookType) []HookFunc {
+	hookMu.RLock()
+	defer hookMu.RUnlock()
+	var hooks []HookFunc
+	if global, ok := hookRegistry[AllTables]; ok {
+		hooks = append(hooks, global[hookType]...)
+	}
+	if tableName != AllTables {
+		if table, ok := hookRegistry[tableName]; ok {
+			hooks = append(hooks, table[hookType]...)
+		}
+	}
+	return hooks
+}
+
+// SetModel registers the entity type stored in the table. It is only needed
+// for entity-level AfterDelete hooks because delete operations do not receive
+// the entity.
+func (b *baseAction) SetModel(model interface{}) {
+	if model == nil {
+		b.modelType = nil
+		return
+	}
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	b.modelType = t
+}
+
+// runHooks executes the entity hook (on every element for slices) followed by
+// the global hooks registered for the table.
+func (b *baseAction) runHooks(ctx context.Context, event *HookEvent) error {
+	event.TableName = b.tableName
+	ctx = context.WithValue(ctx, hookEventContextKey{}, event)
+
+	if event.Data != nil {
+		if err := forEachEntity(event.Data, func(entity interface{}) error {
+			return callEntityHook(ctx, event.Type, entity)
+		}); err != nil {
+			return errors.Wrap(err, "phastos.database.action.hooks."+string(event.Type))
+		}
+	} else if event.Type == HookAfterDelete && b.modelType != nil {
+		if err := callEntityHook(ctx, event.Type, reflect.New(b.modelType).Interface()); err != nil {
+			return errors.Wrap(err, "phastos.database.action.hooks."+string(event.Type))
+		}
+	}
+
+	for _, hook := range getHooks(b.tableName, event.Type) {
+		if err := hook(ctx, event); err != nil {
+			return errors.Wrap(err, "phastos.database.action.hooks."+string(event.Type))
+		}
+	}
+	return nil
+}
+
+func callEntityHook(ctx context.Context, hookType HookType, entity interface{}) error {
+	switch hookType {
+	case HookBeforeInsert:
+		if h, ok := entity.(BeforeInserter); ok {
+			return h.BeforeInsert(ctx)
+		}
+	case HookAfterInsert:
+		if h, ok := entity.(AfterInserter); ok {
+			return h.AfterInsert(ctx)
+		}
+	case HookBeforeUpdate:
+		if h, ok := entity.(BeforeUpdater); ok {
+			return h.BeforeUpdate(ctx)
+		}
+	case HookAfterUpdate:
+		if h, ok := entity.(AfterUpdater); ok {
+			return h.AfterUpdate(ctx)
+		}
+	case HookAfterDelete:
+		if h, ok := entity.(AfterDeleter); ok {
+			return h.AfterDelete(ctx)
+		}
+	case HookAfterFind:
+		if h, ok := entity.(AfterFinder); ok {
+			return h.AfterFind(ctx)
+		}
+	}
+	return nil
+}
+
+// forEachEntity calls fn with an addressable pointer to the entity, or to every
+// element when data is a slice / array (bulk operations and list results).
+func forEachEntity(data interface{}, fn func(entity interface{}) error) error {
+	val := reflect.ValueOf(data)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		elem := val.Elem()
+		switch elem.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Ptr, reflect.Interface:
+			val = elem
+		default:
+			return fn(val.Interface())
+		}
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			item := val.Index(i)
+			if item.Kind() == reflect.Struct && item.CanAddr() {
+				item = item.Addr()
+			}
+			if err := forEachEntity(item.Interface(), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		// non-pointer struct: hooks with pointer receivers can't mutate it,
+		// but value receivers are still honored
+		return fn(val.Interface())
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+)
+
+type hookedEntity struct {
+	ID        string `db:"id"`
+	Name      string `db:"name"`
+	UpdatedBy string `db:"updated_by"`
+	Found     bool   `db:"-"`
+	Inserted  bool   `db:"-"`
+}
+
+func (e *hookedEntity) BeforeInsert(ctx context.Context) error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	e.ID = "generated-" + e.Name
+	return nil
+}
+
+func (e *hookedEntity) AfterInsert(ctx context.Context) error {
+	e.Inserted = true
+	return nil
+}
+
+func (e *hookedEntity) BeforeUpdate(ctx context.Context) error {
+	e.UpdatedBy = "system"
+	return nil
+}
+
+func (e *hookedEntity) AfterFind(ctx context.Context) error {
+	e.Found = true
+	return nil
+}
+
+type deletedModel struct{}
+
+var deletedIDs []interface{}
+
+func (deletedModel) AfterDelete(ctx context.Context) error {
+	deletedIDs = append(deletedIDs, HookEventFromContext(ctx).ID)
+	return nil
+}
+
+func TestHooks_EntityInsert(t *testing.T) {
+	t.Cleanup(ResetHooks)
+
+	t.Run("before insert mutates entity before columns are built", func(t *testing.T) {
+		db := newStubSQL(t)
+		var writtenValues []interface{}
+		db.writeFn = func(ctx context.Context, opts *database.QueryOpts, isSoftDelete ...bool) (*database.CUDResponse, error) {
+			writtenValues = opts.CUDRequest.Values
+			return &database.CUDResponse{Status: true, RowsAffected: 1}, nil
+		}
+		bw := NewBaseWrite(db, "hooked")
+		entity := &hookedEntity{Name: "john"}
+		_, err := bw.Insert(context.Background(), entity)
+		require.NoError(t, err)
+		assert.Equal(t, "generated-john", entity.ID)
+		assert.Contains(t, writtenValues, "generated-john")
+		assert.True(t, entity.Inserted)
+	})
+
+	t.Run("before insert error aborts the write", func(t *testing.T) {
+		db := newStubSQL(t)
+		written := false
+		db.writeFn = func(ctx context.Context, opts *database.QueryOpts, isSoftDelete ...bool) (*database.CUDResponse, error) {
+			written = true
+			return &database.CUDResponse{}, nil
+		}
+		bw := NewBaseWrite(db, "hooked")
+		_, err := bw.Insert(context.Background(), &hookedEntity{})
+		assert.Error(t, err)
+		assert.False(t, written)
+	})
+
+	t.Run("bulk insert calls hook on every element", func(t *testing.T) {
+		db := newStubSQL(t)
+		bw := NewBaseWrite(db, "hooked")
+		entities := []hookedEntity{{Name: "a"}, {Name: "b"}}
+		_, err := bw.BulkInsert(context.Background(), &entities)
+		require.NoError(t, err)
+		assert.Equal(t, "generated-a", entities[0].ID)
+		assert.Equal(t, "generated-b", entities[1].ID)
+		assert.True(t, entities[1].Inserted)
+	})
+}
+
+func TestHooks_EntityUpdate(t *testing.T) {
+	t.Cleanup(ResetHooks)
+
+	var before, after *HookEvent
+	RegisterHook("hooked", HookBeforeUpdate, func(ctx context.Context, event *HookEvent) error {
+		before = event
+		return nil
+	})
+	RegisterHook("hooked", HookAfterUpdate, func(ctx context.Context, event *HookEvent) error {
+		after = event
+		return nil
+	})
+
+	db := newStubSQL(t)
+	bw := NewBaseWrite(db, "hooked")
+	entity := &hookedEntity{Name: "john"}
+	_, err := bw.UpdateById(context.Background(), entity, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "system", entity.UpdatedBy)
+	require.NotNil(t, before)
+	require.NotNil(t, after)
+	assert.Equal(t, HookBeforeUpdate, before.Type, "the before event is not reused for the after hooks")
+	assert.Nil(t, before.Result)
+	assert.Equal(t, HookAfterUpdate, after.Type)
+	assert.Equal(t, 1, after.ID)
+	assert.NotNil(t, after.Result)
+
+	entities := []*hookedEntity{{Name: "a"}, {Name: "b"}}
+	_, err = bw.BulkUpdate(context.Background(), entities, map[string][]interface{}{"id": {1, 2}})
+	require.NoError(t, err)
+	assert.Equal(t, "system", entities[0].UpdatedBy)
+	assert.Equal(t, "system", entities[1].UpdatedBy)
+}
+
+func TestHooks_AfterFind(t *testing.T) {
+	t.Cleanup(ResetHooks)
+
+	db := newStubSQL(t)
+	db.readFn = func(ctx context.Context, opts *database.QueryOpts, additionalParams ...interface{}) error {
+		if list, ok := opts.Result.(*[]hookedEntity); ok {
+			*list = []hookedEntity{{Name: "a"}, {Name: "b"}}
+		}
+		return nil
+	}
+	br := NewBaseRead(db, "hooked")
+
+	var list []hookedEntity
+	require.NoError(t, br.GetList(context.Background(), &database.QueryOpts{Result: &list}))
+	require.Len(t, list, 2)
+	assert.True(t, list[0].Found)
+	assert.True(t, list[1].Found)
+
+	detail := &hookedEntity{}
+	require.NoError(t, br.GetDetailById(context.Background(), detail, 1))
+	assert.True(t, detail.Found)
+
+	t.Run("read error skips hook", func(t *testing.T) {
+		db.readFn = func(ctx context.Context, opts *database.QueryOpts, additionalParams ...interface{}) error {
+			return errors.New("db error")
+		}
+		detail := &hookedEntity{}
+		assert.Error(t, br.GetDetail(context.Background(), &database.QueryOpts{Result: detail}))
+		assert.False(t, detail.Found)
+	})
+}
+
+func TestHooks_GlobalRegistry(t *testing.T) {
+	t.Cleanup(ResetHooks)
+
+	var calls []string
+	RegisterHook(AllTables, HookAfterInsert, func(ctx context.Context, event *HookEvent) error {
+		calls = append(calls, "global:"+event.TableName)
+		return nil
+	})
+	RegisterHook("hooked", HookAfterInsert, func(ctx context.Context, event *HookEvent) error {
+		calls = append(calls, "table:"+event.Action)
+		assert.NotNil(t, event.Result)
+		return nil
+	})
+	RegisterHook("other", HookAfterInsert, func(ctx context.Context, event *HookEvent) error {
+		calls = append(calls, "other")
+		return nil
+	})
+	RegisterHook("hooked", HookBeforeDelete, func(ctx context.Context, event *HookEvent) error {
+		if event.ID == 99 {
+			return errors.New("protected row")
+		}
+		return nil
+	})
+	RegisterHook("hooked", HookAfterInsert, nil)
+
+	db := newStubSQL(t)
+	bw := NewBaseWrite(db, "hooked")
+	_, err := bw.Insert(context.Background(), &hookedEntity{Name: "john"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"global:hooked", "table:insert"}, calls)
+
+	_, err = bw.DeleteById(context.Background(), 99)
+	assert.ErrorContains(t, err, "protected row")
+}
+
+func TestHooks_AfterDeleteWithModel(t *testing.T) {
+	t.Cleanup(ResetHooks)
+	deletedIDs = nil
+
+	db := newStubSQL(t)
+	base := NewBase(db, "hooked")
+	base.SetModel(&deletedModel{})
+
+	_, err := base.DeleteById(context.Background(), 7)
+	require.NoError(t, err)
+	_, err = base.Delete(context.Background(), map[string]interface{}{"name = ?": "john"})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{7, nil}, deletedIDs)
+}
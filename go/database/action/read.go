@@ -102,7 +102,7 @@ func NewBaseRead(db database.ISQL, tableName string, isSoftDelete ...bool) *Base
 	if len(isSoftDelete) > 0 {
 		sofDelete = isSoftDelete[0]
 	}
-	return &BaseRead{&baseAction{db, tableName, sofDelete}}
+	return &BaseRead{&baseAction{db: db, tableName: tableName, isSoftDelete: sofDelete}}
 }
 
 func (b *BaseRead) getBaseQuery(ctx context.Context, opts *database.QueryOpts) string {
@@ -161,7 +161,10 @@ func (b *BaseRead) GetList(ctx context.Context, opts *database.QueryOpts) error
 	}
 	opts.IsList = true
 	opts.BaseQuery = b.getBaseQuery(ctx, opts)
-	return b.db.Read(ctx, opts)
+	if err := b.db.Read(ctx, opts); err != nil {
+		return err
+	}
+	return b.afterFind(ctx, opts.Result)
 }
 
 // GetDetail - Query Detail with specific Query and return single data
@@ -172,7 +175,10 @@ func (b *BaseRead) GetDetail(ctx context.Context, opts *database.QueryOpts) erro
 		defer segment.End()
 	}
 	opts.BaseQuery = b.getBaseQuery(ctx, opts)
-	return b.db.Read(ctx, opts)
+	if err := b.db.Read(ctx, opts); err != nil {
+		return err
+	}
+	return b.afterFind(ctx, opts.Result)
 }
 
 // GetDetailById - Generate Query "SELECT * FROM <table_name | optional_table_name> WHERE id = ?"
@@ -203,7 +209,10 @@ func (b *BaseRead) GetDetailById(ctx context.Context, resultStruct interface{},
 		opts.BaseQuery = entry.ReboundQuery
 		err := b.db.Read(ctx, opts, id)
 		database.PutQueryOpts(opts)
-		return err
+		if err != nil {
+			return err
+		}
+		return b.afterFind(ctx, resultStruct, id)
 	}
 
 	// Slow path: original implementation for non-struct inputs
@@ -214,7 +223,22 @@ func (b *BaseRead) GetDetailById(ctx context.Context, resultStruct interface{},
 		opts.OptionalTableName = optionalTableName[0]
 	}
 	opts.BaseQuery = fmt.Sprintf("%s WHERE id = ?", b.getBaseQuery(ctx, opts))
-	return b.db.Read(ctx, opts, id)
+	if err := b.db.Read(ctx, opts, id); err != nil {
+		return err
+	}
+	return b.afterFind(ctx, resultStruct, id)
+}
+
+// afterFind runs the AfterFind entity + global hooks on the loaded result(s).
+func (b *BaseRead) afterFind(ctx context.Context, result interface{}, id ...interface{}) error {
+	if result == nil {
+		return nil
+	}
+	event := &HookEvent{Type: HookAfterFind, Data: result}
+	if len(id) > 0 {
+		event.ID = id[0]
+	}
+	return b.runHooks(ctx, event)
 }
 
 func (b *BaseRead) Count(ctx context.Context, reqData *database.TableRequest, tableName ...string) (totalData, totalFiltered int, err error) {
@@ -29,7 +29,7 @@ func NewBaseWrite(db database.ISQL, tableName string, isSoftDelete ...bool) *Bas
 	if len(isSoftDelete) > 0 {
 		sofDelete = isSoftDelete[0]
 	}
-	return &BaseWrite{&baseAction{db, tableName, sofDelete}}
+	return &BaseWrite{&baseAction{db: db, tableName: tableName, isSoftDelete: sofDelete}}
 }
 
 func (b *BaseWrite) Insert(ctx context.Context, data interface{}, optTrx ...*sqlx.Tx) (*database.CUDResponse, error) {
@@ -59,12 +59,16 @@ func (b *BaseWrite) BulkInsert(ctx context.Context, data interface{}, optTrx ...
 }
 
 func (b *BaseWrite) BulkUpdate(ctx context.Context, data interface{}, condition map[string][]interface{}, optTrx ...*sqlx.Tx) (*database.CUDResponse, error) {
+	action := database.ActionBulkUpdate
+	if err := b.runHooks(ctx, &HookEvent{Type: HookBeforeUpdate, Action: action, Data: data}); err != nil {
+		return nil, err
+	}
+
 	cudRequestData, err := helper.ConstructColNameAndValueBulk(ctx, data, condition)
 	if err != nil {
 		return nil, err
 	}
 
-	action := database.ActionBulkUpdate
 	cudRequestData.Action = action
 	cudRequestData.TableName = b.tableName
 
@@ -81,6 +85,9 @@ func (b *BaseWrite) BulkUpdate(ctx context.Context, data interface{}, condition
 		return result, errors.Wrap(err, "phastos.database.action."+action+".Write")
 	}
 
+	if err = b.runHooks(ctx, &HookEvent{Type: HookAfterUpdate, Action: action, Data: data, Result: result}); err != nil {
+		return result, err
+	}
 	return result, nil
 }
 
@@ -120,6 +127,10 @@ func (b *BaseWrite) Delete(ctx context.Context, condition map[string]interface{}
 		deleteSegment := txn.StartSegment("PhastosDB-Delete")
 		defer deleteSegment.End()
 	}
+	if err := b.runHooks(ctx, &HookEvent{Type: HookBeforeDelete, Action: database.ActionDelete, Condition: condition}); err != nil {
+		return nil, err
+	}
+
 	// soft delete, just update the deleted_at to not null
 	data := &database.CUDConstructData{
 		Cols:      []string{"deleted_at = now()"},
@@ -141,7 +152,15 @@ func (b *BaseWrite) Delete(ctx context.Context, condition map[string]interface{}
 		tableRequest.SetWhereCondition(cond, value)
 	}
 	qOpts.SelectRequest = tableRequest
-	return b.db.Write(ctx, qOpts, b.isSoftDelete)
+	result, err := b.db.Write(ctx, qOpts, b.isSoftDelete)
+	if err != nil {
+		return result, err
+	}
+
+	if err = b.runHooks(ctx, &HookEvent{Type: HookAfterDelete, Action: database.ActionDelete, Condition: condition, Result: result}); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
 func (b *BaseWrite) DeleteById(ctx context.Context, id interface{}, optTrx ...*sqlx.Tx) (*database.CUDResponse, error) {
@@ -151,6 +170,22 @@ func (b *BaseWrite) DeleteById(ctx context.Context, id interface{}, optTrx ...*s
 		defer deleteByIdSegment.End()
 	}
 
+	if err := b.runHooks(ctx, &HookEvent{Type: HookBeforeDelete, Action: database.ActionDeleteById, ID: id}); err != nil {
+		return nil, err
+	}
+
+	result, err := b.deleteById(ctx, id, optTrx...)
+	if err != nil {
+		return result, err
+	}
+
+	if err = b.runHooks(ctx, &HookEvent{Type: HookAfterDelete, Action: database.ActionDeleteById, ID: id, Result: result}); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (b *BaseWrite) deleteById(ctx context.Context, id interface{}, optTrx ...*sqlx.Tx) (*database.CUDResponse, error) {
 	// O5: Fast path — cache query + stmt per (tableName, isSoftDelete).
 	// No CUDConstructData, no QueryOpts, no Write() builder.
 	var trx *sqlx.Tx
@@ -279,6 +314,23 @@ func (b *BaseWrite) Upsert(ctx context.Context, data interface{}, condition map[
 func (b *BaseWrite) cudProcess(ctx context.Context, action string, data interface{}, condition map[string]interface{}, opts ...interface{}) (*database.CUDResponse, error) {
 	var cudRequestData *database.CUDConstructData
 	var err error
+
+	beforeHook, afterHook := writeHookTypes(action)
+	var hookID interface{}
+	hookCondition := condition
+	if action == database.ActionUpdateById {
+		hookID, hookCondition = condition["id = ?"], nil
+	}
+	// every phase gets its own event, a before hook keeping it does not see it change
+	newHookEvent := func(hookType HookType) *HookEvent {
+		return &HookEvent{Type: hookType, Action: action, Data: data, ID: hookID, Condition: hookCondition}
+	}
+	if beforeHook != "" {
+		if err = b.runHooks(ctx, newHookEvent(beforeHook)); err != nil {
+			return nil, err
+		}
+	}
+
 	switch action {
 	case database.ActionInsert:
 		cols, vals := helper.ConstructColNameAndValue(ctx, data)
@@ -338,5 +390,23 @@ func (b *BaseWrite) cudProcess(ctx context.Context, action string, data interfac
 		return result, errors.Wrap(err, "phastos.database.action."+action+".ExecTransation")
 	}
 
+	if afterHook != "" {
+		hookEvent := newHookEvent(afterHook)
+		hookEvent.Result = result
+		if err = b.runHooks(ctx, hookEvent); err != nil {
+			return result, err
+		}
+	}
 	return result, nil
 }
+
+// writeHookTypes maps a write action to its before / after lifecycle hooks.
+func writeHookTypes(action string) (before, after HookType) {
+	switch action {
+	case database.ActionInsert, database.ActionBulkInsert:
+		return HookBeforeInsert, HookAfterInsert
+	case database.ActionUpdate, database.ActionUpdateById, database.ActionUpsert:
+		return HookBeforeUpdate, HookAfterUpdate
+	}
+	return "", ""
+}
@@ -17,6 +17,9 @@ const (
 
 	LockShare  = "share"
 	LockUpdate = "update"
+
+	metricsOperationRead    = "read"
+	metricsOperationUnknown = "unknown"
 )
 
 var (
@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+type (
+	// Notification is a payload delivered by PostgreSQL NOTIFY.
+	Notification struct {
+		Channel    string    `json:"channel"`
+		Payload    string    `json:"payload"`
+		BePid      int       `json:"be_pid"`
+		ReceivedAt time.Time `json:"received_at"`
+	}
+
+	// NotificationHandler processes notifications of a subscribed channel.
+	NotificationHandler func(ctx context.Context, notification *Notification) error
+
+	// Listener holds a dedicated LISTEN connection (separate from the sqlx pool)
+	// and dispatches notifications to the subscribed Go handlers.
+	// The connection is re-established automatically after connection loss.
+	Listener struct {
+		connString   string
+		minReconnect time.Duration
+		maxReconnect time.Duration
+		pingInterval time.Duration
+		onReconnect  func(ctx context.Context)
+
+		mu       sync.RWMutex
+		handlers map[string][]NotificationHandler
+		conn     pgListener
+		cancel   context.CancelFunc
+		done     chan struct{}
+	}
+
+	ListenerOption func(*Listener)
+
+	// pgListener is the subset of *pq.Listener used by Listener.
+	pgListener interface {
+		Listen(channel string) error
+		Unlisten(channel string) error
+		NotificationChannel() <-chan *pq.Notification
+		Ping() error
+		Close() error
+	}
+)
+
+// newPgListener is replaced in tests to avoid a real PostgreSQL connection.
+var newPgListener = func(connString string, minReconnect, maxReconnect time.Duration, cb pq.EventCallbackType) pgListener {
+	return pq.NewListener(connString, minReconnect, maxReconnect, cb)
+}
+
+// NewListener creates a LISTEN/NOTIFY listener. When connString is empty,
+// DATABASE_CONN_STRING_MASTER is read on Start.
+func NewListener(connString string, opts ...ListenerOption) *Listener {
+	l := &Listener{
+		connString:   connString,
+		minReconnect: 10 * time.Second,
+		maxReconnect: time.Minute,
+		pingInterval: 90 * time.Second,
+		handlers:     make(map[string][]NotificationHandler),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithReconnectInterval sets the min / max backoff used when the listener
+// connection is lost.
+func WithReconnectInterval(minReconnect, maxReconnect time.Duration) ListenerOption {
+	return func(l *Listener) {
+		l.minReconnect = minReconnect
+		l.maxReconnect = maxReconnect
+	}
+}
+
+// WithPingInterval sets how often the idle listener connection is checked.
+func WithPingInterval(interval time.Duration) ListenerOption {
+	return func(l *Listener) {
+		l.pingInterval = interval
+	}
+}
+
+// WithOnReconnect registers a callback invoked after the connection is
+// re-established. Notifications sent while disconnected are lost, so this is
+// the place to resync state (e.g. reload from the table).
+func WithOnReconnect(fn func(ctx context.Context)) ListenerOption {
+	return func(l *Listener) {
+		l.onReconnect = fn
+	}
+}
+
+// Subscribe registers handler for channel. It can be called before or after
+// Start; channels subscribed after Start are LISTENed immediately.
+func (l *Listener) Subscribe(channel string, handler NotificationHandler) error {
+	if channel == "" {
+		return errors.New("channel cannot be empty")
+	}
+	if handler == nil {
+		return errors.New("handler cannot be nil")
+	}
+	l.mu.Lock()
+	_, listened := l.handlers[channel]
+	l.handlers[channel] = append(l.handlers[channel], handler)
+	conn := l.conn
+	l.mu.Unlock()
+
+	if conn != nil && !listened {
+		if err := conn.Listen(channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			return errors.Wrap(err, "phastos.database.Listener.Subscribe.Listen")
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes every handler of channel and stops listening to it.
+func (l *Listener) Unsubscribe(channel string) error {
+	l.mu.Lock()
+	_, listened := l.handlers[channel]
+	delete(l.handlers, channel)
+	conn := l.conn
+	l.mu.Unlock()
+
+	if conn != nil && listened {
+		if err := conn.Unlisten(channel); err != nil && !errors.Is(err, pq.ErrChannelNotOpen) {
+			return errors.Wrap(err, "phastos.database.Listener.Unsubscribe.Unlisten")
+		}
+	}
+	return nil
+}
+
+// Channels returns the subscribed channel names.
+func (l *Listener) Channels() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// Start opens the listener connection, LISTENs every subscribed channel and
+// starts dispatching notifications until ctx is canceled or Stop is called.
+func (l *Listener) Start(ctx context.Context) error {
+	if l.connString == "" {
+		l.connString = os.Getenv("DATABASE_CONN_STRING_MASTER")
+	}
+	if l.connString == "" {
+		return errors.New("phastos.database.Listener.Start: connection string is empty")
+	}
+
+	l.mu.Lock()
+	if l.conn != nil {
+		l.mu.Unlock()
+		return errors.New("phastos.database.Listener.Start: listener already started")
+	}
+	log := plog.Get()
+	conn := newPgListener(l.connString, l.minReconnect, l.maxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn().Err(err).Str("event", listenerEventName(event)).Msg("[PHASTOS][DB_LISTENER] connection event")
+			return
+		}
+		log.Info().Str("event", listenerEventName(event)).Msg("[PHASTOS][DB_LISTENER] connection event")
+	})
+	l.conn = conn
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	l.cancel = cancel
+	l.done = done
+	l.mu.Unlock()
+
+	go l.run(runCtx, conn, done)
+	for _, channel := range channels {
+		if err := conn.Listen(channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			_ = l.Stop()
+			return errors.Wrap(err, fmt.Sprintf("phastos.database.Listener.Start.Listen(%s)", channel))
+		}
+	}
+	log.Info().Strs("channels", channels).Msg("[PHASTOS][DB_LISTENER] started")
+	return nil
+}
+
+// Stop closes the listener connection and waits for the dispatcher to exit.
+func (l *Listener) Stop() error {
+	l.mu.Lock()
+	conn, cancel, done := l.conn, l.cancel, l.done
+	l.conn, l.cancel, l.done = nil, nil, nil
+	l.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	cancel()
+	err := conn.Close()
+	if done != nil {
+		<-done
+	}
+	if err != nil {
+		return errors.Wrap(err, "phastos.database.Listener.Stop")
+	}
+	return nil
+}
+
+func (l *Listener) run(ctx context.Context, conn pgListener, done chan struct{}) {
+	defer close(done)
+	log := plog.Get()
+	ticker := time.NewTicker(l.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-conn.NotificationChannel():
+			if !ok {
+				return
+			}
+			if n == nil {
+				// pq sends nil after the connection has been re-established
+				if l.onReconnect != nil {
+					l.onReconnect(ctx)
+				}
+				continue
+			}
+			l.dispatch(ctx, &Notification{
+				Channel:    n.Channel,
+				Payload:    n.Extra,
+				BePid:      n.BePid,
+				ReceivedAt: time.Now(),
+			})
+		case <-ticker.C:
+			go func() {
+				if err := conn.Ping(); err != nil {
+					log.Warn().Err(err).Msg("[PHASTOS][DB_LISTENER] ping failed")
+				}
+			}()
+		}
+	}
+}
+
+func (l *Listener) dispatch(ctx context.Context, notification *Notification) {
+	l.mu.RLock()
+	handlers := l.handlers[notification.Channel]
+	l.mu.RUnlock()
+
+	log := plog.Get()
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().Interface("panic", r).Str("channel", notification.Channel).Msg("[PHASTOS][DB_LISTENER] handler panic recovered")
+				}
+			}()
+			if err := handler(ctx, notification); err != nil {
+				log.Err(err).Str("channel", notification.Channel).Msg("[PHASTOS][DB_LISTENER] handler failed")
+			}
+		}()
+	}
+}
+
+// Decode unmarshals a JSON payload into v.
+func (n *Notification) Decode(v interface{}) error {
+	if err := json.Unmarshal([]byte(n.Payload), v); err != nil {
+		return errors.Wrap(err, "phastos.database.Notification.Decode")
+	}
+	return nil
+}
+
+// Notify sends a NOTIFY through the master connection (pg_notify), e.g. from
+// application code that wants to reach listeners in other services.
+func (this *SQL) Notify(ctx context.Context, channel, payload string) error {
+	if !this.IsPostgres() {
+		return errors.New("phastos.database.Notify: LISTEN/NOTIFY is only supported on postgres")
+	}
+	if _, err := this.Master.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return errors.Wrap(err, "phastos.database.Notify")
+	}
+	return nil
+}
+
+func listenerEventName(event pq.ListenerEventType) string {
+	switch event {
+	case pq.ListenerEventConnected:
+		return "connected"
+	case pq.ListenerEventDisconnected:
+		return "disconnected"
+	case pq.ListenerEventReconnected:
+		return "reconnected"
+	case pq.ListenerEventConnectionAttemptFailed:
+		return "connection_attempt_failed"
+	}
+	return "unknown"
+}
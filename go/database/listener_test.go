@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePgListener struct {
+	mu       sync.Mutex
+	listened map[string]bool
+	notify   chan *pq.Notification
+	closed   bool
+}
+
+func (f *fakePgListener) Listen(channel string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.listened[channel] {
+		return pq.ErrChannelAlreadyOpen
+	}
+	f.listened[channel] = true
+	return nil
+}
+
+func (f *fakePgListener) Unlisten(channel string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.listened[channel] {
+		return pq.ErrChannelNotOpen
+	}
+	delete(f.listened, channel)
+	return nil
+}
+
+func (f *fakePgListener) NotificationChannel() <-chan *pq.Notification { return f.notify }
+func (f *fakePgListener) Ping() error                                  { return nil }
+
+func (f *fakePgListener) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakePgListener) isListening(channel string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listened[channel]
+}
+
+func useFakePgListener(t *testing.T) *fakePgListener {
+	fake := &fakePgListener{listened: make(map[string]bool), notify: make(chan *pq.Notification, 10)}
+	original := newPgListener
+	newPgListener = func(string, time.Duration, time.Duration, pq.EventCallbackType) pgListener {
+		return fake
+	}
+	t.Cleanup(func() { newPgListener = original })
+	return fake
+}
+
+func TestListener_Dispatch(t *testing.T) {
+	fake := useFakePgListener(t)
+	l := NewListener("postgres://test")
+
+	received := make(chan *Notification, 1)
+	require.NoError(t, l.Subscribe("orders", func(ctx context.Context, n *Notification) error {
+		received <- n
+		return nil
+	}))
+	require.NoError(t, l.Start(context.Background()))
+	defer l.Stop()
+	assert.True(t, fake.isListening("orders"))
+
+	fake.notify <- &pq.Notification{Channel: "orders", Extra: `{"id":1}`, BePid: 42}
+	select {
+	case n := <-received:
+		assert.Equal(t, "orders", n.Channel)
+		assert.Equal(t, 42, n.BePid)
+		var payload struct {
+			ID int `json:"id"`
+		}
+		require.NoError(t, n.Decode(&payload))
+		assert.Equal(t, 1, payload.ID)
+	case <-time.After(time.Second):
+		t.Fatal("expected notification to be dispatched")
+	}
+}
+
+func TestListener_SubscribeAfterStartAndUnsubscribe(t *testing.T) {
+	fake := useFakePgListener(t)
+	l := NewListener("postgres://test")
+	require.NoError(t, l.Start(context.Background()))
+	defer l.Stop()
+
+	noop := func(ctx context.Context, n *Notification) error { return nil }
+	require.NoError(t, l.Subscribe("users", noop))
+	require.NoError(t, l.Subscribe("users", noop))
+	assert.True(t, fake.isListening("users"))
+	assert.Equal(t, []string{"users"}, l.Channels())
+
+	require.NoError(t, l.Unsubscribe("users"))
+	assert.False(t, fake.isListening("users"))
+	assert.Empty(t, l.Channels())
+	require.NoError(t, l.Unsubscribe("users"))
+}
+
+func TestListener_HandlerFailuresAreIsolated(t *testing.T) {
+	fake := useFakePgListener(t)
+	l := NewListener("postgres://test")
+
+	received := make(chan struct{}, 1)
+	require.NoError(t, l.Subscribe("orders", func(ctx context.Context, n *Notification) error {
+		panic("boom")
+	}))
+	require.NoError(t, l.Subscribe("orders", func(ctx context.Context, n *Notification) error {
+		return errors.New("failed")
+	}))
+	require.NoError(t, l.Subscribe("orders", func(ctx context.Context, n *Notification) error {
+		received <- struct{}{}
+		return nil
+	}))
+	require.NoError(t, l.Start(context.Background()))
+	defer l.Stop()
+
+	fake.notify <- &pq.Notification{Channel: "orders", Extra: "plain"}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("expected last handler to be called")
+	}
+}
+
+func TestListener_OnReconnect(t *testing.T) {
+	fake := useFakePgListener(t)
+	reconnected := make(chan struct{}, 1)
+	l := NewListener("postgres://test", WithOnReconnect(func(ctx context.Context) {
+		reconnected <- struct{}{}
+	}))
+	require.NoError(t, l.Start(context.Background()))
+	defer l.Stop()
+
+	fake.notify <- nil
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("expected reconnect callback")
+	}
+}
+
+func TestListener_StartStop(t *testing.T) {
+	t.Run("should fail without connection string", func(t *testing.T) {
+		t.Setenv("DATABASE_CONN_STRING_MASTER", "")
+		assert.Error(t, NewListener("").Start(context.Background()))
+	})
+
+	t.Run("should fail when already started", func(t *testing.T) {
+		fake := useFakePgListener(t)
+		l := NewListener("postgres://test")
+		require.NoError(t, l.Start(context.Background()))
+		assert.Error(t, l.Start(context.Background()))
+		require.NoError(t, l.Stop())
+		assert.True(t, fake.closed)
+		require.NoError(t, l.Stop())
+	})
+
+	t.Run("should stop a start waiting for an unreachable database", func(t *testing.T) {
+		l := NewListener("postgres://127.0.0.1:1/phastos?sslmode=disable&connect_timeout=1", WithReconnectInterval(10*time.Millisecond, 50*time.Millisecond))
+		require.NoError(t, l.Subscribe("orders", func(ctx context.Context, n *Notification) error { return nil }))
+
+		started := make(chan error, 1)
+		go func() { started <- l.Start(context.Background()) }()
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, l.Stop())
+
+		select {
+		case err := <-started:
+			assert.Error(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected Stop to unblock Start")
+		}
+	})
+
+	t.Run("should validate subscribe params", func(t *testing.T) {
+		l := NewListener("postgres://test")
+		assert.Error(t, l.Subscribe("", func(ctx context.Context, n *Notification) error { return nil }))
+		assert.Error(t, l.Subscribe("orders", nil))
+	})
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+// RegisterMetrics exposes the connection pool stats of the master and follower databases
+// (go_sql_* labelled db_name="master" / "follower") on the metrics registry
+func (this *SQL) RegisterMetrics() error {
+	if db := sqlDB(this.Master); db != nil {
+		if err := metrics.RegisterDBStats("master", db); err != nil {
+			return errors.Wrap(err, "phastos.database.RegisterMetrics.Master")
+		}
+	}
+	if db := sqlDB(this.Follower); db != nil {
+		if err := metrics.RegisterDBStats("follower", db); err != nil {
+			return errors.Wrap(err, "phastos.database.RegisterMetrics.Follower")
+		}
+	}
+	return nil
+}
+
+func sqlDB(db any) *sql.DB {
+	switch conn := db.(type) {
+	case *sqlx.DB:
+		if conn != nil {
+			return conn.DB
+		}
+	case *sql.DB:
+		return conn
+	}
+	return nil
+}
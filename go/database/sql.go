@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	"time"
 
 	sgw "github.com/ashwanthkumar/slack-go-webhook"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	_ "github.com/newrelic/go-agent/v3/integrations/nrmysql"
@@ -27,6 +29,7 @@ import (
 	"github.com/kodekoding/phastos/v2/go/env"
 	custerr "github.com/kodekoding/phastos/v2/go/error"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
 	"github.com/kodekoding/phastos/v2/go/monitoring"
 )
 
@@ -65,15 +68,72 @@ func Connect() (*SQL, error) {
 	return db, nil
 }
 
+// ConnectWithConfig connects to the master and follower of cfg (e.g. loaded by the config package)
+// instead of the DATABASE_* env. The follower defaults to the master when it is not configured.
+func ConnectWithConfig(cfg *SQLs) (*SQL, error) {
+	log := plog.Get()
+	engine := cfg.Master.Engine
+
+	masterDB, err := connectDBWithConfig(engine, cfg.Master)
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.database.ConnectWithConfig.Master")
+	}
+
+	followerDB := masterDB
+	if cfg.Follower.DSN(engine) != "" {
+		followerDB, err = connectDBWithConfig(engine, cfg.Follower)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.database.ConnectWithConfig.Follower")
+		}
+	}
+
+	db := newSQL(masterDB, followerDB)
+	db.engine = engine
+	if cfg.SlowQueryThreshold > 0 {
+		db.slowQueryThreshold = cfg.SlowQueryThreshold
+	}
+
+	log.Info().Msg(fmt.Sprintf("Successful connect to DB %s", engine))
+	return db, nil
+}
+
+// DSN returns ConnString, or the connection string built from the host, port, credentials and
+// database name for the postgres and mysql engines
+func (c SQLConfig) DSN(engine string) string {
+	if c.ConnString != "" || c.Host == "" {
+		return c.ConnString
+	}
+	if mySQLEngineGroup[engine] {
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", c.Username, c.Password, c.Host, c.Port, c.DBName)
+	}
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   c.Host,
+		Path:   c.DBName,
+	}
+	if c.Port != "" {
+		dsn.Host = c.Host + ":" + c.Port
+	}
+	return dsn.String()
+}
+
 func connectDB(engine string, dbType string) (*sqlx.DB, error) {
+	cfg := SQLConfig{ConnString: os.Getenv(fmt.Sprintf("DATABASE_CONN_STRING_%s", dbType))}
+	cfg.MaxConnLifetime, _ = strconv.Atoi(os.Getenv("DATABASE_CONN_MAX_LIFETIME"))
+	cfg.MaxIdleTime, _ = strconv.Atoi(os.Getenv("DATABASE_CONN_MAX_IDLE_TIME"))
+	cfg.MaxOpenConn, _ = strconv.Atoi(os.Getenv("DATABASE_MAX_OPEN_CONN"))
+	cfg.MaxIdleConn, _ = strconv.Atoi(os.Getenv("DATABASE_MAX_IDLE_CONN"))
+	return connectDBWithConfig(engine, cfg)
+}
 
-	connString := os.Getenv(fmt.Sprintf("DATABASE_CONN_STRING_%s", dbType))
-	db, err := sqlx.Connect(engine, connString)
+func connectDBWithConfig(engine string, cfg SQLConfig) (*sqlx.DB, error) {
+	db, err := sqlx.Connect(engine, cfg.DSN(engine))
 	if err != nil {
 		return nil, errors.Wrap(err, "phastos.database.Connect")
 	}
 
-	cfgMaxConnLifeTime, _ := strconv.Atoi(os.Getenv("DATABASE_CONN_MAX_LIFETIME"))
+	cfgMaxConnLifeTime := cfg.MaxConnLifetime
 	if cfgMaxConnLifeTime == 0 {
 		// set default max conn lifetime to 5 minutes
 		cfgMaxConnLifeTime = 300
@@ -81,9 +141,7 @@ func connectDB(engine string, dbType string) (*sqlx.DB, error) {
 	maxLifetime := time.Duration(cfgMaxConnLifeTime) * time.Second
 	db.SetConnMaxLifetime(maxLifetime)
 
-	cfgMaxIdleTime, _ := strconv.Atoi(os.Getenv("DATABASE_CONN_MAX_IDLE_TIME"))
-
-	maxIdleTime := time.Duration(cfgMaxIdleTime) * time.Second
+	maxIdleTime := time.Duration(cfg.MaxIdleTime) * time.Second
 	if maxIdleTime == 0 {
 		// set default max iddle time to 45 seconds
 		maxIdleTime = 45
@@ -91,13 +149,13 @@ func connectDB(engine string, dbType string) (*sqlx.DB, error) {
 	db.SetConnMaxIdleTime(maxIdleTime)
 
 	// set maximum open connection to DB
-	maxOpenConn, _ := strconv.Atoi(os.Getenv("DATABASE_MAX_OPEN_CONN"))
+	maxOpenConn := cfg.MaxOpenConn
 	if maxOpenConn == 0 {
 		maxOpenConn = 10
 	}
 	db.SetMaxOpenConns(maxOpenConn)
 
-	maxIdleConn, _ := strconv.Atoi(os.Getenv("DATABASE_MAX_IDLE_CONN"))
+	maxIdleConn := cfg.MaxIdleConn
 	if maxIdleConn == 0 {
 		maxIdleConn = 2
 	}
@@ -106,6 +164,13 @@ func connectDB(engine string, dbType string) (*sqlx.DB, error) {
 }
 
 func (this *SQL) Read(ctx context.Context, opts *QueryOpts, additionalParams ...interface{}) error {
+	start := time.Now()
+	err := this.read(ctx, opts, additionalParams...)
+	metrics.ObserveDBQuery(metricsOperationRead, start, err)
+	return err
+}
+
+func (this *SQL) read(ctx context.Context, opts *QueryOpts, additionalParams ...interface{}) error {
 	ctx, span := monitoring.StartSpan(ctx, "PhastosDB-Read")
 	defer span.End()
 	span.SetAttributes(attribute.String("db.system", this.engine))
@@ -603,6 +668,17 @@ func (this *SQL) CachedRebind(query string) string {
 }
 
 func (this *SQL) Write(ctx context.Context, opts *QueryOpts, isSoftDelete ...bool) (*CUDResponse, error) {
+	start := time.Now()
+	result, err := this.write(ctx, opts, isSoftDelete...)
+	operation := metricsOperationUnknown
+	if opts.CUDRequest != nil {
+		operation = opts.CUDRequest.Action
+	}
+	metrics.ObserveDBQuery(operation, start, err)
+	return result, err
+}
+
+func (this *SQL) write(ctx context.Context, opts *QueryOpts, isSoftDelete ...bool) (*CUDResponse, error) {
 	ctx, span := monitoring.StartSpan(ctx, "PhastosDB-Write")
 	defer span.End()
 	span.SetAttributes(attribute.String("db.system", this.engine))
@@ -1068,25 +1144,59 @@ func sendNilResponse(err error, ctxMsg string, params ...interface{}) (interface
 	}
 
 	statusCode := 500
+	customErr := custerr.New(err)
 
 	var pqErr *pq.Error
-	if errors.As(err, &pqErr) {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &pqErr):
 		switch pqErr.Code {
 		case "23505": // unique_violation
 			statusCode = 409
 		case "23514": // check_violation
 			statusCode = 422
 		}
-	}
-
-	customErr := custerr.New(err).SetCode(statusCode)
-	if pqErr != nil {
 		customErr.AppendData("constraint", pqErr.Constraint)  //nolint:errcheck
 		customErr.AppendData("sql_state", string(pqErr.Code)) //nolint:errcheck
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case 1062: // ER_DUP_ENTRY
+			statusCode = 409
+		case 3819: // ER_CHECK_CONSTRAINT_VIOLATED
+			statusCode = 422
+		}
+		customErr.AppendData("constraint", mysqlConstraintName(mysqlErr.Message)) //nolint:errcheck
+		customErr.AppendData("sql_state", strconv.Itoa(int(mysqlErr.Number)))     //nolint:errcheck
 	}
+	customErr.SetCode(statusCode) //nolint:errcheck
+
 	for i, paramValue := range params {
 		keyParam := fmt.Sprintf("param %d", i+1)
 		customErr.AppendData(keyParam, paramValue) //nolint:errcheck
 	}
 	return nil, errors.Wrap(customErr, ctxMsg)
 }
+
+// mysqlConstraintName extracts the key / constraint name from a MySQL error message, e.g.
+// "Duplicate entry 'a@b.c' for key 'users.users_email_key'" or "Check constraint 'chk_age' is violated."
+func mysqlConstraintName(message string) string {
+	var start int
+	switch {
+	case strings.Contains(message, "for key '"):
+		start = strings.LastIndex(message, "for key '") + len("for key '")
+	case strings.HasPrefix(message, "Check constraint '"):
+		start = len("Check constraint '")
+	default:
+		return ""
+	}
+	end := strings.Index(message[start:], "'")
+	if end < 0 {
+		return ""
+	}
+	name := message[start : start+end]
+	// MySQL 8 prefixes the key with the table name
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return name
+}
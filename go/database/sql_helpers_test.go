@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/go-sql-driver/mysql"
 	custerr "github.com/kodekoding/phastos/v2/go/error"
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
@@ -685,3 +686,30 @@ func TestEvictWriteStmt_NoPanic(t *testing.T) {
 	// Evicting a non-existent key should not panic
 	EvictWriteStmt("nonexistent-query")
 }
+
+func TestSendNilResponse_MySQLDuplicateEntry(t *testing.T) {
+	mysqlErr := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.users_email_key'"}
+	result, err := sendNilResponse(errors.Wrap(mysqlErr, "outer wrap"), "test.context")
+	assert.Nil(t, result)
+	require.Error(t, err)
+
+	var reqErr *custerr.RequestError
+	require.True(t, errors.As(err, &reqErr))
+	assert.Equal(t, 409, reqErr.GetCode())
+	assert.Equal(t, "users_email_key", reqErr.GetData()["constraint"])
+	assert.Equal(t, "1062", reqErr.GetData()["sql_state"])
+}
+
+func TestSendNilResponse_MySQLOtherErrors(t *testing.T) {
+	_, err := sendNilResponse(&mysql.MySQLError{Number: 3819, Message: "Check constraint 'chk_age' is violated."}, "test.context")
+	var reqErr *custerr.RequestError
+	require.True(t, errors.As(err, &reqErr))
+	assert.Equal(t, 422, reqErr.GetCode())
+	assert.Equal(t, "chk_age", reqErr.GetData()["constraint"])
+
+	_, err = sendNilResponse(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, "test.context")
+	require.True(t, errors.As(err, &reqErr))
+	assert.Equal(t, 500, reqErr.GetCode())
+	assert.Equal(t, "", reqErr.GetData()["constraint"])
+	assert.Equal(t, "1452", reqErr.GetData()["sql_state"])
+}
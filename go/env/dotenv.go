@@ -0,0 +1,364 @@
+package env
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type (
+	// FileOption configures SetFromEnvFile and Load
+	FileOption func(*fileOptions)
+
+	fileOptions struct {
+		noOverride bool
+		strict     bool
+	}
+
+	// ParseError is a syntax error of a dotenv file
+	ParseError struct {
+		File string
+		Line int
+		Msg  string
+	}
+
+	// entry is a variable parsed from a dotenv file
+	entry struct {
+		key   string
+		value string
+	}
+)
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// WithNoOverride keeps the variables already set in the process environment; the variables set
+// by the previous files of Load are still overridden
+func WithNoOverride() FileOption {
+	return func(o *fileOptions) {
+		o.noOverride = true
+	}
+}
+
+// WithStrict fails on the lines without "=", on the characters after a quoted value and on the
+// undefined variables of ${VAR}, which are otherwise skipped, ignored and expanded to ""
+func WithStrict() FileOption {
+	return func(o *fileOptions) {
+		o.strict = true
+	}
+}
+
+// Load sets the variables of .env, .env.local and .env.{APPS_ENV} of the working directory, in that
+// order, each file overriding the previous ones. APPS_ENV may be set by .env or .env.local.
+// The missing files are skipped.
+func Load(opts ...FileOption) error {
+	l := newLoader(opts)
+	for _, path := range []string{".env", ".env.local", ""} {
+		if path == "" {
+			path = ".env." + ServiceEnv()
+		}
+		if err := l.loadFile(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loader sets the variables of dotenv files, protecting the variables of the process environment
+// set before it was created when noOverride is set
+type loader struct {
+	opts      *fileOptions
+	protected map[string]bool
+}
+
+func newLoader(opts []FileOption) *loader {
+	l := &loader{opts: &fileOptions{}}
+	for _, opt := range opts {
+		opt(l.opts)
+	}
+	if l.opts.noOverride {
+		l.protected = make(map[string]bool)
+		for _, kv := range os.Environ() {
+			key, _, _ := strings.Cut(kv, "=")
+			l.protected[key] = true
+		}
+	}
+	return l
+}
+
+func (l *loader) loadFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	f, err := osOpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	entries, err := parseDotEnv(path, string(content), l.opts.strict, l.protected)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if l.protected[e.key] {
+			continue
+		}
+		if err = os.Setenv(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseDotEnv parses a dotenv file:
+//
+//	# comment
+//	export KEY=value          # inline comment after a space
+//	KEY = ${OTHER:-default}/path
+//	KEY='literal $NOT_EXPANDED'
+//	KEY="escaped \"quotes\", \n newlines and ${EXPANDED}"
+//	KEY="multi
+//	line"
+//
+// ${VAR} and $VAR are looked up in the previous entries of the file, then in the process environment,
+// which wins for the protected variables.
+func parseDotEnv(file, src string, strict bool, protected map[string]bool) ([]entry, error) {
+	p := &dotEnvParser{file: file, strict: strict, protected: protected, values: make(map[string]string)}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var entries []entry
+	for i := 0; i < len(lines); i++ {
+		p.line = i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export"); ok && (strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "\t")) {
+			line = strings.TrimSpace(rest)
+		}
+
+		key, raw, found := strings.Cut(line, "=")
+		if !found {
+			if strict {
+				return nil, p.errorf("missing '=' in %q", line)
+			}
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if !validKey(key) {
+			return nil, p.errorf("invalid variable name %q", key)
+		}
+
+		raw = strings.TrimLeft(raw, " \t")
+		var value string
+		var err error
+		if raw != "" && (raw[0] == '"' || raw[0] == '\'') {
+			value, i, err = p.quoted(lines, i, raw)
+		} else {
+			value, err = p.unquoted(raw)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.values[key] = value
+		entries = append(entries, entry{key: key, value: value})
+	}
+	return entries, nil
+}
+
+type dotEnvParser struct {
+	file      string
+	line      int
+	strict    bool
+	protected map[string]bool
+	values    map[string]string
+}
+
+func (p *dotEnvParser) errorf(format string, args ...any) error {
+	return &ParseError{File: p.file, Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func validKey(key string) bool {
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		return false
+	}
+	for _, c := range key {
+		if !(c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// unquoted returns raw without its inline comment, expanded
+func (p *dotEnvParser) unquoted(raw string) (string, error) {
+	if strings.HasPrefix(raw, "#") {
+		return "", nil
+	}
+	if idx := strings.Index(raw, " #"); idx != -1 {
+		raw = raw[:idx]
+	}
+	if idx := strings.Index(raw, "\t#"); idx != -1 {
+		raw = raw[:idx]
+	}
+	return p.expand(strings.TrimSpace(raw))
+}
+
+// quoted returns the value starting with a quote at lines[i], which may end on a following line,
+// and the index of the line where it ends
+func (p *dotEnvParser) quoted(lines []string, i int, raw string) (string, int, error) {
+	quote := raw[0]
+	text := raw[1:]
+	start := p.line
+	for {
+		if end := closingQuote(text, quote); end != -1 {
+			if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") && p.strict {
+				return "", i, p.errorf("unexpected %q after the quoted value", rest)
+			}
+			text = text[:end]
+			break
+		}
+		if i+1 >= len(lines) {
+			p.line = start
+			return "", i, p.errorf("unterminated quoted value")
+		}
+		i++
+		p.line = i + 1
+		text += "\n" + lines[i]
+	}
+
+	if quote == '\'' {
+		return text, i, nil
+	}
+	value, err := p.doubleQuoted(text)
+	return value, i, err
+}
+
+// closingQuote returns the index of the quote closing text, skipping the escaped ones in double quotes
+func closingQuote(text string, quote byte) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// doubleQuoted unescapes \n, \r, \t, \", \\ and \$ and expands the variables of text
+func (p *dotEnvParser) doubleQuoted(text string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			i++
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(text[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(text[i])
+			}
+		case c == '$':
+			value, n, err := p.variable(text[i:])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += n - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// expand replaces the variables of s
+func (p *dotEnvParser) expand(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		value, n, err := p.variable(s[i:])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		i += n - 1
+	}
+	return b.String(), nil
+}
+
+// variable expands the $VAR, ${VAR}, ${VAR:-default} or ${VAR-default} at the start of s, returning
+// its value and length. A "$" not followed by a name is kept.
+func (p *dotEnvParser) variable(s string) (string, int, error) {
+	if strings.HasPrefix(s, "${") {
+		end := strings.IndexByte(s, '}')
+		if end == -1 {
+			return "", 0, p.errorf("unterminated variable %q", s)
+		}
+		name, def, hasDefault := s[2:end], "", false
+		emptyIsUnset := false
+		if idx := strings.Index(name, ":-"); idx != -1 {
+			name, def, hasDefault, emptyIsUnset = name[:idx], name[idx+2:], true, true
+		} else if idx = strings.IndexByte(name, '-'); idx != -1 {
+			name, def, hasDefault = name[:idx], name[idx+1:], true
+		}
+		value, ok := p.get(name)
+		if hasDefault && (!ok || (emptyIsUnset && value == "")) {
+			value, err := p.expand(def)
+			return value, end + 1, err
+		}
+		if !ok && p.strict {
+			return "", 0, p.errorf("undefined variable %s", name)
+		}
+		return value, end + 1, nil
+	}
+
+	n := 1
+	for n < len(s) && (s[n] == '_' || (s[n] >= 'a' && s[n] <= 'z') || (s[n] >= 'A' && s[n] <= 'Z') || (n > 1 && s[n] >= '0' && s[n] <= '9')) {
+		n++
+	}
+	if n == 1 {
+		return "$", 1, nil
+	}
+	value, ok := p.get(s[1:n])
+	if !ok && p.strict {
+		return "", 0, p.errorf("undefined variable %s", s[1:n])
+	}
+	return value, n, nil
+}
+
+// get returns the value of a previous entry of the file, else the one of the process environment
+func (p *dotEnvParser) get(name string) (string, bool) {
+	if value, ok := p.values[name]; ok && !p.protected[name] {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
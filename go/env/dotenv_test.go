@@ -0,0 +1,117 @@
+package env_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/env"
+)
+
+func TestSetFromEnvFile_Syntax(t *testing.T) {
+	t.Setenv("DOTENV_UNDEFINED", "")
+	require.NoError(t, env.SetFromEnvFile("testfile/full.env"))
+
+	expected := map[string]string{
+		"DOTENV_EXPORTED": "exported",
+		"DOTENV_SPACED":   "spaced value",
+		"DOTENV_HASH":     "color#fff",
+		"DOTENV_SINGLE":   `literal $DOTENV_EXPORTED \n`,
+		"DOTENV_DOUBLE":   "line1\nline2 \"quoted\" $HOME exported",
+		"DOTENV_MULTI":    "first\nsecond",
+		"DOTENV_EXPANDED": "exported/spaced value",
+		"DOTENV_DEFAULT":  "fallback",
+		"DOTENV_EMPTY":    "",
+	}
+	for key, value := range expected {
+		assert.Equal(t, value, os.Getenv(key), key)
+	}
+
+	require.NoError(t, env.SetFromEnvFile("testfile/crlf.env"))
+	assert.Equal(t, "crlf", os.Getenv("DOTENV_CRLF"))
+	assert.Equal(t, "ok", os.Getenv("DOTENV_AFTER_CRLF"))
+}
+
+func writeEnvFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestSetFromEnvFile_Strict(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]struct {
+		content string
+		line    int
+		lenient bool
+	}{
+		"missing equal":     {content: "A=1\nNOEQUALS\n", line: 2, lenient: true},
+		"after quote":       {content: "A=1\n\nB=\"x\" y\n", line: 3, lenient: true},
+		"undefined":         {content: "B=${DOTENV_NOT_SET}\n", line: 1, lenient: true},
+		"unterminated":      {content: "A=1\nB=\"open\nC=2\n", line: 2},
+		"invalid name":      {content: "A=1\n1A=2\n", line: 2},
+		"unterminated expr": {content: "A=${B\n", line: 1},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := writeEnvFile(t, dir, "case.env", c.content)
+
+			err := env.SetFromEnvFile(path, env.WithStrict())
+			var parseErr *env.ParseError
+			require.True(t, errors.As(err, &parseErr), "%v", err)
+			assert.Equal(t, c.line, parseErr.Line)
+			assert.Equal(t, path, parseErr.File)
+
+			if c.lenient {
+				assert.NoError(t, env.SetFromEnvFile(path))
+			} else {
+				assert.Error(t, env.SetFromEnvFile(path))
+			}
+		})
+	}
+}
+
+func TestSetFromEnvFile_NoOverride(t *testing.T) {
+	t.Setenv("DOTENV_PRESET", "process")
+	t.Setenv("DOTENV_NEW", "")
+	os.Unsetenv("DOTENV_NEW") //nolint:errcheck
+	path := writeEnvFile(t, t.TempDir(), ".env", "DOTENV_PRESET=file\nDOTENV_NEW=file\nDOTENV_REF=${DOTENV_PRESET}\n")
+
+	require.NoError(t, env.SetFromEnvFile(path, env.WithNoOverride()))
+	assert.Equal(t, "process", os.Getenv("DOTENV_PRESET"))
+	assert.Equal(t, "file", os.Getenv("DOTENV_NEW"))
+	assert.Equal(t, "process", os.Getenv("DOTENV_REF"), "expansions use the effective value")
+
+	require.NoError(t, env.SetFromEnvFile(path))
+	assert.Equal(t, "file", os.Getenv("DOTENV_PRESET"))
+}
+
+func TestLoad_Layers(t *testing.T) {
+	dir := t.TempDir()
+	writeEnvFile(t, dir, ".env", "APPS_ENV=staging\nLAYER_BASE=base\nLAYER_VALUE=base\nLAYER_PRESET=base\n")
+	writeEnvFile(t, dir, ".env.local", "LAYER_VALUE=local\n")
+	writeEnvFile(t, dir, ".env.staging", "LAYER_VALUE=${LAYER_VALUE}-staging\n")
+	writeEnvFile(t, dir, ".env.production", "LAYER_VALUE=production\n")
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd) //nolint:errcheck
+
+	for _, key := range []string{"APPS_ENV", "LAYER_BASE", "LAYER_VALUE"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key) //nolint:errcheck
+	}
+	t.Setenv("LAYER_PRESET", "process")
+
+	require.NoError(t, env.Load(env.WithNoOverride()))
+	assert.Equal(t, "staging", env.ServiceEnv(), "APPS_ENV set by .env selects the environment file")
+	assert.Equal(t, "base", os.Getenv("LAYER_BASE"))
+	assert.Equal(t, "local-staging", os.Getenv("LAYER_VALUE"), "each file overrides the previous ones")
+	assert.Equal(t, "process", os.Getenv("LAYER_PRESET"))
+}
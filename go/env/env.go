@@ -1,11 +1,9 @@
 package env
 
 import (
-	"bufio"
 	"log"
 	"os"
 	"runtime"
-	"strings"
 )
 
 // ServiceNameEnv type, not used anymore. Preserve to prevent breaking others.
@@ -30,37 +28,17 @@ var (
 )
 
 func init() {
-	// env package will read .env file when application is started
-	err := SetFromEnvFile(".env")
-	if err != nil && !os.IsNotExist(err) {
+	// env package will read .env, .env.local and .env.{APPS_ENV} files when application is started
+	err := Load()
+	if err != nil {
 		log.Printf("failed to set env file: %v\n", err)
 	}
 	goVersion = runtime.Version()
 }
 
-// SetFromEnvFile read env file and set the environment variables
-func SetFromEnvFile(filepath string) error {
-	if _, err := os.Stat(filepath); err != nil {
-		return err
-	}
-
-	f, err := osOpenFile(filepath)
-	if err != nil {
-		return err
-	}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		text := scanner.Text()
-		text = strings.TrimSpace(text)
-		vars := strings.SplitN(text, "=", 2)
-		if len(vars) < 2 {
-			return err
-		}
-		if err := os.Setenv(vars[0], vars[1]); err != nil {
-			return err
-		}
-	}
-	return nil
+// SetFromEnvFile read env file and set the environment variables, see Load for the format and the options
+func SetFromEnvFile(filepath string, opts ...FileOption) error {
+	return newLoader(opts).loadFile(filepath)
 }
 
 // ServiceEnv return ServiceENV service environment
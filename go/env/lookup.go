@@ -0,0 +1,86 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is a type Lookup parses an env variable into
+type Value interface {
+	string | bool | int | int8 | int16 | int32 | int64 | uint | uint8 | uint16 | uint32 | uint64 |
+		float32 | float64 | time.Duration | []string
+}
+
+// Lookup returns the env variable key parsed as T: durations as "1m30s", slices as comma-separated values.
+// It returns def when the variable is unset or empty, and def with an error when it does not parse.
+//
+//	timeout, err := env.Lookup("REDIS_TIMEOUT", 3*time.Second)
+//	origins, _ := env.Lookup[[]string]("CORS_ORIGIN", nil)
+func Lookup[T Value](key string, def T) (T, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+
+	var value T
+	var err error
+	switch v := any(&value).(type) {
+	case *string:
+		*v = raw
+	case *bool:
+		*v, err = strconv.ParseBool(raw)
+	case *int:
+		*v, err = strconv.Atoi(raw)
+	case *int8:
+		err = parseInt(raw, 8, v)
+	case *int16:
+		err = parseInt(raw, 16, v)
+	case *int32:
+		err = parseInt(raw, 32, v)
+	case *int64:
+		err = parseInt(raw, 64, v)
+	case *uint:
+		err = parseUint(raw, strconv.IntSize, v)
+	case *uint8:
+		err = parseUint(raw, 8, v)
+	case *uint16:
+		err = parseUint(raw, 16, v)
+	case *uint32:
+		err = parseUint(raw, 32, v)
+	case *uint64:
+		err = parseUint(raw, 64, v)
+	case *float32:
+		var f float64
+		f, err = strconv.ParseFloat(raw, 32)
+		*v = float32(f)
+	case *float64:
+		*v, err = strconv.ParseFloat(raw, 64)
+	case *time.Duration:
+		*v, err = time.ParseDuration(raw)
+	case *[]string:
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	}
+	if err != nil {
+		return def, fmt.Errorf("env: invalid %s %q: %w", key, raw, err)
+	}
+	return value, nil
+}
+
+func parseInt[T int8 | int16 | int32 | int64](raw string, bits int, v *T) error {
+	i, err := strconv.ParseInt(raw, 10, bits)
+	*v = T(i)
+	return err
+}
+
+func parseUint[T uint | uint8 | uint16 | uint32 | uint64](raw string, bits int, v *T) error {
+	u, err := strconv.ParseUint(raw, 10, bits)
+	*v = T(u)
+	return err
+}
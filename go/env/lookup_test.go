@@ -0,0 +1,58 @@
+package env_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/env"
+)
+
+func TestLookup(t *testing.T) {
+	t.Setenv("LOOKUP_INT", "42")
+	t.Setenv("LOOKUP_BOOL", "true")
+	t.Setenv("LOOKUP_FLOAT", "0.5")
+	t.Setenv("LOOKUP_DURATION", "1m30s")
+	t.Setenv("LOOKUP_LIST", "a, b,,c")
+	t.Setenv("LOOKUP_UINT8", "255")
+	t.Setenv("LOOKUP_EMPTY", "")
+	t.Setenv("LOOKUP_INVALID", "forty-two")
+
+	i, err := env.Lookup("LOOKUP_INT", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 42, i)
+
+	b, err := env.Lookup("LOOKUP_BOOL", false)
+	require.NoError(t, err)
+	assert.True(t, b)
+
+	f, err := env.Lookup("LOOKUP_FLOAT", 1.0)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, f)
+
+	d, err := env.Lookup("LOOKUP_DURATION", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	list, err := env.Lookup[[]string]("LOOKUP_LIST", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, list)
+
+	u, err := env.Lookup[uint8]("LOOKUP_UINT8", 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(255), u)
+
+	s, err := env.Lookup("LOOKUP_EMPTY", "default")
+	require.NoError(t, err)
+	assert.Equal(t, "default", s, "empty is unset")
+
+	s, err = env.Lookup("LOOKUP_UNSET", "default")
+	require.NoError(t, err)
+	assert.Equal(t, "default", s)
+
+	i, err = env.Lookup("LOOKUP_INVALID", 7)
+	assert.ErrorContains(t, err, "LOOKUP_INVALID")
+	assert.Equal(t, 7, i)
+}
@@ -0,0 +1,104 @@
+package health
+
+import (
+	"context"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+	"github.com/kodekoding/phastos/v2/go/cron"
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/sse"
+	"github.com/kodekoding/phastos/v2/go/ws"
+)
+
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type runningChecker interface {
+	IsRunning() bool
+}
+
+// DBMaster checks the master connection of db.
+func DBMaster(db *database.SQL) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if db == nil || db.Master == nil {
+			return errors.New("master database is not initialized")
+		}
+		return errors.Wrap(pingDB(ctx, db.Master), "phastos.health.DBMaster")
+	})
+}
+
+// DBFollower checks the follower connection of db.
+func DBFollower(db *database.SQL) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if db == nil || db.Follower == nil {
+			return errors.New("follower database is not initialized")
+		}
+		return errors.Wrap(pingDB(ctx, db.Follower), "phastos.health.DBFollower")
+	})
+}
+
+// pingDB uses PingContext when the connection supports it (sqlx.DB), SELECT 1 otherwise.
+func pingDB(ctx context.Context, db database.Follower) error {
+	if p, ok := db.(pinger); ok {
+		return p.PingContext(ctx)
+	}
+	var one int
+	return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// Redis sends a PING through the pool of store.
+func Redis(store *cache.Store) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if store == nil || store.Pool == nil {
+			return errors.New("redis is not initialized")
+		}
+		conn, err := store.Pool.GetContext(ctx)
+		if err != nil {
+			return errors.Wrap(err, "phastos.health.Redis.GetContext")
+		}
+		defer conn.Close() //nolint:errcheck
+
+		if _, err = redigo.String(conn.Do("PING")); err != nil {
+			return errors.Wrap(err, "phastos.health.Redis.Ping")
+		}
+		return nil
+	})
+}
+
+// SSEHub checks that the SSE hub is running.
+func SSEHub(hub *sse.Hub) Checker {
+	return CheckerFunc(func(context.Context) error {
+		if hub == nil || !hub.IsRunning() {
+			return errors.New("SSE hub is not running")
+		}
+		return nil
+	})
+}
+
+// WebSocketHub checks that the WebSocket hub is running.
+func WebSocketHub(hub *ws.Hub) Checker {
+	return CheckerFunc(func(context.Context) error {
+		if hub == nil || !hub.IsRunning() {
+			return errors.New("WebSocket hub is not running")
+		}
+		return nil
+	})
+}
+
+// Cron checks that the cron engine is running.
+func Cron(engine cron.Engines) Checker {
+	return CheckerFunc(func(context.Context) error {
+		running, ok := engine.(runningChecker)
+		if !ok {
+			return nil
+		}
+		if !running.IsRunning() {
+			return errors.New("cron engine is not running")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kodekoding/phastos/v2/go/cron"
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/sse"
+	"github.com/kodekoding/phastos/v2/go/ws"
+)
+
+type stubCronEngine struct {
+	running bool
+}
+
+func (s *stubCronEngine) RegisterScheduler(string, cron.HandlerFunc) {}
+func (s *stubCronEngine) RemoveScheduler(string)                     {}
+func (s *stubCronEngine) Start()                                     {}
+func (s *stubCronEngine) Stop()                                      {}
+func (s *stubCronEngine) Wrap(cron.Wrapper)                          {}
+func (s *stubCronEngine) IsRunning() bool                            { return s.running }
+
+func TestDBCheckers_NotInitialized(t *testing.T) {
+	ctx := context.Background()
+	assert.Error(t, DBMaster(nil).Check(ctx))
+	assert.Error(t, DBFollower(&database.SQL{}).Check(ctx))
+}
+
+func TestRedisChecker_NotInitialized(t *testing.T) {
+	assert.Error(t, Redis(nil).Check(context.Background()))
+}
+
+func TestSSEHubChecker(t *testing.T) {
+	ctx := context.Background()
+	assert.Error(t, SSEHub(nil).Check(ctx))
+	assert.Error(t, SSEHub(sse.NewHub(ctx)).Check(ctx))
+}
+
+func TestWebSocketHubChecker(t *testing.T) {
+	ctx := context.Background()
+	assert.Error(t, WebSocketHub(nil).Check(ctx))
+
+	hub := ws.NewHub(ctx)
+	assert.Error(t, WebSocketHub(hub).Check(ctx))
+	go hub.Run()
+	defer hub.Stop()
+	assert.Eventually(t, func() bool { return WebSocketHub(hub).Check(ctx) == nil }, time.Second, 5*time.Millisecond)
+}
+
+func TestCronChecker(t *testing.T) {
+	ctx := context.Background()
+	engine := &stubCronEngine{}
+	assert.Error(t, Cron(engine).Check(ctx))
+	engine.running = true
+	assert.NoError(t, Cron(engine).Check(ctx))
+}
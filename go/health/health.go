@@ -0,0 +1,284 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+
+	ContentHealthJSON = "application/health+json"
+
+	defaultTimeout  = 3 * time.Second
+	defaultCacheTTL = 5 * time.Second
+)
+
+// ErrShuttingDown is reported by the readiness probe once graceful shutdown started.
+var ErrShuttingDown = errors.New("service is shutting down")
+
+type (
+	// Checker checks a single dependency. A nil error means healthy.
+	Checker interface {
+		Check(ctx context.Context) error
+	}
+
+	// CheckerFunc adapts a function to the Checker interface.
+	CheckerFunc func(ctx context.Context) error
+
+	// CheckOption configures a registered check.
+	CheckOption func(*check)
+
+	check struct {
+		name     string
+		checker  Checker
+		timeout  time.Duration
+		cacheTTL time.Duration
+		liveness bool
+		optional bool
+
+		mu     sync.Mutex
+		result *CheckResult
+	}
+
+	// Registry holds the checks behind the liveness (/healthz) and readiness (/readyz) probes.
+	Registry struct {
+		mu           sync.RWMutex
+		checks       []*check
+		shuttingDown atomic.Bool
+	}
+
+	// Report is the JSON body of the probes.
+	Report struct {
+		Status string                 `json:"status"`
+		Error  string                 `json:"error,omitempty"`
+		Checks map[string]CheckResult `json:"checks,omitempty"`
+	}
+
+	// CheckResult is the outcome of a single check.
+	CheckResult struct {
+		Status     string    `json:"status"`
+		Error      string    `json:"error,omitempty"`
+		DurationMs float64   `json:"duration_ms"`
+		CheckedAt  time.Time `json:"checked_at"`
+		Cached     bool      `json:"cached,omitempty"`
+	}
+)
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// WithTimeout sets how long the check may run before it fails (default 3s).
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// WithCacheTTL sets how long a result is reused before the check runs again (default 5s, 0 disables caching).
+func WithCacheTTL(ttl time.Duration) CheckOption {
+	return func(c *check) {
+		c.cacheTTL = ttl
+	}
+}
+
+// WithLiveness also runs the check in the liveness probe. Only use it for failures a
+// restart can fix (deadlocks, exhausted resources), never for external dependencies.
+func WithLiveness() CheckOption {
+	return func(c *check) {
+		c.liveness = true
+	}
+}
+
+// WithOptional reports a failing check as "warn" without failing the probe.
+func WithOptional() CheckOption {
+	return func(c *check) {
+		c.optional = true
+	}
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check. A check registered with an existing name replaces it.
+func (r *Registry) Register(name string, checker Checker, opts ...CheckOption) {
+	c := &check{
+		name:     name,
+		checker:  checker,
+		timeout:  defaultTimeout,
+		cacheTTL: defaultCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.checks {
+		if existing.name == name {
+			r.checks[i] = c
+			return
+		}
+	}
+	r.checks = append(r.checks, c)
+}
+
+// Has reports whether a check with the given name is registered.
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.checks {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetShuttingDown makes the readiness probe fail, so the load balancer drains the instance.
+func (r *Registry) SetShuttingDown() {
+	r.shuttingDown.Store(true)
+}
+
+// IsShuttingDown reports whether SetShuttingDown was called.
+func (r *Registry) IsShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Liveness runs the checks registered WithLiveness.
+func (r *Registry) Liveness(ctx context.Context) *Report {
+	return r.run(ctx, true)
+}
+
+// Readiness runs every check. It fails right away once shutdown started.
+func (r *Registry) Readiness(ctx context.Context) *Report {
+	if r.IsShuttingDown() {
+		return &Report{Status: StatusFail, Error: ErrShuttingDown.Error()}
+	}
+	return r.run(ctx, false)
+}
+
+// LivenessHandler serves the liveness report (/healthz).
+func (r *Registry) LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Liveness(req.Context()))
+	}
+}
+
+// ReadinessHandler serves the readiness report (/readyz).
+func (r *Registry) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Readiness(req.Context()))
+	}
+}
+
+func (r *Registry) run(ctx context.Context, livenessOnly bool) *Report {
+	r.mu.RLock()
+	checks := make([]*check, 0, len(r.checks))
+	for _, c := range r.checks {
+		if !livenessOnly || c.liveness {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	report := &Report{Status: StatusPass, Checks: make(map[string]CheckResult, len(checks))}
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		result := results[i]
+		report.Checks[c.name] = result
+		switch {
+		case result.Status == StatusFail:
+			report.Status = StatusFail
+		case result.Status == StatusWarn && report.Status == StatusPass:
+			report.Status = StatusWarn
+		}
+	}
+	return report
+}
+
+func (c *check) run(ctx context.Context) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.result != nil && c.cacheTTL > 0 && time.Since(c.result.CheckedAt) < c.cacheTTL {
+		cached := *c.result
+		cached.Cached = true
+		return cached
+	}
+
+	start := time.Now()
+	err := c.checkWithTimeout(ctx)
+	result := CheckResult{
+		Status:     StatusPass,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt:  start,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		if c.optional {
+			result.Status = StatusWarn
+		}
+		result.Error = err.Error()
+	}
+	c.result = &result
+	return result
+}
+
+func (c *check) checkWithTimeout(ctx context.Context) (err error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- errors.Errorf("check panicked: %v", rec)
+			}
+		}()
+		done <- c.checker.Check(ctx)
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "phastos.health.check."+c.name)
+	}
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	w.Header().Set("Content-Type", ContentHealthJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func passing() Checker {
+	return CheckerFunc(func(context.Context) error { return nil })
+}
+
+func failing(msg string) Checker {
+	return CheckerFunc(func(context.Context) error { return errors.New(msg) })
+}
+
+func TestRegistry_Readiness_AllPass(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", passing())
+	registry.Register("redis", passing())
+
+	report := registry.Readiness(context.Background())
+
+	assert.Equal(t, StatusPass, report.Status)
+	assert.Len(t, report.Checks, 2)
+	assert.Equal(t, StatusPass, report.Checks["db"].Status)
+}
+
+func TestRegistry_Readiness_FailAndOptional(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", passing())
+	registry.Register("search", failing("unreachable"), WithOptional())
+
+	report := registry.Readiness(context.Background())
+	assert.Equal(t, StatusWarn, report.Status)
+	assert.Equal(t, "unreachable", report.Checks["search"].Error)
+
+	registry.Register("redis", failing("connection refused"))
+	report = registry.Readiness(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusFail, report.Checks["redis"].Status)
+}
+
+func TestRegistry_Register_ReplacesSameName(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", failing("down"))
+	registry.Register("db", passing())
+
+	assert.True(t, registry.Has("db"))
+	assert.False(t, registry.Has("redis"))
+	report := registry.Readiness(context.Background())
+	assert.Equal(t, StatusPass, report.Status)
+	assert.Len(t, report.Checks, 1)
+}
+
+func TestRegistry_Liveness_OnlyLivenessChecks(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", failing("down"))
+	registry.Register("goroutines", passing(), WithLiveness())
+
+	report := registry.Liveness(context.Background())
+
+	assert.Equal(t, StatusPass, report.Status)
+	assert.Len(t, report.Checks, 1)
+	assert.Contains(t, report.Checks, "goroutines")
+}
+
+func TestRegistry_Timeout(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}), WithTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	report := registry.Readiness(context.Background())
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Contains(t, report.Checks["slow"].Error, "deadline exceeded")
+}
+
+func TestRegistry_PanicIsFailure(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("broken", CheckerFunc(func(context.Context) error { panic("boom") }))
+
+	report := registry.Readiness(context.Background())
+
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Contains(t, report.Checks["broken"].Error, "boom")
+}
+
+func TestRegistry_CachesResults(t *testing.T) {
+	var calls int32
+	counter := CheckerFunc(func(context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	registry := NewRegistry()
+	registry.Register("cached", counter, WithCacheTTL(time.Minute))
+	registry.Register("uncached", counter, WithCacheTTL(0))
+
+	registry.Readiness(context.Background())
+	report := registry.Readiness(context.Background())
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.True(t, report.Checks["cached"].Cached)
+	assert.False(t, report.Checks["uncached"].Cached)
+}
+
+func TestRegistry_ShuttingDown_FailsReadinessOnly(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", passing())
+	registry.SetShuttingDown()
+
+	assert.True(t, registry.IsShuttingDown())
+	readiness := registry.Readiness(context.Background())
+	assert.Equal(t, StatusFail, readiness.Status)
+	assert.Equal(t, ErrShuttingDown.Error(), readiness.Error)
+	assert.Equal(t, StatusPass, registry.Liveness(context.Background()).Status)
+}
+
+func TestRegistry_Handlers(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", failing("down"))
+
+	rr := httptest.NewRecorder()
+	registry.ReadinessHandler()(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, ContentHealthJSON, rr.Header().Get("Content-Type"))
+
+	var report Report
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "down", report.Checks["db"].Error)
+
+	rr = httptest.NewRecorder()
+	registry.LivenessHandler()(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/kodekoding/phastos/v2/go/env"
 	"github.com/kodekoding/phastos/v2/go/helper"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
 	"github.com/kodekoding/phastos/v2/go/monitoring"
 )
 
@@ -308,6 +309,7 @@ func (r *importer) ProcessData() *ImportResult {
 	notifData["-process name"] = fmt.Sprintf("Import Data %s from %s", r.processName, r.sourceType)
 
 	end := time.Since(start)
+	metrics.ObserveImport(r.processName, totalData-totalFailed, totalFailed, end)
 	notifData["total_data"] = fmt.Sprintf("%d", totalData)
 	notifData["time_execution"] = fmt.Sprintf("%.2f second(s)", end.Seconds())
 	go func() {
@@ -472,6 +474,7 @@ func (r *importer) ProcessPivotData() *ImportResult {
 	notifData["-process name"] = fmt.Sprintf("Import Pivot Data %s from %s", r.processName, r.sourceType)
 
 	end := time.Since(start)
+	metrics.ObserveImport(r.processName, totalData-totalFailed, totalFailed, end)
 	notifData["total_data"] = fmt.Sprintf("%d", totalData)
 	notifData["time_execution"] = fmt.Sprintf("%.2f second(s)", end.Seconds())
 	go func() {
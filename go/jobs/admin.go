@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const defaultAdminLimit = 50
+
+// AdminHandler serves the states of the jobs of m, under the path it is mounted on without prefix:
+//
+//	GET /                                      jobs per state of every queue
+//	GET /{queue}?state=failed&offset=0&limit=50 jobs of queue in state (default queued)
+//	GET /{queue}/{id}                          job
+//
+// It exposes the payloads: serve it behind an authorization middleware.
+func AdminHandler(m *Manager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := m.Stats(r.Context())
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]any{"queues": stats})
+	})
+
+	mux.HandleFunc("GET /{queue}", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		state := State(query.Get("state"))
+		switch state {
+		case "":
+			state = StateQueued
+		case StateQueued, StateRunning, StateSucceeded, StateFailed:
+		default:
+			writeAdminError(w, http.StatusBadRequest, errors.Errorf("unknown state %q", state))
+			return
+		}
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		if limit <= 0 {
+			limit = defaultAdminLimit
+		}
+
+		jobs, err := m.List(r.Context(), r.PathValue("queue"), state, max(offset, 0), limit)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]any{"state": state, "offset": offset, "jobs": jobs})
+	})
+
+	mux.HandleFunc("GET /{queue}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, err := m.Get(r.Context(), r.PathValue("queue"), r.PathValue("id"))
+		switch {
+		case errors.Is(err, ErrNotFound):
+			writeAdminError(w, http.StatusNotFound, err)
+		case err != nil:
+			writeAdminError(w, http.StatusInternalServerError, err)
+		default:
+			writeAdminJSON(w, http.StatusOK, job)
+		}
+	})
+	return mux
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
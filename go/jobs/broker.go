@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// Broker stores the jobs and their states: NewRedisBroker to survive restarts and share the jobs
+// between instances, NewMemoryBroker for tests and local development
+type Broker interface {
+	// Enqueue stores a queued job. When its unique key is held, it returns ErrDuplicate and the ID of
+	// the job holding the key.
+	Enqueue(ctx context.Context, job *Job) (existingID string, err error)
+	// Claim returns the due job of queue to run next, marked running for lease, nil without due job.
+	// The running jobs whose lease expired (their worker died) are queued again first.
+	Claim(ctx context.Context, queue string, lease time.Duration) (*Job, error)
+	// Extend extends the lease of a running job, ErrLeaseLost when the job was claimed again
+	Extend(ctx context.Context, job *Job, lease time.Duration) error
+	// Retry queues a failed attempt of job again, to run at its RunAt. It returns ErrLeaseLost,
+	// storing nothing, when the job was claimed again.
+	Retry(ctx context.Context, job *Job) error
+	// Finish stores job succeeded or failed, kept for retention, and releases its unique key. It
+	// returns ErrLeaseLost, storing nothing, when the job was claimed again.
+	Finish(ctx context.Context, job *Job, retention time.Duration) error
+
+	// Get returns the job id of queue, ErrNotFound when it does not exist or its retention expired
+	Get(ctx context.Context, queue, id string) (*Job, error)
+	// List returns the jobs of queue in state: the queued jobs in run order, the finished jobs latest first
+	List(ctx context.Context, queue string, state State, offset, limit int) ([]*Job, error)
+	// Stats returns the number of jobs of queue per state
+	Stats(ctx context.Context, queue string) (map[State]int64, error)
+}
+
+// markRunning records the start of an attempt of a claimed job
+func markRunning(job *Job, now time.Time) {
+	job.State = StateRunning
+	job.Attempts++
+	job.StartedAt = &now
+	job.FinishedAt = nil
+}
@@ -0,0 +1,219 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/entity"
+	"github.com/kodekoding/phastos/v2/go/helper"
+)
+
+// States of a job
+const (
+	// StateQueued jobs wait for a worker: enqueued, delayed until RunAt or waiting for a retry
+	StateQueued State = "queued"
+	// StateRunning jobs are processed by a worker
+	StateRunning State = "running"
+	// StateSucceeded jobs returned without error
+	StateSucceeded State = "succeeded"
+	// StateFailed jobs failed their last attempt or with ErrPermanent
+	StateFailed State = "failed"
+)
+
+const (
+	// DefaultQueue is the queue of the jobs enqueued without WithQueue
+	DefaultQueue = "default"
+
+	defaultMaxAttempts = 3
+	defaultBackoff     = 10 * time.Second
+	maxBackoff         = time.Hour
+	defaultTimeout     = 5 * time.Minute
+	defaultUniqueFor   = 24 * time.Hour
+	minPriority        = -100
+	maxPriority        = 100
+)
+
+var (
+	// ErrPermanent, wrapped in the error of a Handler, fails the job without retrying it, e.g. for an
+	// invalid payload
+	ErrPermanent = errors.New("permanent failure")
+	// ErrDuplicate is returned by Enqueue when a job with the same unique key is not finished yet
+	ErrDuplicate = errors.New("duplicate job")
+	// ErrNotFound is returned when the job does not exist or its retention has expired
+	ErrNotFound = errors.New("job not found")
+	// ErrLeaseLost is returned by Extend, Retry and Finish when the lease of the attempt expired and
+	// the job was claimed again
+	ErrLeaseLost = errors.New("job lease lost")
+)
+
+type (
+	// State is the state of a job
+	State string
+
+	// Job is a unit of work processed by the Handler registered for its Type
+	Job struct {
+		ID          string          `json:"id"`
+		Type        string          `json:"type"`
+		Queue       string          `json:"queue"`
+		Payload     json.RawMessage `json:"payload,omitempty"`
+		State       State           `json:"state"`
+		Priority    int             `json:"priority"`
+		Attempts    int             `json:"attempts"`
+		MaxAttempts int             `json:"max_attempts"`
+		Backoff     time.Duration   `json:"backoff"`
+		Timeout     time.Duration   `json:"timeout"`
+		UniqueKey   string          `json:"unique_key,omitempty"`
+		UniqueFor   time.Duration   `json:"unique_for,omitempty"`
+		Error       string          `json:"error,omitempty"`
+		EnqueuedAt  time.Time       `json:"enqueued_at"`
+		RunAt       time.Time       `json:"run_at"`
+		StartedAt   *time.Time      `json:"started_at,omitempty"`
+		FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+
+		// RequestID and JWT of the request enqueuing the job, restored in the context of the Handler
+		RequestID string               `json:"request_id,omitempty"`
+		JWT       *entity.JWTClaimData `json:"jwt,omitempty"`
+
+		// claim is the token of the attempt returned by Broker.Claim, checked when the attempt is stored
+		claim string
+	}
+
+	// EnqueueOptions configures a job, see Manager.Enqueue
+	EnqueueOptions func(*Job)
+)
+
+// Decode unmarshals the payload of the job into dest
+func (j *Job) Decode(dest any) error {
+	if err := json.Unmarshal(j.Payload, dest); err != nil {
+		return errors.Wrap(err, "phastos.jobs.Job.Decode")
+	}
+	return nil
+}
+
+// WithQueue enqueues the job to queue instead of DefaultQueue
+func WithQueue(queue string) EnqueueOptions {
+	return func(j *Job) {
+		j.Queue = queue
+	}
+}
+
+// WithDelay runs the job after delay
+func WithDelay(delay time.Duration) EnqueueOptions {
+	return func(j *Job) {
+		j.RunAt = j.EnqueuedAt.Add(delay)
+	}
+}
+
+// WithRunAt runs the job at runAt
+func WithRunAt(runAt time.Time) EnqueueOptions {
+	return func(j *Job) {
+		j.RunAt = runAt
+	}
+}
+
+// WithPriority sets the priority of the job, from -100 to 100 (default 0): the due jobs of higher
+// priority run first, the jobs of the same priority in order
+func WithPriority(priority int) EnqueueOptions {
+	return func(j *Job) {
+		j.Priority = min(max(priority, minPriority), maxPriority)
+	}
+}
+
+// WithUniqueKey rejects the job with ErrDuplicate while another job enqueued with key is not finished,
+// for at most uniqueFor (default 24h)
+func WithUniqueKey(key string, uniqueFor ...time.Duration) EnqueueOptions {
+	return func(j *Job) {
+		j.UniqueKey = key
+		j.UniqueFor = defaultUniqueFor
+		if len(uniqueFor) > 0 && uniqueFor[0] > 0 {
+			j.UniqueFor = uniqueFor[0]
+		}
+	}
+}
+
+// WithMaxAttempts sets how many times the job runs before it fails (default 3)
+func WithMaxAttempts(maxAttempts int) EnqueueOptions {
+	return func(j *Job) {
+		j.MaxAttempts = max(maxAttempts, 1)
+	}
+}
+
+// WithBackoff sets the delay before the first retry (default 10s), doubled on every retry up to 1h
+func WithBackoff(backoff time.Duration) EnqueueOptions {
+	return func(j *Job) {
+		j.Backoff = backoff
+	}
+}
+
+// WithTimeout sets how long an attempt may run (default 5m), its context being canceled then
+func WithTimeout(timeout time.Duration) EnqueueOptions {
+	return func(j *Job) {
+		j.Timeout = timeout
+	}
+}
+
+// newJob returns the job of jobType enqueued from ctx, carrying its request ID and JWT claims
+func newJob(ctx context.Context, jobType string, payload any, opts ...EnqueueOptions) (*Job, error) {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.jobs.Enqueue.MarshalPayload")
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:          helper.GenerateFastID(),
+		Type:        jobType,
+		Queue:       DefaultQueue,
+		Payload:     rawPayload,
+		State:       StateQueued,
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		Timeout:     defaultTimeout,
+		EnqueuedAt:  now,
+		RunAt:       now,
+		RequestID:   requestID(ctx),
+		JWT:         storedClaims(ctx),
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	return job, nil
+}
+
+// storedClaims returns a copy of the JWT claims of ctx without the raw token, which is not kept in
+// the broker nor shown by AdminHandler
+func storedClaims(ctx context.Context) *entity.JWTClaimData {
+	claims := phastosctx.GetJWT(ctx)
+	if claims == nil {
+		return nil
+	}
+	stored := *claims
+	stored.Token = ""
+	return &stored
+}
+
+// requestID returns the request ID of ctx: the one of the gRPC calls and workers, or the header of
+// the HTTP request
+func requestID(ctx context.Context) string {
+	if id := common.GetRequestID(ctx); id != "" {
+		return id
+	}
+	if r := phastosctx.HTTPRequest(ctx); r != nil {
+		return r.Header.Get(common.RequestIDHeader)
+	}
+	return ""
+}
+
+// retryDelay returns the delay before the next attempt: Backoff doubled on every attempt, up to 1h
+func (j *Job) retryDelay() time.Duration {
+	delay := j.Backoff
+	for i := 1; i < j.Attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,368 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const (
+	defaultConcurrency        = 10
+	defaultPollInterval       = time.Second
+	defaultLease              = 30 * time.Second
+	defaultShutdownTimeout    = 30 * time.Second
+	defaultSucceededRetention = 24 * time.Hour
+	defaultFailedRetention    = 7 * 24 * time.Hour
+)
+
+type (
+	// Handler processes a job, the job being retried while it fails, see Manager.Register
+	Handler func(ctx context.Context, job *Job) error
+
+	// Manager enqueues the jobs, runs them with the handlers registered for their type and reports
+	// their states
+	Manager struct {
+		broker             Broker
+		queues             []string
+		concurrency        int
+		pollInterval       time.Duration
+		lease              time.Duration
+		shutdownTimeout    time.Duration
+		succeededRetention time.Duration
+		failedRetention    time.Duration
+
+		mu       sync.RWMutex
+		handlers map[string]Handler
+	}
+
+	// Options configures New
+	Options func(*Manager)
+)
+
+// New creates the Manager of the jobs stored by broker
+//
+//	manager := jobs.New(jobs.NewRedisBroker(store), jobs.WithQueues("critical", jobs.DefaultQueue))
+//	jobs.Handle(manager, "payslip.pdf", func(ctx context.Context, payload PayslipPayload) error {
+//		return payslips.Render(ctx, payload.EmployeeID, payload.Period)
+//	})
+//	go manager.Run(ctx)
+//	...
+//	job, err := manager.Enqueue(ctx, "payslip.pdf", PayslipPayload{EmployeeID: 42, Period: "2026-10"})
+func New(broker Broker, opts ...Options) *Manager {
+	m := &Manager{
+		broker:             broker,
+		queues:             []string{DefaultQueue},
+		concurrency:        defaultConcurrency,
+		pollInterval:       defaultPollInterval,
+		lease:              defaultLease,
+		shutdownTimeout:    defaultShutdownTimeout,
+		succeededRetention: defaultSucceededRetention,
+		failedRetention:    defaultFailedRetention,
+		handlers:           make(map[string]Handler),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// WithQueues sets the queues run by the workers (default DefaultQueue), in order of priority: the
+// jobs of a queue run once the previous queues have no due job
+func WithQueues(queues ...string) Options {
+	return func(m *Manager) {
+		if len(queues) > 0 {
+			m.queues = queues
+		}
+	}
+}
+
+// WithConcurrency sets the number of jobs run at once by the instance (default 10)
+func WithConcurrency(concurrency int) Options {
+	return func(m *Manager) {
+		m.concurrency = max(concurrency, 1)
+	}
+}
+
+// WithPollInterval sets how often an idle worker looks for due jobs (default 1s)
+func WithPollInterval(interval time.Duration) Options {
+	return func(m *Manager) {
+		m.pollInterval = interval
+	}
+}
+
+// WithLease sets how long a running job is owned without renewal (default 30s). The lease is renewed
+// while the job runs; the job of a dead instance runs again when it expires.
+func WithLease(lease time.Duration) Options {
+	return func(m *Manager) {
+		m.lease = lease
+	}
+}
+
+// WithShutdownTimeout sets how long Run waits for the running jobs once its context is done (default
+// 30s), their context being canceled then
+func WithShutdownTimeout(timeout time.Duration) Options {
+	return func(m *Manager) {
+		m.shutdownTimeout = timeout
+	}
+}
+
+// WithRetention sets how long the succeeded (default 24h) and failed (default 7 days) jobs are kept
+func WithRetention(succeeded, failed time.Duration) Options {
+	return func(m *Manager) {
+		m.succeededRetention = succeeded
+		m.failedRetention = failed
+	}
+}
+
+// Register sets the handler of the jobs of jobType
+func (m *Manager) Register(jobType string, handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[jobType] = handler
+}
+
+// Handle registers the handler of the jobs of jobType with payloads of type T. A payload that cannot
+// be decoded fails the job without retry.
+func Handle[T any](m *Manager, jobType string, handler func(ctx context.Context, payload T) error) {
+	m.Register(jobType, func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := job.Decode(&payload); err != nil {
+			return errors.Wrap(ErrPermanent, err.Error())
+		}
+		return handler(ctx, payload)
+	})
+}
+
+// Queues returns the queues run by the workers
+func (m *Manager) Queues() []string {
+	return m.queues
+}
+
+// Enqueue stores a job of jobType with payload (encoded as JSON), to run as soon as a worker is free
+// unless delayed. The request ID and the JWT claims of ctx are restored in the context of the handler.
+// A job with the unique key of an unfinished job returns ErrDuplicate with the job holding the key.
+func (m *Manager) Enqueue(ctx context.Context, jobType string, payload any, opts ...EnqueueOptions) (*Job, error) {
+	job, err := newJob(ctx, jobType, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	existingID, err := m.broker.Enqueue(ctx, job)
+	if errors.Is(err, ErrDuplicate) {
+		existing, getErr := m.broker.Get(ctx, job.Queue, existingID)
+		if getErr != nil {
+			return nil, err
+		}
+		return existing, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns the job id of queue, ErrNotFound when it does not exist or its retention expired
+func (m *Manager) Get(ctx context.Context, queue, id string) (*Job, error) {
+	return m.broker.Get(ctx, queue, id)
+}
+
+// List returns the jobs of queue in state: the queued jobs in run order, the finished jobs latest first
+func (m *Manager) List(ctx context.Context, queue string, state State, offset, limit int) ([]*Job, error) {
+	return m.broker.List(ctx, queue, state, offset, limit)
+}
+
+// Stats returns the number of jobs per state of the queues run by the workers
+func (m *Manager) Stats(ctx context.Context) (map[string]map[State]int64, error) {
+	stats := make(map[string]map[State]int64, len(m.queues))
+	for _, queue := range m.queues {
+		queueStats, err := m.broker.Stats(ctx, queue)
+		if err != nil {
+			return nil, err
+		}
+		stats[queue] = queueStats
+	}
+	return stats, nil
+}
+
+// Run runs the jobs of the queues with the workers until ctx is done, then waits for the running jobs
+// up to the shutdown timeout. A job returning an error is retried after its backoff until its
+// maximum attempts; the jobs interrupted by the shutdown run again.
+func (m *Manager) Run(ctx context.Context) {
+	log := plog.Get()
+	log.Info().Strs("queues", m.queues).Int("concurrency", m.concurrency).Msg("[PHASTOS][JOBS] Workers started")
+
+	// the running jobs outlive ctx up to the shutdown timeout
+	jobsCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		select {
+		case <-time.After(m.shutdownTimeout):
+			cancelJobs()
+		case <-stopped:
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < m.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			m.work(ctx, jobsCtx)
+		}()
+	}
+	workers.Wait()
+	close(stopped)
+	log.Info().Strs("queues", m.queues).Msg("[PHASTOS][JOBS] Workers stopped")
+}
+
+// work claims and runs the due jobs until ctx is done
+func (m *Manager) work(ctx, jobsCtx context.Context) {
+	log := plog.Get()
+	for ctx.Err() == nil {
+		job, err := m.claim(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("[PHASTOS][JOBS] Failed to claim job")
+		}
+		if job == nil {
+			select {
+			case <-time.After(m.pollInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		m.process(jobsCtx, job)
+	}
+}
+
+// claim returns the next due job of the queues in order
+func (m *Manager) claim(ctx context.Context) (*Job, error) {
+	for _, queue := range m.queues {
+		job, err := m.broker.Claim(ctx, queue, m.lease)
+		if err != nil || job != nil {
+			return job, err
+		}
+	}
+	return nil, nil
+}
+
+// process runs an attempt of job, then stores it succeeded, failed or to retry
+func (m *Manager) process(ctx context.Context, job *Job) {
+	ctx = m.jobContext(ctx, job)
+	log := plog.Ctx(ctx)
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// the last attempt did not return: its instance died
+		err = errors.New("attempt interrupted, the worker was lost")
+	} else {
+		err = m.runAttempt(ctx, job)
+	}
+
+	// the outcome is stored even when the shutdown timeout canceled ctx
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now()
+	if err == nil {
+		job.State, job.Error, job.FinishedAt = StateSucceeded, "", &now
+		if err = m.broker.Finish(ctx, job, m.succeededRetention); err != nil {
+			m.logStoreError(ctx, err, "[PHASTOS][JOBS] Failed to store succeeded job, it will run again")
+		}
+		return
+	}
+
+	job.Error = err.Error()
+	if errors.Is(err, ErrPermanent) || job.Attempts >= job.MaxAttempts {
+		job.State, job.FinishedAt = StateFailed, &now
+		log.Error().Err(err).Int("attempts", job.Attempts).Msg("[PHASTOS][JOBS] Job failed")
+		if err = m.broker.Finish(ctx, job, m.failedRetention); err != nil {
+			m.logStoreError(ctx, err, "[PHASTOS][JOBS] Failed to store failed job")
+		}
+		return
+	}
+
+	job.State, job.RunAt = StateQueued, now.Add(job.retryDelay())
+	log.Warn().Err(err).Int("attempts", job.Attempts).Time("retry_at", job.RunAt).Msg("[PHASTOS][JOBS] Job attempt failed, it will be retried")
+	if err = m.broker.Retry(ctx, job); err != nil {
+		m.logStoreError(ctx, err, "[PHASTOS][JOBS] Failed to store job to retry, it will run again after its lease")
+	}
+}
+
+// logStoreError logs the failure to store the outcome of an attempt. An attempt whose lease was lost
+// is left to the worker running the job again.
+func (m *Manager) logStoreError(ctx context.Context, err error, msg string) {
+	log := plog.Ctx(ctx)
+	if errors.Is(err, ErrLeaseLost) {
+		log.Warn().Err(err).Msg("[PHASTOS][JOBS] Job lease lost, the outcome of the attempt is dropped")
+		return
+	}
+	log.Error().Err(err).Msg(msg)
+}
+
+// jobContext restores the request ID and the JWT claims of the request enqueuing job
+func (m *Manager) jobContext(ctx context.Context, job *Job) context.Context {
+	if job.JWT != nil {
+		ctx = phastosctx.WithJWT(ctx, job.JWT)
+	}
+	if job.RequestID != "" {
+		ctx = common.WithRequestID(ctx, job.RequestID)
+	}
+	logger := plog.Get().With().
+		Str("request_id", job.RequestID).
+		Str("job_id", job.ID).
+		Str("job_type", job.Type).
+		Str("queue", job.Queue).
+		Logger()
+	return logger.WithContext(ctx)
+}
+
+// runAttempt runs the handler of job with its timeout, renewing the lease meanwhile
+func (m *Manager) runAttempt(ctx context.Context, job *Job) (err error) {
+	m.mu.RLock()
+	handler, ok := m.handlers[job.Type]
+	m.mu.RUnlock()
+	if !ok {
+		return errors.Wrap(ErrPermanent, fmt.Sprintf("no handler registered for job type %q", job.Type))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	go m.renewLease(ctx, job)
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.Errorf("panic: %v", recovered)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// renewLease extends the lease of the running job until ctx is done
+func (m *Manager) renewLease(ctx context.Context, job *Job) {
+	log := plog.Ctx(ctx)
+	ticker := time.NewTicker(max(m.lease/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.broker.Extend(context.WithoutCancel(ctx), job, m.lease); err != nil {
+				log.Warn().Err(err).Msg("[PHASTOS][JOBS] Failed to renew job lease")
+				if errors.Is(err, ErrLeaseLost) {
+					return
+				}
+			}
+		}
+	}
+}
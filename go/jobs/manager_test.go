@@ -0,0 +1,356 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/common"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/entity"
+)
+
+type payslipPayload struct {
+	EmployeeID int    `json:"employee_id"`
+	Period     string `json:"period"`
+}
+
+// runManager runs m until the end of the test
+func runManager(t *testing.T, m *Manager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitState waits until the job is in state
+func waitState(t *testing.T, m *Manager, job *Job, state State) *Job {
+	var current *Job
+	require.Eventually(t, func() bool {
+		var err error
+		current, err = m.Get(context.Background(), job.Queue, job.ID)
+		return err == nil && current.State == state
+	}, 5*time.Second, 5*time.Millisecond)
+	return current
+}
+
+func TestManager_RestoresContext(t *testing.T) {
+	m := New(NewMemoryBroker(), WithPollInterval(5*time.Millisecond))
+	type received struct {
+		payload   payslipPayload
+		requestID string
+		jwt       *entity.JWTClaimData
+	}
+	got := make(chan received, 1)
+	Handle(m, "payslip.pdf", func(ctx context.Context, payload payslipPayload) error {
+		got <- received{payload: payload, requestID: common.GetRequestID(ctx), jwt: phastosctx.GetJWT(ctx)}
+		return nil
+	})
+	runManager(t, m)
+
+	ctx := common.WithRequestID(context.Background(), "req-1")
+	ctx = phastosctx.WithJWT(ctx, &entity.JWTClaimData{Data: map[string]any{"user_id": "42"}})
+	job, err := m.Enqueue(ctx, "payslip.pdf", payslipPayload{EmployeeID: 42, Period: "2026-10"})
+	require.NoError(t, err)
+	assert.Equal(t, StateQueued, job.State)
+
+	r := <-got
+	assert.Equal(t, payslipPayload{EmployeeID: 42, Period: "2026-10"}, r.payload)
+	assert.Equal(t, "req-1", r.requestID)
+	require.NotNil(t, r.jwt)
+	assert.Equal(t, "42", r.jwt.Data.(map[string]any)["user_id"])
+
+	done := waitState(t, m, job, StateSucceeded)
+	assert.Equal(t, 1, done.Attempts)
+	assert.NotNil(t, done.FinishedAt)
+}
+
+func TestManager_RetriesAndFailures(t *testing.T) {
+	m := New(NewMemoryBroker(), WithPollInterval(5*time.Millisecond))
+	var flakyCalls atomic.Int32
+	m.Register("flaky", func(ctx context.Context, job *Job) error {
+		if flakyCalls.Add(1) < 3 {
+			return errors.New("smtp unavailable")
+		}
+		return nil
+	})
+	m.Register("broken", func(ctx context.Context, job *Job) error {
+		panic("nil map")
+	})
+	m.Register("invalid", func(ctx context.Context, job *Job) error {
+		return errors.Wrap(ErrPermanent, "unknown template")
+	})
+	m.Register("slow", func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	Handle(m, "typed", func(ctx context.Context, payload payslipPayload) error { return nil })
+	runManager(t, m)
+
+	ctx := context.Background()
+	retry := []EnqueueOptions{WithMaxAttempts(3), WithBackoff(time.Millisecond)}
+	flaky, err := m.Enqueue(ctx, "flaky", nil, retry...)
+	require.NoError(t, err)
+	broken, err := m.Enqueue(ctx, "broken", nil, retry...)
+	require.NoError(t, err)
+	invalid, err := m.Enqueue(ctx, "invalid", nil, retry...)
+	require.NoError(t, err)
+	slow, err := m.Enqueue(ctx, "slow", nil, WithMaxAttempts(1), WithTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+	typed, err := m.Enqueue(ctx, "typed", "not an object", retry...)
+	require.NoError(t, err)
+	unknown, err := m.Enqueue(ctx, "unknown", nil, retry...)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, waitState(t, m, flaky, StateSucceeded).Attempts)
+
+	failed := waitState(t, m, broken, StateFailed)
+	assert.Equal(t, 3, failed.Attempts)
+	assert.Equal(t, "panic: nil map", failed.Error)
+
+	failed = waitState(t, m, invalid, StateFailed)
+	assert.Equal(t, 1, failed.Attempts, "ErrPermanent is not retried")
+	assert.Equal(t, "unknown template: permanent failure", failed.Error)
+
+	assert.Equal(t, "context deadline exceeded", waitState(t, m, slow, StateFailed).Error)
+	assert.Equal(t, 1, waitState(t, m, typed, StateFailed).Attempts)
+	assert.Contains(t, waitState(t, m, unknown, StateFailed).Error, `no handler registered for job type "unknown"`)
+
+	stats, err := m.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[State]int64{StateQueued: 0, StateRunning: 0, StateSucceeded: 1, StateFailed: 5}, stats[DefaultQueue])
+}
+
+func TestJob_RetryDelay(t *testing.T) {
+	job := &Job{Backoff: 10 * time.Second, Attempts: 1}
+	assert.Equal(t, 10*time.Second, job.retryDelay())
+	job.Attempts = 3
+	assert.Equal(t, 40*time.Second, job.retryDelay())
+	job.Attempts = 20
+	assert.Equal(t, time.Hour, job.retryDelay())
+}
+
+func TestMemoryBroker_Order(t *testing.T) {
+	ctx := context.Background()
+	m := New(NewMemoryBroker())
+	low, err := m.Enqueue(ctx, "report", nil)
+	require.NoError(t, err)
+	delayed, err := m.Enqueue(ctx, "report", nil, WithPriority(50), WithDelay(time.Hour))
+	require.NoError(t, err)
+	high, err := m.Enqueue(ctx, "report", nil, WithPriority(10))
+	require.NoError(t, err)
+
+	queued, err := m.List(ctx, DefaultQueue, StateQueued, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, queued, 3)
+
+	claimed, err := m.broker.Claim(ctx, DefaultQueue, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, high.ID, claimed.ID, "higher priority first")
+	assert.Equal(t, StateRunning, claimed.State)
+	claimed, err = m.broker.Claim(ctx, DefaultQueue, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, low.ID, claimed.ID)
+	claimed, err = m.broker.Claim(ctx, DefaultQueue, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "the delayed job is not due")
+
+	running, err := m.List(ctx, DefaultQueue, StateRunning, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, running, 2)
+
+	m.broker.(*MemoryBroker).now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	claimed, err = m.broker.Claim(ctx, DefaultQueue, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Contains(t, []string{delayed.ID, high.ID, low.ID}, claimed.ID, "the delayed job and the expired leases are due")
+}
+
+func TestMemoryBroker_LeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker()
+	m := New(broker)
+	job, err := m.Enqueue(ctx, "import", nil)
+	require.NoError(t, err)
+
+	claimed, err := broker.Claim(ctx, DefaultQueue, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed.Attempts)
+
+	// the worker died: the job runs again once its lease expired
+	time.Sleep(5 * time.Millisecond)
+	claimed, err = broker.Claim(ctx, DefaultQueue, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, job.ID, claimed.ID)
+	assert.Equal(t, 2, claimed.Attempts)
+
+	stale := *claimed
+	stale.claim = "attempt-1"
+	assert.ErrorIs(t, broker.Extend(ctx, &stale, time.Minute), ErrLeaseLost)
+	assert.ErrorIs(t, broker.Retry(ctx, &stale), ErrLeaseLost)
+	stale.State = StateFailed
+	assert.ErrorIs(t, broker.Finish(ctx, &stale, time.Hour), ErrLeaseLost, "the first attempt does not store its outcome")
+
+	claimed.State = StateSucceeded
+	require.NoError(t, broker.Finish(ctx, claimed, time.Hour))
+	stored, err := broker.Get(ctx, DefaultQueue, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StateSucceeded, stored.State)
+}
+
+func TestManager_UniqueKey(t *testing.T) {
+	ctx := context.Background()
+	m := New(NewMemoryBroker(), WithPollInterval(5*time.Millisecond))
+	m.Register("import", func(ctx context.Context, job *Job) error { return nil })
+
+	first, err := m.Enqueue(ctx, "import", nil, WithUniqueKey("import:client:7"))
+	require.NoError(t, err)
+	duplicate, err := m.Enqueue(ctx, "import", nil, WithUniqueKey("import:client:7"))
+	assert.ErrorIs(t, err, ErrDuplicate)
+	require.NotNil(t, duplicate)
+	assert.Equal(t, first.ID, duplicate.ID)
+
+	runManager(t, m)
+	waitState(t, m, first, StateSucceeded)
+	_, err = m.Enqueue(ctx, "import", nil, WithUniqueKey("import:client:7"))
+	assert.NoError(t, err, "the key is released when the job is finished")
+}
+
+func TestManager_GracefulShutdown(t *testing.T) {
+	m := New(NewMemoryBroker(), WithPollInterval(5*time.Millisecond))
+	started := make(chan struct{})
+	m.Register("pdf", func(ctx context.Context, job *Job) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return ctx.Err()
+	})
+	job, err := m.Enqueue(context.Background(), "pdf", nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	finished, err := m.Get(context.Background(), job.Queue, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StateSucceeded, finished.State, "the running job finishes before Run returns")
+}
+
+func TestManager_DoesNotStoreToken(t *testing.T) {
+	broker := NewMemoryBroker()
+	m := New(broker)
+	claims := &entity.JWTClaimData{Data: map[string]any{"user_id": "42"}, Token: "secret-bearer-token"}
+	job, err := m.Enqueue(phastosctx.WithJWT(context.Background(), claims), "payslip.pdf", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-bearer-token", claims.Token, "the claims of the request are not changed")
+
+	broker.mu.Lock()
+	stored := string(broker.queues[DefaultQueue].jobs[job.ID])
+	broker.mu.Unlock()
+	assert.Contains(t, stored, `"user_id":"42"`)
+	assert.NotContains(t, stored, "secret-bearer-token")
+
+	server := httptest.NewServer(AdminHandler(m))
+	defer server.Close()
+	for _, path := range []string{"/" + DefaultQueue + "?state=queued", "/" + DefaultQueue + "/" + job.ID} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		assert.Contains(t, string(body), job.ID)
+		assert.NotContains(t, string(body), "secret-bearer-token", path)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	ctx := context.Background()
+	m := New(NewMemoryBroker(), WithQueues(DefaultQueue, "emails"))
+	job, err := m.Enqueue(ctx, "newsletter", map[string]string{"campaign": "october"}, WithQueue("emails"))
+	require.NoError(t, err)
+	server := httptest.NewServer(AdminHandler(m))
+	defer server.Close()
+
+	get := func(path string, dest any) int {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(dest))
+		return resp.StatusCode
+	}
+
+	var stats struct {
+		Queues map[string]map[State]int64 `json:"queues"`
+	}
+	assert.Equal(t, http.StatusOK, get("/", &stats))
+	assert.EqualValues(t, 1, stats.Queues["emails"][StateQueued])
+	assert.EqualValues(t, 0, stats.Queues[DefaultQueue][StateQueued])
+
+	var list struct {
+		Jobs []*Job `json:"jobs"`
+	}
+	assert.Equal(t, http.StatusOK, get("/emails?state=queued", &list))
+	require.Len(t, list.Jobs, 1)
+	assert.Equal(t, job.ID, list.Jobs[0].ID)
+
+	var got Job
+	assert.Equal(t, http.StatusOK, get("/emails/"+job.ID, &got))
+	assert.JSONEq(t, `{"campaign":"october"}`, string(got.Payload))
+
+	var errBody map[string]string
+	assert.Equal(t, http.StatusNotFound, get("/emails/missing", &errBody))
+	assert.Equal(t, http.StatusBadRequest, get("/emails?state=lost", &errBody))
+}
+
+func TestManager_ConcurrentWorkers(t *testing.T) {
+	m := New(NewMemoryBroker(), WithConcurrency(4), WithPollInterval(5*time.Millisecond))
+	var mu sync.Mutex
+	running, peak := 0, 0
+	m.Register("email", func(ctx context.Context, job *Job) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	var enqueued []*Job
+	for i := 0; i < 8; i++ {
+		job, err := m.Enqueue(context.Background(), "email", i)
+		require.NoError(t, err)
+		enqueued = append(enqueued, job)
+	}
+	runManager(t, m)
+	for _, job := range enqueued {
+		waitState(t, m, job, StateSucceeded)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, peak)
+}
@@ -0,0 +1,294 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/helper"
+)
+
+type (
+	// MemoryBroker is a Broker keeping the jobs in process: they are lost on restart and not shared
+	// between instances
+	MemoryBroker struct {
+		mu     sync.Mutex
+		queues map[string]*memoryQueue
+		now    func() time.Time
+	}
+
+	memoryQueue struct {
+		jobs     map[string][]byte           // JSON of the jobs
+		queued   map[string]int64            // queued job IDs with their enqueue sequence
+		running  map[string]memoryLease      // running job IDs with their lease
+		finished map[string]time.Time        // finished job IDs with their expiry
+		unique   map[string]memoryUniqueHold // unique keys with the job holding them
+		seq      int64
+	}
+
+	memoryUniqueHold struct {
+		id    string
+		until time.Time
+	}
+
+	memoryLease struct {
+		claim    string
+		deadline time.Time
+	}
+)
+
+// NewMemoryBroker creates an empty MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{queues: make(map[string]*memoryQueue), now: time.Now}
+}
+
+func (b *MemoryBroker) queueLocked(name string) *memoryQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			jobs:     make(map[string][]byte),
+			queued:   make(map[string]int64),
+			running:  make(map[string]memoryLease),
+			finished: make(map[string]time.Time),
+			unique:   make(map[string]memoryUniqueHold),
+		}
+		b.queues[name] = q
+	}
+	now := b.now()
+	for id, expiry := range q.finished {
+		if !now.Before(expiry) {
+			delete(q.finished, id)
+			delete(q.jobs, id)
+		}
+	}
+	return q
+}
+
+func (q *memoryQueue) job(id string) (*Job, error) {
+	data, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	job := new(Job)
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, errors.Wrap(err, "phastos.jobs.memory.Unmarshal")
+	}
+	return job, nil
+}
+
+func (q *memoryQueue) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "phastos.jobs.memory.Marshal")
+	}
+	q.jobs[job.ID] = data
+	return nil
+}
+
+// Enqueue implements Broker
+func (b *MemoryBroker) Enqueue(ctx context.Context, job *Job) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(job.Queue)
+	if job.UniqueKey != "" {
+		if hold, held := q.unique[job.UniqueKey]; held && b.now().Before(hold.until) {
+			return hold.id, ErrDuplicate
+		}
+		q.unique[job.UniqueKey] = memoryUniqueHold{id: job.ID, until: job.RunAt.Add(job.UniqueFor)}
+	}
+	if err := q.save(job); err != nil {
+		return "", err
+	}
+	q.seq++
+	q.queued[job.ID] = q.seq
+	return "", nil
+}
+
+// Claim implements Broker
+func (b *MemoryBroker) Claim(ctx context.Context, queue string, lease time.Duration) (*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(queue)
+	now := b.now()
+	for id, held := range q.running {
+		if now.After(held.deadline) {
+			job, err := q.job(id)
+			if err != nil {
+				return nil, err
+			}
+			job.State = StateQueued
+			if err = q.save(job); err != nil {
+				return nil, err
+			}
+			delete(q.running, id)
+			q.seq++
+			q.queued[id] = q.seq
+		}
+	}
+
+	jobs, err := q.queuedJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.RunAt.After(now) {
+			continue
+		}
+		delete(q.queued, job.ID)
+		job.claim = helper.GenerateFastID()
+		q.running[job.ID] = memoryLease{claim: job.claim, deadline: now.Add(lease)}
+		markRunning(job, now)
+		return job, q.save(job)
+	}
+	return nil, nil
+}
+
+// queuedJobs returns the queued jobs in run order: higher priority first, then by run time
+func (q *memoryQueue) queuedJobs() ([]*Job, error) {
+	jobs := make([]*Job, 0, len(q.queued))
+	for id := range q.queued {
+		job, err := q.job(id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		if !jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].RunAt.Before(jobs[j].RunAt)
+		}
+		return q.queued[jobs[i].ID] < q.queued[jobs[j].ID]
+	})
+	return jobs, nil
+}
+
+// Extend implements Broker
+func (b *MemoryBroker) Extend(ctx context.Context, job *Job, lease time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(job.Queue)
+	if !q.claimed(job) {
+		return ErrLeaseLost
+	}
+	q.running[job.ID] = memoryLease{claim: job.claim, deadline: b.now().Add(lease)}
+	return nil
+}
+
+// claimed reports whether the attempt of job still holds its lease
+func (q *memoryQueue) claimed(job *Job) bool {
+	lease, ok := q.running[job.ID]
+	return ok && lease.claim == job.claim
+}
+
+// Retry implements Broker
+func (b *MemoryBroker) Retry(ctx context.Context, job *Job) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(job.Queue)
+	if !q.claimed(job) {
+		return ErrLeaseLost
+	}
+	delete(q.running, job.ID)
+	q.seq++
+	q.queued[job.ID] = q.seq
+	return q.save(job)
+}
+
+// Finish implements Broker
+func (b *MemoryBroker) Finish(ctx context.Context, job *Job, retention time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(job.Queue)
+	if !q.claimed(job) {
+		return ErrLeaseLost
+	}
+	delete(q.running, job.ID)
+	delete(q.queued, job.ID)
+	q.finished[job.ID] = b.now().Add(retention)
+	if hold, ok := q.unique[job.UniqueKey]; ok && hold.id == job.ID {
+		delete(q.unique, job.UniqueKey)
+	}
+	return q.save(job)
+}
+
+// Get implements Broker
+func (b *MemoryBroker) Get(ctx context.Context, queue, id string) (*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queueLocked(queue).job(id)
+}
+
+// List implements Broker
+func (b *MemoryBroker) List(ctx context.Context, queue string, state State, offset, limit int) ([]*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(queue)
+
+	var jobs []*Job
+	switch state {
+	case StateQueued:
+		queued, err := q.queuedJobs()
+		if err != nil {
+			return nil, err
+		}
+		jobs = queued
+	default:
+		var ids []string
+		if state == StateRunning {
+			for id := range q.running {
+				ids = append(ids, id)
+			}
+		} else {
+			for id := range q.finished {
+				ids = append(ids, id)
+			}
+		}
+		for _, id := range ids {
+			job, err := q.job(id)
+			if err != nil {
+				return nil, err
+			}
+			if job.State == state {
+				jobs = append(jobs, job)
+			}
+		}
+		sort.Slice(jobs, func(i, j int) bool {
+			if state == StateRunning {
+				return jobs[i].StartedAt.Before(*jobs[j].StartedAt)
+			}
+			return jobs[i].FinishedAt.After(*jobs[j].FinishedAt)
+		})
+	}
+	if offset >= len(jobs) {
+		return []*Job{}, nil
+	}
+	return jobs[offset:min(offset+limit, len(jobs))], nil
+}
+
+// Stats implements Broker
+func (b *MemoryBroker) Stats(ctx context.Context, queue string) (map[State]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queueLocked(queue)
+	stats := map[State]int64{
+		StateQueued:    int64(len(q.queued)),
+		StateRunning:   int64(len(q.running)),
+		StateSucceeded: 0,
+		StateFailed:    0,
+	}
+	for id := range q.finished {
+		job, err := q.job(id)
+		if err != nil {
+			return nil, err
+		}
+		stats[job.State]++
+	}
+	return stats, nil
+}
@@ -0,0 +1,425 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+	"github.com/kodekoding/phastos/v2/go/helper"
+)
+
+// priorityWeight spaces the ready scores of the priorities beyond any run time in milliseconds
+const priorityWeight = int64(1e13)
+
+var (
+	// KEYS: job, scheduled, ready, unique key, scores. ARGV: id, data, ready score, run at, now, unique TTL (ms)
+	enqueueScript = redigo.NewScript(5, `
+if ARGV[6] ~= '0' then
+	local holder = redis.call('GET', KEYS[4])
+	if holder then
+		return {0, holder}
+	end
+	redis.call('SET', KEYS[4], ARGV[1], 'PX', ARGV[6])
+end
+redis.call('HSET', KEYS[1], 'data', ARGV[2])
+redis.call('HSET', KEYS[5], ARGV[1], ARGV[3])
+if tonumber(ARGV[4]) > tonumber(ARGV[5]) then
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[1])
+else
+	redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+end
+return {1, ARGV[1]}`)
+
+	// KEYS: scheduled, ready, running, scores, claims. ARGV: now, lease deadline (ms), claim token.
+	// The due scheduled jobs and the running jobs whose lease expired are made ready first, the claim
+	// of an expired lease is dropped.
+	claimScript = redigo.NewScript(5, `
+for _, set in ipairs({KEYS[1], KEYS[3]}) do
+	local due = redis.call('ZRANGEBYSCORE', set, '-inf', ARGV[1], 'LIMIT', 0, 100)
+	for _, id in ipairs(due) do
+		redis.call('ZREM', set, id)
+		redis.call('HDEL', KEYS[5], id)
+		local score = redis.call('HGET', KEYS[4], id)
+		if score then
+			redis.call('ZADD', KEYS[2], score, id)
+		end
+	end
+end
+local popped = redis.call('ZPOPMIN', KEYS[2])
+if #popped == 0 then
+	return false
+end
+redis.call('ZADD', KEYS[3], ARGV[2], popped[1])
+redis.call('HSET', KEYS[5], popped[1], ARGV[3])
+return popped[1]`)
+
+	// KEYS: claims, job. ARGV: id, data of the running job, claim token
+	startScript = redigo.NewScript(2, `
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[3] then
+	return 0
+end
+redis.call('HSET', KEYS[2], 'data', ARGV[2])
+return 1`)
+
+	// KEYS: running, claims. ARGV: id, lease deadline (ms), claim token
+	extendScript = redigo.NewScript(2, `
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[3] then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', ARGV[2], ARGV[1])
+return 1`)
+
+	// KEYS: running, job, scheduled, scores, claims. ARGV: id, data, ready score, run at (ms), claim token
+	retryScript = redigo.NewScript(5, `
+if redis.call('HGET', KEYS[5], ARGV[1]) ~= ARGV[5] then
+	return 0
+end
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[2], 'data', ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+return 1`)
+
+	// KEYS: running, ready, scheduled, job, finished set, unique key, scores, claims.
+	// ARGV: id, data, now, retention, expired before (ms), claim token
+	finishScript = redigo.NewScript(8, `
+if redis.call('HGET', KEYS[8], ARGV[1]) ~= ARGV[6] then
+	return 0
+end
+redis.call('HDEL', KEYS[8], ARGV[1])
+redis.call('HDEL', KEYS[7], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HSET', KEYS[4], 'data', ARGV[2])
+redis.call('PEXPIRE', KEYS[4], ARGV[4])
+redis.call('ZADD', KEYS[5], ARGV[3], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[5], '-inf', ARGV[5])
+if redis.call('GET', KEYS[6]) == ARGV[1] then
+	redis.call('DEL', KEYS[6])
+end
+return 1`)
+)
+
+type (
+	// RedisBroker is a Broker storing the jobs in Redis, shared by the instances and kept across
+	// restarts. The keys of a queue share the hash tag {queue}, so it runs on a Redis Cluster.
+	RedisBroker struct {
+		store *cache.Store
+	}
+
+	// redisQueueKeys are the keys of a queue
+	redisQueueKeys struct {
+		prefix    string
+		scheduled string
+		ready     string
+		running   string
+		scores    string // ready score of the jobs by ID
+		claims    string // claim token of the running jobs by ID
+	}
+)
+
+// NewRedisBroker creates a RedisBroker storing the jobs with the connections of store, under its key
+// prefix
+func NewRedisBroker(store *cache.Store) *RedisBroker {
+	return &RedisBroker{store: store}
+}
+
+func (b *RedisBroker) keys(queue string) redisQueueKeys {
+	prefix := b.store.Key("jobs:{" + queue + "}:")
+	return redisQueueKeys{
+		prefix:    prefix,
+		scheduled: prefix + "scheduled",
+		ready:     prefix + "ready",
+		running:   prefix + "running",
+		scores:    prefix + "scores",
+		claims:    prefix + "claims",
+	}
+}
+
+func (k redisQueueKeys) job(id string) string {
+	return k.prefix + "job:" + id
+}
+
+func (k redisQueueKeys) finished(state State) string {
+	return k.prefix + string(state)
+}
+
+func (k redisQueueKeys) unique(key string) string {
+	return k.prefix + "unique:" + key
+}
+
+// readyScore orders the ready jobs: higher priority first, then by run time
+func readyScore(job *Job) string {
+	return strconv.FormatInt(-int64(job.Priority)*priorityWeight+job.RunAt.UnixMilli(), 10)
+}
+
+func (b *RedisBroker) do(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	conn, err := b.store.Pool.GetContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "phastos.jobs.redis.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+	return fn(conn)
+}
+
+// Enqueue implements Broker
+func (b *RedisBroker) Enqueue(ctx context.Context, job *Job) (string, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return "", errors.Wrap(err, "phastos.jobs.redis.Enqueue.Marshal")
+	}
+	keys := b.keys(job.Queue)
+	var uniqueTTL int64
+	if job.UniqueKey != "" {
+		uniqueTTL = max(time.Until(job.RunAt.Add(job.UniqueFor)).Milliseconds(), 1)
+	}
+
+	var existingID string
+	err = b.do(ctx, func(conn redigo.Conn) error {
+		reply, err := redigo.Values(enqueueScript.Do(conn,
+			keys.job(job.ID), keys.scheduled, keys.ready, keys.unique(job.UniqueKey), keys.scores,
+			job.ID, data, readyScore(job), job.RunAt.UnixMilli(), time.Now().UnixMilli(), uniqueTTL))
+		if err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.Enqueue")
+		}
+		var enqueued int64
+		if _, err = redigo.Scan(reply, &enqueued, &existingID); err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.Enqueue")
+		}
+		if enqueued == 0 {
+			return ErrDuplicate
+		}
+		existingID = ""
+		return nil
+	})
+	return existingID, err
+}
+
+// Claim implements Broker
+func (b *RedisBroker) Claim(ctx context.Context, queue string, lease time.Duration) (*Job, error) {
+	keys := b.keys(queue)
+	var job *Job
+	err := b.do(ctx, func(conn redigo.Conn) error {
+		for {
+			now := time.Now()
+			token := helper.GenerateFastID()
+			id, err := redigo.String(claimScript.Do(conn,
+				keys.scheduled, keys.ready, keys.running, keys.scores, keys.claims,
+				now.UnixMilli(), now.Add(lease).UnixMilli(), token))
+			if err != nil {
+				if errors.Is(err, redigo.ErrNil) {
+					return nil
+				}
+				return errors.Wrap(err, "phastos.jobs.redis.Claim")
+			}
+			claimed, err := b.job(conn, keys, id)
+			if errors.Is(err, ErrNotFound) {
+				// the data of the job was removed, it is dropped from the queue
+				if _, err = conn.Do("ZREM", keys.running, id); err != nil {
+					return errors.Wrap(err, "phastos.jobs.redis.Claim.ZREM")
+				}
+				if _, err = conn.Do("HDEL", keys.claims, id); err != nil {
+					return errors.Wrap(err, "phastos.jobs.redis.Claim.HDEL")
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			markRunning(claimed, now)
+			data, err := json.Marshal(claimed)
+			if err != nil {
+				return errors.Wrap(err, "phastos.jobs.redis.Claim.Marshal")
+			}
+			started, err := redigo.Int(startScript.Do(conn, keys.claims, keys.job(id), id, data, token))
+			if err != nil {
+				return errors.Wrap(err, "phastos.jobs.redis.Claim.Start")
+			}
+			if started == 0 {
+				// the lease expired meanwhile and another worker claimed the job
+				continue
+			}
+			claimed.claim = token
+			job = claimed
+			return nil
+		}
+	})
+	return job, err
+}
+
+// Extend implements Broker
+func (b *RedisBroker) Extend(ctx context.Context, job *Job, lease time.Duration) error {
+	keys := b.keys(job.Queue)
+	return b.do(ctx, func(conn redigo.Conn) error {
+		extended, err := redigo.Int(extendScript.Do(conn, keys.running, keys.claims,
+			job.ID, time.Now().Add(lease).UnixMilli(), job.claim))
+		if err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.Extend")
+		}
+		if extended == 0 {
+			return ErrLeaseLost
+		}
+		return nil
+	})
+}
+
+// Retry implements Broker
+func (b *RedisBroker) Retry(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "phastos.jobs.redis.Retry.Marshal")
+	}
+	keys := b.keys(job.Queue)
+	return b.do(ctx, func(conn redigo.Conn) error {
+		retried, err := redigo.Int(retryScript.Do(conn, keys.running, keys.job(job.ID), keys.scheduled, keys.scores, keys.claims,
+			job.ID, data, readyScore(job), job.RunAt.UnixMilli(), job.claim))
+		if err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.Retry")
+		}
+		if retried == 0 {
+			return ErrLeaseLost
+		}
+		return nil
+	})
+}
+
+// Finish implements Broker
+func (b *RedisBroker) Finish(ctx context.Context, job *Job, retention time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "phastos.jobs.redis.Finish.Marshal")
+	}
+	keys := b.keys(job.Queue)
+	now := time.Now()
+	return b.do(ctx, func(conn redigo.Conn) error {
+		finished, err := redigo.Int(finishScript.Do(conn,
+			keys.running, keys.ready, keys.scheduled, keys.job(job.ID), keys.finished(job.State), keys.unique(job.UniqueKey),
+			keys.scores, keys.claims,
+			job.ID, data, now.UnixMilli(), retention.Milliseconds(), now.Add(-retention).UnixMilli(), job.claim))
+		if err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.Finish")
+		}
+		if finished == 0 {
+			return ErrLeaseLost
+		}
+		return nil
+	})
+}
+
+// Get implements Broker
+func (b *RedisBroker) Get(ctx context.Context, queue, id string) (*Job, error) {
+	var job *Job
+	err := b.do(ctx, func(conn redigo.Conn) error {
+		var err error
+		job, err = b.job(conn, b.keys(queue), id)
+		return err
+	})
+	return job, err
+}
+
+func (b *RedisBroker) job(conn redigo.Conn, keys redisQueueKeys, id string) (*Job, error) {
+	data, err := redigo.Bytes(conn.Do("HGET", keys.job(id), "data"))
+	if err != nil {
+		if errors.Is(err, redigo.ErrNil) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "phastos.jobs.redis.Get")
+	}
+	job := new(Job)
+	if err = json.Unmarshal(data, job); err != nil {
+		return nil, errors.Wrap(err, "phastos.jobs.redis.Get.Unmarshal")
+	}
+	return job, nil
+}
+
+// List implements Broker
+func (b *RedisBroker) List(ctx context.Context, queue string, state State, offset, limit int) ([]*Job, error) {
+	keys := b.keys(queue)
+	jobs := []*Job{}
+	err := b.do(ctx, func(conn redigo.Conn) error {
+		var ids []string
+		var err error
+		switch state {
+		case StateQueued:
+			ids, err = queuedIDs(conn, keys, offset, limit)
+		case StateRunning:
+			ids, err = redigo.Strings(conn.Do("ZRANGE", keys.running, offset, offset+limit-1))
+		default:
+			ids, err = redigo.Strings(conn.Do("ZREVRANGE", keys.finished(state), offset, offset+limit-1))
+		}
+		if err != nil {
+			return errors.Wrap(err, "phastos.jobs.redis.List")
+		}
+
+		for _, id := range ids {
+			job, err := b.job(conn, keys, id)
+			if errors.Is(err, ErrNotFound) {
+				// expired meanwhile
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// a job whose worker died is queued again before its data is updated
+			job.State = state
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// queuedIDs returns the IDs of the ready jobs in run order, then of the scheduled jobs by run time
+func queuedIDs(conn redigo.Conn, keys redisQueueKeys, offset, limit int) ([]string, error) {
+	ready, err := redigo.Int(conn.Do("ZCARD", keys.ready))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if offset < ready {
+		if ids, err = redigo.Strings(conn.Do("ZRANGE", keys.ready, offset, offset+limit-1)); err != nil {
+			return nil, err
+		}
+	}
+	if remaining := limit - len(ids); remaining > 0 {
+		start := max(offset-ready, 0)
+		scheduled, err := redigo.Strings(conn.Do("ZRANGE", keys.scheduled, start, start+remaining-1))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, scheduled...)
+	}
+	return ids, nil
+}
+
+// Stats implements Broker
+func (b *RedisBroker) Stats(ctx context.Context, queue string) (map[State]int64, error) {
+	keys := b.keys(queue)
+	stats := make(map[State]int64, 4)
+	err := b.do(ctx, func(conn redigo.Conn) error {
+		for state, sets := range map[State][]string{
+			StateQueued:    {keys.ready, keys.scheduled},
+			StateRunning:   {keys.running},
+			StateSucceeded: {keys.finished(StateSucceeded)},
+			StateFailed:    {keys.finished(StateFailed)},
+		} {
+			for _, set := range sets {
+				count, err := redigo.Int64(conn.Do("ZCARD", set))
+				if err != nil {
+					return errors.Wrap(err, "phastos.jobs.redis.Stats")
+				}
+				stats[state] += count
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logWriter "github.com/newrelic/go-agent/v3/integrations/logcontext-v2/zerologWriter"
@@ -19,6 +20,12 @@ import (
 var logZero zerolog.Logger
 var once sync.Once
 
+// level overrides the level of the logger once levelSet, see SetLevel
+var (
+	level    atomic.Int32
+	levelSet atomic.Bool
+)
+
 type (
 	Logger struct {
 		newRelicApp     *newrelic.Application
@@ -128,9 +135,28 @@ func Get(loggerOption ...LoggerOption) zerolog.Logger {
 		logZero.Info().Msgf("Logger succesfully initialized")
 	})
 
+	if levelSet.Load() {
+		return logZero.Level(zerolog.Level(level.Load()))
+	}
 	return logZero
 }
 
+// SetLevel changes the level ("debug", "info", "warn", ...) of the loggers returned by Get,
+// it can be called at runtime (e.g. on a config reload). An empty name restores the level of the environment.
+func SetLevel(name string) error {
+	if name == "" {
+		levelSet.Store(false)
+		return nil
+	}
+	parsed, err := zerolog.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Store(int32(parsed))
+	levelSet.Store(true)
+	return nil
+}
+
 func Ctx(ctx context.Context) *zerolog.Logger {
 	return zerolog.Ctx(ctx)
 }
@@ -135,3 +135,16 @@ func TestGetWithNewRelicApp(t *testing.T) {
 	logger := Get(WithNewRelicApp(app))
 	assert.NotNil(t, logger)
 }
+
+func TestSetLevel(t *testing.T) {
+	defer func() { _ = SetLevel("") }()
+
+	assert.NoError(t, SetLevel("warn"))
+	assert.Equal(t, zerolog.WarnLevel, Get().GetLevel())
+
+	assert.Error(t, SetLevel("verbose"))
+	assert.Equal(t, zerolog.WarnLevel, Get().GetLevel())
+
+	assert.NoError(t, SetLevel(""))
+	assert.NotEqual(t, zerolog.WarnLevel, Get().GetLevel())
+}
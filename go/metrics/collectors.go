@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const (
+	statusSuccess = "success"
+	statusError   = "error"
+)
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Query latency of database.SQL Read/Write by operation and status.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "status"})
+
+	redisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Redis command latency by command and status.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "status"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups by operation and result (hit, miss).",
+	}, []string{"operation", "result"})
+
+	cronRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "cron",
+		Name:      "runs_total",
+		Help:      "Cron job runs by schedule and status (success, error, timeout).",
+	}, []string{"schedule", "status"})
+	cronRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "cron",
+		Name:      "run_duration_seconds",
+		Help:      "Cron job duration by schedule.",
+		Buckets:   []float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"schedule"})
+
+	sseClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "sse",
+		Name:      "connected_clients",
+		Help:      "Clients connected to the SSE hub.",
+	})
+	sseDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "sse",
+		Name:      "dropped_messages_total",
+		Help:      "SSE messages dropped because a buffer was full, by buffer (hub, client).",
+	}, []string{"buffer"})
+
+	importerRowsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "importer",
+		Name:      "rows_total",
+		Help:      "Imported rows by process and status (success, failed).",
+	}, []string{"process", "status"})
+	importerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "importer",
+		Name:      "duration_seconds",
+		Help:      "Import duration by process.",
+		Buckets:   []float64{.1, .5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"process"})
+)
+
+func builtinCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		httpRequestsTotal, httpRequestDuration, httpRequestsInFlight,
+		dbQueryDuration,
+		redisCommandDuration,
+		cacheRequestsTotal,
+		cronRunsTotal, cronRunDuration,
+		sseClients, sseDroppedTotal,
+		importerRowsTotal, importerDuration,
+	}
+}
+
+func status(err error) string {
+	if err != nil {
+		return statusError
+	}
+	return statusSuccess
+}
+
+// ObserveHTTPRequest records a served request; route is the route pattern, never the raw path
+func ObserveHTTPRequest(method, route string, code int, duration time.Duration) {
+	if !Enabled() {
+		return
+	}
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveDBQuery records a query started at start; operation is "read" or the write action
+func ObserveDBQuery(operation string, start time.Time, err error) {
+	if !Enabled() {
+		return
+	}
+	dbQueryDuration.WithLabelValues(operation, status(err)).Observe(time.Since(start).Seconds())
+}
+
+// RegisterDBStats exposes the pool stats of db (open, in use, idle, wait count ...) as go_sql_* labelled db_name=name
+func RegisterDBStats(name string, db *sql.DB) error {
+	return Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRedisCommand records a Redis command started at start. redigo.ErrNil is a successful
+// reply.
+func ObserveRedisCommand(command string, start time.Time, err error) {
+	if !Enabled() {
+		return
+	}
+	if err == redigo.ErrNil {
+		err = nil
+	}
+	redisCommandDuration.WithLabelValues(command, status(err)).Observe(time.Since(start).Seconds())
+}
+
+// RegisterRedisPool exposes the stats of a redigo pool labelled pool=name
+func RegisterRedisPool(name string, pool *redigo.Pool) error {
+	return Register(&redisPoolCollector{name: name, pool: pool})
+}
+
+// ObserveCacheGet records a cache lookup; a miss is a lookup that went to the fallback or the caller
+func ObserveCacheGet(operation string, hit bool) {
+	if !Enabled() {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// ObserveCronRun records a cron job run; timedOut wins over err
+func ObserveCronRun(schedule string, start time.Time, timedOut bool, err error) {
+	if !Enabled() {
+		return
+	}
+	runStatus := status(err)
+	if timedOut {
+		runStatus = "timeout"
+	}
+	cronRunsTotal.WithLabelValues(schedule, runStatus).Inc()
+	cronRunDuration.WithLabelValues(schedule).Observe(time.Since(start).Seconds())
+}
+
+// SetSSEClients sets the number of clients connected to the SSE hub
+func SetSSEClients(total int) {
+	if !Enabled() {
+		return
+	}
+	sseClients.Set(float64(total))
+}
+
+// ObserveSSEDropped records a message dropped because the hub or a client buffer was full
+func ObserveSSEDropped(buffer string) {
+	if !Enabled() {
+		return
+	}
+	sseDroppedTotal.WithLabelValues(buffer).Inc()
+}
+
+// ObserveImport records the rows processed by an import
+func ObserveImport(process string, success, failed int, duration time.Duration) {
+	if !Enabled() {
+		return
+	}
+	importerRowsTotal.WithLabelValues(process, statusSuccess).Add(float64(success))
+	importerRowsTotal.WithLabelValues(process, "failed").Add(float64(failed))
+	importerDuration.WithLabelValues(process).Observe(duration.Seconds())
+}
+
+// redisPoolCollector reads redigo.Pool.Stats on every scrape
+type redisPoolCollector struct {
+	name string
+	pool *redigo.Pool
+}
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+	labels := prometheus.Labels{"pool": c.name}
+	ch <- prometheus.MustNewConstMetric(redisPoolDesc("pool_active_connections", "Connections in the pool, idle and in use.", labels),
+		prometheus.GaugeValue, float64(stats.ActiveCount))
+	ch <- prometheus.MustNewConstMetric(redisPoolDesc("pool_idle_connections", "Idle connections in the pool.", labels),
+		prometheus.GaugeValue, float64(stats.IdleCount))
+	ch <- prometheus.MustNewConstMetric(redisPoolDesc("pool_wait_total", "Connections waited for.", labels),
+		prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(redisPoolDesc("pool_wait_duration_seconds_total", "Time blocked waiting for a connection.", labels),
+		prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
+
+func redisPoolDesc(name, help string, labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "redis", name), help, nil, labels)
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// UnmatchedRoute labels requests that did not match any route, so 404 scans cannot blow up
+// the label cardinality
+const UnmatchedRoute = "unmatched"
+
+// HTTPMiddleware records the RED metrics of every request, labelled by the chi route pattern
+// (/v1/users/{id}) instead of the raw path.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+			ObserveHTTPRequest(r.Method, routePattern(r), code, time.Since(start))
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return UnmatchedRoute
+}
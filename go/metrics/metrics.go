@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every built-in metric
+const Namespace = "phastos"
+
+var (
+	registry = prometheus.NewRegistry()
+	enabled  atomic.Bool
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	registry.MustRegister(builtinCollectors()...)
+}
+
+// Enable turns on the built-in instrumentation. Until then the Observe* hooks are no-ops,
+// so packages can call them unconditionally.
+func Enable() {
+	enabled.Store(true)
+}
+
+// Disable turns the built-in instrumentation off again
+func Disable() {
+	enabled.Store(false)
+}
+
+// Enabled reports whether the built-in instrumentation is on
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Registry returns the registry served by Handler
+func Registry() *prometheus.Registry {
+	return registry
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Factory creates custom metrics registered on the Phastos registry
+//
+//	ordersCreated := metrics.Factory().NewCounterVec(prometheus.CounterOpts{
+//		Name: "orders_created_total",
+//		Help: "Orders created by channel",
+//	}, []string{"channel"})
+func Factory() promauto.Factory {
+	return promauto.With(registry)
+}
+
+// Register adds custom collectors to the Phastos registry. Registering a collector that is
+// already registered is not an error.
+func Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			var alreadyRegistered prometheus.AlreadyRegisteredError
+			if errors.As(err, &alreadyRegistered) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// MustRegister is like Register but panics on error
+func MustRegister(cs ...prometheus.Collector) {
+	if err := Register(cs...); err != nil {
+		panic(err)
+	}
+}
+
+// Unregister removes a collector from the Phastos registry
+func Unregister(c prometheus.Collector) bool {
+	return registry.Unregister(c)
+}
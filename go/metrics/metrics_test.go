@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enableForTest(t *testing.T) {
+	t.Helper()
+	Enable()
+	t.Cleanup(Disable)
+}
+
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, observer.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestObserve_DisabledIsNoop(t *testing.T) {
+	Disable()
+	before := testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("noop", "hit"))
+	ObserveCacheGet("noop", true)
+	assert.Equal(t, before, testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("noop", "hit")))
+}
+
+func TestHTTPMiddleware_LabelsByRoutePattern(t *testing.T) {
+	enableForTest(t)
+	router := chi.NewRouter()
+	router.Use(HTTPMiddleware)
+	router.Get("/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/users/"+id, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wp-admin.php", nil))
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/v1/users/{id}", "201")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", UnmatchedRoute, "404")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(httpRequestsInFlight))
+}
+
+func TestObserveHooks(t *testing.T) {
+	enableForTest(t)
+	start := time.Now()
+
+	ObserveDBQuery("test_insert", start, nil)
+	ObserveDBQuery("test_insert", start, errors.New("duplicate key"))
+	ObserveRedisCommand("TESTGET", start, redigo.ErrNil)
+	ObserveCacheGet("test", true)
+	ObserveCacheGet("test", false)
+	ObserveCronRun("@every test", start, false, nil)
+	ObserveCronRun("@every test", start, true, errors.New("late"))
+	ObserveSSEDropped("test")
+	SetSSEClients(4)
+	ObserveImport("test-import", 8, 2, time.Second)
+
+	assert.Equal(t, uint64(1), sampleCount(t, dbQueryDuration.WithLabelValues("test_insert", "success")))
+	assert.Equal(t, uint64(1), sampleCount(t, dbQueryDuration.WithLabelValues("test_insert", "error")))
+	assert.Equal(t, uint64(1), sampleCount(t, redisCommandDuration.WithLabelValues("TESTGET", "success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("test", "hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("test", "miss")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cronRunsTotal.WithLabelValues("@every test", "success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cronRunsTotal.WithLabelValues("@every test", "timeout")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sseDroppedTotal.WithLabelValues("test")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(sseClients))
+	assert.Equal(t, float64(8), testutil.ToFloat64(importerRowsTotal.WithLabelValues("test-import", "success")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(importerRowsTotal.WithLabelValues("test-import", "failed")))
+}
+
+func TestRegister_IgnoresAlreadyRegistered(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_register_total", Help: "test"})
+	t.Cleanup(func() { Unregister(counter) })
+
+	require.NoError(t, Register(counter))
+	require.NoError(t, Register(prometheus.NewCounter(prometheus.CounterOpts{Name: "test_register_total", Help: "test"})))
+
+	inconsistent := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_register_total", Help: "other help"})
+	assert.Error(t, Register(inconsistent))
+}
+
+func TestHandler_ServesCustomMetrics(t *testing.T) {
+	counter := Factory().NewCounterVec(prometheus.CounterOpts{
+		Name: "test_orders_created_total",
+		Help: "Orders created by channel",
+	}, []string{"channel"})
+	t.Cleanup(func() { Unregister(counter) })
+	counter.WithLabelValues("web").Add(3)
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `test_orders_created_total{channel="web"} 3`)
+	assert.True(t, strings.Contains(body, "go_goroutines"))
+}
+
+func TestRegisterRedisPool(t *testing.T) {
+	pool := &redigo.Pool{Dial: func() (redigo.Conn, error) { return nil, errors.New("unused") }}
+	require.NoError(t, RegisterRedisPool("test", pool))
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), `phastos_redis_pool_active_connections{pool="test"} 0`)
+}
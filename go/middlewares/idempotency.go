@@ -0,0 +1,344 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/kodekoding/phastos/v2/go/api"
+	"github.com/kodekoding/phastos/v2/go/cache"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client generated key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	idempotencyStateInFlight  = "in_flight"
+	idempotencyStateCompleted = "completed"
+	maxIdempotencyKeyLength   = 255
+	// defaultIdempotencyMaxBodyBytes limits the request body read for the fingerprint
+	defaultIdempotencyMaxBodyBytes = 10 << 20
+)
+
+// IdempotencyStore is the part of cache.Store used by the Idempotency middleware.
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string, typeDestination any, fallbackFn ...cache.FallbackFn) error
+	Set(ctx context.Context, key string, value any, expire ...int) error
+	SetNX(ctx context.Context, key string, value any, expire int) (bool, error)
+	Del(ctx context.Context, key string) (int64, error)
+}
+
+// IdempotencyScope returns the owner of an idempotency key (default: JWT subject,
+// Authorization header or client IP).
+type IdempotencyScope func(r *http.Request) string
+
+// IdempotencyOption configures the Idempotency middleware.
+type IdempotencyOption func(*idempotency)
+
+type idempotency struct {
+	store    IdempotencyStore
+	ttl      time.Duration
+	lockTTL  time.Duration
+	header   string
+	methods  map[string]struct{}
+	scope    IdempotencyScope
+	required bool
+	maxBody  int64
+	writeErr func(w http.ResponseWriter, r *http.Request, err *api.HttpError)
+}
+
+type idempotencyRecord struct {
+	State       string      `json:"state"`
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Idempotency returns a middleware that makes unsafe requests carrying an Idempotency-Key
+// header safe to retry. The first response (status, headers, body) is stored under a key
+// scoped by user and route and replayed for retries within the TTL. While the first request
+// is still running, retries get 409 IDEMPOTENCY_IN_PROGRESS; a retry with the same key but a
+// different body gets 422 IDEMPOTENCY_KEY_REUSED. 5xx responses are not stored, so the
+// request can be retried. When the store is unavailable, requests pass through.
+func Idempotency(store IdempotencyStore, opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	idem := &idempotency{
+		store:    store,
+		ttl:      24 * time.Hour,
+		lockTTL:  time.Minute,
+		header:   IdempotencyKeyHeader,
+		methods:  map[string]struct{}{http.MethodPost: {}, http.MethodPatch: {}},
+		scope:    defaultIdempotencyScope,
+		maxBody:  defaultIdempotencyMaxBodyBytes,
+		writeErr: writeIdempotencyError,
+	}
+	for _, opt := range opts {
+		opt(idem)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := idem.methods[r.Method]; !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			idemKey := r.Header.Get(idem.header)
+			if idemKey == "" {
+				if idem.required {
+					idem.writeErr(w, r, api.BadRequest(idem.header+" header is required", "IDEMPOTENCY_KEY_REQUIRED"))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idemKey) > maxIdempotencyKeyLength {
+				idem.writeErr(w, r, api.BadRequest(idem.header+" header is too long", "IDEMPOTENCY_KEY_INVALID"))
+				return
+			}
+
+			idem.serve(next, w, r, idemKey)
+		})
+	}
+}
+
+func (idem *idempotency) serve(next http.Handler, w http.ResponseWriter, r *http.Request, idemKey string) {
+	ctx := r.Context()
+	log := plog.Ctx(ctx)
+
+	if r.ContentLength > idem.maxBody {
+		idem.writeErr(w, r, bodyTooLarge(idem.maxBody))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idem.maxBody))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			idem.writeErr(w, r, bodyTooLarge(idem.maxBody))
+			return
+		}
+		idem.writeErr(w, r, api.BadRequest(err.Error(), api.ErrParsedBodyCode))
+		return
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	storeKey := idem.storeKey(r, idemKey)
+	fingerprint := requestFingerprint(r, body)
+
+	acquired, err := idem.store.SetNX(ctx, storeKey, idempotencyRecord{
+		State:       idempotencyStateInFlight,
+		Fingerprint: fingerprint,
+	}, int(idem.lockTTL.Seconds()))
+	if err != nil {
+		log.Err(err).Str("idempotency_key", idemKey).Msg("[PHASTOS][IDEMPOTENCY] store unavailable, skipping idempotency check")
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if !acquired {
+		var record idempotencyRecord
+		if err = idem.store.Get(ctx, storeKey, &record); err != nil {
+			// the record expired between SET NX and GET, treat it as still running
+			record.State = idempotencyStateInFlight
+			record.Fingerprint = fingerprint
+		}
+		switch {
+		case record.Fingerprint != fingerprint:
+			idem.writeErr(w, r, api.UnprocessableEntity("Idempotency key was already used with a different request", "IDEMPOTENCY_KEY_REUSED"))
+		case record.State != idempotencyStateCompleted:
+			w.Header().Set("Retry-After", "1")
+			idem.writeErr(w, r, api.ConflictError("A request with this idempotency key is still in progress", "IDEMPOTENCY_IN_PROGRESS"))
+		default:
+			replayResponse(w, &record)
+		}
+		return
+	}
+
+	recorder := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+	stored := false
+	defer func() {
+		if stored {
+			return
+		}
+		// panic or server error: release the key so the client can retry
+		if _, err := idem.store.Del(ctx, storeKey); err != nil {
+			log.Err(err).Str("idempotency_key", idemKey).Msg("[PHASTOS][IDEMPOTENCY] failed to release idempotency key")
+		}
+	}()
+
+	next.ServeHTTP(recorder, r)
+
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+	record := idempotencyRecord{
+		State:       idempotencyStateCompleted,
+		Fingerprint: fingerprint,
+		Status:      recorder.status,
+		Header:      recorder.header,
+		Body:        recorder.body.Bytes(),
+	}
+	if err = idem.store.Set(ctx, storeKey, record, int(idem.ttl.Seconds())); err != nil {
+		log.Err(err).Str("idempotency_key", idemKey).Msg("[PHASTOS][IDEMPOTENCY] failed to store response")
+		return
+	}
+	stored = true
+}
+
+// storeKey scopes the idempotency key by user and route.
+func (idem *idempotency) storeKey(r *http.Request, idemKey string) string {
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+	hash := sha256.Sum256([]byte(idem.scope(r) + "\n" + r.Method + " " + route + "\n" + idemKey))
+	return "idempotency:" + hex.EncodeToString(hash[:])
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeIdempotencyError sends err and returns its Response to the pool
+func writeIdempotencyError(w http.ResponseWriter, r *http.Request, err *api.HttpError) {
+	resp := api.NewResponse().SetHTTPError(err).Negotiate(r)
+	resp.Send(w)
+	api.ReleaseResponse(resp)
+}
+
+func bodyTooLarge(limit int64) *api.HttpError {
+	return api.PayloadTooLarge(fmt.Sprintf("request body exceeds %d bytes", limit), "REQUEST_BODY_TOO_LARGE")
+}
+
+func replayResponse(w http.ResponseWriter, record *idempotencyRecord) {
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Body)
+}
+
+func defaultIdempotencyScope(r *http.Request) string {
+	if jwtData := phastosctx.GetJWT(r.Context()); jwtData != nil && jwtData.Subject != "" {
+		return "sub:" + jwtData.Subject
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		hash := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(hash[:])
+	}
+	return "ip:" + defaultKeyExtractor()(r)
+}
+
+// WithIdempotencyTTL sets how long completed responses are replayed (default 24h).
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL sets how long a request is considered in flight (default 1m).
+// It should be longer than the route timeout.
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.lockTTL = ttl
+	}
+}
+
+// WithIdempotencyHeader replaces the Idempotency-Key header name.
+func WithIdempotencyHeader(header string) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.header = header
+	}
+}
+
+// WithIdempotencyMethods sets the HTTP methods checked by the middleware (default POST and PATCH).
+func WithIdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.methods = make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			idem.methods[method] = struct{}{}
+		}
+	}
+}
+
+// WithIdempotencyScope replaces the default key owner (JWT subject, Authorization header, client IP).
+func WithIdempotencyScope(scope IdempotencyScope) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.scope = scope
+	}
+}
+
+// WithIdempotencyRequired rejects requests without the header with 400 IDEMPOTENCY_KEY_REQUIRED.
+func WithIdempotencyRequired() IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.required = true
+	}
+}
+
+// WithIdempotencyMaxBodyBytes limits the request body read to fingerprint the request (default
+// 10 MiB). Larger bodies are rejected with 413 REQUEST_BODY_TOO_LARGE.
+func WithIdempotencyMaxBodyBytes(limit int64) IdempotencyOption {
+	return func(idem *idempotency) {
+		idem.maxBody = limit
+	}
+}
+
+// WithIdempotencyErrorWriter sends the 400, 409, 413 and 422 errors of the middleware with write,
+// e.g. app.WriteHttpError to follow the problem details and content negotiation of the App.
+func WithIdempotencyErrorWriter(write func(w http.ResponseWriter, r *http.Request, err *api.HttpError)) IdempotencyOption {
+	return func(idem *idempotency) {
+		if write != nil {
+			idem.writeErr = write
+		}
+	}
+}
+
+// idempotencyRecorder passes the response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,263 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/api"
+	"github.com/kodekoding/phastos/v2/go/cache"
+)
+
+// memoryIdempotencyStore is an in-memory IdempotencyStore for tests.
+type memoryIdempotencyStore struct {
+	mu    sync.Mutex
+	data  map[string]string
+	setNX error
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{data: map[string]string{}}
+}
+
+func (m *memoryIdempotencyStore) Get(_ context.Context, key string, dest any, _ ...cache.FallbackFn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, ok := m.data[key]
+	if !ok {
+		return redigo.ErrNil
+	}
+	return json.Unmarshal([]byte(val), dest)
+}
+
+func (m *memoryIdempotencyStore) Set(_ context.Context, key string, value any, _ ...int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, _ := json.Marshal(value)
+	m.data[key] = string(b)
+	return nil
+}
+
+func (m *memoryIdempotencyStore) SetNX(_ context.Context, key string, value any, _ int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.setNX != nil {
+		return false, m.setNX
+	}
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	b, _ := json.Marshal(value)
+	m.data[key] = string(b)
+	return true, nil
+}
+
+func (m *memoryIdempotencyStore) Del(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return 1, nil
+}
+
+func newIdempotentRequest(key, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/attendance", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	req.Header.Set("Authorization", "Bearer user-1")
+	return req
+}
+
+func TestIdempotency_ReplaysStoredResponse(t *testing.T) {
+	var calls int32
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Record-ID", "42")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":42}`))
+	}))
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, newIdempotentRequest("key-1", `{"in":"08:00"}`))
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, newIdempotentRequest("key-1", `{"in":"08:00"}`))
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, `{"id":42}`, second.Body.String())
+	assert.Equal(t, "42", second.Header().Get("X-Record-ID"))
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+}
+
+func TestIdempotency_ScopedByUser(t *testing.T) {
+	var calls int32
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{}`))
+	other := newIdempotentRequest("key-1", `{}`)
+	other.Header.Set("Authorization", "Bearer user-2")
+	handler.ServeHTTP(httptest.NewRecorder(), other)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_DifferentBody_Rejected(t *testing.T) {
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{"amount":100}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("key-1", `{"amount":200}`))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "IDEMPOTENCY_KEY_REUSED")
+}
+
+func TestIdempotency_BodyTooLarge(t *testing.T) {
+	var calls int32
+	handler := Idempotency(newMemoryIdempotencyStore(), WithIdempotencyMaxBodyBytes(8))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("key-1", `{"amount":100}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "REQUEST_BODY_TOO_LARGE")
+
+	// chunked body without Content-Length
+	req := newIdempotentRequest("key-2", `{"amount":100}`)
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_InFlight_Returns409(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{}`))
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("key-1", `{}`))
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "IDEMPOTENCY_IN_PROGRESS")
+}
+
+func TestIdempotency_ServerError_ReleasesKey(t *testing.T) {
+	var calls int32
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, newIdempotentRequest("key-1", `{}`))
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, newIdempotentRequest("key-1", `{}`))
+
+	assert.Equal(t, http.StatusInternalServerError, first.Code)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_BodyStillReadable(t *testing.T) {
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		_, _ = w.Write([]byte(payload["name"]))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("key-1", `{"name":"budi"}`))
+	assert.Equal(t, "budi", rr.Body.String())
+}
+
+func TestIdempotency_SkipsWithoutKeyOrSafeMethod(t *testing.T) {
+	var calls int32
+	handler := Idempotency(newMemoryIdempotencyStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	noKey := newIdempotentRequest("", `{}`)
+	handler.ServeHTTP(httptest.NewRecorder(), noKey)
+	handler.ServeHTTP(httptest.NewRecorder(), noKey)
+	get := httptest.NewRequest(http.MethodGet, "/v1/attendance", nil)
+	get.Header.Set(IdempotencyKeyHeader, "key-1")
+	handler.ServeHTTP(httptest.NewRecorder(), get)
+	handler.ServeHTTP(httptest.NewRecorder(), get)
+
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_Required(t *testing.T) {
+	handler := Idempotency(newMemoryIdempotencyStore(), WithIdempotencyRequired())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("", `{}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "IDEMPOTENCY_KEY_REQUIRED")
+}
+
+func TestIdempotency_ErrorWriterOfTheApp(t *testing.T) {
+	app := api.NewApp(api.WithProblemDetails())
+	handler := Idempotency(newMemoryIdempotencyStore(), WithIdempotencyErrorWriter(app.WriteHttpError))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{"amount":100}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest("key-1", `{"amount":200}`))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, api.ContentProblemJSON, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"instance":"/v1/attendance"`)
+}
+
+func TestIdempotency_StoreUnavailable_PassesThrough(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	store.setNX = errors.New("connection refused")
+	var calls int32
+	handler := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{}`))
+	handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest("key-1", `{}`))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_CacheStoreImplementsInterface(t *testing.T) {
+	var _ IdempotencyStore = (*cache.Store)(nil)
+}
@@ -1,21 +1,14 @@
 package middlewares
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/newrelic/go-agent/v3/newrelic"
 
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/pkg/errors"
-
 	"github.com/kodekoding/phastos/v2/go/api"
 	"github.com/kodekoding/phastos/v2/go/common"
 	"github.com/kodekoding/phastos/v2/go/context"
-	"github.com/kodekoding/phastos/v2/go/entity"
 )
 
 type JWTConfig struct {
@@ -40,55 +33,15 @@ func JWTAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		if os.Getenv(common.EnvJWTSigningKey) == "" {
-			err := errors.New("JWT Signing Key is nil")
-			newError := api.Unauthorized(err.Error(), "INVALID_KEY")
-			newError.TraceId = traceId
-			api.NewResponse().SetError(newError).Send(w)
-
-			return
-		}
-
-		var keyFunc jwt.Keyfunc = func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != "HS256" {
-				return nil, fmt.Errorf("unexpected jwt signing method=%v", token.Header["alg"])
-			}
-			return []byte(os.Getenv(common.EnvJWTSigningKey)), nil
-		}
-
-		tokenClient := strings.TrimSpace(token)
-		data := jwt.MapClaims{}
-
-		var errToken error
-		tokenData, errToken := jwt.ParseWithClaims(tokenClient, data, keyFunc)
-		if errToken != nil {
-			invalidClaim := api.Unauthorized(errToken.Error(), "INVALID_CLAIMS")
-			invalidClaim.TraceId = traceId
-			api.NewResponse().SetError(invalidClaim).Send(w)
-
-			return
-		}
-		if !tokenData.Valid {
-			invalidTokenError := api.Unauthorized("Token is not valid", "TOKEN_NOT_VALID")
-			invalidTokenError.TraceId = traceId
-			api.NewResponse().SetError(invalidTokenError).Send(w)
-
-			return
-		}
-
-		claimByte, _ := json.Marshal(tokenData.Claims)
-		var result entity.JWTClaimData
-		if err := json.Unmarshal(claimByte, &result); err != nil {
-			invalidClaim := api.Unauthorized("invalid struct claim", "INVALID_STRUCT_CLAIM")
-			invalidClaim.TraceId = traceId
-			api.NewResponse().SetError(invalidClaim).Send(w)
+		result, jwtErr := api.ParseJWT(token)
+		if jwtErr != nil {
+			jwtErr.TraceId = traceId
+			api.NewResponse().SetError(jwtErr).Send(w)
 
 			return
 		}
 
-		result.Token = token
-
-		context.SetJWT(r, &result)
+		context.SetJWT(r, result)
 
 		next.ServeHTTP(w, r)
 	})
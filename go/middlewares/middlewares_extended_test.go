@@ -4,8 +4,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
 )
 
 func TestDefaultKeyExtractor(t *testing.T) {
@@ -46,8 +49,7 @@ func TestWithRate(t *testing.T) {
 	opt := WithRate(100, 200)
 	rl := &rateLimiter{}
 	opt(rl)
-	assert.Equal(t, float64(100), float64(rl.limiter.Limit()))
-	assert.Equal(t, 200, rl.limiter.Burst())
+	assert.Equal(t, ratelimit.Limit{Requests: 1, Period: 10 * time.Millisecond, Burst: 200}, *rl.limit.Load())
 }
 
 func TestWithKeyExtractor(t *testing.T) {
@@ -1,13 +1,17 @@
 package middlewares
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
-	"sync"
+	"sync/atomic"
 
 	"github.com/kodekoding/phastos/v2/go/api"
-	"golang.org/x/time/rate"
+	"github.com/kodekoding/phastos/v2/go/config"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
 )
 
 // KeyExtractor returns the rate-limit bucket key for a request.
@@ -17,25 +21,32 @@ type KeyExtractor func(r *http.Request) string
 type RateLimiterOption func(*rateLimiter)
 
 type rateLimiter struct {
-	limiter      *rate.Limiter
-	limiters     *sync.Map
+	limiter      ratelimit.Limiter
+	limit        atomic.Pointer[ratelimit.Limit]
+	name         string
 	keyExtractor KeyExtractor
+	plan         func(r *http.Request) string
+	plans        map[string]ratelimit.Limit
 	skipPaths    map[string]struct{}
 	msg          string
 	code         string
 }
 
-// NewRateLimiter returns an http.Handler middleware that rate-limits requests
-// using a token-bucket algorithm per bucket key (default = IP address).
+// NewRateLimiter returns an http.Handler middleware that rate-limits requests per bucket key
+// (default = IP address) with GCRA, by default 10 req/s with a burst of 20. The limits are counted
+// in process, in an LRU of 10000 keys, unless WithLimiter shares them through Redis. Every response
+// carries the RateLimit-* headers; rejected requests get 429 with Retry-After. When the limiter
+// fails, requests pass through.
 func NewRateLimiter(opts ...RateLimiterOption) func(http.Handler) http.Handler {
 	rl := &rateLimiter{
-		limiter:      rate.NewLimiter(rate.Limit(10), 20),
-		limiters:     &sync.Map{},
+		limiter:      ratelimit.NewMemoryLimiter(),
+		name:         "http",
 		keyExtractor: defaultKeyExtractor(),
 		skipPaths:    map[string]struct{}{},
 		msg:          "rate limit exceeded",
 		code:         "RATE_LIMITED",
 	}
+	rl.setLimit(ratelimit.Rate(10, 20))
 
 	for _, opt := range opts {
 		opt(rl)
@@ -48,13 +59,21 @@ func NewRateLimiter(opts ...RateLimiterOption) func(http.Handler) http.Handler {
 				return
 			}
 
-			key := rl.keyExtractor(r)
-			var lim *rate.Limiter
-			v, loaded := rl.limiters.LoadOrStore(key, rate.NewLimiter(rl.limiter.Limit(), rl.limiter.Burst()))
-			lim = v.(*rate.Limiter) //nolint:errcheck
-			_ = loaded
+			limit := rl.requestLimit(r)
+			res, err := rl.limiter.Allow(r.Context(), rl.name+":"+rl.keyExtractor(r), limit)
+			if err != nil {
+				log := plog.Ctx(r.Context())
+				log.Warn().Err(err).Msg("[PHASTOS][RATELIMIT] limiter unavailable, skipping rate limit")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if limit.IsZero() {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if !lim.Allow() {
+			ratelimit.SetHeaders(w.Header(), res)
+			if !res.Allowed {
 				err := api.TooManyRequest(rl.msg, rl.code)
 				api.NewResponse().SetHTTPError(err).Send(w)
 				return
@@ -65,10 +84,74 @@ func NewRateLimiter(opts ...RateLimiterOption) func(http.Handler) http.Handler {
 	}
 }
 
+// requestLimit returns the limit of the plan of r, the default limit without plan
+func (rl *rateLimiter) requestLimit(r *http.Request) ratelimit.Limit {
+	if rl.plan != nil {
+		if limit, ok := rl.plans[rl.plan(r)]; ok {
+			return limit
+		}
+	}
+	return *rl.limit.Load()
+}
+
+func (rl *rateLimiter) setLimit(limit ratelimit.Limit) {
+	rl.limit.Store(&limit)
+}
+
 // WithRate sets the request-per-second limit and burst size.
 func WithRate(rps float64, burst int) RateLimiterOption {
 	return func(rl *rateLimiter) {
-		rl.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		rl.setLimit(ratelimit.Rate(rps, burst))
+	}
+}
+
+// WithLimit sets the default limit, e.g. ratelimit.PerMinute(100).
+func WithLimit(limit ratelimit.Limit) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.setLimit(limit)
+	}
+}
+
+// WithRateConfig sets the limit and burst from the rate_limit settings of cfg, when set. With a
+// config.Reloadable (config.Watcher), the limit follows the reloads, starting the quotas over.
+func WithRateConfig(cfg config.Provider) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		if rateCfg := cfg.Framework().RateLimit; rateCfg.RPS > 0 {
+			rl.setLimit(ratelimit.Rate(rateCfg.RPS, rateCfg.Burst))
+		}
+		if reloadable, ok := cfg.(config.Reloadable); ok {
+			reloadable.OnReload(func(c *config.Config) {
+				if c.RateLimit.RPS > 0 {
+					rl.setLimit(ratelimit.Rate(c.RateLimit.RPS, c.RateLimit.Burst))
+				}
+			})
+		}
+	}
+}
+
+// WithLimiter counts the requests with limiter instead of in process, e.g.
+// ratelimit.NewRedisLimiter(store, ratelimit.NewMemoryLimiter()) to share the limits between the
+// instances.
+func WithLimiter(limiter ratelimit.Limiter) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.limiter = limiter
+	}
+}
+
+// WithPlans sets the limit of the requests per plan, e.g. per API key tier or JWT claim. The
+// requests of a plan missing from limits get the default limit; a zero limit is unlimited.
+func WithPlans(plan func(r *http.Request) string, limits map[string]ratelimit.Limit) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.plan = plan
+		rl.plans = limits
+	}
+}
+
+// WithName sets the name the keys are counted under (default "http"): limiters with different
+// names count separately, e.g. per route group.
+func WithName(name string) RateLimiterOption {
+	return func(rl *rateLimiter) {
+		rl.name = name
 	}
 }
 
@@ -117,3 +200,60 @@ func defaultKeyExtractor() KeyExtractor {
 		return ip
 	}
 }
+
+// KeyByHeader keys the requests by the header name (e.g. X-Api-Key) when validate accepts its
+// value, by client IP otherwise. The header is sent by the client: validate must check it is a
+// known credential, or a client sending a new value per request gets a new quota each time. With
+// a nil validate, the requests are keyed by client IP and header, which only splits the quota of
+// the clients sharing an IP.
+func KeyByHeader(name string, validate func(r *http.Request, value string) bool) KeyExtractor {
+	ip := defaultKeyExtractor()
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if value == "" {
+			return ip(r)
+		}
+		if validate == nil {
+			return ip(r) + "|header:" + value
+		}
+		if validate(r, value) {
+			return "header:" + value
+		}
+		return ip(r)
+	}
+}
+
+// KeyByJWTClaim keys the requests by the claim of their JWT (see JWTAuth), by client IP without it.
+func KeyByJWTClaim(claim string) KeyExtractor {
+	ip := defaultKeyExtractor()
+	return func(r *http.Request) string {
+		if value := jwtClaim(r, claim); value != "" {
+			return "claim:" + value
+		}
+		return ip(r)
+	}
+}
+
+// PlanByJWTClaim returns the claim of the JWT of the request as its plan, for WithPlans.
+func PlanByJWTClaim(claim string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return jwtClaim(r, claim)
+	}
+}
+
+// jwtClaim returns the claim of the JWT data of r, or its subject for "sub"
+func jwtClaim(r *http.Request, claim string) string {
+	jwtData := phastosctx.GetJWT(r.Context())
+	if jwtData == nil {
+		return ""
+	}
+	if data, ok := jwtData.Data.(map[string]any); ok {
+		if value, ok := data[claim]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+	}
+	if claim == "sub" {
+		return jwtData.Subject
+	}
+	return ""
+}
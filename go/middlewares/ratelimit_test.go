@@ -1,15 +1,23 @@
 package middlewares
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/config"
+	phastosctx "github.com/kodekoding/phastos/v2/go/context"
+	"github.com/kodekoding/phastos/v2/go/entity"
+	"github.com/kodekoding/phastos/v2/go/ratelimit"
 )
 
 func TestRateLimiter_AllowsRequestsWithinBurst(t *testing.T) {
@@ -217,3 +225,132 @@ func TestNewRateLimiter_DefaultValues(t *testing.T) {
 	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
 	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
 }
+
+// reloadableConfig is a config.Reloadable whose reloads are triggered by the test
+type reloadableConfig struct {
+	cfg       config.Config
+	listeners []func(*config.Config)
+}
+
+func (c *reloadableConfig) Framework() *config.Config { return &c.cfg }
+
+func (c *reloadableConfig) OnReload(fn func(*config.Config)) {
+	c.listeners = append(c.listeners, fn)
+}
+
+func (c *reloadableConfig) reload(rateLimit config.RateLimit) {
+	c.cfg.RateLimit = rateLimit
+	for _, fn := range c.listeners {
+		fn(&c.cfg)
+	}
+}
+
+func TestRateLimiter_WithRateConfig_Reload(t *testing.T) {
+	cfg := &reloadableConfig{cfg: config.Config{RateLimit: config.RateLimit{RPS: 0.001, Burst: 1}}}
+	handler := NewRateLimiter(WithRateConfig(cfg))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	// the limiter already created for the client follows the new rate
+	cfg.reload(config.RateLimit{RPS: 1000, Burst: 1})
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve())
+}
+
+func TestRateLimiter_Headers(t *testing.T) {
+	handler := NewRateLimiter(WithLimit(ratelimit.PerMinute(2)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	rr := serve()
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+
+	serve()
+	rr = serve()
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+}
+
+func TestRateLimiter_PlansByJWTClaim(t *testing.T) {
+	handler := NewRateLimiter(
+		WithLimit(ratelimit.PerHour(1)),
+		WithKeyExtractor(KeyByJWTClaim("user_id")),
+		WithPlans(PlanByJWTClaim("plan"), map[string]ratelimit.Limit{
+			"pro":      ratelimit.PerHour(3),
+			"internal": {},
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(claims map[string]any) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(phastosctx.WithJWT(r.Context(), &entity.JWTClaimData{Data: claims}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	free := map[string]any{"user_id": "1", "plan": "free"}
+	assert.Equal(t, http.StatusOK, serve(free))
+	assert.Equal(t, http.StatusTooManyRequests, serve(free), "unknown plans get the default limit")
+
+	pro := map[string]any{"user_id": "2", "plan": "pro"}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(pro))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(pro))
+
+	internal := map[string]any{"user_id": "3", "plan": "internal"}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve(internal), "a zero limit is unlimited")
+	}
+}
+
+// failingLimiter is a limiter whose store is down
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimiter_FailOpen(t *testing.T) {
+	handler := NewRateLimiter(WithRate(1, 1), WithLimiter(failingLimiter{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestKeyByHeader(t *testing.T) {
+	extractor := KeyByHeader("X-Api-Key", func(r *http.Request, value string) bool { return value == "key-1" })
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.7:1234"
+	assert.Equal(t, "10.0.0.7", extractor(r))
+	r.Header.Set("X-Api-Key", "key-1")
+	assert.Equal(t, "header:key-1", extractor(r))
+	r.Header.Set("X-Api-Key", "made-up")
+	assert.Equal(t, "10.0.0.7", extractor(r), "an unknown key is limited by client IP")
+
+	r.Header.Set("X-Api-Key", "key-2")
+	assert.Equal(t, "10.0.0.7|header:key-2", KeyByHeader("X-Api-Key", nil)(r))
+}
@@ -109,6 +109,42 @@ func ActivateFirebase(serviceAccountPath string) Options {
 	}
 }
 
+// WithConfig activates the platforms of cfg that are active or have their credentials set,
+// e.g. loaded by the config package
+func WithConfig(cfg Config) Options {
+	return func(platform *Platform) {
+		log := plog.Get()
+		var err error
+		if cfg.Slack != nil && (cfg.Slack.IsActive || cfg.Slack.URL != "") {
+			slackCfg := *cfg.Slack
+			slackCfg.IsActive = true
+			if platform.slack, err = newSlackService(&slackCfg); err != nil {
+				log.Error().Msgf("slack cannot initialized: %s", err)
+			} else {
+				platform.list = append(platform.list, platform.slack)
+			}
+		}
+		if cfg.Telegram != nil && (cfg.Telegram.IsActive || cfg.Telegram.BotToken != "") {
+			telegramCfg := *cfg.Telegram
+			telegramCfg.IsActive = true
+			if platform.telegram, err = telegram.New(&telegramCfg); err != nil {
+				log.Error().Msgf("telegram cannot initialized: %s", err)
+			} else {
+				platform.list = append(platform.list, platform.telegram)
+			}
+		}
+		if cfg.FCM != nil && (cfg.FCM.IsActive || cfg.FCM.ServiceAccountPath != "") {
+			fcmCfg := *cfg.FCM
+			fcmCfg.IsActive = true
+			if platform.fcm, err = fcmpkg.New(&fcmCfg); err != nil {
+				log.Error().Msgf("fcm cannot initialized: %s", err)
+			} else {
+				platform.list = append(platform.list, platform.fcm)
+			}
+		}
+	}
+}
+
 func (this *Platform) Telegram() Action {
 	return this.telegram
 }
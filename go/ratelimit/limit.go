@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	// Limit is a quota of Requests per Period, up to Burst of them at once (default Requests). The
+	// requests are spread with GCRA: a client that used its burst gets a request back every
+	// Period/Requests.
+	Limit struct {
+		Requests int
+		Period   time.Duration
+		Burst    int
+	}
+
+	// Result is the outcome of Limiter.Allow
+	Result struct {
+		Allowed bool
+		Limit   Limit
+		// Remaining is the number of requests the client can still make at once
+		Remaining int
+		// RetryAfter is how long a rejected client waits for its next request
+		RetryAfter time.Duration
+		// ResetAfter is how long the client waits for its whole burst
+		ResetAfter time.Duration
+	}
+
+	// Limiter counts the requests of the clients against their Limit
+	Limiter interface {
+		// Allow counts a request of key when the limit allows it
+		Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	}
+)
+
+// PerSecond allows requests per second
+func PerSecond(requests int) Limit {
+	return Limit{Requests: requests, Period: time.Second}
+}
+
+// PerMinute allows requests per minute
+func PerMinute(requests int) Limit {
+	return Limit{Requests: requests, Period: time.Minute}
+}
+
+// PerHour allows requests per hour
+func PerHour(requests int) Limit {
+	return Limit{Requests: requests, Period: time.Hour}
+}
+
+// Rate allows rps requests per second with burst, rps being possibly below 1
+func Rate(rps float64, burst int) Limit {
+	if rps <= 0 {
+		return Limit{}
+	}
+	return Limit{Requests: 1, Period: time.Duration(float64(time.Second) / rps), Burst: burst}
+}
+
+// WithBurst returns l allowing burst requests at once
+func (l Limit) WithBurst(burst int) Limit {
+	l.Burst = burst
+	return l
+}
+
+// IsZero reports whether l is unset, allowing every request
+func (l Limit) IsZero() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// interval is the time a request is given back after
+func (l Limit) interval() time.Duration {
+	return max(l.Period/time.Duration(l.Requests), 1)
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// tolerance is the time the burst is given back after
+func (l Limit) tolerance() time.Duration {
+	return l.interval() * time.Duration(l.burst())
+}
+
+// id tells the limits apart in the stored keys: a changed limit starts its quotas over
+func (l Limit) id() string {
+	return strconv.FormatInt(int64(l.interval()), 36) + "." + strconv.Itoa(l.burst())
+}
+
+// Policy describes l as the RateLimit-Policy header: the burst and the seconds it is given back in
+func (l Limit) Policy() string {
+	return strconv.Itoa(l.burst()) + ";w=" + strconv.FormatInt(int64(math.Ceil(l.tolerance().Seconds())), 10)
+}
+
+// SetHeaders sets the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers of res, with Retry-After when the request is rejected
+func SetHeaders(header http.Header, res Result) {
+	header.Set("RateLimit-Limit", strconv.Itoa(res.Limit.burst()))
+	header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	header.Set("RateLimit-Reset", seconds(res.ResetAfter))
+	header.Set("RateLimit-Policy", res.Limit.Policy())
+	if !res.Allowed {
+		header.Set("Retry-After", seconds(res.RetryAfter))
+	}
+}
+
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// gcra counts a request at now of a client whose quota is back at tat, returning the new tat when
+// it is allowed
+func gcra(now, tat time.Time, limit Limit) (Result, time.Time) {
+	res := Result{Limit: limit}
+	if tat.Before(now) {
+		tat = now
+	}
+	interval := limit.interval()
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-limit.tolerance())
+	if now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+		res.ResetAfter = tat.Sub(now)
+		return res, tat
+	}
+	res.Allowed = true
+	res.Remaining = int(now.Sub(allowAt) / interval)
+	res.ResetAfter = newTat.Sub(now)
+	return res, newTat
+}
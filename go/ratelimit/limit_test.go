@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+)
+
+func TestMemoryLimiter_GCRA(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := PerMinute(6).WithBurst(3) // a request back every 10s
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		res, err := limiter.Allow(ctx, "client", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, remaining, res.Remaining)
+	}
+
+	res, err := limiter.Allow(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 10*time.Second, res.RetryAfter)
+	assert.Equal(t, 30*time.Second, res.ResetAfter)
+
+	now = now.Add(10 * time.Second)
+	res, err = limiter.Allow(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "a request is back after the interval")
+	assert.Equal(t, 0, res.Remaining)
+
+	res, err = limiter.Allow(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "the clients are limited apart")
+
+	res, err = limiter.Allow(ctx, "client", PerMinute(60))
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "a changed limit starts its quota over")
+}
+
+func TestMemoryLimiter_Eviction(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewMemoryLimiter(2)
+	limit := PerHour(1)
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, err := limiter.Allow(ctx, key, limit)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, limiter.Len())
+
+	res, _ := limiter.Allow(ctx, "a", limit)
+	assert.False(t, res.Allowed, "the recently seen client is kept")
+	res, _ = limiter.Allow(ctx, "b", limit)
+	assert.True(t, res.Allowed, "the least recently seen client was evicted")
+}
+
+func TestLimit(t *testing.T) {
+	assert.True(t, Limit{}.IsZero())
+	assert.True(t, Rate(0, 10).IsZero())
+	assert.Equal(t, "20;w=2", Rate(10, 20).Policy())
+	assert.Equal(t, "100;w=60", PerMinute(100).Policy())
+	assert.Equal(t, 100*time.Millisecond, Rate(10, 20).interval())
+
+	header := http.Header{}
+	SetHeaders(header, Result{Limit: PerSecond(5), Remaining: 0, RetryAfter: 1500 * time.Millisecond, ResetAfter: time.Second})
+	assert.Equal(t, "5", header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", header.Get("RateLimit-Reset"))
+	assert.Equal(t, "5;w=1", header.Get("RateLimit-Policy"))
+	assert.Equal(t, "2", header.Get("Retry-After"))
+}
+
+// downPool is a redis that cannot be reached
+type downPool struct{}
+
+func (downPool) Get() redigo.Conn { return nil } // unused by the limiter
+
+func (downPool) GetContext(context.Context) (redigo.Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRedisLimiter_Fallback(t *testing.T) {
+	ctx := context.Background()
+	store := &cache.Store{Pool: downPool{}}
+
+	_, err := NewRedisLimiter(store).Allow(ctx, "client", PerMinute(1))
+	assert.ErrorContains(t, err, "connection refused")
+
+	limiter := NewRedisLimiter(store, NewMemoryLimiter())
+	res, err := limiter.Allow(ctx, "client", PerMinute(1))
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = limiter.Allow(ctx, "client", PerMinute(1))
+	require.NoError(t, err)
+	assert.False(t, res.Allowed, "the fallback keeps limiting")
+	assert.True(t, limiter.degraded.Load())
+}
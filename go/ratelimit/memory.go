@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultMemoryMaxKeys = 10000
+
+type (
+	// MemoryLimiter is a Limiter counting in process, the limits being per instance. It keeps the
+	// state of at most maxKeys clients, evicting the least recently seen first.
+	MemoryLimiter struct {
+		mu      sync.Mutex
+		maxKeys int
+		keys    map[string]*list.Element
+		lru     *list.List // front = most recently seen
+		now     func() time.Time
+	}
+
+	memoryEntry struct {
+		key string
+		tat time.Time
+	}
+)
+
+// NewMemoryLimiter creates a MemoryLimiter keeping at most maxKeys clients (default 10000)
+func NewMemoryLimiter(maxKeys ...int) *MemoryLimiter {
+	l := &MemoryLimiter{
+		maxKeys: defaultMemoryMaxKeys,
+		keys:    make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+	if len(maxKeys) > 0 && maxKeys[0] > 0 {
+		l.maxKeys = maxKeys[0]
+	}
+	return l
+}
+
+// Allow implements Limiter
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.IsZero() {
+		return Result{Allowed: true, Limit: limit}, nil
+	}
+	key += ":" + limit.id()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	elem, ok := l.keys[key]
+	if !ok {
+		elem = l.lru.PushFront(&memoryEntry{key: key})
+		l.keys[key] = elem
+		for l.lru.Len() > l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.keys, oldest.Value.(*memoryEntry).key) //nolint:errcheck
+		}
+	} else {
+		l.lru.MoveToFront(elem)
+	}
+
+	entry := elem.Value.(*memoryEntry) //nolint:errcheck
+	res, tat := gcra(now, entry.tat, limit)
+	entry.tat = tat
+	return res, nil
+}
+
+// Len returns the number of clients kept
+func (l *MemoryLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// KEYS: client. ARGV: interval, tolerance (µs). Returns allowed, remaining, retry after, reset after (µs).
+// The clock of redis is shared by every instance.
+var gcraScript = redigo.NewScript(1, `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - tolerance
+if now < allowAt then
+	return {0, 0, allowAt - now, tat - now}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', math.ceil((newTat - now) / 1000))
+return {1, math.floor((now - allowAt) / interval), 0, newTat - now}
+`)
+
+// RedisLimiter is a Limiter counting in Redis, the limits being shared by the instances. While
+// Redis is unreachable it counts with its fallback, per instance.
+type RedisLimiter struct {
+	store    *cache.Store
+	fallback Limiter
+	degraded atomic.Bool
+}
+
+// NewRedisLimiter creates a RedisLimiter counting with the connections of store, under its key
+// prefix. Without fallback, Allow returns the Redis errors.
+//
+//	limiter := ratelimit.NewRedisLimiter(store, ratelimit.NewMemoryLimiter())
+func NewRedisLimiter(store *cache.Store, fallback ...Limiter) *RedisLimiter {
+	l := &RedisLimiter{store: store}
+	if len(fallback) > 0 {
+		l.fallback = fallback[0]
+	}
+	return l
+}
+
+// Allow implements Limiter
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.IsZero() {
+		return Result{Allowed: true, Limit: limit}, nil
+	}
+	res, err := l.allow(ctx, key, limit)
+	if err == nil {
+		if l.degraded.CompareAndSwap(true, false) {
+			log := plog.Ctx(ctx)
+			log.Info().Msg("[PHASTOS][RATELIMIT] Redis is back, limits are shared again")
+		}
+		return res, nil
+	}
+	if l.fallback == nil {
+		return Result{Limit: limit}, err
+	}
+	if l.degraded.CompareAndSwap(false, true) {
+		log := plog.Ctx(ctx)
+		log.Warn().Err(err).Msg("[PHASTOS][RATELIMIT] Redis unavailable, limiting per instance")
+	}
+	return l.fallback.Allow(ctx, key, limit)
+}
+
+func (l *RedisLimiter) allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	conn, err := l.store.Pool.GetContext(ctx)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "phastos.ratelimit.redis.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	reply, err := redigo.Int64s(gcraScript.Do(conn,
+		l.store.Key("ratelimit:"+key+":"+limit.id()),
+		max(limit.interval().Microseconds(), 1), limit.tolerance().Microseconds()))
+	if err != nil {
+		return Result{}, errors.Wrap(err, "phastos.ratelimit.redis.Allow")
+	}
+	if len(reply) != 4 {
+		return Result{}, errors.Errorf("phastos.ratelimit.redis.Allow: unexpected reply %v", reply)
+	}
+	return Result{
+		Allowed:    reply[0] == 1,
+		Limit:      limit,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Microsecond,
+		ResetAfter: time.Duration(reply[3]) * time.Microsecond,
+	}, nil
+}
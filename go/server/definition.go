@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"time"
 )
 
 type (
@@ -22,16 +23,36 @@ type (
 	}
 
 	Config struct {
-		Port          int    `yaml:"port"`
-		ReadTimeout   int    `yaml:"read_timeout"`
-		WriteTimeout  int    `yaml:"write_timeout"`
-		MaxHeaderByte int    `yaml:"max_header_byte"`
-		Environment   string `yaml:"environment"`
-		Handler       http.Handler
-		CertFile      string
-		KeyFile       string
-		EncryptionKey string `yaml:"encryption_key"`
-		Ctx           context.Context
-		Version       string
+		Port          int             `yaml:"port"`
+		ReadTimeout   int             `yaml:"read_timeout"`
+		WriteTimeout  int             `yaml:"write_timeout"`
+		MaxHeaderByte int             `yaml:"max_header_byte"`
+		Environment   string          `yaml:"environment"`
+		Handler       http.Handler    `yaml:"-"`
+		CertFile      string          `yaml:"cert_file"`
+		KeyFile       string          `yaml:"key_file"`
+		EncryptionKey string          `yaml:"encryption_key"`
+		Ctx           context.Context `yaml:"-"`
+		Version       string          `yaml:"-"`
+		// BeforeShutdown runs as soon as the termination signal is received, before the
+		// server stops accepting connections (e.g. to fail the readiness probe)
+		BeforeShutdown func() `yaml:"-"`
+		// ShutdownDelay keeps serving for a while after BeforeShutdown, so the load
+		// balancer can drain the instance before the listener is closed
+		ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+		// GRPCServer is served next to the HTTP server, on GRPCPort or, when GRPCPort
+		// is 0, on Port through a connection multiplexer (plaintext only)
+		GRPCServer GRPC `yaml:"-"`
+		GRPCPort   int  `yaml:"grpc_port"`
 	}
 )
+
+// DrainBeforeShutdown runs BeforeShutdown and waits ShutdownDelay
+func (c *Config) DrainBeforeShutdown() {
+	if c.BeforeShutdown != nil {
+		c.BeforeShutdown()
+	}
+	if c.ShutdownDelay > 0 {
+		time.Sleep(c.ShutdownDelay)
+	}
+}
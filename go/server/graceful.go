@@ -42,6 +42,7 @@ func serveHTTPs(config *Config, secure bool) error {
 	sign := WaitTermSig(config.Ctx, func(ctx context.Context) error {
 
 		<-ctx.Done()
+		config.DrainBeforeShutdown()
 
 		stopped := make(chan struct{})
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
@@ -0,0 +1,147 @@
+package sse
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/helper"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// AuthError is the rejection of a connection request by the token validators
+type AuthError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Write sends the rejection as a JSON error response
+func (e *AuthError) Write(w http.ResponseWriter) {
+	http.Error(w, fmt.Sprintf(`{"code":"%s","message":"%s"}`, e.Code, e.Message), e.Status)
+}
+
+// Authenticator validates connection requests with the plain and encrypted token hooks.
+// It is shared by the SSE hub and the ws hub.
+type Authenticator struct {
+	TokenValidator          TokenValidator
+	EncryptedTokenValidator EncryptedTokenValidator
+	CryptoManager           *helper.CryptoManager
+}
+
+// Enabled reports whether a validator is set, i.e. whether a token is required
+func (a *Authenticator) Enabled() bool {
+	return a.TokenValidator != nil || a.EncryptedTokenValidator != nil
+}
+
+// Authenticate validates the encrypted token (X-Encrypted-Token header or encrypted_token query)
+// first, then the plain token (Authorization header or token query, "Bearer " prefix optional).
+// It returns nil when no validator is set.
+func (a *Authenticator) Authenticate(r *http.Request) *AuthError {
+	log := plog.Ctx(r.Context())
+
+	// Try encrypted token validation first
+	if a.EncryptedTokenValidator != nil && a.CryptoManager != nil {
+		if encryptedToken := requestEncryptedToken(r); encryptedToken != "" {
+			decryptedToken, err := a.CryptoManager.Decrypt(encryptedToken)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to decrypt token")
+				return &AuthError{Status: http.StatusForbidden, Code: "FORBIDDEN", Message: "Invalid encrypted token"}
+			}
+
+			isValid, err := a.EncryptedTokenValidator(decryptedToken)
+			if err != nil {
+				log.Error().Err(err).Msg("Encrypted token validation error")
+				return &AuthError{Status: http.StatusInternalServerError, Code: "SERVER_ERROR", Message: "Token validation failed"}
+			}
+
+			if !isValid {
+				log.Warn().Msg("Connection attempt with invalid encrypted token")
+				return &AuthError{Status: http.StatusForbidden, Code: "FORBIDDEN", Message: "Invalid or expired token/api-key"}
+			}
+
+			log.Debug().Msg("Encrypted token validation successful")
+			return nil
+		}
+	}
+
+	// Try plain text token validation if no encrypted token or encrypted validation is not enabled
+	if a.TokenValidator != nil {
+		tokenValue := requestToken(r)
+		if tokenValue == "" {
+			log.Warn().Msg("Connection attempt without token")
+			return &AuthError{Status: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "Missing token/api-key"}
+		}
+
+		isValid, err := a.TokenValidator(tokenValue)
+		if err != nil {
+			log.Error().Err(err).Msg("Token validation error")
+			return &AuthError{Status: http.StatusInternalServerError, Code: "SERVER_ERROR", Message: "Token validation failed"}
+		}
+
+		if !isValid {
+			log.Warn().Str("token", maskToken(tokenValue)).Msg("Connection attempt with invalid token")
+			return &AuthError{Status: http.StatusForbidden, Code: "FORBIDDEN", Message: "Invalid or expired token/api-key"}
+		}
+
+		log.Debug().Msg("Token validation successful")
+		return nil
+	}
+
+	// Check if any validation is required but not passed
+	if a.Enabled() {
+		log.Warn().Msg("Connection attempt without valid token")
+		return &AuthError{Status: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "Missing or invalid token/api-key"}
+	}
+	return nil
+}
+
+// Token returns the token of r as Authenticate reads it: the decrypted encrypted token when a
+// CryptoManager is set, else the plain token without its "Bearer " prefix
+func (a *Authenticator) Token(r *http.Request) (string, error) {
+	if a.CryptoManager != nil {
+		if encryptedToken := requestEncryptedToken(r); encryptedToken != "" {
+			token, err := a.CryptoManager.Decrypt(encryptedToken)
+			if err != nil {
+				return "", errors.Wrap(err, "phastos.sse.Authenticator.Token")
+			}
+			return token, nil
+		}
+	}
+	if token := requestToken(r); token != "" {
+		return token, nil
+	}
+	return "", errors.New("phastos.sse.Authenticator.Token: missing token")
+}
+
+// requestEncryptedToken reads the X-Encrypted-Token header or the encrypted_token query
+func requestEncryptedToken(r *http.Request) string {
+	if token := r.Header.Get("X-Encrypted-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("encrypted_token")
+}
+
+// requestToken reads the Authorization header or the token query, without "Bearer " prefix
+func requestToken(r *http.Request) string {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if len(token) > 7 && token[:7] == "Bearer " {
+		return token[7:]
+	}
+	return token
+}
+
+func maskToken(token string) string {
+	if len(token) <= 5 {
+		return "..."
+	}
+	return token[:len(token)-5] + "..."
+}
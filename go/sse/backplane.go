@@ -0,0 +1,319 @@
+package sse
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+// scopes of the envelopes: who the message is for
+const (
+	scopeBroadcast = "broadcast"
+	scopeTopic     = "topic"
+	scopeUser      = "user"
+	scopeTenant    = "tenant"
+	scopeClient    = "client"
+
+	// broadcastStream is the replay stream of the broadcasts, the topics having their own
+	broadcastStream = "broadcast"
+
+	defaultDedupWindow      = 10 * time.Minute
+	defaultReplayBufferSize = 1000
+	defaultReplayBufferTTL  = 24 * time.Hour
+)
+
+type (
+	// Backplane fans the messages of a Hub out to the hubs of the other instances and keeps their
+	// replay buffers, so that a client gets the messages of any instance and replays them when it
+	// reconnects to another one: NewRedisBackplane to share them between instances,
+	// NewMemoryBackplane for tests and the hubs of a single process.
+	Backplane interface {
+		// Publish fans env out to the hubs of every instance. It returns false without publishing
+		// when a message of the same ID was already published to the same recipients, by any instance.
+		Publish(ctx context.Context, env *Envelope) (bool, error)
+		// Subscribe passes the envelopes published by every instance, this one included, to handle
+		// until ctx is done, reconnecting when the connection is lost
+		Subscribe(ctx context.Context, handle func(env *Envelope))
+		// Buffer keeps msg for the replay of stream
+		Buffer(ctx context.Context, stream string, msg *BufferedMessage) error
+		// MessagesAfter returns the messages of streams buffered after the message lastID of any
+		// stream, oldest first. When lastID is not buffered anymore, every buffered message is returned.
+		MessagesAfter(ctx context.Context, lastID string, streams ...string) ([]*BufferedMessage, error)
+	}
+
+	// Envelope is a Message sent through a Backplane, with the instance sending it and its recipients
+	Envelope struct {
+		// Origin is the instance publishing the message, which delivered it to its own clients
+		Origin string `json:"origin"`
+		// Scope is who the message is for: "broadcast", "topic", "user", "tenant" or "client"
+		Scope string `json:"scope"`
+		// Target is the topic, user, tenant or client of Scope
+		Target string          `json:"target,omitempty"`
+		Event  string          `json:"event,omitempty"`
+		ID     string          `json:"id,omitempty"`
+		Retry  int             `json:"retry,omitempty"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		// Text is the data of a message whose Data is a string, sent as is
+		Text string `json:"text,omitempty"`
+	}
+
+	// MemoryBackplane is a Backplane between the hubs of a single process
+	MemoryBackplane struct {
+		mu          sync.Mutex
+		handlers    map[int]func(env *Envelope)
+		nextHandler int
+		published   map[string]time.Time // dedup key -> end of its dedup window
+		nextSweep   time.Time            // when the ended dedup windows are removed next
+		streams     map[string]*MessageBuffer
+		now         func() time.Time
+	}
+)
+
+// WithBackplane fans the messages of the hub out to the hubs of the other instances through
+// backplane and keeps the replay buffers of the broadcasts and the topics in it, see SetBackplane
+func WithBackplane(backplane Backplane) HubOption {
+	return func(hub *Hub) {
+		hub.backplane = backplane
+	}
+}
+
+// SetBackplane sets the backplane of the hub, before Run. Broadcast, PublishToTopic, SendToUser,
+// SendToTenant and SendToClient then reach the clients of every instance, and SendToUser,
+// SendToTenant and SendToClient do not fail for a recipient connected to none. A message whose ID
+// was already published to the same recipients by an instance is dropped. While the backplane is
+// unavailable the messages reach the clients of this instance only.
+func (hub *Hub) SetBackplane(backplane Backplane) {
+	hub.backplane = backplane
+}
+
+// newEnvelope returns the envelope of message published by origin to the target of scope
+func newEnvelope(origin, scope, target string, message *Message) (*Envelope, error) {
+	env := &Envelope{Origin: origin, Scope: scope, Target: target, Event: message.Event, ID: message.ID, Retry: message.Retry}
+	if text, ok := message.Data.(string); ok {
+		env.Text = text
+		return env, nil
+	}
+	data, err := json.Marshal(message.Data)
+	if err != nil {
+		return nil, err
+	}
+	env.Data = data
+	return env, nil
+}
+
+// dedupKey identifies the message of env for the dedup: its ID for its recipients, so the same ID
+// may be sent to another user or topic
+func (env *Envelope) dedupKey() string {
+	return env.Scope + ":" + env.Target + ":" + env.ID
+}
+
+// Message returns the message of env
+func (env *Envelope) Message() *Message {
+	message := &Message{Event: env.Event, ID: env.ID, Retry: env.Retry, Data: env.Text}
+	if env.Data != nil {
+		message.Data = env.Data
+	}
+	return message
+}
+
+// newBufferedMessage returns message as kept for the replay, its data encoded as JSON
+func newBufferedMessage(message *Message) *BufferedMessage {
+	bufferedMsg := &BufferedMessage{
+		ID:        message.ID,
+		Event:     message.Event,
+		Timestamp: time.Now(),
+		Retry:     message.Retry,
+	}
+	if dataBytes, err := json.Marshal(message.Data); err == nil {
+		bufferedMsg.Data = string(dataBytes)
+	}
+	return bufferedMsg
+}
+
+func topicStream(topic string) string {
+	return "topic:" + topic
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// fanOut publishes message to the target of scope through the backplane, and buffers the
+// broadcasts and the topic messages with an ID in it. It returns false when the message is a
+// duplicate, to be delivered to no client.
+func (hub *Hub) fanOut(scope, target string, message *Message) bool {
+	log := plog.Get()
+	env, err := newEnvelope(hub.instanceID, scope, target, message)
+	if err != nil {
+		log.Err(err).Str("event", message.Event).Msg("[PHASTOS][SSE] failed to encode the message for the backplane")
+		return true
+	}
+
+	published, err := hub.backplane.Publish(hub.ctx, env)
+	if err != nil {
+		log.Warn().Err(err).Str("event", message.Event).Msg("[PHASTOS][SSE] backplane unavailable, message sent to the clients of this instance only")
+		return true
+	}
+	if !published {
+		log.Debug().Str("message_id", message.ID).Msg("[PHASTOS][SSE] message already published, dropped")
+		return false
+	}
+
+	if message.ID != "" && (scope == scopeBroadcast || scope == scopeTopic) {
+		stream := broadcastStream
+		if scope == scopeTopic {
+			stream = topicStream(target)
+		}
+		if err := hub.backplane.Buffer(hub.ctx, stream, newBufferedMessage(message)); err != nil {
+			log.Warn().Err(err).Str("message_id", message.ID).Msg("[PHASTOS][SSE] failed to buffer the message in the backplane")
+		}
+	}
+	return true
+}
+
+// receive delivers an envelope published by another instance to the clients of this one
+func (hub *Hub) receive(env *Envelope) {
+	if env.Origin == hub.instanceID {
+		return
+	}
+	message := env.Message()
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	switch env.Scope {
+	case scopeBroadcast:
+		hub.deliver(hub.clients, message)
+	case scopeTopic:
+		hub.deliver(hub.topics[env.Target], message)
+	case scopeUser:
+		hub.deliver(hub.users[env.Target], message)
+	case scopeTenant:
+		hub.deliver(hub.tenants[env.Target], message)
+	case scopeClient:
+		if client, ok := hub.clients[env.Target]; ok {
+			hub.deliver(map[string]*Client{client.ID: client}, message)
+		}
+	}
+}
+
+// replayTopics returns the messages of the topics of client sent after lastEventID, from the
+// backplane when the hub has one
+func (hub *Hub) replayTopics(ctx context.Context, client *Client, lastEventID string) []*BufferedMessage {
+	if hub.backplane == nil {
+		return hub.topicMessagesAfter(client, lastEventID)
+	}
+	topics := client.Topics()
+	if len(topics) == 0 {
+		return nil
+	}
+	streams := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		streams = append(streams, topicStream(topic))
+	}
+	messages, err := hub.backplane.MessagesAfter(ctx, lastEventID, streams...)
+	if err != nil {
+		log := plog.Ctx(ctx)
+		log.Warn().Err(err).Str("client_id", client.ID).Msg("[PHASTOS][SSE] failed to read the replay of the backplane")
+	}
+	return messages
+}
+
+// NewMemoryBackplane creates a MemoryBackplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		handlers:  make(map[int]func(env *Envelope)),
+		published: make(map[string]time.Time),
+		streams:   make(map[string]*MessageBuffer),
+		now:       time.Now,
+	}
+}
+
+// Publish implements Backplane, passing env to the handlers before returning
+func (b *MemoryBackplane) Publish(_ context.Context, env *Envelope) (bool, error) {
+	b.mu.Lock()
+	now := b.now()
+	if now.After(b.nextSweep) {
+		for key, until := range b.published {
+			if now.After(until) {
+				delete(b.published, key)
+			}
+		}
+		b.nextSweep = now.Add(defaultDedupWindow)
+	}
+	if env.ID != "" {
+		key := env.dedupKey()
+		if until, ok := b.published[key]; ok && !now.After(until) {
+			b.mu.Unlock()
+			return false, nil
+		}
+		b.published[key] = now.Add(defaultDedupWindow)
+	}
+	handlers := make([]func(env *Envelope), 0, len(b.handlers))
+	for _, handle := range b.handlers {
+		handlers = append(handlers, handle)
+	}
+	b.mu.Unlock()
+
+	for _, handle := range handlers {
+		handle(env)
+	}
+	return true, nil
+}
+
+// Subscribe implements Backplane
+func (b *MemoryBackplane) Subscribe(ctx context.Context, handle func(env *Envelope)) {
+	b.mu.Lock()
+	id := b.nextHandler
+	b.nextHandler++
+	b.handlers[id] = handle
+	b.mu.Unlock()
+
+	<-ctx.Done()
+	b.mu.Lock()
+	delete(b.handlers, id)
+	b.mu.Unlock()
+}
+
+// Buffer implements Backplane, keeping 1000 messages per stream for 24h
+func (b *MemoryBackplane) Buffer(_ context.Context, stream string, msg *BufferedMessage) error {
+	b.mu.Lock()
+	buffer, ok := b.streams[stream]
+	if !ok {
+		buffer = newMessageBuffer(defaultReplayBufferSize, defaultReplayBufferTTL)
+		b.streams[stream] = buffer
+	}
+	b.mu.Unlock()
+
+	buffer.removeExpired(b.now())
+	buffer.AddMessage(msg)
+	return nil
+}
+
+// MessagesAfter implements Backplane
+func (b *MemoryBackplane) MessagesAfter(_ context.Context, lastID string, streams ...string) ([]*BufferedMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var since time.Time
+	for _, buffer := range b.streams {
+		if last, ok := buffer.GetMessage(lastID); ok {
+			since = last.CreatedAt
+			break
+		}
+	}
+	var messages []*BufferedMessage
+	for _, stream := range streams {
+		if buffer, ok := b.streams[stream]; ok {
+			messages = append(messages, buffer.messagesAfter(since)...)
+		}
+	}
+	sortByCreatedAt(messages)
+	return messages, nil
+}
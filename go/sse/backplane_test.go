@@ -0,0 +1,231 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+)
+
+// newPods returns two hubs sharing a MemoryBackplane, as on two instances
+func newPods(t *testing.T) (*Hub, *Hub) {
+	backplane := NewMemoryBackplane()
+	podA := newTopicHub(t, WithBackplane(backplane))
+	podB := newTopicHub(t, WithBackplane(backplane))
+	require.Eventually(t, func() bool {
+		backplane.mu.Lock()
+		defer backplane.mu.Unlock()
+		return len(backplane.handlers) == 2
+	}, time.Second, 10*time.Millisecond)
+	return podA, podB
+}
+
+func TestBackplane_FansOutAcrossHubs(t *testing.T) {
+	podA, podB := newPods(t)
+	serverA, serverB := newTestServer(t, podA), newTestServer(t, podB)
+
+	alice, _ := connect(t, serverA, "alice-1", "alice", "topics=orders")
+	aliceTab2, _ := connect(t, serverB, "alice-2", "alice", "")
+	bob, _ := connect(t, serverB, "bob-1", "bob", "topics=orders")
+	require.Eventually(t, func() bool { return podA.GetClientCount() == 1 && podB.GetClientCount() == 2 }, time.Second, 10*time.Millisecond)
+
+	podA.Broadcast(NewSSEMessage("news", "hello"))
+	for _, stream := range []*testStream{alice, aliceTab2, bob} {
+		event := stream.next(t)
+		assert.Equal(t, "news", event.Event)
+		assert.Equal(t, "hello", event.Data, "a string is sent as is by every instance")
+	}
+
+	podB.PublishToTopic("orders", NewSSEMessage("order.created", map[string]int{"id": 1}))
+	assert.JSONEq(t, `{"id":1}`, alice.next(t).Data)
+	assert.JSONEq(t, `{"id":1}`, bob.next(t).Data)
+
+	require.NoError(t, podA.SendToUser("alice", NewSSEMessage("user.notified", "1")))
+	assert.Equal(t, "user.notified", alice.next(t).Event)
+	assert.Equal(t, "user.notified", aliceTab2.next(t).Event)
+
+	require.NoError(t, podA.SendToClient("bob-1", NewSSEMessage("client.notified", "2")), "the client is connected to another instance")
+	assert.Equal(t, "client.notified", bob.next(t).Event)
+	require.NoError(t, podA.SendToTenant("globex", NewSSEMessage("tenant.notified", "3")))
+
+	require.NoError(t, podA.SendToUser("alice", &Message{Event: "invoice.paid", ID: "invoice-1", Data: "6"}))
+	require.NoError(t, podA.SendToUser("bob", &Message{Event: "invoice.paid", ID: "invoice-1", Data: "6"}))
+	assert.Equal(t, "invoice-1", alice.next(t).ID)
+	assert.Equal(t, "invoice-1", aliceTab2.next(t).ID)
+	assert.Equal(t, "invoice-1", bob.next(t).ID, "the same ID is sent to other recipients")
+
+	// a cron job of every instance sends the same message
+	podA.Broadcast(&Message{Event: "report.ready", ID: "report-2026-10-19", Data: "4"})
+	podB.Broadcast(&Message{Event: "report.ready", ID: "report-2026-10-19", Data: "4"})
+	podB.Broadcast(NewSSEMessage("news", "5"))
+	for _, stream := range []*testStream{alice, aliceTab2, bob} {
+		assert.Equal(t, "report.ready", stream.next(t).Event)
+		assert.Equal(t, "news", stream.next(t).Event, "the message ID is sent once")
+	}
+}
+
+func TestBackplane_SharedReplay(t *testing.T) {
+	podA, podB := newPods(t)
+	serverB := newTestServer(t, podB)
+
+	for _, id := range []string{"1", "2", "3"} {
+		podA.PublishToTopic("orders", &Message{Event: "order.created", ID: "order-" + id, Data: id})
+	}
+	podA.PublishToTopic("invoices", &Message{Event: "invoice.created", ID: "invoice-1", Data: "1"})
+	podA.Broadcast(&Message{Event: "news", ID: "news-1", Data: "1"})
+	podA.Broadcast(&Message{Event: "news", ID: "news-2", Data: "2"})
+	assert.Nil(t, podA.GetTopicBuffer("orders"), "the topics are buffered in the backplane")
+
+	stream, _ := connect(t, serverB, "alice-1", "alice", "topics=orders", "Last-Event-ID", "order-1")
+	assert.Equal(t, "order-2", stream.next(t).ID, "the client reconnecting to another instance gets the messages it missed")
+	assert.Equal(t, "order-3", stream.next(t).ID)
+
+	missed := podB.GetMissedMessages("alice-1", "news-1")
+	require.Len(t, missed, 1)
+	assert.Equal(t, "news-2", missed[0].ID)
+}
+
+// fakeConn is a redis connection serving SET NX and recording the published messages
+type fakeConn struct {
+	mu          sync.Mutex
+	keys        map[string]string
+	published   []string
+	failPublish bool
+}
+
+func (c *fakeConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch commandName {
+	case "SET":
+		key := args[0].(string) //nolint:errcheck
+		if _, ok := c.keys[key]; ok {
+			return nil, nil
+		}
+		c.keys[key] = args[1].(string) //nolint:errcheck
+		return "OK", nil
+	case "DEL":
+		delete(c.keys, args[0].(string)) //nolint:errcheck
+		return int64(1), nil
+	case "PUBLISH":
+		if c.failPublish {
+			return nil, errors.New("connection reset")
+		}
+		c.published = append(c.published, string(args[1].([]byte))) //nolint:errcheck
+		return int64(1), nil
+	}
+	return nil, errors.Errorf("unexpected command %s", commandName)
+}
+
+func (c *fakeConn) Close() error                                    { return nil }
+func (c *fakeConn) Err() error                                      { return nil }
+func (c *fakeConn) Send(string, ...interface{}) error               { return nil }
+func (c *fakeConn) Flush() error                                    { return nil }
+func (c *fakeConn) Receive() (interface{}, error)                   { return nil, nil }
+func (c *fakeConn) Get() redigo.Conn                                { return c }
+func (c *fakeConn) GetContext(context.Context) (redigo.Conn, error) { return c, nil }
+
+// downPool is a redis that cannot be reached
+type downPool struct{}
+
+func (downPool) Get() redigo.Conn { return nil } // unused without Run
+
+func (downPool) GetContext(context.Context) (redigo.Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRedisBackplane_Publish(t *testing.T) {
+	conn := &fakeConn{keys: map[string]string{}}
+	backplane := NewRedisBackplane(&cache.Store{Pool: conn})
+	env, err := newEnvelope("pod-a", scopeTopic, "orders", &Message{Event: "order.created", ID: "order-1", Data: map[string]int{"id": 1}})
+	require.NoError(t, err)
+
+	published, err := backplane.Publish(context.Background(), env)
+	require.NoError(t, err)
+	assert.True(t, published)
+	published, err = backplane.Publish(context.Background(), env)
+	require.NoError(t, err)
+	assert.False(t, published, "the message ID is claimed by the first instance")
+
+	env.Target = "invoices"
+	published, err = backplane.Publish(context.Background(), env)
+	require.NoError(t, err)
+	assert.True(t, published, "the message ID is claimed for its recipients")
+
+	require.Len(t, conn.published, 2)
+	var received Envelope
+	require.NoError(t, json.Unmarshal([]byte(conn.published[0]), &received))
+	assert.Equal(t, "orders", received.Target)
+	assert.JSONEq(t, `{"id":1}`, string(received.Message().Data.(json.RawMessage))) //nolint:errcheck
+}
+
+func TestRedisBackplane_PublishFailureReleasesTheID(t *testing.T) {
+	conn := &fakeConn{keys: map[string]string{}, failPublish: true}
+	backplane := NewRedisBackplane(&cache.Store{Pool: conn})
+	env, err := newEnvelope("pod-a", scopeBroadcast, "", &Message{Event: "report.ready", ID: "report-1", Data: "1"})
+	require.NoError(t, err)
+
+	published, err := backplane.Publish(context.Background(), env)
+	require.Error(t, err)
+	assert.False(t, published)
+	assert.Empty(t, conn.keys, "the message ID is released")
+
+	conn.failPublish = false
+	published, err = backplane.Publish(context.Background(), env)
+	require.NoError(t, err)
+	assert.True(t, published, "the retry is not dropped as a duplicate")
+}
+
+func TestMemoryBackplane_DedupWindow(t *testing.T) {
+	backplane := NewMemoryBackplane()
+	now := time.Now()
+	backplane.now = func() time.Time { return now }
+	env := &Envelope{Scope: scopeUser, Target: "alice", ID: "invoice-1"}
+
+	published, _ := backplane.Publish(context.Background(), env)
+	assert.True(t, published)
+	published, _ = backplane.Publish(context.Background(), env)
+	assert.False(t, published)
+
+	now = now.Add(defaultDedupWindow + time.Second)
+	published, _ = backplane.Publish(context.Background(), &Envelope{Scope: scopeBroadcast, ID: "news-1"})
+	assert.True(t, published)
+	assert.Len(t, backplane.published, 1, "the ended dedup windows are removed")
+	published, _ = backplane.Publish(context.Background(), env)
+	assert.True(t, published)
+}
+
+func TestRedisBackplane_Unavailable(t *testing.T) {
+	hub := NewHub(context.Background(), WithBackplane(NewRedisBackplane(&cache.Store{Pool: downPool{}})))
+	client := &Client{ID: "client-1", Channel: make(chan *Message, 1), Request: &http.Request{}}
+	hub.mu.Lock()
+	hub.clients[client.ID] = client
+	hub.mu.Unlock()
+
+	hub.Broadcast(NewSSEMessage("news", "hello"))
+	select {
+	case message := <-client.Channel:
+		assert.Equal(t, "news", message.Event, "the clients of the instance still get the message")
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+	assert.Empty(t, hub.GetMissedMessages(client.ID, ""))
+}
+
+func TestStreamEntryTime(t *testing.T) {
+	createdAt, ok := streamEntryTime("1760832000000-3")
+	require.True(t, ok)
+	assert.Equal(t, time.UnixMilli(1760832000000), createdAt)
+
+	_, ok = streamEntryTime("invalid")
+	assert.False(t, ok)
+}
@@ -1,6 +1,7 @@
 package sse
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -35,11 +36,7 @@ type ClientDeliveryTracker struct {
 
 // NewMessageBuffer creates a new message buffer
 func NewMessageBuffer(maxSize int, ttl time.Duration) *MessageBuffer {
-	mb := &MessageBuffer{
-		messages: make(map[string]*BufferedMessage),
-		maxSize:  maxSize,
-		ttl:      ttl,
-	}
+	mb := newMessageBuffer(maxSize, ttl)
 
 	// Start cleanup goroutine
 	go mb.cleanupExpired()
@@ -47,6 +44,15 @@ func NewMessageBuffer(maxSize int, ttl time.Duration) *MessageBuffer {
 	return mb
 }
 
+// newMessageBuffer creates a message buffer whose expired messages are removed by its owner
+func newMessageBuffer(maxSize int, ttl time.Duration) *MessageBuffer {
+	return &MessageBuffer{
+		messages: make(map[string]*BufferedMessage),
+		maxSize:  maxSize,
+		ttl:      ttl,
+	}
+}
+
 // AddMessage adds a message to the buffer
 func (mb *MessageBuffer) AddMessage(msg *BufferedMessage) {
 	mb.mu.Lock()
@@ -82,6 +88,36 @@ func (mb *MessageBuffer) GetMessagesSince(messageID string) []*BufferedMessage {
 	return result
 }
 
+// GetMessage returns the buffered message with ID messageID
+func (mb *MessageBuffer) GetMessage(messageID string) (*BufferedMessage, bool) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	msg, ok := mb.messages[messageID]
+	return msg, ok
+}
+
+// messagesAfter returns the messages buffered after since, oldest first
+func (mb *MessageBuffer) messagesAfter(since time.Time) []*BufferedMessage {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	var result []*BufferedMessage
+	for _, msg := range mb.messages {
+		if msg.CreatedAt.After(since) {
+			result = append(result, msg)
+		}
+	}
+	sortByCreatedAt(result)
+	return result
+}
+
+// sortByCreatedAt sorts messages oldest first
+func sortByCreatedAt(messages []*BufferedMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+}
+
 // GetUndeliveredMessages returns messages that haven't been delivered to a client
 func (mb *MessageBuffer) GetUndeliveredMessages(clientID string, lastAcknowledgedID string) []*BufferedMessage {
 	return mb.GetMessagesSince(lastAcknowledgedID)
@@ -110,14 +146,18 @@ func (mb *MessageBuffer) cleanupExpired() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		mb.mu.Lock()
-		now := time.Now()
-		for id, msg := range mb.messages {
-			if now.After(msg.ExpiresAt) {
-				delete(mb.messages, id)
-			}
+		mb.removeExpired(time.Now())
+	}
+}
+
+// removeExpired removes the messages expired at now
+func (mb *MessageBuffer) removeExpired(now time.Time) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for id, msg := range mb.messages {
+		if now.After(msg.ExpiresAt) {
+			delete(mb.messages, id)
 		}
-		mb.mu.Unlock()
 	}
 }
 
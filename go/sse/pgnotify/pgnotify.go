@@ -0,0 +1,86 @@
+// Package pgnotify forwards the Postgres LISTEN/NOTIFY notifications of a database.Listener to the
+// clients of an SSE hub. It is kept apart from the sse package so SSE does not depend on the
+// database drivers.
+package pgnotify
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/sse"
+)
+
+type (
+	// Publisher sends the forwarded messages, implemented by *sse.Hub
+	Publisher interface {
+		Broadcast(message *sse.Message)
+		SendToClient(clientID string, message *sse.Message) error
+	}
+
+	// Option customizes how database notifications are turned into SSE messages
+	Option func(*forwarder)
+
+	forwarder struct {
+		hub         Publisher
+		eventMapper func(notification *database.Notification) string
+		target      func(notification *database.Notification) (clientID string, ok bool)
+	}
+)
+
+// WithEventName sends every forwarded notification with the given SSE event name
+// (default: the NOTIFY channel name)
+func WithEventName(event string) Option {
+	return func(f *forwarder) {
+		f.eventMapper = func(*database.Notification) string { return event }
+	}
+}
+
+// WithEventMapper derives the SSE event name from the notification (e.g. from a field of the payload)
+func WithEventMapper(mapper func(notification *database.Notification) string) Option {
+	return func(f *forwarder) {
+		f.eventMapper = mapper
+	}
+}
+
+// WithClientTarget sends the notification only to the returned client (SendToClient)
+// instead of broadcasting it, when ok is true
+func WithClientTarget(target func(notification *database.Notification) (clientID string, ok bool)) Option {
+	return func(f *forwarder) {
+		f.target = target
+	}
+}
+
+// Forwarder returns a database.NotificationHandler that pushes
+// Postgres NOTIFY payloads to the SSE clients of hub.
+// JSON payloads are forwarded as raw JSON, anything else as a string.
+func Forwarder(hub Publisher, opts ...Option) database.NotificationHandler {
+	f := &forwarder{hub: hub}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f.forward
+}
+
+func (f *forwarder) forward(_ context.Context, notification *database.Notification) error {
+	event := notification.Channel
+	if f.eventMapper != nil {
+		if mapped := f.eventMapper(notification); mapped != "" {
+			event = mapped
+		}
+	}
+
+	var data interface{} = notification.Payload
+	if json.Valid([]byte(notification.Payload)) {
+		data = json.RawMessage(notification.Payload)
+	}
+	message := sse.NewSSEMessage(event, data)
+
+	if f.target != nil {
+		if clientID, ok := f.target(notification); ok {
+			return f.hub.SendToClient(clientID, message)
+		}
+	}
+	f.hub.Broadcast(message)
+	return nil
+}
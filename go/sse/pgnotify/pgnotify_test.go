@@ -0,0 +1,67 @@
+package pgnotify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kodekoding/phastos/v2/go/database"
+	"github.com/kodekoding/phastos/v2/go/sse"
+)
+
+var _ Publisher = (*sse.Hub)(nil)
+
+// recorder is a Publisher recording the messages, with a single client "client-1"
+type recorder struct {
+	broadcast []*sse.Message
+	sent      map[string][]*sse.Message
+}
+
+func (r *recorder) Broadcast(message *sse.Message) {
+	r.broadcast = append(r.broadcast, message)
+}
+
+func (r *recorder) SendToClient(clientID string, message *sse.Message) error {
+	if clientID != "client-1" {
+		return errors.New("client not found")
+	}
+	r.sent[clientID] = append(r.sent[clientID], message)
+	return nil
+}
+
+func TestForwarder(t *testing.T) {
+	hub := &recorder{sent: map[string][]*sse.Message{}}
+
+	t.Run("should broadcast with channel as event name", func(t *testing.T) {
+		forward := Forwarder(hub)
+		require.NoError(t, forward(context.Background(), &database.Notification{Channel: "orders", Payload: `{"id":1}`}))
+		require.Len(t, hub.broadcast, 1)
+		assert.Equal(t, "orders", hub.broadcast[0].Event)
+		assert.Equal(t, json.RawMessage(`{"id":1}`), hub.broadcast[0].Data)
+	})
+
+	t.Run("should map event name and keep non-JSON payload as string", func(t *testing.T) {
+		forward := Forwarder(hub, WithEventName("order_updated"))
+		require.NoError(t, forward(context.Background(), &database.Notification{Channel: "orders", Payload: "plain text"}))
+		require.Len(t, hub.broadcast, 2)
+		assert.Equal(t, "order_updated", hub.broadcast[1].Event)
+		assert.Equal(t, "plain text", hub.broadcast[1].Data)
+	})
+
+	t.Run("should send to targeted client", func(t *testing.T) {
+		forward := Forwarder(hub,
+			WithEventMapper(func(n *database.Notification) string { return n.Channel + ".changed" }),
+			WithClientTarget(func(n *database.Notification) (string, bool) { return n.Payload, n.Payload != "" }),
+		)
+		require.NoError(t, forward(context.Background(), &database.Notification{Channel: "users", Payload: "client-1"}))
+		require.Len(t, hub.sent["client-1"], 1)
+		assert.Equal(t, "users.changed", hub.sent["client-1"][0].Event)
+
+		assert.Error(t, forward(context.Background(), &database.Notification{Channel: "users", Payload: "unknown"}))
+		assert.Len(t, hub.broadcast, 2, "a targeted notification is not broadcast")
+	})
+}
@@ -0,0 +1,378 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/cache"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+)
+
+const (
+	defaultBackplaneChannel = "sse:backplane"
+
+	// the replay keys share the hash tag {sse-replay}, so the scripts run on a Redis Cluster
+	replayKeyPrefix = "sse:{sse-replay}:"
+
+	streamReadBlock = 5 * time.Second
+)
+
+var (
+	// KEYS: stream, message ID key. ARGV: message, max length, TTL (ms).
+	// The message ID points to its stream entry, for the replay after it.
+	bufferScript = redigo.NewScript(2, `
+local entry = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[2], '*', 'message', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('SET', KEYS[2], entry, 'PX', ARGV[3])
+return entry`)
+
+	// KEYS: last message ID key, streams. Returns the stream entries after the last message, or
+	// every entry when it is not buffered anymore.
+	messagesAfterScript = redigo.NewScript(-1, `
+local after = redis.call('GET', KEYS[1])
+local start = '-'
+if after then
+	start = '(' .. after
+end
+local result = {}
+for i = 2, #KEYS do
+	for _, entry in ipairs(redis.call('XRANGE', KEYS[i], start, '+')) do
+		table.insert(result, entry[1])
+		table.insert(result, entry[2][2])
+	end
+end
+return result`)
+)
+
+type (
+	// RedisBackplane is a Backplane between the instances sharing a Redis. The messages are fanned
+	// out with pub/sub, or a stream with WithBackplaneStream. The replay buffers are Redis streams,
+	// and the message IDs are claimed for the dedup window before publishing.
+	RedisBackplane struct {
+		store        *cache.Store
+		channel      string
+		streamMaxLen int64
+		dedupWindow  time.Duration
+		bufferSize   int
+		bufferTTL    time.Duration
+	}
+
+	// RedisBackplaneOption configures NewRedisBackplane
+	RedisBackplaneOption func(*RedisBackplane)
+)
+
+// WithBackplaneChannel sets the pub/sub channel or the stream of the messages (default
+// "sse:backplane", under the key prefix of the store), e.g. to separate the hubs of the services
+// sharing a Redis
+func WithBackplaneChannel(channel string) RedisBackplaneOption {
+	return func(b *RedisBackplane) {
+		b.channel = channel
+	}
+}
+
+// WithBackplaneStream fans the messages out with a Redis stream of about maxLen messages instead of
+// pub/sub, so an instance briefly disconnected from Redis gets the messages sent meanwhile
+func WithBackplaneStream(maxLen int64) RedisBackplaneOption {
+	return func(b *RedisBackplane) {
+		b.streamMaxLen = maxLen
+	}
+}
+
+// WithDedupWindow sets how long a message ID is remembered to drop the messages published again
+// with it to the same recipients (default 10 minutes, 0 disables the dedup)
+func WithDedupWindow(window time.Duration) RedisBackplaneOption {
+	return func(b *RedisBackplane) {
+		b.dedupWindow = window
+	}
+}
+
+// WithReplayBuffer keeps about size messages per replay stream (the broadcasts and every topic)
+// for ttl (default 1000 messages for 24h)
+func WithReplayBuffer(size int, ttl time.Duration) RedisBackplaneOption {
+	return func(b *RedisBackplane) {
+		b.bufferSize = size
+		b.bufferTTL = ttl
+	}
+}
+
+// NewRedisBackplane creates a RedisBackplane with the connections of store, under its key prefix
+//
+//	hub := sse.NewHub(ctx, sse.WithBackplane(sse.NewRedisBackplane(store)))
+func NewRedisBackplane(store *cache.Store, opts ...RedisBackplaneOption) *RedisBackplane {
+	b := &RedisBackplane{
+		store:       store,
+		channel:     defaultBackplaneChannel,
+		dedupWindow: defaultDedupWindow,
+		bufferSize:  defaultReplayBufferSize,
+		bufferTTL:   defaultReplayBufferTTL,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.channel = store.Key(b.channel)
+	return b
+}
+
+// Publish implements Backplane
+func (b *RedisBackplane) Publish(ctx context.Context, env *Envelope) (bool, error) {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return false, errors.Wrap(err, "phastos.sse.redis.Publish")
+	}
+	conn, err := b.store.Pool.GetContext(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "phastos.sse.redis.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	dedupKey := ""
+	if env.ID != "" && b.dedupWindow > 0 {
+		dedupKey = b.store.Key("sse:dedup:" + env.dedupKey())
+		_, err := redigo.String(conn.Do("SET", dedupKey, env.Origin, "NX", "PX", b.dedupWindow.Milliseconds()))
+		if errors.Is(err, redigo.ErrNil) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "phastos.sse.redis.Publish")
+		}
+	}
+
+	if b.streamMaxLen > 0 {
+		_, err = conn.Do("XADD", b.channel, "MAXLEN", "~", b.streamMaxLen, "*", "envelope", payload)
+	} else {
+		_, err = conn.Do("PUBLISH", b.channel, payload)
+	}
+	if err != nil {
+		if dedupKey != "" {
+			// release the message ID so that a retry is not dropped as a duplicate
+			_, _ = conn.Do("DEL", dedupKey)
+		}
+		return false, errors.Wrap(err, "phastos.sse.redis.Publish")
+	}
+	return true, nil
+}
+
+// Subscribe implements Backplane
+func (b *RedisBackplane) Subscribe(ctx context.Context, handle func(env *Envelope)) {
+	log := plog.Get()
+	receive := b.receivePubSub
+	if b.streamMaxLen > 0 {
+		lastID := "$"
+		receive = func(ctx context.Context, handle func(env *Envelope)) error {
+			return b.receiveStream(ctx, &lastID, handle)
+		}
+	}
+
+	backoff := 100 * time.Millisecond
+	for {
+		started := time.Now()
+		err := receive(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = 100 * time.Millisecond
+		}
+		log.Warn().Err(err).Str("channel", b.channel).Msg("[PHASTOS][SSE] backplane subscription lost, reconnecting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// receivePubSub passes the envelopes of the channel to handle until the connection is lost or ctx is done
+func (b *RedisBackplane) receivePubSub(ctx context.Context, handle func(env *Envelope)) error {
+	conn := b.store.Pool.Get()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer conn.Close() //nolint:errcheck
+
+	psc := redigo.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(b.channel); err != nil {
+		return err
+	}
+	_, withTimeout := conn.(redigo.ConnWithTimeout)
+	for {
+		var reply interface{}
+		if withTimeout {
+			// no read timeout, the channel may be quiet for long
+			reply = psc.ReceiveWithTimeout(0)
+		} else {
+			reply = psc.Receive()
+		}
+		switch msg := reply.(type) {
+		case redigo.Message:
+			b.handle(msg.Data, handle)
+		case error:
+			return msg
+		}
+	}
+}
+
+// receiveStream passes the envelopes of the stream after lastID to handle until the connection is
+// lost or ctx is done, lastID following the entries read
+func (b *RedisBackplane) receiveStream(ctx context.Context, lastID *string, handle func(env *Envelope)) error {
+	conn, err := b.store.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer conn.Close() //nolint:errcheck
+
+	for {
+		reply, err := redigo.Values(doBlocking(conn, streamReadBlock, "XREAD", "COUNT", 100, "BLOCK", streamReadBlock.Milliseconds(), "STREAMS", b.channel, *lastID))
+		if errors.Is(err, redigo.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, stream := range reply {
+			entries, err := streamEntries(stream)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				*lastID = entry.id
+				b.handle([]byte(entry.fields["envelope"]), handle)
+			}
+		}
+	}
+}
+
+// doBlocking runs a blocking command, extending the read timeout of the connection by block
+func doBlocking(conn redigo.Conn, block time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	if _, ok := conn.(redigo.ConnWithTimeout); ok {
+		return redigo.DoWithTimeout(conn, block+time.Second, commandName, args...)
+	}
+	return conn.Do(commandName, args...)
+}
+
+func (b *RedisBackplane) handle(payload []byte, handle func(env *Envelope)) {
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log := plog.Get()
+		log.Warn().Err(err).Msg("[PHASTOS][SSE] invalid backplane message")
+		return
+	}
+	handle(&env)
+}
+
+// Buffer implements Backplane
+func (b *RedisBackplane) Buffer(ctx context.Context, stream string, msg *BufferedMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "phastos.sse.redis.Buffer")
+	}
+	conn, err := b.store.Pool.GetContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "phastos.sse.redis.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	_, err = bufferScript.Do(conn, b.streamKey(stream), b.idKey(msg.ID), payload, b.bufferSize, b.bufferTTL.Milliseconds())
+	return errors.Wrap(err, "phastos.sse.redis.Buffer")
+}
+
+// MessagesAfter implements Backplane, without the messages older than the TTL of the buffers
+func (b *RedisBackplane) MessagesAfter(ctx context.Context, lastID string, streams ...string) ([]*BufferedMessage, error) {
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	conn, err := b.store.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.sse.redis.GetContext")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	args := []interface{}{len(streams) + 1, b.idKey(lastID)}
+	for _, stream := range streams {
+		args = append(args, b.streamKey(stream))
+	}
+	reply, err := redigo.Strings(messagesAfterScript.Do(conn, args...))
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.sse.redis.MessagesAfter")
+	}
+
+	expiredBefore := time.Now().Add(-b.bufferTTL)
+	messages := make([]*BufferedMessage, 0, len(reply)/2)
+	for i := 0; i+1 < len(reply); i += 2 {
+		createdAt, ok := streamEntryTime(reply[i])
+		if !ok || createdAt.Before(expiredBefore) {
+			continue
+		}
+		var msg BufferedMessage
+		if err := json.Unmarshal([]byte(reply[i+1]), &msg); err != nil {
+			continue
+		}
+		msg.CreatedAt = createdAt
+		msg.ExpiresAt = createdAt.Add(b.bufferTTL)
+		messages = append(messages, &msg)
+	}
+	sortByCreatedAt(messages)
+	return messages, nil
+}
+
+func (b *RedisBackplane) streamKey(stream string) string {
+	return b.store.Key(replayKeyPrefix + "stream:" + stream)
+}
+
+func (b *RedisBackplane) idKey(id string) string {
+	return b.store.Key(replayKeyPrefix + "id:" + id)
+}
+
+// streamEntryTime returns the time of a stream entry ID ("<ms>-<seq>"), the clock of Redis
+func streamEntryTime(id string) (time.Time, bool) {
+	ms, _, _ := strings.Cut(id, "-")
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
+
+type streamEntry struct {
+	id     string
+	fields map[string]string
+}
+
+// streamEntries parses a stream of an XREAD reply: its name and its entries
+func streamEntries(reply interface{}) ([]streamEntry, error) {
+	stream, err := redigo.Values(reply, nil)
+	if err != nil || len(stream) < 2 {
+		return nil, errors.New("phastos.sse.redis: invalid stream reply")
+	}
+	items, err := redigo.Values(stream[1], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "phastos.sse.redis: invalid stream entries")
+	}
+	entries := make([]streamEntry, 0, len(items))
+	for _, item := range items {
+		entry, err := redigo.Values(item, nil)
+		if err != nil || len(entry) < 2 {
+			return nil, errors.New("phastos.sse.redis: invalid stream entry")
+		}
+		id, err := redigo.String(entry[0], nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.sse.redis: invalid stream entry ID")
+		}
+		fields, err := redigo.StringMap(entry[1], nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "phastos.sse.redis: invalid stream entry fields")
+		}
+		entries = append(entries, streamEntry{id: id, fields: fields})
+	}
+	return entries, nil
+}
@@ -6,26 +6,38 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kodekoding/phastos/v2/go/helper"
 	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
 	"github.com/rs/zerolog"
 )
 
 type Events interface {
 	Broadcast(message *Message)
+	PublishToTopic(topic string, message *Message)
+	SendToUser(userID string, message *Message) error
+	SendToTenant(tenantID string, message *Message) error
+	Subscribe(clientID, topic string) error
+	Unsubscribe(clientID, topic string) error
 }
 
 // Client SSEClient represents a single SSE connection
 type Client struct {
-	ID         string
+	ID string
+	// Identity is the user and tenant of the connection, resolved by the identity resolver
+	Identity
 	Channel    chan *Message
 	Request    *http.Request
 	Writer     http.ResponseWriter
 	Flusher    http.Flusher
 	disconnect chan bool
 	mu         sync.Mutex
+	topics     map[string]struct{}
+	topicsMu   sync.Mutex
+	closed     bool // guarded by hub.mu, set when Channel is closed
 }
 
 // Message SSEMessage represents a message to be sent via SSE
@@ -45,6 +57,9 @@ type EncryptedTokenValidator func(encryptedToken string) (bool, error)
 // Hub SSEHub manages all SSE connections
 type Hub struct {
 	clients                 map[string]*Client
+	users                   map[string]map[string]*Client
+	tenants                 map[string]map[string]*Client
+	topics                  map[string]map[string]*Client
 	broadcast               chan *Message
 	register                chan *Client
 	unregister              chan *Client
@@ -56,13 +71,25 @@ type Hub struct {
 	cryptoManager           *helper.CryptoManager
 	messageBuffer           *MessageBuffer
 	deliveryManager         *ClientDeliveryManager
+	identityResolver        IdentityResolver
+	topicAuthorizer         TopicAuthorizer
+	topicBuffers            map[string]*MessageBuffer
+	topicBuffersMu          sync.Mutex
+	topicBufferSize         int
+	topicBufferTTL          time.Duration
+	backplane               Backplane
+	instanceID              string
+	running                 atomic.Bool
 }
 
 // NewHub creates a new SSE hub
-func NewHub(ctx context.Context) *Hub {
+func NewHub(ctx context.Context, opts ...HubOption) *Hub {
 	hubCtx, cancel := context.WithCancel(ctx)
-	return &Hub{
+	hub := &Hub{
 		clients:                 make(map[string]*Client),
+		users:                   make(map[string]map[string]*Client),
+		tenants:                 make(map[string]map[string]*Client),
+		topics:                  make(map[string]map[string]*Client),
 		broadcast:               make(chan *Message, 100),
 		register:                make(chan *Client),
 		unregister:              make(chan *Client),
@@ -73,7 +100,15 @@ func NewHub(ctx context.Context) *Hub {
 		cryptoManager:           nil,
 		messageBuffer:           NewMessageBuffer(1000, 24*time.Hour), // Keep 1000 messages for 24 hours
 		deliveryManager:         NewClientDeliveryManager(),
+		topicBuffers:            make(map[string]*MessageBuffer),
+		topicBufferSize:         defaultTopicBufferSize,
+		topicBufferTTL:          defaultTopicBufferTTL,
+		instanceID:              newInstanceID(),
 	}
+	for _, opt := range opts {
+		opt(hub)
+	}
+	return hub
 }
 
 // SetTokenValidator sets the token validation function
@@ -110,36 +145,74 @@ func (hub *Hub) GetDeliveryManager() *ClientDeliveryManager {
 func (hub *Hub) Run() {
 	log := plog.Get()
 	log.Info().Msg("SSE Hub started")
+	hub.running.Store(true)
+	defer hub.running.Store(false)
+
+	if hub.backplane != nil {
+		go hub.backplane.Subscribe(hub.ctx, hub.receive)
+	}
+
+	// removes the expired messages of the topic buffers
+	cleanup := time.NewTicker(30 * time.Second)
+	defer cleanup.Stop()
 
 	for {
 		select {
+		case now := <-cleanup.C:
+			hub.removeExpiredTopicMessages(now)
 		case <-hub.ctx.Done():
 			log.Info().Msg("SSE Hub stopped")
 			return
-		case client := <-hub.register:
+		case client, ok := <-hub.register:
+			if !ok {
+				return
+			}
 			hub.mu.Lock()
+			if previous, exists := hub.clients[client.ID]; exists && previous.UserID != client.UserID {
+				// another user took the ID since Handle checked it
+				hub.mu.Unlock()
+				log.Warn().Str("client_id", client.ID).Str("user_id", client.UserID).Msg("SSE client of another user not registered")
+				continue
+			}
 			hub.clients[client.ID] = client
+			hub.addMemberships(client)
+			metrics.SetSSEClients(len(hub.clients))
 			hub.mu.Unlock()
 			// Register client with delivery manager for message tracking
 			hub.deliveryManager.RegisterClient(client.ID)
 			log.Info().Str("client_id", client.ID).Int("total_clients", len(hub.clients)).Msg("SSE client registered")
-		case client := <-hub.unregister:
+		case client, ok := <-hub.unregister:
+			if !ok {
+				return
+			}
 			hub.mu.Lock()
-			if _, ok := hub.clients[client.ID]; ok {
+			// a connection replaced by a new one of the same client ID only leaves its memberships
+			current := hub.clients[client.ID] == client
+			if current && !client.closed {
 				close(client.Channel)
+				client.closed = true
 				delete(hub.clients, client.ID)
 			}
+			hub.removeMemberships(client)
+			metrics.SetSSEClients(len(hub.clients))
 			hub.mu.Unlock()
+			if !current {
+				continue
+			}
 			// Unregister client from delivery manager
 			hub.deliveryManager.UnregisterClient(client.ID)
 			log.Info().Str("client_id", client.ID).Int("total_clients", len(hub.clients)).Msg("SSE client unregistered")
-		case message := <-hub.broadcast:
+		case message, ok := <-hub.broadcast:
+			if !ok {
+				return
+			}
 			hub.mu.RLock()
 			for _, client := range hub.clients {
 				select {
 				case client.Channel <- message:
 				default:
 					// Channel is full, skip this message for this clientz
+					metrics.ObserveSSEDropped("client")
 					log.Warn().Str("client_id", client.ID).Msg("SSE message dropped for client")
 				}
 			}
@@ -148,6 +221,11 @@ func (hub *Hub) Run() {
 	}
 }
 
+// IsRunning reports whether Run is processing the hub channels
+func (hub *Hub) IsRunning() bool {
+	return hub.running.Load()
+}
+
 // Stop gracefully stops the SSE hub
 func (hub *Hub) Stop() {
 	log := plog.Get()
@@ -157,9 +235,16 @@ func (hub *Hub) Stop() {
 
 	hub.mu.Lock()
 	for _, client := range hub.clients {
-		close(client.Channel)
+		if !client.closed {
+			close(client.Channel)
+			client.closed = true
+		}
 	}
 	hub.clients = make(map[string]*Client)
+	hub.users = make(map[string]map[string]*Client)
+	hub.tenants = make(map[string]map[string]*Client)
+	hub.topics = make(map[string]map[string]*Client)
+	metrics.SetSSEClients(0)
 	hub.mu.Unlock()
 
 	close(hub.broadcast)
@@ -169,6 +254,14 @@ func (hub *Hub) Stop() {
 
 // Broadcast sends a message to all connected clients and buffers it for later retrieval
 func (hub *Hub) Broadcast(message *Message) {
+	if hub.backplane != nil {
+		if hub.fanOut(scopeBroadcast, "", message) {
+			hub.mu.RLock()
+			hub.deliver(hub.clients, message)
+			hub.mu.RUnlock()
+		}
+		return
+	}
 	// Buffer the message for offline clients
 
 	if hub.messageBuffer != nil && message.ID != "" {
@@ -201,6 +294,7 @@ func (hub *Hub) Broadcast(message *Message) {
 	case <-hub.ctx.Done():
 		log.Warn().Msg("Cannot broadcast, SSE hub is stopped")
 	default:
+		metrics.ObserveSSEDropped("hub")
 		log.Warn().Msg("Broadcast channel is full, message dropped")
 	}
 }
@@ -209,7 +303,7 @@ func (hub *Hub) Broadcast(message *Message) {
 // clientID: the client requesting missed messages
 // lastReceivedID: the ID of the last message the client successfully received
 func (hub *Hub) GetMissedMessages(clientID string, lastReceivedID string) []*BufferedMessage {
-	if hub.messageBuffer == nil {
+	if hub.messageBuffer == nil && hub.backplane == nil {
 		return nil
 	}
 	log := plog.Get()
@@ -219,6 +313,14 @@ func (hub *Hub) GetMissedMessages(clientID string, lastReceivedID string) []*Buf
 			Str("client_id", clientID).
 			Str("last_received_id", lastReceivedID)
 	})
+	if hub.backplane != nil {
+		// the broadcasts are buffered in the backplane
+		messages, err := hub.backplane.MessagesAfter(hub.ctx, lastReceivedID, broadcastStream)
+		if err != nil {
+			log.Warn().Err(err).Msg("[PHASTOS][SSE] failed to read the replay of the backplane")
+		}
+		return messages
+	}
 	return hub.messageBuffer.GetMessagesSince(lastReceivedID)
 }
 
@@ -228,6 +330,11 @@ func (hub *Hub) SendToClient(clientID string, message *Message) error {
 	client, exists := hub.clients[clientID]
 	hub.mu.RUnlock()
 
+	if !exists && hub.backplane != nil {
+		// the client may be connected to another instance
+		hub.fanOut(scopeClient, clientID, message)
+		return nil
+	}
 	if !exists {
 		return fmt.Errorf("client %s not found", clientID)
 	}
@@ -253,6 +360,7 @@ func (hub *Hub) SendToClient(clientID string, message *Message) error {
 	case client.Channel <- message:
 		return nil
 	default:
+		metrics.ObserveSSEDropped("client")
 		return fmt.Errorf("client %s channel is full", clientID)
 	}
 }
@@ -264,6 +372,26 @@ func (hub *Hub) GetClientCount() int {
 	return len(hub.clients)
 }
 
+// Authenticator returns the Authenticator checking the token of the clients connecting to Handle
+func (hub *Hub) Authenticator() *Authenticator {
+	return &Authenticator{
+		TokenValidator:          hub.tokenValidator,
+		EncryptedTokenValidator: hub.encryptedTokenValidator,
+		CryptoManager:           hub.cryptoManager,
+	}
+}
+
+// SetStreamHeaders sets the headers of an SSE response
+func SetStreamHeaders(header http.Header) {
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Encrypted-Token")
+	header.Set("X-Accel-Buffering", "no") // Disable proxy buffering
+}
+
 // Handle is an HTTP handler for SSE connections
 func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
 
@@ -272,94 +400,28 @@ func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
 	log.UpdateContext(func(c zerolog.Context) zerolog.Context {
 		return c.Str("process_name", "[SSE][CLIENT_CONNECTED]")
 	})
-	// Token validation - supports both plain and encrypted tokens
-	tokenValidated := false
-
-	// Try encrypted token validation first
-	if hub.encryptedTokenValidator != nil && hub.cryptoManager != nil {
-		encryptedToken := r.Header.Get("X-Encrypted-Token")
-		if encryptedToken == "" {
-			encryptedToken = r.URL.Query().Get("encrypted_token")
-		}
-
-		if encryptedToken != "" {
-			// Decrypt the token
-			decryptedToken, err := hub.cryptoManager.Decrypt(encryptedToken)
-			if err != nil {
-				log.Warn().Err(err).Msg("Failed to decrypt token")
-				http.Error(w, `{"code":"FORBIDDEN","message":"Invalid encrypted token"}`, http.StatusForbidden)
-				return
-			}
-
-			isValid, err := hub.encryptedTokenValidator(decryptedToken)
-			if err != nil {
-				log.Error().Err(err).Msg("Encrypted token validation error")
-				http.Error(w, `{"code":"SERVER_ERROR","message":"Token validation failed"}`, http.StatusInternalServerError)
-				return
-			}
-
-			if !isValid {
-				log.Warn().Msg("SSE connection attempt with invalid encrypted token")
-				http.Error(w, `{"code":"FORBIDDEN","message":"Invalid or expired token/api-key"}`, http.StatusForbidden)
-				return
-			}
-
-			tokenValidated = true
-			log.Debug().Msg("SSE encrypted token validation successful")
-		}
+	if authErr := hub.Authenticator().Authenticate(r); authErr != nil {
+		authErr.Write(w)
+		return
 	}
-
-	// Try plain text token validation if no encrypted token or encrypted validation is not enabled
-	if !tokenValidated && hub.tokenValidator != nil {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			token = r.URL.Query().Get("token")
-		}
-
-		if token == "" {
-			log.Warn().Msg("SSE connection attempt without token")
-			http.Error(w, `{"code":"UNAUTHORIZED","message":"Missing token/api-key"}`, http.StatusUnauthorized)
-			return
-		}
-
-		// Validate token - remove "Bearer " prefix if present
-		tokenValue := token
-		if len(token) > 7 && token[:7] == "Bearer " {
-			tokenValue = token[7:]
-		}
-
-		isValid, err := hub.tokenValidator(tokenValue)
-		if err != nil {
-			log.Error().Err(err).Msg("Token validation error")
-			http.Error(w, `{"code":"SERVER_ERROR","message":"Token validation failed"}`, http.StatusInternalServerError)
-			return
-		}
-
-		if !isValid {
-			log.Warn().Str("token", tokenValue[:len(tokenValue)-5]+"...").Msg("SSE connection attempt with invalid token")
-			http.Error(w, `{"code":"FORBIDDEN","message":"Invalid or expired token/api-key"}`, http.StatusForbidden)
-			return
-		}
-
-		tokenValidated = true
-		log.Debug().Msg("SSE token validation successful")
+	identity, authErr := hub.resolveIdentity(r)
+	if authErr != nil {
+		authErr.Write(w)
+		return
 	}
 
-	// Check if any validation is required but not passed
-	if (hub.tokenValidator != nil || hub.encryptedTokenValidator != nil) && !tokenValidated {
-		log.Warn().Msg("SSE connection attempt without valid token")
-		http.Error(w, `{"code":"UNAUTHORIZED","message":"Missing or invalid token/api-key"}`, http.StatusUnauthorized)
+	clientID := r.Header.Get("X-Client-ID")
+	if clientID == "" {
+		clientID = fmt.Sprintf("client-%d", time.Now().UnixNano())
+	}
+	if !hub.ownsClientID(clientID, identity.UserID) {
+		log.Warn().Str("client_id", clientID).Str("user_id", identity.UserID).Msg("SSE connection with the client ID of another user")
+		authErr := &AuthError{Status: http.StatusConflict, Code: "CLIENT_ID_IN_USE", Message: "Client of another user"}
+		authErr.Write(w)
 		return
 	}
 
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Encrypted-Token")
-	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	SetStreamHeaders(w.Header())
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -369,13 +431,10 @@ func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create client
-	clientID := r.Header.Get("X-Client-ID")
-	if clientID == "" {
-		clientID = fmt.Sprintf("client-%d", time.Now().UnixNano())
-	}
 
 	client := &Client{
 		ID:         clientID,
+		Identity:   *identity,
 		Channel:    make(chan *Message, 10),
 		Request:    r,
 		Writer:     w,
@@ -386,13 +445,51 @@ func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
 	// Register client
 	hub.register <- client
 
+	// Subscribe to the topics of the request, the forbidden ones are reported in the initial message
+	var rejectedTopics []string
+	for _, topic := range splitTopics(r.URL.Query().Get("topics")) {
+		if err := hub.subscribe(client, topic); err != nil {
+			log.Warn().Err(err).Str("client_id", clientID).Msg("SSE topic subscription rejected")
+			rejectedTopics = append(rejectedTopics, topic)
+		}
+	}
+
 	// Send initial connection message
+	connectedData := map[string]interface{}{"client_id": clientID, "timestamp": time.Now().Format(time.RFC3339)}
+	if client.UserID != "" {
+		connectedData["user_id"] = client.UserID
+	}
+	if client.TenantID != "" {
+		connectedData["tenant_id"] = client.TenantID
+	}
+	if topics := client.Topics(); len(topics) > 0 || len(rejectedTopics) > 0 {
+		connectedData["topics"] = topics
+	}
+	if len(rejectedTopics) > 0 {
+		connectedData["rejected_topics"] = rejectedTopics
+	}
 	initialMsg := &Message{
 		Event: "connected",
-		Data:  map[string]string{"client_id": clientID, "timestamp": time.Now().Format(time.RFC3339)},
+		Data:  connectedData,
 	}
 	client.sendMessage(initialMsg) //nolint:errcheck
 
+	// Replay the messages of the topics sent after the last event received (sent by EventSource
+	// on reconnect)
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		for _, msg := range hub.replayTopics(r.Context(), client, lastEventID) {
+			replayed := &Message{Event: msg.Event, ID: msg.ID, Retry: msg.Retry, Data: json.RawMessage(msg.Data)}
+			if err := client.sendMessage(replayed); err != nil {
+				log.Err(err).Str("client_id", clientID).Msg("Failed to replay SSE message")
+				break
+			}
+		}
+	}
+
 	// Handle client disconnect
 	defer func() {
 		hub.unregister <- client
@@ -429,6 +526,15 @@ func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ownsClientID reports whether userID may connect with clientID: the ID is free or its client
+// belongs to the same user
+func (hub *Hub) ownsClientID(clientID, userID string) bool {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	previous, exists := hub.clients[clientID]
+	return !exists || previous.UserID == userID
+}
+
 // sendMessage sends a message to the client
 func (client *Client) sendMessage(message *Message) error {
 	client.mu.Lock()
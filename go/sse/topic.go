@@ -0,0 +1,440 @@
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/metrics"
+)
+
+const (
+	defaultTopicBufferSize = 100
+	defaultTopicBufferTTL  = 24 * time.Hour
+)
+
+var (
+	// ErrClientNotFound is returned when subscribing a client that is not connected
+	ErrClientNotFound = errors.New("client not found")
+	// ErrUserNotConnected is returned when sending to a user without connection
+	ErrUserNotConnected = errors.New("user not connected")
+	// ErrTenantNotConnected is returned when sending to a tenant without connection
+	ErrTenantNotConnected = errors.New("tenant not connected")
+	// ErrTopicForbidden is returned when the topic authorizer rejects a subscription
+	ErrTopicForbidden = errors.New("topic forbidden")
+)
+
+type (
+	// Identity is the user and tenant of a connection, used by SendToUser, SendToTenant and the
+	// topic authorizer
+	Identity struct {
+		UserID   string
+		TenantID string
+		// Claims are the other claims of the token, e.g. the roles checked by the topic authorizer
+		Claims map[string]interface{}
+	}
+
+	// IdentityResolver returns the identity of a connection request, nil for an anonymous
+	// connection. An error rejects the connection with 401.
+	IdentityResolver func(r *http.Request) (*Identity, error)
+
+	// TokenIdentityParser returns the identity of a validated token
+	TokenIdentityParser func(token string) (*Identity, error)
+
+	// TopicAuthorizer reports whether client may subscribe to topic
+	TopicAuthorizer func(client *Client, topic string) bool
+
+	// HubOption tunes the Hub
+	HubOption func(*Hub)
+)
+
+// WithIdentityResolver sets how the identity of a connection is resolved, see SetIdentityResolver
+func WithIdentityResolver(resolver IdentityResolver) HubOption {
+	return func(hub *Hub) {
+		hub.identityResolver = resolver
+	}
+}
+
+// WithTokenIdentity resolves the identity of a connection from its token, read as the token
+// validators read it (the encrypted token is decrypted), e.g. by parsing the claims of a JWT
+func WithTokenIdentity(parse TokenIdentityParser) HubOption {
+	return func(hub *Hub) {
+		hub.identityResolver = func(r *http.Request) (*Identity, error) {
+			token, err := hub.Authenticator().Token(r)
+			if err != nil {
+				return nil, err
+			}
+			return parse(token)
+		}
+	}
+}
+
+// WithTopicAuthorizer sets the check of every subscription, see SetTopicAuthorizer
+func WithTopicAuthorizer(authorizer TopicAuthorizer) HubOption {
+	return func(hub *Hub) {
+		hub.topicAuthorizer = authorizer
+	}
+}
+
+// WithTopicBuffer keeps the last size messages of every topic for ttl to replay them on reconnect
+// (default 100 messages for 24h). A size of 0 disables the replay of the topics.
+func WithTopicBuffer(size int, ttl time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.topicBufferSize = size
+		hub.topicBufferTTL = ttl
+	}
+}
+
+// SetIdentityResolver sets how the identity of a connection is resolved. Without it every
+// connection is anonymous.
+func (hub *Hub) SetIdentityResolver(resolver IdentityResolver) {
+	hub.identityResolver = resolver
+}
+
+// SetTopicAuthorizer sets the check of every subscription, on connect, by HandleSubscriptions or
+// by Subscribe. Without it any topic can be subscribed to.
+func (hub *Hub) SetTopicAuthorizer(authorizer TopicAuthorizer) {
+	hub.topicAuthorizer = authorizer
+}
+
+// GetTopicBuffer returns the buffer of the messages published to topic, nil when none was
+func (hub *Hub) GetTopicBuffer(topic string) *MessageBuffer {
+	hub.topicBuffersMu.Lock()
+	defer hub.topicBuffersMu.Unlock()
+	return hub.topicBuffers[topic]
+}
+
+// Topics returns the topics the client subscribed to
+func (client *Client) Topics() []string {
+	client.topicsMu.Lock()
+	defer client.topicsMu.Unlock()
+
+	topics := make([]string, 0, len(client.topics))
+	for topic := range client.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// PublishToTopic sends message to the clients subscribed to topic, and buffers it for the replay
+// of the topic when it has an ID
+func (hub *Hub) PublishToTopic(topic string, message *Message) {
+	if hub.backplane == nil {
+		hub.bufferTopicMessage(topic, message)
+	} else if !hub.fanOut(scopeTopic, topic, message) {
+		return
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	hub.deliver(hub.topics[topic], message)
+}
+
+// SendToUser sends message to every connection of userID
+func (hub *Hub) SendToUser(userID string, message *Message) error {
+	if hub.backplane != nil && !hub.fanOut(scopeUser, userID, message) {
+		return nil
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	clients := hub.users[userID]
+	if len(clients) == 0 && hub.backplane == nil {
+		return errors.Wrapf(ErrUserNotConnected, "phastos.sse.SendToUser: %s", userID)
+	}
+	hub.deliver(clients, message)
+	return nil
+}
+
+// SendToTenant sends message to every connection of tenantID
+func (hub *Hub) SendToTenant(tenantID string, message *Message) error {
+	if hub.backplane != nil && !hub.fanOut(scopeTenant, tenantID, message) {
+		return nil
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	clients := hub.tenants[tenantID]
+	if len(clients) == 0 && hub.backplane == nil {
+		return errors.Wrapf(ErrTenantNotConnected, "phastos.sse.SendToTenant: %s", tenantID)
+	}
+	hub.deliver(clients, message)
+	return nil
+}
+
+// Subscribe subscribes a connected client to topic, checked by the topic authorizer
+func (hub *Hub) Subscribe(clientID, topic string) error {
+	hub.mu.RLock()
+	client, exists := hub.clients[clientID]
+	hub.mu.RUnlock()
+
+	if !exists {
+		return errors.Wrapf(ErrClientNotFound, "phastos.sse.Subscribe: %s", clientID)
+	}
+	return hub.subscribe(client, topic)
+}
+
+// Unsubscribe unsubscribes a connected client from topic
+func (hub *Hub) Unsubscribe(clientID, topic string) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	client, exists := hub.clients[clientID]
+	if !exists {
+		return errors.Wrapf(ErrClientNotFound, "phastos.sse.Unsubscribe: %s", clientID)
+	}
+	hub.removeTopic(client, topic)
+	return nil
+}
+
+// HandleSubscriptions is an HTTP handler changing the topics of a connected client: POST
+// subscribes and DELETE unsubscribes the client (client_id query or X-Client-ID header) to the
+// comma separated topics query. The request is authenticated like a connection, and a client with
+// a user can only be changed by that user. No topic is subscribed to when one is forbidden.
+func (hub *Hub) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	log := plog.Ctx(r.Context())
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		authErr := &AuthError{Status: http.StatusMethodNotAllowed, Code: "METHOD_NOT_ALLOWED", Message: "Use POST or DELETE"}
+		authErr.Write(w)
+		return
+	}
+	if authErr := hub.Authenticator().Authenticate(r); authErr != nil {
+		authErr.Write(w)
+		return
+	}
+	identity, authErr := hub.resolveIdentity(r)
+	if authErr != nil {
+		authErr.Write(w)
+		return
+	}
+
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		clientID = r.Header.Get("X-Client-ID")
+	}
+	hub.mu.RLock()
+	client, exists := hub.clients[clientID]
+	hub.mu.RUnlock()
+	if !exists {
+		authErr := &AuthError{Status: http.StatusNotFound, Code: "NOT_FOUND", Message: "Unknown client"}
+		authErr.Write(w)
+		return
+	}
+	if client.UserID != "" && client.UserID != identity.UserID {
+		log.Warn().Str("client_id", clientID).Str("user_id", identity.UserID).Msg("SSE subscription change of another user's client")
+		authErr := &AuthError{Status: http.StatusForbidden, Code: "FORBIDDEN", Message: "Client of another user"}
+		authErr.Write(w)
+		return
+	}
+
+	topics := splitTopics(r.URL.Query().Get("topics"))
+	if r.Method == http.MethodPost {
+		for _, topic := range topics {
+			if !hub.authorizeTopic(client, topic) {
+				authErr := &AuthError{Status: http.StatusForbidden, Code: "FORBIDDEN", Message: "Topic forbidden: " + topic}
+				authErr.Write(w)
+				return
+			}
+		}
+	}
+	for _, topic := range topics {
+		if r.Method == http.MethodPost {
+			if err := hub.subscribe(client, topic); err != nil {
+				log.Warn().Err(err).Str("client_id", clientID).Msg("SSE subscription failed")
+			}
+			continue
+		}
+		hub.mu.Lock()
+		hub.removeTopic(client, topic)
+		hub.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"client_id": client.ID,
+		"topics":    client.Topics(),
+	})
+}
+
+// resolveIdentity returns the identity of r, empty without identity resolver
+func (hub *Hub) resolveIdentity(r *http.Request) (*Identity, *AuthError) {
+	if hub.identityResolver == nil {
+		return &Identity{}, nil
+	}
+	identity, err := hub.identityResolver(r)
+	if err != nil {
+		log := plog.Ctx(r.Context())
+		log.Warn().Err(err).Msg("SSE connection attempt without identity")
+		return nil, &AuthError{Status: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "Unknown user"}
+	}
+	if identity == nil {
+		identity = &Identity{}
+	}
+	return identity, nil
+}
+
+func (hub *Hub) authorizeTopic(client *Client, topic string) bool {
+	return hub.topicAuthorizer == nil || hub.topicAuthorizer(client, topic)
+}
+
+func (hub *Hub) subscribe(client *Client, topic string) error {
+	if !hub.authorizeTopic(client, topic) {
+		return errors.Wrapf(ErrTopicForbidden, "phastos.sse.Subscribe: %s", topic)
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if client.closed {
+		// the client disconnected meanwhile, its channel is closed
+		return nil
+	}
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = make(map[string]*Client)
+	}
+	hub.topics[topic][client.ID] = client
+	client.topicsMu.Lock()
+	if client.topics == nil {
+		client.topics = make(map[string]struct{})
+	}
+	client.topics[topic] = struct{}{}
+	client.topicsMu.Unlock()
+	return nil
+}
+
+// removeTopic unsubscribes client from topic, hub.mu being locked
+func (hub *Hub) removeTopic(client *Client, topic string) {
+	client.topicsMu.Lock()
+	delete(client.topics, topic)
+	client.topicsMu.Unlock()
+	removeMember(hub.topics, topic, client)
+}
+
+// addMemberships adds client to its user and tenant, hub.mu being locked
+func (hub *Hub) addMemberships(client *Client) {
+	addMember(hub.users, client.UserID, client)
+	addMember(hub.tenants, client.TenantID, client)
+}
+
+// removeMemberships removes client from its user, tenant and topics, unless a new connection
+// replaced it, hub.mu being locked
+func (hub *Hub) removeMemberships(client *Client) {
+	removeMember(hub.users, client.UserID, client)
+	removeMember(hub.tenants, client.TenantID, client)
+	for _, topic := range client.Topics() {
+		removeMember(hub.topics, topic, client)
+	}
+}
+
+func addMember(groups map[string]map[string]*Client, key string, client *Client) {
+	if key == "" {
+		return
+	}
+	if groups[key] == nil {
+		groups[key] = make(map[string]*Client)
+	}
+	groups[key][client.ID] = client
+}
+
+func removeMember(groups map[string]map[string]*Client, key string, client *Client) {
+	if members := groups[key]; members[client.ID] == client {
+		delete(members, client.ID)
+		if len(members) == 0 {
+			delete(groups, key)
+		}
+	}
+}
+
+// deliver queues message for clients, hub.mu being read locked so that no channel is closed meanwhile
+func (hub *Hub) deliver(clients map[string]*Client, message *Message) {
+	for _, client := range clients {
+		if client.closed {
+			continue
+		}
+		select {
+		case client.Channel <- message:
+		default:
+			metrics.ObserveSSEDropped("client")
+			log := plog.Get()
+			log.Warn().Str("client_id", client.ID).Msg("SSE message dropped for client")
+		}
+	}
+}
+
+// bufferTopicMessage keeps a message with an ID in the buffer of topic
+func (hub *Hub) bufferTopicMessage(topic string, message *Message) {
+	if hub.topicBufferSize <= 0 || message.ID == "" {
+		return
+	}
+	hub.topicBuffersMu.Lock()
+	buffer, ok := hub.topicBuffers[topic]
+	if !ok {
+		buffer = newMessageBuffer(hub.topicBufferSize, hub.topicBufferTTL)
+		hub.topicBuffers[topic] = buffer
+	}
+	hub.topicBuffersMu.Unlock()
+
+	bufferedMsg := newBufferedMessage(message)
+	bufferedMsg.Extra = map[string]interface{}{"topic": topic}
+	buffer.AddMessage(bufferedMsg)
+}
+
+// removeExpiredTopicMessages removes the expired messages of the topic buffers, and the buffers
+// left empty
+func (hub *Hub) removeExpiredTopicMessages(now time.Time) {
+	hub.topicBuffersMu.Lock()
+	defer hub.topicBuffersMu.Unlock()
+	for topic, buffer := range hub.topicBuffers {
+		buffer.removeExpired(now)
+		if buffer.GetSize() == 0 {
+			delete(hub.topicBuffers, topic)
+		}
+	}
+}
+
+// topicMessagesAfter returns the messages of the topics of client buffered after lastEventID,
+// oldest first. When lastEventID is not buffered anymore, every buffered message is returned.
+func (hub *Hub) topicMessagesAfter(client *Client, lastEventID string) []*BufferedMessage {
+	var buffers []*MessageBuffer
+	for _, topic := range client.Topics() {
+		if buffer := hub.GetTopicBuffer(topic); buffer != nil {
+			buffers = append(buffers, buffer)
+		}
+	}
+
+	// the last event may be a message of a topic or a broadcast
+	var since time.Time
+	for _, buffer := range append([]*MessageBuffer{hub.messageBuffer}, buffers...) {
+		if buffer == nil {
+			continue
+		}
+		if last, ok := buffer.GetMessage(lastEventID); ok {
+			since = last.CreatedAt
+			break
+		}
+	}
+	var messages []*BufferedMessage
+	for _, buffer := range buffers {
+		messages = append(messages, buffer.messagesAfter(since)...)
+	}
+	sortByCreatedAt(messages)
+	return messages
+}
+
+func splitTopics(query string) []string {
+	var topics []string
+	for _, topic := range strings.Split(query, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
@@ -0,0 +1,245 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEvent is an event read from an SSE stream
+type testEvent struct {
+	Event string
+	ID    string
+	Data  string
+}
+
+type testStream struct {
+	reader *bufio.Reader
+}
+
+func newTestServer(t *testing.T, hub *Hub) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", hub.Handle)
+	mux.HandleFunc("/events/subscriptions", hub.HandleSubscriptions)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// connect opens a stream of clientID with token and query, and returns it with its connected event
+func connect(t *testing.T, server *httptest.Server, clientID, token, query string, header ...string) (*testStream, map[string]interface{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?"+query, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Client-ID", clientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	t.Cleanup(func() { res.Body.Close() }) //nolint:errcheck
+
+	stream := &testStream{reader: bufio.NewReader(res.Body)}
+	connected := stream.next(t)
+	require.Equal(t, "connected", connected.Event)
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(connected.Data), &data))
+	return stream, data
+}
+
+func (s *testStream) next(t *testing.T) testEvent {
+	events := make(chan testEvent, 1)
+	go func() {
+		var event testEvent
+		for {
+			line, err := s.reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				events <- event
+				return
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no SSE event received")
+		return testEvent{}
+	}
+}
+
+func newTopicHub(t *testing.T, opts ...HubOption) *Hub {
+	opts = append([]HubOption{
+		WithTokenIdentity(func(token string) (*Identity, error) {
+			users := map[string]*Identity{
+				"alice": {UserID: "alice", TenantID: "acme"},
+				"bob":   {UserID: "bob", TenantID: "acme"},
+				"carol": {UserID: "carol", TenantID: "globex", Claims: map[string]interface{}{"role": "admin"}},
+			}
+			if identity, ok := users[token]; ok {
+				return identity, nil
+			}
+			return nil, errors.New("unknown token")
+		}),
+		WithTopicAuthorizer(func(client *Client, topic string) bool {
+			return topic != "admin" || client.Claims["role"] == "admin"
+		}),
+	}, opts...)
+	hub := NewHub(context.Background(), opts...)
+	go hub.Run()
+	t.Cleanup(hub.cancel)
+	return hub
+}
+
+func TestHub_TopicsAndIdentity(t *testing.T) {
+	hub := newTopicHub(t)
+	server := newTestServer(t, hub)
+
+	aliceTab1, connected := connect(t, server, "alice-1", "alice", "topics=orders,admin")
+	assert.Equal(t, "alice", connected["user_id"])
+	assert.Equal(t, "acme", connected["tenant_id"])
+	assert.Equal(t, []interface{}{"orders"}, connected["topics"])
+	assert.Equal(t, []interface{}{"admin"}, connected["rejected_topics"], "the authorizer rejects the topic")
+	aliceTab2, _ := connect(t, server, "alice-2", "alice", "")
+	bob, _ := connect(t, server, "bob-1", "bob", "topics=orders")
+	carol, connected := connect(t, server, "carol-1", "carol", "topics=admin")
+	assert.Equal(t, []interface{}{"admin"}, connected["topics"])
+	require.Eventually(t, func() bool { return hub.GetClientCount() == 4 }, time.Second, 10*time.Millisecond)
+
+	hub.PublishToTopic("orders", &Message{Event: "order.created", Data: "1"})
+	assert.Equal(t, "order.created", aliceTab1.next(t).Event)
+	assert.Equal(t, "order.created", bob.next(t).Event)
+
+	require.NoError(t, hub.SendToUser("alice", &Message{Event: "user.notified", Data: "2"}))
+	assert.Equal(t, "user.notified", aliceTab1.next(t).Event, "every tab of the user gets it")
+	assert.Equal(t, "user.notified", aliceTab2.next(t).Event)
+
+	require.NoError(t, hub.SendToTenant("acme", &Message{Event: "tenant.notified", Data: "3"}))
+	for _, stream := range []*testStream{aliceTab1, aliceTab2, bob} {
+		assert.Equal(t, "tenant.notified", stream.next(t).Event)
+	}
+	hub.PublishToTopic("admin", &Message{Event: "admin.alert", Data: "4"})
+	assert.Equal(t, "admin.alert", carol.next(t).Event, "other tenants and topics got nothing before")
+
+	assert.ErrorIs(t, hub.SendToUser("dave", &Message{Event: "x"}), ErrUserNotConnected)
+	assert.ErrorIs(t, hub.SendToTenant("initech", &Message{Event: "x"}), ErrTenantNotConnected)
+}
+
+func TestHub_ClientIDOfAnotherUser(t *testing.T) {
+	hub := newTopicHub(t)
+	server := newTestServer(t, hub)
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Client-ID", "shared-1")
+	req.Header.Set("Authorization", "Bearer alice")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close() //nolint:errcheck
+	require.Eventually(t, func() bool { return hub.GetClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Client-ID", "shared-1")
+	req.Header.Set("Authorization", "Bearer bob")
+	stolen, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = stolen.Body.Close()
+	assert.Equal(t, http.StatusConflict, stolen.StatusCode, "the client of another user is not taken over")
+
+	reconnected, _ := connect(t, server, "shared-1", "alice", "")
+	disconnect()
+	time.Sleep(100 * time.Millisecond) // the replaced connection is unregistered
+
+	require.NoError(t, hub.SendToClient("shared-1", &Message{Event: "ping", Data: "1"}))
+	assert.Equal(t, "ping", reconnected.next(t).Event, "the replaced connection does not unregister the new one")
+}
+
+func TestHub_HandleSubscriptions(t *testing.T) {
+	hub := newTopicHub(t)
+	server := newTestServer(t, hub)
+	alice, _ := connect(t, server, "alice-1", "alice", "")
+	require.Eventually(t, func() bool { return hub.GetClientCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	change := func(method, token, topics string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+"/events/subscriptions?client_id=alice-1&topics="+topics, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() }) //nolint:errcheck
+		return res
+	}
+
+	res := change(http.MethodPost, "alice", "invoices,orders")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var body struct {
+		Topics []string `json:"topics"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.Equal(t, []string{"invoices", "orders"}, body.Topics)
+
+	assert.Equal(t, http.StatusForbidden, change(http.MethodPost, "bob", "reports").StatusCode, "another user cannot change the client")
+	assert.Equal(t, http.StatusForbidden, change(http.MethodPost, "alice", "reports,admin").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, change(http.MethodPost, "mallory", "reports").StatusCode)
+	assert.Equal(t, http.StatusOK, change(http.MethodDelete, "alice", "orders").StatusCode)
+
+	hub.mu.RLock()
+	client := hub.clients["alice-1"]
+	hub.mu.RUnlock()
+	assert.Equal(t, []string{"invoices"}, client.Topics(), "nothing is subscribed to when a topic is forbidden")
+
+	hub.PublishToTopic("orders", &Message{Event: "order.created", Data: "1"})
+	hub.PublishToTopic("invoices", &Message{Event: "invoice.created", Data: "2"})
+	assert.Equal(t, "invoice.created", alice.next(t).Event)
+
+	assert.ErrorIs(t, hub.Subscribe("alice-1", "admin"), ErrTopicForbidden)
+	assert.ErrorIs(t, hub.Subscribe("gone", "orders"), ErrClientNotFound)
+}
+
+func TestHub_TopicReplay(t *testing.T) {
+	hub := newTopicHub(t, WithTopicBuffer(2, time.Hour))
+	server := newTestServer(t, hub)
+
+	for _, id := range []string{"1", "2", "3"} {
+		hub.PublishToTopic("orders", &Message{Event: "order.created", ID: "order-" + id, Data: map[string]string{"id": id}})
+	}
+	hub.PublishToTopic("invoices", &Message{Event: "invoice.created", ID: "invoice-1", Data: "1"})
+	hub.PublishToTopic("orders", &Message{Event: "order.created", ID: "order-4", Data: map[string]string{"id": "4"}})
+	assert.Equal(t, 2, hub.GetTopicBuffer("orders").GetSize(), "the buffer of a topic keeps its last messages")
+	assert.Equal(t, 1, hub.GetTopicBuffer("invoices").GetSize(), "the topics are buffered apart")
+
+	stream, _ := connect(t, server, "alice-1", "alice", "topics=orders,invoices", "Last-Event-ID", "order-3")
+	replayed := stream.next(t)
+	assert.Equal(t, "invoice-1", replayed.ID)
+	replayed = stream.next(t)
+	assert.Equal(t, "order-4", replayed.ID)
+	assert.JSONEq(t, `{"id":"4"}`, replayed.Data)
+
+	hub.removeExpiredTopicMessages(time.Now().Add(2 * time.Hour))
+	assert.Nil(t, hub.GetTopicBuffer("orders"), "the expired buffers are removed")
+}
@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/sse"
+)
+
+// ErrClientClosed is returned when sending to a disconnected client
+var ErrClientClosed = errors.New("client closed")
+
+// Client represents a single WebSocket connection
+type Client struct {
+	ID      string
+	UserID  string
+	Request *http.Request
+
+	hub       *Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	rooms     map[string]struct{} // guarded by hub.mu
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Send queues message for this client. A client whose send buffer is full is disconnected.
+func (client *Client) Send(message *Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "phastos.ws.Send")
+	}
+	if !client.enqueue(payload) {
+		return errors.Wrapf(ErrClientClosed, "phastos.ws.Send: %s", client.ID)
+	}
+	return nil
+}
+
+// Rooms returns the rooms the client joined
+func (client *Client) Rooms() []string {
+	client.hub.mu.RLock()
+	defer client.hub.mu.RUnlock()
+
+	rooms := make([]string, 0, len(client.rooms))
+	for room := range client.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Close disconnects the client
+func (client *Client) Close() {
+	client.close(websocket.CloseNormalClosure, "")
+}
+
+func (client *Client) enqueue(payload []byte) bool {
+	select {
+	case <-client.done:
+		return false
+	default:
+	}
+
+	select {
+	case client.send <- payload:
+		return true
+	default:
+		// the client does not keep up, drop it rather than blocking the hub
+		log := plog.Get()
+		log.Warn().Str("client_id", client.ID).Msg("WebSocket send buffer is full, disconnecting slow client")
+		client.close(websocket.ClosePolicyViolation, "send buffer full")
+		return false
+	}
+}
+
+func (client *Client) sendError(messageID string, wsErr *Error) {
+	client.Send(&Message{Event: EventError, ID: messageID, Data: wsErr}) //nolint:errcheck
+}
+
+// close sends a close frame and closes the connection, only once
+func (client *Client) close(code int, reason string) {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		deadline := time.Now().Add(client.hub.writeWait)
+		_ = client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		_ = client.conn.Close()
+	})
+}
+
+// isRecipient reports whether a buffered message was addressed to this client
+func (client *Client) isRecipient(msg *sse.BufferedMessage) bool {
+	if room, ok := msg.Extra[scopeRoom].(string); ok {
+		client.hub.mu.RLock()
+		_, joined := client.rooms[room]
+		client.hub.mu.RUnlock()
+		return joined
+	}
+	if userID, ok := msg.Extra[scopeUser].(string); ok {
+		return userID == client.UserID
+	}
+	if clientID, ok := msg.Extra[scopeClient].(string); ok {
+		userID, _ := msg.Extra[clientUserKey].(string)
+		return clientID == client.ID && userID == client.UserID
+	}
+	return true
+}
+
+// readPump reads the frames of the client and dispatches them until the connection is closed
+func (client *Client) readPump(ctx context.Context) {
+	log := plog.Ctx(ctx)
+	hub := client.hub
+
+	client.conn.SetReadLimit(hub.maxMessageSize)
+	_ = client.conn.SetReadDeadline(time.Now().Add(hub.pongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(hub.pongWait))
+	})
+
+	for {
+		_, payload, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				log.Warn().Err(err).Str("client_id", client.ID).Msg("WebSocket connection closed unexpectedly")
+			}
+			return
+		}
+		_ = client.conn.SetReadDeadline(time.Now().Add(hub.pongWait))
+		hub.dispatch(ctx, client, payload)
+	}
+}
+
+// writePump writes the queued messages and the pings; it is the only writer of data frames
+func (client *Client) writePump() {
+	hub := client.hub
+	ticker := time.NewTicker(hub.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case payload := <-client.send:
+			_ = client.conn.SetWriteDeadline(time.Now().Add(hub.writeWait))
+			if err := client.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				client.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(hub.writeWait)); err != nil {
+				client.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
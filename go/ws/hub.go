@@ -0,0 +1,647 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/kodekoding/phastos/v2/go/helper"
+	plog "github.com/kodekoding/phastos/v2/go/log"
+	"github.com/kodekoding/phastos/v2/go/sse"
+)
+
+const (
+	defaultSendBufferSize = 256
+	defaultPingInterval   = 30 * time.Second
+	defaultPongWait       = 60 * time.Second
+	defaultWriteWait      = 10 * time.Second
+	defaultMaxMessageSize = 64 * 1024
+
+	// buffered message scopes, used to replay a message only to the clients it was sent to
+	scopeRoom   = "room"
+	scopeUser   = "user"
+	scopeClient = "client"
+
+	// clientUserKey is the user of the client of a message buffered for a client, who alone gets
+	// its replay
+	clientUserKey = "client_user"
+)
+
+var (
+	// ErrClientNotFound is returned when sending to a client that is not connected
+	ErrClientNotFound = errors.New("client not found")
+	// ErrUserNotConnected is returned when sending to a user without connection
+	ErrUserNotConnected = errors.New("user not connected")
+	// ErrRoomForbidden is returned when the room authorizer rejects a join
+	ErrRoomForbidden = errors.New("room forbidden")
+	// ErrClientIDInUse is returned when a connection takes the ID of a client of another user
+	ErrClientIDInUse = errors.New("client ID in use by another user")
+)
+
+type (
+	// IdentityResolver returns the user of a connection request, used by SendToUser.
+	// An error rejects the connection with 401.
+	IdentityResolver func(r *http.Request) (userID string, err error)
+
+	// RoomAuthorizer reports whether client may join room
+	RoomAuthorizer func(client *Client, room string) bool
+
+	// HubOption tunes the transport of the Hub
+	HubOption func(*Hub)
+
+	// Hub manages the WebSocket connections: authentication with the SSE token validators,
+	// rooms, per-user targeting, message handlers routed by event, ping/pong keepalive and
+	// per-client send buffers.
+	Hub struct {
+		clients          map[string]*Client
+		users            map[string]map[string]*Client
+		rooms            map[string]map[string]*Client
+		handlers         map[string]HandlerFunc
+		mu               sync.RWMutex
+		ctx              context.Context
+		cancel           context.CancelFunc
+		authenticator    sse.Authenticator
+		identityResolver IdentityResolver
+		roomAuthorizer   RoomAuthorizer
+		messageBuffer    *sse.MessageBuffer
+		upgrader         websocket.Upgrader
+		sendBufferSize   int
+		pingInterval     time.Duration
+		pongWait         time.Duration
+		writeWait        time.Duration
+		maxMessageSize   int64
+		running          atomic.Bool
+	}
+)
+
+// WithSendBufferSize sets the number of messages queued per client (default 256).
+// A client whose queue is full is too slow and gets disconnected.
+func WithSendBufferSize(size int) HubOption {
+	return func(hub *Hub) {
+		hub.sendBufferSize = size
+	}
+}
+
+// WithPingInterval sets how often clients are pinged (default 30s) and how long the hub waits
+// for any frame, pongs included, before closing the connection (default 60s)
+func WithPingInterval(interval, pongWait time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.pingInterval = interval
+		hub.pongWait = pongWait
+	}
+}
+
+// WithWriteWait sets the deadline of a single write (default 10s)
+func WithWriteWait(wait time.Duration) HubOption {
+	return func(hub *Hub) {
+		hub.writeWait = wait
+	}
+}
+
+// WithMaxMessageSize sets the largest frame accepted from a client (default 64KB)
+func WithMaxMessageSize(size int64) HubOption {
+	return func(hub *Hub) {
+		hub.maxMessageSize = size
+	}
+}
+
+// WithCheckOrigin sets the Origin check of the upgrade. By default only same-origin
+// browsers (and clients without Origin) are accepted.
+func WithCheckOrigin(check func(r *http.Request) bool) HubOption {
+	return func(hub *Hub) {
+		hub.upgrader.CheckOrigin = check
+	}
+}
+
+// NewHub creates a new WebSocket hub
+func NewHub(ctx context.Context, opts ...HubOption) *Hub {
+	hubCtx, cancel := context.WithCancel(ctx)
+	hub := &Hub{
+		clients:        make(map[string]*Client),
+		users:          make(map[string]map[string]*Client),
+		rooms:          make(map[string]map[string]*Client),
+		handlers:       make(map[string]HandlerFunc),
+		ctx:            hubCtx,
+		cancel:         cancel,
+		messageBuffer:  sse.NewMessageBuffer(1000, 24*time.Hour), // Keep 1000 messages for 24 hours
+		sendBufferSize: defaultSendBufferSize,
+		pingInterval:   defaultPingInterval,
+		pongWait:       defaultPongWait,
+		writeWait:      defaultWriteWait,
+		maxMessageSize: defaultMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(hub)
+	}
+	return hub
+}
+
+// SetTokenValidator sets the token validation function, see sse.Hub.SetTokenValidator
+func (hub *Hub) SetTokenValidator(validator sse.TokenValidator) {
+	hub.authenticator.TokenValidator = validator
+}
+
+// SetEncryptedTokenValidator sets the encrypted token validation function
+func (hub *Hub) SetEncryptedTokenValidator(validator sse.EncryptedTokenValidator) {
+	hub.authenticator.EncryptedTokenValidator = validator
+}
+
+// SetCryptoManager sets the crypto manager for decrypting tokens
+func (hub *Hub) SetCryptoManager(cm *helper.CryptoManager) {
+	hub.authenticator.CryptoManager = cm
+}
+
+// SetIdentityResolver sets how the user of a connection is resolved
+func (hub *Hub) SetIdentityResolver(resolver IdentityResolver) {
+	hub.identityResolver = resolver
+}
+
+// SetRoomAuthorizer sets the check of every join, by the client or by Join. Without it any room can be joined.
+func (hub *Hub) SetRoomAuthorizer(authorizer RoomAuthorizer) {
+	hub.roomAuthorizer = authorizer
+}
+
+// SetMessageBuffer sets a custom message buffer, nil disables replay
+func (hub *Hub) SetMessageBuffer(mb *sse.MessageBuffer) {
+	hub.messageBuffer = mb
+}
+
+// GetMessageBuffer returns the message buffer
+func (hub *Hub) GetMessageBuffer() *sse.MessageBuffer {
+	return hub.messageBuffer
+}
+
+// On routes the messages of event to handler
+func (hub *Hub) On(event string, handler HandlerFunc) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.handlers[event] = handler
+}
+
+// Run starts the hub and blocks until the context of the hub is done or Stop is called
+func (hub *Hub) Run() {
+	log := plog.Get()
+	log.Info().Msg("WebSocket Hub started")
+	hub.running.Store(true)
+	defer hub.running.Store(false)
+
+	<-hub.ctx.Done()
+	hub.closeAll()
+	log.Info().Msg("WebSocket Hub stopped")
+}
+
+// IsRunning reports whether the hub is running
+func (hub *Hub) IsRunning() bool {
+	return hub.running.Load()
+}
+
+// Stop disconnects every client and stops the hub
+func (hub *Hub) Stop() {
+	log := plog.Get()
+	log.Info().Msg("Stopping WebSocket Hub")
+	hub.cancel()
+	hub.closeAll()
+}
+
+func (hub *Hub) closeAll() {
+	hub.mu.RLock()
+	clients := make([]*Client, 0, len(hub.clients))
+	for _, client := range hub.clients {
+		clients = append(clients, client)
+	}
+	hub.mu.RUnlock()
+
+	for _, client := range clients {
+		client.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// Broadcast sends message to every connected client
+func (hub *Hub) Broadcast(message *Message) {
+	hub.bufferMessage(message, nil)
+
+	hub.mu.RLock()
+	clients := make([]*Client, 0, len(hub.clients))
+	for _, client := range hub.clients {
+		clients = append(clients, client)
+	}
+	hub.mu.RUnlock()
+	hub.deliver(clients, message)
+}
+
+// BroadcastToRoom sends message to the clients of room
+func (hub *Hub) BroadcastToRoom(room string, message *Message) {
+	message.Room = room
+	hub.bufferMessage(message, map[string]interface{}{scopeRoom: room})
+
+	hub.mu.RLock()
+	clients := make([]*Client, 0, len(hub.rooms[room]))
+	for _, client := range hub.rooms[room] {
+		clients = append(clients, client)
+	}
+	hub.mu.RUnlock()
+	hub.deliver(clients, message)
+}
+
+// SendToUser sends message to every connection of userID
+func (hub *Hub) SendToUser(userID string, message *Message) error {
+	hub.bufferMessage(message, map[string]interface{}{scopeUser: userID})
+
+	hub.mu.RLock()
+	clients := make([]*Client, 0, len(hub.users[userID]))
+	for _, client := range hub.users[userID] {
+		clients = append(clients, client)
+	}
+	hub.mu.RUnlock()
+
+	if len(clients) == 0 {
+		return errors.Wrapf(ErrUserNotConnected, "phastos.ws.SendToUser: %s", userID)
+	}
+	hub.deliver(clients, message)
+	return nil
+}
+
+// SendToClient sends message to a specific client
+func (hub *Hub) SendToClient(clientID string, message *Message) error {
+	hub.mu.RLock()
+	client, exists := hub.clients[clientID]
+	hub.mu.RUnlock()
+
+	if !exists {
+		return errors.Wrapf(ErrClientNotFound, "phastos.ws.SendToClient: %s", clientID)
+	}
+	hub.bufferMessage(message, map[string]interface{}{scopeClient: clientID, clientUserKey: client.UserID})
+	return client.Send(message)
+}
+
+// Join adds a connected client to room, checked by the room authorizer
+func (hub *Hub) Join(clientID, room string) error {
+	hub.mu.RLock()
+	client, exists := hub.clients[clientID]
+	hub.mu.RUnlock()
+
+	if !exists {
+		return errors.Wrapf(ErrClientNotFound, "phastos.ws.Join: %s", clientID)
+	}
+	return hub.join(client, room)
+}
+
+// Leave removes a connected client from room
+func (hub *Hub) Leave(clientID, room string) error {
+	hub.mu.RLock()
+	client, exists := hub.clients[clientID]
+	hub.mu.RUnlock()
+
+	if !exists {
+		return errors.Wrapf(ErrClientNotFound, "phastos.ws.Leave: %s", clientID)
+	}
+	hub.leave(client, room)
+	return nil
+}
+
+// GetClientCount returns the number of connected clients
+func (hub *Hub) GetClientCount() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.clients)
+}
+
+// GetRoomClients returns the IDs of the clients in room
+func (hub *Hub) GetRoomClients(room string) []string {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	clientIDs := make([]string, 0, len(hub.rooms[room]))
+	for clientID := range hub.rooms[room] {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Strings(clientIDs)
+	return clientIDs
+}
+
+// Handle is an HTTP handler upgrading the request to a WebSocket connection.
+//
+// The token is validated like an SSE connection (Authorization header / token query, or
+// X-Encrypted-Token header / encrypted_token query). The client ID comes from the X-Client-ID
+// header or the client_id query: a connection taking the ID of a connected client of another user
+// is rejected with 409. Rooms come from the comma separated rooms query, and the messages buffered
+// after last_event_id (query or Last-Event-ID header) are replayed on connect.
+func (hub *Hub) Handle(w http.ResponseWriter, r *http.Request) {
+	log := plog.Ctx(r.Context())
+
+	if authErr := hub.authenticator.Authenticate(r); authErr != nil {
+		authErr.Write(w)
+		return
+	}
+
+	var userID string
+	if hub.identityResolver != nil {
+		var err error
+		if userID, err = hub.identityResolver(r); err != nil {
+			log.Warn().Err(err).Msg("WebSocket connection attempt without identity")
+			authErr := &sse.AuthError{Status: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "Unknown user"}
+			authErr.Write(w)
+			return
+		}
+	}
+
+	clientID := r.Header.Get("X-Client-ID")
+	if clientID == "" {
+		clientID = r.URL.Query().Get("client_id")
+	}
+	if clientID == "" {
+		clientID = fmt.Sprintf("client-%d", time.Now().UnixNano())
+	}
+	if !hub.ownsClientID(clientID, userID) {
+		log.Warn().Str("client_id", clientID).Str("user_id", userID).Msg("WebSocket connection with the client ID of another user")
+		authErr := &sse.AuthError{Status: http.StatusConflict, Code: "CLIENT_ID_IN_USE", Message: "Client of another user"}
+		authErr.Write(w)
+		return
+	}
+
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with the HTTP error
+		log.Warn().Err(err).Msg("Failed to upgrade WebSocket connection")
+		return
+	}
+
+	client := &Client{
+		ID:      clientID,
+		UserID:  userID,
+		Request: r,
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan []byte, hub.sendBufferSize),
+		rooms:   make(map[string]struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := hub.register(client); err != nil {
+		// another user connected with the ID since the check
+		log.Warn().Err(err).Msg("Failed to register WebSocket client")
+		client.close(websocket.ClosePolicyViolation, "client ID in use")
+		return
+	}
+	defer hub.unregister(client)
+
+	go client.writePump()
+
+	for _, room := range strings.Split(r.URL.Query().Get("rooms"), ",") {
+		if room = strings.TrimSpace(room); room == "" {
+			continue
+		}
+		if err := hub.join(client, room); err != nil {
+			client.sendError("", NewError("FORBIDDEN", err.Error()))
+		}
+	}
+
+	client.Send(&Message{ //nolint:errcheck
+		Event: EventConnected,
+		Data: map[string]interface{}{
+			"client_id": clientID,
+			"user_id":   userID,
+			"rooms":     client.Rooms(),
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	})
+
+	lastEventID := r.URL.Query().Get("last_event_id")
+	if lastEventID == "" {
+		lastEventID = r.Header.Get("Last-Event-ID")
+	}
+	if lastEventID != "" {
+		hub.replay(client, lastEventID)
+	}
+
+	client.readPump(r.Context())
+}
+
+// ownsClientID reports whether userID may connect with clientID: the ID is free or its client
+// belongs to the same user
+func (hub *Hub) ownsClientID(clientID, userID string) bool {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	previous, exists := hub.clients[clientID]
+	return !exists || previous.UserID == userID
+}
+
+// register adds client, replacing the connection of the same client ID and user
+func (hub *Hub) register(client *Client) error {
+	log := plog.Get()
+
+	hub.mu.Lock()
+	previous, exists := hub.clients[client.ID]
+	if exists && previous.UserID != client.UserID {
+		hub.mu.Unlock()
+		return errors.Wrapf(ErrClientIDInUse, "phastos.ws.register: %s", client.ID)
+	}
+	hub.clients[client.ID] = client
+	if client.UserID != "" {
+		if hub.users[client.UserID] == nil {
+			hub.users[client.UserID] = make(map[string]*Client)
+		}
+		hub.users[client.UserID][client.ID] = client
+	}
+	total := len(hub.clients)
+	hub.mu.Unlock()
+
+	if exists {
+		// the same client reconnected, the old connection is gone
+		hub.removeMemberships(previous)
+		previous.close(websocket.ClosePolicyViolation, "replaced by a new connection")
+	}
+	log.Info().Str("client_id", client.ID).Str("user_id", client.UserID).Int("total_clients", total).Msg("WebSocket client registered")
+	return nil
+}
+
+func (hub *Hub) unregister(client *Client) {
+	log := plog.Get()
+	client.close(websocket.CloseNormalClosure, "")
+
+	hub.mu.Lock()
+	if current, ok := hub.clients[client.ID]; ok && current == client {
+		delete(hub.clients, client.ID)
+	}
+	total := len(hub.clients)
+	hub.mu.Unlock()
+
+	hub.removeMemberships(client)
+	log.Info().Str("client_id", client.ID).Int("total_clients", total).Msg("WebSocket client unregistered")
+}
+
+// removeMemberships removes client from its user and rooms, unless a new connection replaced it
+func (hub *Hub) removeMemberships(client *Client) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if users := hub.users[client.UserID]; users[client.ID] == client {
+		delete(users, client.ID)
+		if len(users) == 0 {
+			delete(hub.users, client.UserID)
+		}
+	}
+	for room := range client.rooms {
+		if members := hub.rooms[room]; members[client.ID] == client {
+			delete(members, client.ID)
+			if len(members) == 0 {
+				delete(hub.rooms, room)
+			}
+		}
+	}
+}
+
+func (hub *Hub) join(client *Client, room string) error {
+	if hub.roomAuthorizer != nil && !hub.roomAuthorizer(client, room) {
+		return errors.Wrapf(ErrRoomForbidden, "phastos.ws.Join: %s", room)
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.rooms[room] == nil {
+		hub.rooms[room] = make(map[string]*Client)
+	}
+	hub.rooms[room][client.ID] = client
+	client.rooms[room] = struct{}{}
+	return nil
+}
+
+func (hub *Hub) leave(client *Client, room string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	delete(client.rooms, room)
+	if members := hub.rooms[room]; members[client.ID] == client {
+		delete(members, client.ID)
+		if len(members) == 0 {
+			delete(hub.rooms, room)
+		}
+	}
+}
+
+func (hub *Hub) deliver(clients []*Client, message *Message) {
+	if len(clients) == 0 {
+		return
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log := plog.Get()
+		log.Err(err).Str("event", message.Event).Msg("Failed to encode WebSocket message")
+		return
+	}
+	for _, client := range clients {
+		client.enqueue(payload)
+	}
+}
+
+// bufferMessage keeps messages with an ID for replay, scoped to the room, user or client they were sent to
+func (hub *Hub) bufferMessage(message *Message, recipients map[string]interface{}) {
+	if hub.messageBuffer == nil || message.ID == "" {
+		return
+	}
+	bufferedMsg := &sse.BufferedMessage{
+		ID:        message.ID,
+		Event:     message.Event,
+		Timestamp: time.Now(),
+	}
+	if dataBytes, err := json.Marshal(message.Data); err == nil {
+		bufferedMsg.Data = string(dataBytes)
+	}
+	bufferedMsg.Extra = recipients
+	hub.messageBuffer.AddMessage(bufferedMsg)
+}
+
+// replay sends the buffered messages after lastEventID that were addressed to client, oldest
+// first. When lastEventID is not buffered anymore, every buffered message is replayed.
+func (hub *Hub) replay(client *Client, lastEventID string) {
+	if hub.messageBuffer == nil {
+		return
+	}
+	buffered := hub.messageBuffer.GetMessagesSince("")
+	sort.SliceStable(buffered, func(i, j int) bool {
+		return buffered[i].CreatedAt.Before(buffered[j].CreatedAt)
+	})
+	for i, msg := range buffered {
+		if msg.ID == lastEventID {
+			buffered = buffered[i+1:]
+			break
+		}
+	}
+
+	for _, msg := range buffered {
+		if !client.isRecipient(msg) {
+			continue
+		}
+		room, _ := msg.Extra[scopeRoom].(string)
+		client.Send(&Message{ //nolint:errcheck
+			Event: msg.Event,
+			ID:    msg.ID,
+			Room:  room,
+			Data:  json.RawMessage(msg.Data),
+		})
+	}
+}
+
+func (hub *Hub) dispatch(ctx context.Context, client *Client, payload []byte) {
+	log := plog.Ctx(ctx)
+
+	var inbound inboundMessage
+	if err := json.Unmarshal(payload, &inbound); err != nil || inbound.Event == "" {
+		client.sendError("", NewError("INVALID_MESSAGE", "message must be a JSON object with an event"))
+		return
+	}
+	message := &Message{Event: inbound.Event, ID: inbound.ID, Room: inbound.Room}
+	if len(inbound.Data) > 0 {
+		message.Data = inbound.Data
+	}
+
+	switch message.Event {
+	case EventJoin:
+		if message.Room == "" {
+			client.sendError(message.ID, NewError("INVALID_ROOM", "room is required"))
+			return
+		}
+		if err := hub.join(client, message.Room); err != nil {
+			client.sendError(message.ID, NewError("FORBIDDEN", err.Error()))
+			return
+		}
+		client.Send(&Message{Event: EventJoined, ID: message.ID, Room: message.Room}) //nolint:errcheck
+		return
+	case EventLeave:
+		hub.leave(client, message.Room)
+		client.Send(&Message{Event: EventLeft, ID: message.ID, Room: message.Room}) //nolint:errcheck
+		return
+	}
+
+	hub.mu.RLock()
+	handler, exists := hub.handlers[message.Event]
+	hub.mu.RUnlock()
+	if !exists {
+		client.sendError(message.ID, NewError("UNKNOWN_EVENT", fmt.Sprintf("no handler for event %s", message.Event)))
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Error().Any("panic", rec).Str("client_id", client.ID).Str("event", message.Event).Msg("WebSocket handler panicked")
+			client.sendError(message.ID, NewError("INTERNAL_ERROR", "Internal Server Error"))
+		}
+	}()
+
+	if err := handler(ctx, client, message); err != nil {
+		var wsErr *Error
+		if !errors.As(err, &wsErr) {
+			log.Err(err).Str("client_id", client.ID).Str("event", message.Event).Msg("WebSocket handler failed")
+			wsErr = NewError("INTERNAL_ERROR", "Internal Server Error")
+		}
+		client.sendError(message.ID, wsErr)
+	}
+}
@@ -0,0 +1,266 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, hub *Hub) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(hub.Handle))
+	t.Cleanup(func() {
+		hub.Stop()
+		server.Close()
+	})
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, query string, header http.Header) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) *Message {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	return &msg
+}
+
+// connect dials and consumes the connected event
+func connect(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	conn := dial(t, server, query, nil)
+	msg := readMessage(t, conn)
+	require.Equal(t, EventConnected, msg.Event)
+	return conn
+}
+
+func TestHub_TokenValidation(t *testing.T) {
+	hub := NewHub(context.Background())
+	hub.SetTokenValidator(func(token string) (bool, error) {
+		return token == "valid-token", nil
+	})
+	server := newTestServer(t, hub)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?token=invalid-token", nil)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	conn := dial(t, server, "", http.Header{"Authorization": {"Bearer valid-token"}})
+	assert.Equal(t, EventConnected, readMessage(t, conn).Event)
+}
+
+func TestHub_Rooms(t *testing.T) {
+	hub := NewHub(context.Background())
+	hub.SetRoomAuthorizer(func(client *Client, room string) bool {
+		return room != "admin"
+	})
+	server := newTestServer(t, hub)
+
+	member := connect(t, server, "client_id=member&rooms=order-1")
+	outsider := connect(t, server, "client_id=outsider")
+	assert.Equal(t, []string{"member"}, hub.GetRoomClients("order-1"))
+
+	require.NoError(t, outsider.WriteJSON(Message{Event: EventJoin, ID: "1", Room: "admin"}))
+	msg := readMessage(t, outsider)
+	assert.Equal(t, EventError, msg.Event)
+	assert.Equal(t, "1", msg.ID)
+
+	hub.BroadcastToRoom("order-1", &Message{Event: "order.updated", Data: map[string]string{"status": "paid"}})
+	msg = readMessage(t, member)
+	assert.Equal(t, "order.updated", msg.Event)
+	assert.Equal(t, "order-1", msg.Room)
+
+	// the outsider joins, then gets the next room message
+	require.NoError(t, outsider.WriteJSON(Message{Event: EventJoin, Room: "order-1"}))
+	assert.Equal(t, EventJoined, readMessage(t, outsider).Event)
+	hub.BroadcastToRoom("order-1", &Message{Event: "order.shipped"})
+	assert.Equal(t, "order.shipped", readMessage(t, outsider).Event)
+	assert.Equal(t, "order.shipped", readMessage(t, member).Event)
+
+	require.NoError(t, member.WriteJSON(Message{Event: EventLeave, Room: "order-1"}))
+	assert.Equal(t, EventLeft, readMessage(t, member).Event)
+	assert.Equal(t, []string{"outsider"}, hub.GetRoomClients("order-1"))
+}
+
+func TestHub_SendToUser(t *testing.T) {
+	hub := NewHub(context.Background())
+	hub.SetIdentityResolver(func(r *http.Request) (string, error) {
+		userID := r.URL.Query().Get("user")
+		if userID == "" {
+			return "", errors.New("anonymous")
+		}
+		return userID, nil
+	})
+	server := newTestServer(t, hub)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	phone := connect(t, server, "user=alice&client_id=phone")
+	laptop := connect(t, server, "user=alice&client_id=laptop")
+	connect(t, server, "user=bob")
+
+	require.NoError(t, hub.SendToUser("alice", &Message{Event: "notification"}))
+	assert.Equal(t, "notification", readMessage(t, phone).Event)
+	assert.Equal(t, "notification", readMessage(t, laptop).Event)
+
+	err = hub.SendToUser("carol", &Message{Event: "notification"})
+	assert.True(t, errors.Is(err, ErrUserNotConnected))
+	err = hub.SendToClient("unknown", &Message{Event: "notification"})
+	assert.True(t, errors.Is(err, ErrClientNotFound))
+}
+
+func TestHub_Handlers(t *testing.T) {
+	hub := NewHub(context.Background())
+	hub.On("chat.send", func(ctx context.Context, client *Client, message *Message) error {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		if err := message.Bind(&payload); err != nil {
+			return err
+		}
+		if payload.Text == "" {
+			return NewError("EMPTY_TEXT", "text is required")
+		}
+		client.hub.BroadcastToRoom(message.Room, &Message{Event: "chat.message", Data: payload})
+		return nil
+	})
+	hub.On("boom", func(ctx context.Context, client *Client, message *Message) error {
+		panic("boom")
+	})
+	server := newTestServer(t, hub)
+	conn := connect(t, server, "rooms=general")
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"event": "chat.send", "room": "general", "data": map[string]string{"text": "hi"}}))
+	msg := readMessage(t, conn)
+	assert.Equal(t, "chat.message", msg.Event)
+	assert.Equal(t, map[string]interface{}{"text": "hi"}, msg.Data)
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"event": "chat.send", "id": "2", "data": map[string]string{}}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, EventError, msg.Event)
+	assert.Equal(t, "2", msg.ID)
+	assert.Equal(t, "EMPTY_TEXT", msg.Data.(map[string]interface{})["code"])
+
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"event": "unknown"}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, "UNKNOWN_EVENT", msg.Data.(map[string]interface{})["code"])
+
+	// a panicking handler does not drop the connection
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"event": "boom"}))
+	msg = readMessage(t, conn)
+	assert.Equal(t, "INTERNAL_ERROR", msg.Data.(map[string]interface{})["code"])
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	msg = readMessage(t, conn)
+	assert.Equal(t, "INVALID_MESSAGE", msg.Data.(map[string]interface{})["code"])
+}
+
+func TestHub_ClientIDOwnership(t *testing.T) {
+	hub := NewHub(context.Background())
+	hub.SetIdentityResolver(func(r *http.Request) (string, error) {
+		return r.URL.Query().Get("user"), nil
+	})
+	server := newTestServer(t, hub)
+
+	phone := connect(t, server, "user=alice&client_id=phone")
+	require.NoError(t, hub.SendToClient("phone", &Message{Event: "private", ID: "private-1"}))
+	assert.Equal(t, "private", readMessage(t, phone).Event)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?user=mallory&client_id=phone"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "the client of another user is not taken over")
+
+	phone = connect(t, server, "user=alice&client_id=phone&last_event_id=unknown")
+	assert.Equal(t, "private-1", readMessage(t, phone).ID, "the user reconnects with its client ID")
+	require.NoError(t, phone.Close())
+	require.Eventually(t, func() bool { return hub.GetClientCount() == 0 }, time.Second, 10*time.Millisecond)
+
+	stolen := connect(t, server, "user=mallory&client_id=phone&last_event_id=unknown")
+	hub.Broadcast(&Message{Event: "news", ID: "news-1"})
+	assert.Equal(t, "news-1", readMessage(t, stolen).ID, "the messages of the client of another user are not replayed")
+}
+
+func TestHub_Replay(t *testing.T) {
+	hub := NewHub(context.Background())
+	server := newTestServer(t, hub)
+
+	hub.Broadcast(&Message{Event: "news", ID: "1"})
+	hub.Broadcast(&Message{Event: "news", ID: "2", Data: map[string]string{"title": "second"}})
+	hub.BroadcastToRoom("vip", &Message{Event: "vip.news", ID: "3"})
+	hub.Broadcast(&Message{Event: "news", ID: "4"})
+
+	conn := connect(t, server, "last_event_id=1")
+	msg := readMessage(t, conn)
+	assert.Equal(t, "2", msg.ID)
+	assert.Equal(t, map[string]interface{}{"title": "second"}, msg.Data)
+	assert.Equal(t, "4", readMessage(t, conn).ID)
+
+	vip := connect(t, server, "last_event_id=2&rooms=vip")
+	msg = readMessage(t, vip)
+	assert.Equal(t, "3", msg.ID)
+	assert.Equal(t, "vip", msg.Room)
+	assert.Equal(t, "4", readMessage(t, vip).ID)
+}
+
+func TestHub_PingPong(t *testing.T) {
+	hub := NewHub(context.Background(), WithPingInterval(20*time.Millisecond, time.Second))
+	server := newTestServer(t, hub)
+	conn := connect(t, server, "")
+
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	assert.Eventually(t, func() bool { return pings.Load() >= 2 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, hub.GetClientCount())
+}
+
+func TestHub_Stop(t *testing.T) {
+	hub := NewHub(context.Background())
+	server := newTestServer(t, hub)
+	go hub.Run()
+	assert.Eventually(t, hub.IsRunning, time.Second, 5*time.Millisecond)
+
+	conn := connect(t, server, "")
+	hub.Stop()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	assert.Eventually(t, func() bool { return hub.GetClientCount() == 0 && !hub.IsRunning() }, time.Second, 5*time.Millisecond)
+}
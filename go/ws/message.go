@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// built-in events
+const (
+	// EventConnected is sent to a client once registered, with its client ID, user ID and rooms
+	EventConnected = "connected"
+	// EventJoin is sent by a client to join the room of the message
+	EventJoin = "join"
+	// EventLeave is sent by a client to leave the room of the message
+	EventLeave = "leave"
+	// EventJoined confirms a join to the client
+	EventJoined = "joined"
+	// EventLeft confirms a leave to the client
+	EventLeft = "left"
+	// EventError reports a rejected or failed message to the client, with the ID of that message
+	EventError = "error"
+)
+
+// Message is the JSON envelope of every frame, in both directions:
+//
+//	{"event": "chat.send", "id": "42", "room": "order-1", "data": {"text": "hi"}}
+//
+// Data of a received message is the raw JSON, decode it with Bind.
+type Message struct {
+	Event string      `json:"event"`
+	ID    string      `json:"id,omitempty"`
+	Room  string      `json:"room,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Bind decodes the data of a received message into v
+func (m *Message) Bind(v interface{}) error {
+	var raw []byte
+	switch data := m.Data.(type) {
+	case json.RawMessage:
+		raw = data
+	case nil:
+		return nil
+	default:
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// NewMessage creates a message with a time based ID, so it is buffered for replay
+func NewMessage(event string, data interface{}) *Message {
+	return &Message{
+		Event: event,
+		Data:  data,
+		ID:    fmt.Sprintf("%d", time.Now().UnixNano()),
+	}
+}
+
+// HandlerFunc handles the messages of one event, registered with Hub.On.
+// Messages of a client are handled one at a time, in order. ctx is the context of the
+// upgrade request (request logger, request ID, JWT ...).
+// A returned *Error is sent to the client as is, any other error as INTERNAL_ERROR.
+type HandlerFunc func(ctx context.Context, client *Client, message *Message) error
+
+// Error is an error reported to the client with an EventError message
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError creates an error reported to the client with code and message
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+type inboundMessage struct {
+	Event string          `json:"event"`
+	ID    string          `json:"id,omitempty"`
+	Room  string          `json:"room,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}